@@ -0,0 +1,245 @@
+package woodwatch
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxAPIRequestSize is the largest request body the Server's HTTP API reads.
+const maxAPIRequestSize = 1 << 20
+
+// ackRequest is the JSON body of a POST /peers/{name}/ack request.
+type ackRequest struct {
+	// Duration is how long the peer is acknowledged for, e.g. "2h".
+	Duration string `json:"duration"`
+	// Message describes why the peer is acknowledged.
+	Message string `json:"message"`
+}
+
+// ackResponse is the JSON body of a /peers/{name}/ack response.
+type ackResponse struct {
+	// Acknowledged is whether the peer is acknowledged.
+	Acknowledged bool `json:"acknowledged"`
+	// Until is when the peer's acknowledgement expires. It is omitted if the
+	// peer isn't acknowledged.
+	Until *time.Time `json:"until,omitempty"`
+	// Message is the message the peer was acknowledged with.
+	Message string `json:"message,omitempty"`
+}
+
+// apiErrorStatus returns the HTTP status code to respond to an API request
+// with when a Server method returned the given error.
+func apiErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrPeerNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidAckDuration), errors.Is(err, ErrInvalidState):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrPeerLockTimeout):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// requester describes who made the given API request for the log, e.g.
+// "192.0.2.1:51234 (curl/8.5.0)".
+func requester(r *http.Request) string {
+	if agent := r.UserAgent(); agent != "" {
+		return r.RemoteAddr + " (" + agent + ")"
+	}
+
+	return r.RemoteAddr
+}
+
+// requireAPIKey wraps the given handler so that it is only called for requests
+// with an "Authorization: Bearer <key>" header matching the Server's API key.
+// Other requests get a 401 Unauthorized. If the Server has no API key every
+// request gets a 403 Forbidden.
+func (s *Server) requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.apiKey == "" {
+			http.Error(w, "no API key is configured", http.StatusForbidden)
+
+			return
+		}
+		key, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(key), []byte(s.apiKey)) != 1 {
+			s.warnf("rejected %s %s from %s: invalid API key\n",
+				r.Method, r.URL.Path, requester(r))
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleAcknowledgePeer acknowledges the peer named in the request path with
+// the duration and message of the ackRequest in the request body. It responds
+// like handlePeerAcknowledgement, or with a 400 Bad Request if the body isn't
+// a valid ackRequest.
+func (s *Server) handleAcknowledgePeer(w http.ResponseWriter, r *http.Request) {
+	var req ackRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	name := r.PathValue("name")
+	if err := s.AcknowledgePeer(name, duration, req.Message); err != nil {
+		http.Error(w, err.Error(), apiErrorStatus(err))
+
+		return
+	}
+	s.infof("peer %s acknowledged by %s\n", name, requester(r))
+	s.handlePeerAcknowledgement(w, r)
+}
+
+// handleManualHeartbeat updates the last seen time of the peer named in the
+// request path with ManualHeartbeat. It responds with a 204 No Content, or
+// a 404 Not Found if there is no such peer.
+func (s *Server) handleManualHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if err := s.ManualHeartbeat(r.PathValue("name")); err != nil {
+		http.Error(w, err.Error(), apiErrorStatus(err))
+
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleResetPeerStats resets the statistics of the peer named in the request
+// path with ResetPeerStats, logging who requested it. It responds with a 204
+// No Content, or a 404 Not Found if there is no such peer.
+func (s *Server) handleResetPeerStats(w http.ResponseWriter, r *http.Request) {
+	if err := s.resetPeerStatsBy(r.PathValue("name"), requester(r)); err != nil {
+		http.Error(w, err.Error(), apiErrorStatus(err))
+
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleResetStats resets the statistics of all of the Server's peers with
+// ResetStats, logging who requested it. It responds with a 204 No Content.
+func (s *Server) handleResetStats(w http.ResponseWriter, r *http.Request) {
+	s.resetStatsBy(requester(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePeers responds with the PeerStatus of each of the Server's peers as
+// a JSON array. If the tags query parameter is a comma separated list of tags,
+// e.g. "production,ams1", only the peers that have all of them are included.
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	var tags []string
+	for _, tag := range strings.Split(r.URL.Query().Get("tags"), ",") {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	statuses := make([]PeerStatus, 0)
+	for _, status := range s.Peers() {
+		if hasAllTags(status.Tags, tags) {
+			statuses = append(statuses, status)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		s.errorf("error writing peer statuses: %v\n", err)
+	}
+}
+
+// hasAllTags returns true if the given peer tags include every one of the
+// wanted tags.
+func hasAllTags(peerTags, wanted []string) bool {
+	for _, tag := range wanted {
+		if !slices.Contains(peerTags, tag) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// handlePeer responds with the PeerStatus of the peer named in the request
+// path as JSON, or a 404 Not Found if there is no such peer.
+func (s *Server) handlePeer(w http.ResponseWriter, r *http.Request) {
+	status, err := s.Peer(r.PathValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), apiErrorStatus(err))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		s.errorf("error writing peer status: %v\n", err)
+	}
+}
+
+// handleState responds with the state of each of the Server's peers as written
+// by SaveState, or a 503 Service Unavailable if a peer's lock can't be
+// acquired.
+func (s *Server) handleState(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	if err := s.SaveState(&buf); err != nil {
+		http.Error(w, err.Error(), apiErrorStatus(err))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := buf.WriteTo(w); err != nil {
+		s.errorf("error writing peer states: %v\n", err)
+	}
+}
+
+// handleRestoreState restores the peer states in the request body, as written
+// by SaveState, with LoadState, logging who requested it. It responds with
+// a 204 No Content, or a 400 Bad Request if the body isn't valid saved state.
+func (s *Server) handleRestoreState(w http.ResponseWriter, r *http.Request) {
+	if err := s.LoadState(http.MaxBytesReader(w, r.Body, maxAPIRequestSize)); err != nil {
+		http.Error(w, err.Error(), apiErrorStatus(err))
+
+		return
+	}
+	s.infof("peer states restored by %s\n", requester(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePeerAcknowledgement responds with the acknowledgement of the peer
+// named in the request path as an ackResponse, or a 404 Not Found if there is
+// no such peer.
+func (s *Server) handlePeerAcknowledgement(w http.ResponseWriter, r *http.Request) {
+	until, message, err := s.PeerAcknowledgement(r.PathValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), apiErrorStatus(err))
+
+		return
+	}
+
+	resp := ackResponse{Message: message}
+	if !until.IsZero() {
+		resp.Acknowledged = true
+		resp.Until = &until
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.errorf("error writing peer acknowledgement: %v\n", err)
+	}
+}
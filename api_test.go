@@ -0,0 +1,439 @@
+package woodwatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAPITestServer returns a Server with a LAN peer serving its health checks
+// and API with the given additional options.
+func newAPITestServer(t *testing.T, opts ...ServerOption) *Server {
+	t.Helper()
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	opts = append([]ServerOption{
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithConfig(c),
+		WithHealthAddr("127.0.0.1:0"),
+	}, opts...)
+	s, err := NewServer(opts...)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	if err := s.listenHealth(); err != nil {
+		t.Fatalf("expected listenHealth to return nil err, got %v", err)
+	}
+	t.Cleanup(s.closeHealth)
+
+	return s
+}
+
+// apiRequest makes a request to the given Server's API with the given method,
+// path, API key and body, returning the response status code and body.
+func apiRequest(t *testing.T, s *Server, method, path, key, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, "http://"+s.healthAddr+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("expected NewRequest to return nil err, got %v", err)
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected %s %s to return nil err, got %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("expected reading %s %s body to return nil err, got %v", method, path, err)
+	}
+
+	return resp.StatusCode, string(respBody)
+}
+
+// TestRequireAPIKey tests that requests that change peers are rejected without
+// the Server's API key.
+func TestRequireAPIKey(t *testing.T) {
+	ack := `{"duration":"1h","message":"maintenance"}`
+
+	t.Run("No API key configured", func(t *testing.T) {
+		s := newAPITestServer(t)
+		if code, _ := apiRequest(t, s, http.MethodPost, "/peers/LAN/ack", "", ack); code != http.StatusForbidden {
+			t.Errorf("expected %d without an API key, got %d", http.StatusForbidden, code)
+		}
+	})
+
+	s := newAPITestServer(t, WithAPIKey("secret"))
+	testCases := []struct {
+		Name         string
+		Key          string
+		ExpectedCode int
+	}{
+		{
+			Name:         "Missing key",
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			Name:         "Wrong key",
+			Key:          "wrong",
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			Name:         "Right key",
+			Key:          "secret",
+			ExpectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if code, _ := apiRequest(t, s, http.MethodPost, "/peers/LAN/ack", tc.Key, ack); code != tc.ExpectedCode {
+				t.Errorf("expected %d, got %d", tc.ExpectedCode, code)
+			}
+		})
+	}
+}
+
+// TestAckAPI tests that peers can be acknowledged and their acknowledgement
+// read through the API.
+func TestAckAPI(t *testing.T) {
+	s := newAPITestServer(t, WithAPIKey("secret"))
+
+	getAck := func() ackResponse {
+		t.Helper()
+		code, body := apiRequest(t, s, http.MethodGet, "/peers/LAN/ack", "", "")
+		if code != http.StatusOK {
+			t.Fatalf("expected GET /peers/LAN/ack to return %d, got %d", http.StatusOK, code)
+		}
+		var resp ackResponse
+		if err := json.Unmarshal([]byte(body), &resp); err != nil {
+			t.Fatalf("expected GET /peers/LAN/ack to return a JSON body, got err %v", err)
+		}
+
+		return resp
+	}
+
+	if resp := getAck(); resp.Acknowledged || resp.Until != nil {
+		t.Errorf("expected peer to not be acknowledged, got %#v", resp)
+	}
+
+	testCases := []struct {
+		Name         string
+		Path         string
+		Body         string
+		ExpectedCode int
+	}{
+		{
+			Name:         "Unknown peer",
+			Path:         "/peers/Unknown/ack",
+			Body:         `{"duration":"1h"}`,
+			ExpectedCode: http.StatusNotFound,
+		},
+		{
+			Name:         "Invalid body",
+			Path:         "/peers/LAN/ack",
+			Body:         `{`,
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "Invalid duration",
+			Path:         "/peers/LAN/ack",
+			Body:         `{"duration":"soon"}`,
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "Non-positive duration",
+			Path:         "/peers/LAN/ack",
+			Body:         `{"duration":"-1h"}`,
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "Acknowledged",
+			Path:         "/peers/LAN/ack",
+			Body:         `{"duration":"1h","message":"maintenance"}`,
+			ExpectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if code, _ := apiRequest(t, s, http.MethodPost, tc.Path, "secret", tc.Body); code != tc.ExpectedCode {
+				t.Errorf("expected %d, got %d", tc.ExpectedCode, code)
+			}
+		})
+	}
+
+	resp := getAck()
+	if !resp.Acknowledged || resp.Until == nil || resp.Message != "maintenance" {
+		t.Errorf("expected peer to be acknowledged with message %q, got %#v", "maintenance", resp)
+	}
+	if code, _ := apiRequest(t, s, http.MethodGet, "/peers/Unknown/ack", "", ""); code != http.StatusNotFound {
+		t.Errorf("expected GET /peers/Unknown/ack to return %d, got %d", http.StatusNotFound, code)
+	}
+}
+
+// TestPeersAPI tests that the statuses of the peers can be read through the API
+// and filtered by tags.
+func TestPeersAPI(t *testing.T) {
+	s := newAPITestServer(t)
+	s.peers[0].Tags = []string{"production", "ams1"}
+	for _, pc := range []PeerConfig{
+		{Name: "WAN", Network: "10.0.0.0/8", Tags: []string{"production", "nyc1"}},
+		{Name: "Lab", Network: "172.16.0.0/12", Tags: []string{"staging", "ams1"}},
+	} {
+		if err := s.AddPeer(pc); err != nil {
+			t.Fatalf("expected AddPeer to return nil err, got %v", err)
+		}
+	}
+
+	testCases := []struct {
+		Name          string
+		Query         string
+		ExpectedPeers []string
+	}{
+		{
+			Name:          "No filter",
+			ExpectedPeers: []string{"LAN", "WAN", "Lab"},
+		},
+		{
+			Name:          "One tag",
+			Query:         "?tags=ams1",
+			ExpectedPeers: []string{"LAN", "Lab"},
+		},
+		{
+			Name:          "All tags",
+			Query:         "?tags=production,ams1",
+			ExpectedPeers: []string{"LAN"},
+		},
+		{
+			Name:          "Empty tags",
+			Query:         "?tags=,production,",
+			ExpectedPeers: []string{"LAN", "WAN"},
+		},
+		{
+			Name:          "No matches",
+			Query:         "?tags=production,staging",
+			ExpectedPeers: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			code, body := apiRequest(t, s, http.MethodGet, "/peers"+tc.Query, "", "")
+			if code != http.StatusOK {
+				t.Fatalf("expected %d, got %d", http.StatusOK, code)
+			}
+			var statuses []PeerStatus
+			if err := json.Unmarshal([]byte(body), &statuses); err != nil {
+				t.Fatalf("expected a JSON body, got err %v", err)
+			}
+			names := []string{}
+			for _, status := range statuses {
+				names = append(names, status.Name)
+			}
+			if !reflect.DeepEqual(names, tc.ExpectedPeers) {
+				t.Errorf("expected peers %v, got %v", tc.ExpectedPeers, names)
+			}
+		})
+	}
+}
+
+// TestPeerAPI tests that the status of a peer, including its flap count, state
+// duration and acknowledgement, can be read through the API.
+func TestPeerAPI(t *testing.T) {
+	s := newAPITestServer(t)
+	p := s.peers[0]
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	p.stableStateEnteredAt = now.Add(-time.Hour)
+	p.flapCount.Store(3)
+	if err := s.AcknowledgePeer("LAN", time.Hour, "maintenance"); err != nil {
+		t.Fatalf("expected AcknowledgePeer to return nil err, got %v", err)
+	}
+
+	code, body := apiRequest(t, s, http.MethodGet, "/peers/LAN", "", "")
+	if code != http.StatusOK {
+		t.Fatalf("expected GET /peers/LAN to return %d, got %d", http.StatusOK, code)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(body), &fields); err != nil {
+		t.Fatalf("expected GET /peers/LAN to return a JSON body, got err %v", err)
+	}
+	expected := map[string]any{
+		"name":          "LAN",
+		"state":         "Down",
+		"flapCount":     float64(3),
+		"stateDuration": float64(time.Hour),
+		"ackUntil":      now.Add(time.Hour).Format(time.RFC3339),
+		"ackMessage":    "maintenance",
+	}
+	for field, value := range expected {
+		if fields[field] != value {
+			t.Errorf("expected GET /peers/LAN %q to be %v, got %v", field, value, fields[field])
+		}
+	}
+
+	if code, _ := apiRequest(t, s, http.MethodGet, "/peers/Unknown", "", ""); code != http.StatusNotFound {
+		t.Errorf("expected GET /peers/Unknown to return %d, got %d", http.StatusNotFound, code)
+	}
+}
+
+// TestHeartbeatAPI tests that a peer's last seen time can be updated through
+// the API.
+func TestHeartbeatAPI(t *testing.T) {
+	s := newAPITestServer(t, WithAPIKey("secret"))
+
+	if code, _ := apiRequest(t, s, http.MethodPost, "/peers/Unknown/heartbeat", "secret", ""); code != http.StatusNotFound {
+		t.Errorf("expected POST /peers/Unknown/heartbeat to return %d, got %d", http.StatusNotFound, code)
+	}
+	if code, _ := apiRequest(t, s, http.MethodPost, "/peers/LAN/heartbeat", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("expected POST /peers/LAN/heartbeat without a key to return %d, got %d", http.StatusUnauthorized, code)
+	}
+
+	before := time.Now()
+	if code, _ := apiRequest(t, s, http.MethodPost, "/peers/LAN/heartbeat", "secret", ""); code != http.StatusNoContent {
+		t.Fatalf("expected POST /peers/LAN/heartbeat to return %d, got %d", http.StatusNoContent, code)
+	}
+	p := s.peers[0]
+	p.lastSeenMu.RLock()
+	lastSeen := p.lastSeen
+	p.lastSeenMu.RUnlock()
+	if lastSeen.Before(before) {
+		t.Errorf("expected last seen to be updated after %v, got %v", before, lastSeen)
+	}
+}
+
+// TestResetStatsAPI tests that peer statistics can be reset through the API
+// and that the resets are logged with the requester.
+func TestResetStatsAPI(t *testing.T) {
+	var buf bytes.Buffer
+	s := newAPITestServer(t, WithAPIKey("secret"), WithLogger(log.New(&buf, "", 0)))
+	p := s.peers[0]
+
+	testCases := []struct {
+		Name         string
+		Path         string
+		Key          string
+		ExpectedCode int
+		ExpectedLog  string
+	}{
+		{
+			Name:         "Missing key",
+			Path:         "/stats/reset",
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			Name:         "Unknown peer",
+			Path:         "/peers/Unknown/stats/reset",
+			Key:          "secret",
+			ExpectedCode: http.StatusNotFound,
+		},
+		{
+			Name:         "Peer",
+			Path:         "/peers/LAN/stats/reset",
+			Key:          "secret",
+			ExpectedCode: http.StatusNoContent,
+			ExpectedLog:  "reset stats for peer LAN at ",
+		},
+		{
+			Name:         "All peers",
+			Path:         "/stats/reset",
+			Key:          "secret",
+			ExpectedCode: http.StatusNoContent,
+			ExpectedLog:  "reset stats for all peers at ",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			buf.Reset()
+			p.flapCount.Store(3)
+			if code, _ := apiRequest(t, s, http.MethodPost, tc.Path, tc.Key, ""); code != tc.ExpectedCode {
+				t.Fatalf("expected %d, got %d", tc.ExpectedCode, code)
+			}
+			if tc.ExpectedLog == "" {
+				if count := p.flapCount.Load(); count != 3 {
+					t.Errorf("expected flap count to be kept, got %d", count)
+				}
+
+				return
+			}
+			if count := p.flapCount.Load(); count != 0 {
+				t.Errorf("expected flap count to be reset, got %d", count)
+			}
+			logged := buf.String()
+			if !strings.Contains(logged, tc.ExpectedLog) || !strings.Contains(logged, " by 127.0.0.1:") {
+				t.Errorf("expected log to contain %q and the requester, got %q", tc.ExpectedLog, logged)
+			}
+		})
+	}
+}
+
+// TestStateAPI tests that peer states can be exported from one Server and
+// restored to another through the API.
+func TestStateAPI(t *testing.T) {
+	s := newAPITestServer(t)
+	up := `[{"name":"LAN","network":"192.168.1.0/24","state":{"state":"Up"}}]`
+	if err := s.LoadState(strings.NewReader(up)); err != nil {
+		t.Fatalf("expected LoadState to return nil err, got %v", err)
+	}
+	code, saved := apiRequest(t, s, http.MethodGet, "/state", "", "")
+	if code != http.StatusOK {
+		t.Fatalf("expected GET /state to return %d, got %d", http.StatusOK, code)
+	}
+
+	restored := newAPITestServer(t, WithAPIKey("secret"))
+	testCases := []struct {
+		Name         string
+		Key          string
+		Body         string
+		ExpectedCode int
+	}{
+		{
+			Name:         "Missing key",
+			Body:         saved,
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			Name:         "Invalid state",
+			Key:          "secret",
+			Body:         `[{"name":"LAN","network":"192.168.1.0/24","state":{"state":"Sideways"}}]`,
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Name:         "Restored",
+			Key:          "secret",
+			Body:         saved,
+			ExpectedCode: http.StatusNoContent,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if code, _ := apiRequest(t, restored, http.MethodPut, "/state", tc.Key, tc.Body); code != tc.ExpectedCode {
+				t.Errorf("expected %d, got %d", tc.ExpectedCode, code)
+			}
+		})
+	}
+
+	status, err := restored.Peer("LAN")
+	if err != nil {
+		t.Fatalf("expected Peer to return nil err, got %v", err)
+	}
+	if status.State != "Up" {
+		t.Errorf("expected restored peer to be Up, got %q", status.State)
+	}
+}
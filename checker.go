@@ -0,0 +1,106 @@
+package woodwatch
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checker is implemented by the ways a Server actively checks if its peers are
+// reachable, as opposed to waiting for peers to send ICMP echo requests. Each checker feeds the peers it reaches to the same
+// Server.updatePeer path as readPacket.
+type checker interface {
+	// run checks the Server's peers once per monitor cycle until the context is
+	// done.
+	run(ctx context.Context)
+}
+
+// checkers returns the Server's checkers.
+func (s *Server) checkers() []checker {
+	return []checker{
+		tcpChecker{
+			s:        s,
+			interval: s.monitorCycle,
+			timeout:  s.monitorCycle,
+		},
+		probeChecker{
+			s:    s,
+			tick: probeTickInterval,
+		},
+	}
+}
+
+// tcpChecker is a checker for peers monitored by a "tcp:<port>" protocol,
+// including peers with a MonitorType of "tcp". It dials the peer's host address
+// on the port and the peer is seen by that protocol when the connection
+// succeeds.
+type tcpChecker struct {
+	// s is the Server whose peers are checked.
+	s *Server
+	// interval is the duration between checking the peers.
+	interval time.Duration
+	// timeout is how long each dial may take.
+	timeout time.Duration
+}
+
+// run checks the Server's TCP peers once per interval until the context is
+// done. Peers are read from the Server for each check so that peers added by
+// reloading the config are checked too.
+func (c tcpChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.check(ctx)
+		}
+	}
+}
+
+// check dials every TCP protocol of the Server's peers concurrently and waits
+// for the dials to finish. Each peer that accepts the connection is updated
+// with Server.updatePeer for the protocol that was dialed.
+func (c tcpChecker) check(ctx context.Context) {
+	c.s.peersMu.RLock()
+	peers := c.s.peers
+	c.s.peersMu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		for _, protocol := range p.protocols {
+			port, found := strings.CutPrefix(protocol, protocolTCPPrefix)
+			if !found {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// Peers monitored by dialing have a single host network.
+				ip := p.Networks[0].IP
+				if c.dial(ctx, net.JoinHostPort(ip.String(), port)) {
+					c.s.updatePeer(ip, protocol)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// dial returns true if a TCP connection to the given address can be made
+// within the tcpChecker's timeout. The connection is closed straight away.
+func (c tcpChecker) dial(ctx context.Context, address string) bool {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		c.s.debugf("error dialing %s: %v\n", address, err)
+
+		return false
+	}
+	_ = conn.Close()
+
+	return true
+}
@@ -0,0 +1,134 @@
+package woodwatch
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenTestTCP listens on the given loopback address and accepts connections
+// until the test is done, returning the port it listens on.
+func listenTestTCP(t *testing.T, address string) int {
+	t.Helper()
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Skipf("unable to listen on loopback: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// TestTCPChecker tests that a tcpChecker updates the peers it can dial by their
+// MonitorType or Protocols and not the peers it can't.
+func TestTCPChecker(t *testing.T) {
+	openPort := listenTestTCP(t, "127.0.0.1:0")
+	protocolPort := listenTestTCP(t, "127.0.0.4:0")
+
+	// Find a port that nothing is listening on.
+	closed, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("unable to listen on loopback: %v", err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{
+				Name:        "Open",
+				Network:     "127.0.0.1/32",
+				MonitorType: MonitorTypeTCP,
+				TCPPort:     uint16(openPort),
+			},
+			{
+				Name:        "Closed",
+				Network:     "127.0.0.2/32",
+				MonitorType: MonitorTypeTCP,
+				TCPPort:     uint16(closedPort),
+			},
+			{
+				Name:    "ICMP",
+				Network: "127.0.0.3/32",
+			},
+			{
+				Name:      "Protocol",
+				Network:   "127.0.0.4/32",
+				Protocols: []string{"icmp", "tcp:" + strconv.Itoa(protocolPort)},
+			},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		return now
+	}
+
+	tc := tcpChecker{s: s, interval: time.Second, timeout: time.Second}
+	tc.check(context.Background())
+
+	open, closedPeer, icmpPeer, protocolPeer := s.peers[0], s.peers[1], s.peers[2], s.peers[3]
+	for _, tc := range []struct {
+		peer     *peer
+		protocol string
+	}{
+		{peer: open, protocol: protocolTCPPrefix + strconv.Itoa(openPort)},
+		{peer: protocolPeer, protocol: protocolTCPPrefix + strconv.Itoa(protocolPort)},
+	} {
+		if !tc.peer.lastSeen.Equal(now) {
+			t.Errorf("expected %s peer to be last seen at %v, got %v", tc.peer.Name, now, tc.peer.lastSeen)
+		}
+		tc.peer.aggregateObservations()
+		if !tc.peer.protocolLastSeen[tc.protocol].Equal(now) {
+			t.Errorf("expected %s peer to be last seen by %q at %v, got %v",
+				tc.peer.Name, tc.protocol, now, tc.peer.protocolLastSeen[tc.protocol])
+		}
+	}
+	if !protocolPeer.protocolLastSeen[protocolICMP].IsZero() {
+		t.Errorf("expected protocol peer to not be seen by ICMP, got %v",
+			protocolPeer.protocolLastSeen[protocolICMP])
+	}
+	if !closedPeer.lastSeen.IsZero() {
+		t.Errorf("expected closed peer to not be seen, got %v", closedPeer.lastSeen)
+	}
+	if !icmpPeer.lastSeen.IsZero() {
+		t.Errorf("expected ICMP peer to not be seen, got %v", icmpPeer.lastSeen)
+	}
+}
+
+// TestTCPCheckerRun tests that a tcpChecker stops running when its context is
+// done.
+func TestTCPCheckerRun(t *testing.T) {
+	s := &Server{log: log.New(io.Discard, "", 0)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tcpChecker{s: s, interval: time.Millisecond, timeout: time.Millisecond}.run(ctx)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected tcpChecker to stop when its context was done")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cpu/woodwatch"
+)
+
+// TestWriteExampleConfig tests that the example config has a comment for every
+// field and loads with LoadConfig as the valid exampleConfig.
+func TestWriteExampleConfig(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeExampleConfig(&buf); err != nil {
+		t.Fatalf("expected writeExampleConfig to return nil err, got %v", err)
+	}
+
+	c, err := woodwatch.LoadConfig(buf.Bytes())
+	if err != nil {
+		t.Fatalf("expected LoadConfig of example config to return nil err, got %v", err)
+	}
+	if err := c.Valid(); err != nil {
+		t.Errorf("expected example config to be valid, got %v", err)
+	}
+	if expected := exampleConfig(); !reflect.DeepEqual(c, expected) {
+		t.Errorf("expected example config %#v, got %#v", expected, c)
+	}
+
+	output := buf.String()
+	for _, typ := range []reflect.Type{
+		reflect.TypeOf(woodwatch.Config{}),
+		reflect.TypeOf(woodwatch.PeerConfig{}),
+		reflect.TypeOf(woodwatch.MaintenanceWindow{}),
+	} {
+		for i := 0; i < typ.NumField(); i++ {
+			name := typ.Field(i).Name
+			if !strings.Contains(output, `"`+commentKeyPrefix+name+`"`) {
+				t.Errorf("expected example config to have a comment for %s.%s", typ.Name(), name)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is an io.Writer that appends to a log file, rotating it when it
+// would grow larger than a maximum size. When rotated the log file is renamed
+// to "{path}.1", any existing "{path}.1" is renamed to "{path}.2" and so on,
+// keeping at most the configured number of backups.
+type rotatingFile struct {
+	// mu is a mutex for controlling access to the file and size for multiple
+	// goroutines writing log lines.
+	mu sync.Mutex
+	// path is the path of the log file.
+	path string
+	// maxSize is the size in bytes the log file may grow to before it is
+	// rotated.
+	maxSize int64
+	// backups is how many rotated log files are kept.
+	backups int
+	// file is the open log file. Writing to this field must be done only after
+	// acquiring the mu.
+	file *os.File
+	// size is the current size of the log file in bytes. Reading or writing
+	// this field must be done only after acquiring the mu.
+	size int64
+}
+
+// openRotatingFile opens the log file at the given path for appending,
+// creating it if it doesn't exist. The log file is rotated when it would grow
+// larger than maxSize bytes, keeping the given number of backups.
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		backups: backups,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// open opens the log file for appending and records its current size.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+
+	return nil
+}
+
+// Write writes p to the log file, first rotating the log file if writing
+// p would make it larger than the maximum size.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+// rotate closes the log file, shifts the existing backups, renames the log file
+// to the first backup and opens a new log file. The caller must hold the mu.
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	if r.backups > 0 {
+		for i := r.backups - 1; i > 0; i-- {
+			err := os.Rename(backupPath(r.path, i), backupPath(r.path, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(r.path, backupPath(r.path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+
+	return r.open()
+}
+
+// Close closes the log file.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
+
+// backupPath returns the path of the nth backup of the log file at the given
+// path.
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRotatingFile tests that a rotatingFile rotates the log file when it
+// would grow past the maximum size and keeps only the configured number of
+// backups.
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "woodwatch.log")
+	r, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("expected openRotatingFile to return nil err, got %v", err)
+	}
+	defer r.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("expected Write to return nil err, got %v", err)
+		}
+	}
+
+	expected := map[string]string{
+		path:                "fourth\n",
+		backupPath(path, 1): "third\n",
+		backupPath(path, 2): "second\n",
+	}
+	for p, expectedContents := range expected {
+		contents, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatalf("expected to read %q, got err %v", p, err)
+		}
+		if string(contents) != expectedContents {
+			t.Errorf("expected %q to contain %q, got %q", p, expectedContents, contents)
+		}
+	}
+	if _, err := os.Stat(backupPath(path, 3)); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 backups to be kept, got err %v for third", err)
+	}
+}
@@ -2,72 +2,327 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"runtime/trace"
 	"syscall"
+	"time"
 
 	"github.com/cpu/woodwatch"
+	"github.com/cpu/woodwatch/internal/webhook"
 )
 
 var (
 	// quitSignals are the signals that can be used to tell the woodwatch binary to
 	// shut down cleanly.
 	quitSignals = []os.Signal{
-		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT,
 	}
+	// reloadSignals are the signals that can be used to tell the woodwatch binary
+	// to reload its config file.
+	reloadSignals = []os.Signal{
+		syscall.SIGHUP,
+	}
 	// listenAddress is the command line flag for the server listen address.
 	listenAddress = flag.String(
 		"listen",
 		"0.0.0.0",
-		"Interface address to listen to for IPv4 ICMP messages")
+		"Interface address to listen to for ICMP messages")
+	// listenNetwork is the command line flag for the server listen network.
+	listenNetwork = flag.String(
+		"network",
+		"",
+		`ICMP network to listen on: "ip4:icmp", "ip6:ipv6-icmp" or "both" (overrides the config ListenNetwork)`)
 )
 
 // main runs the woodwatch program.
 func main() {
-	configFile := flag.String("config", "", "path to a woodwatch JSON config file")
+	configFile := flag.String("config", "", "path to a woodwatch JSON, JSON5, YAML or TOML config file")
 	verbose := flag.Bool("verbose", false, "verbose output and webhook dispatch")
+	traceOutput := flag.String("trace-output", "", "optional path to write a Go execution trace to")
+	logFile := flag.String("log-file", "", "optional path to a log file to write to instead of stdout")
+	logMaxSizeMB := flag.Int("log-max-size-mb", 100, "size in megabytes the -log-file is rotated at")
+	logBackups := flag.Int("log-backups", 3, "how many rotated -log-file backups to keep")
+	logFormat := flag.String("log-format", "text", `log format: "text" or "json"`)
+	metricsAddr := flag.String("metrics-addr", "", "optional address to serve Prometheus metrics on, e.g. :9090")
+	healthAddr := flag.String("health-addr", "", "optional address to serve /healthz and /readyz health checks on, e.g. :8080")
+	expvarAddr := flag.String("expvar-addr", "", "optional address to serve expvar metrics at /debug/vars on, e.g. :6060")
+	stateFile := flag.String("state-file", "", "optional path to a file peer states are restored from on startup and saved to on shutdown")
+	generateConfig := flag.Bool("generate-config", false, "write a commented example JSON config to stdout, or the -output file, and exit")
+	output := flag.String("output", "", "optional path to write the -generate-config example config or the -export-state peer states to instead of stdout")
+	exportState := flag.Bool("export-state", false, "write the peer states of the woodwatch serving its HTTP API on the -health-addr to stdout, or the -output file, and exit")
+	importState := flag.String("import-state", "", "path to a file of peer states written by -export-state to restore to the woodwatch serving its HTTP API on the -health-addr, then exit")
+	validate := flag.Bool("validate", false, "validate the -config file, print any warnings and errors and exit 0 if it is valid or 1 if not")
+	version := flag.Bool("version", false, "print the woodwatch version and exit")
+	drainTimeout := flag.Duration("drain-timeout", 10*time.Second,
+		"how long to wait for in-flight webhook dispatches to finish when quitting")
 	flag.Parse()
 
+	// If requested, print the version and exit without starting the server.
+	if *version {
+		fmt.Printf("woodwatch %s\n", webhook.Version)
+
+		return
+	}
+
 	logger := log.New(os.Stdout, "woodwatch ", log.LstdFlags)
+	// If requested, write an example config and exit without starting the
+	// server.
+	if *generateConfig {
+		w := os.Stdout
+		if *output != "" {
+			f, err := os.Create(*output)
+			if err != nil {
+				logger.Fatalf("error creating example config %q: %v\n", *output, err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := writeExampleConfig(w); err != nil {
+			logger.Fatalf("error writing example config: %v\n", err)
+		}
+
+		return
+	}
+	// If requested, export or import the peer states of a running woodwatch
+	// through its HTTP API and exit without starting the server.
+	if *exportState || *importState != "" {
+		if *healthAddr == "" {
+			logger.Fatal("you must specify the -health-addr of the running woodwatch")
+		}
+		client := &http.Client{Timeout: stateAPITimeout}
+		if *exportState {
+			w := os.Stdout
+			if *output != "" {
+				f, err := os.Create(*output)
+				if err != nil {
+					logger.Fatalf("error creating peer states file %q: %v\n", *output, err)
+				}
+				defer f.Close()
+				w = f
+			}
+			if err := exportPeerStates(client, *healthAddr, w); err != nil {
+				logger.Fatalf("error exporting peer states: %v\n", err)
+			}
+
+			return
+		}
+		f, err := os.Open(*importState)
+		if err != nil {
+			logger.Fatalf("error opening peer states file %q: %v\n", *importState, err)
+		}
+		defer f.Close()
+		// Read the API key from the environment like the server does.
+		if err := importPeerStates(client, *healthAddr, os.Getenv("WOODWATCH_API_KEY"), f); err != nil {
+			logger.Fatalf("error importing peer states: %v\n", err)
+		}
+		logger.Printf("imported peer states from %q\n", *importState)
+
+		return
+	}
+	// If requested, log to a rotating log file instead of stdout.
+	if *logFile != "" {
+		if *logMaxSizeMB <= 0 || *logBackups < 0 {
+			logger.Fatal("-log-max-size-mb must be positive and -log-backups must not be negative")
+		}
+		f, err := openRotatingFile(*logFile, int64(*logMaxSizeMB)*1024*1024, *logBackups)
+		if err != nil {
+			logger.Fatalf("error opening log file %q: %v\n", *logFile, err)
+		}
+		defer f.Close()
+		logger.SetOutput(f)
+	}
+	// If requested, log structured JSON with slog instead of text.
+	var slogger *slog.Logger
+	switch *logFormat {
+	case "text":
+	case "json":
+		// Every level is handled since the server filters its messages by the
+		// config LogLevel.
+		slogger = slog.New(slog.NewJSONHandler(logger.Writer(),
+			&slog.HandlerOptions{Level: slog.LevelDebug}))
+		logger = slog.NewLogLogger(slogger.Handler(), slog.LevelInfo)
+	default:
+		logger.Fatalf("-log-format must be \"text\" or \"json\", not %q\n", *logFormat)
+	}
 	if *configFile == "" {
 		logger.Fatal("you must specify a -config file")
 	}
 
 	// Load a Config instance from disk
-	c, err := woodwatch.LoadConfigFile(*configFile)
+	loadConfig := func() (woodwatch.Config, error) {
+		c, err := woodwatch.LoadConfigFile(*configFile)
+		if err != nil {
+			return c, err
+		}
+		if *listenNetwork != "" {
+			c.ListenNetwork = *listenNetwork
+		}
+
+		return c, nil
+	}
+
+	// If requested, validate the config and exit without starting the server.
+	if *validate {
+		c, err := loadConfig()
+		if err != nil {
+			fmt.Printf("error: loading config %q: %v\n", *configFile, err)
+			os.Exit(1)
+		}
+		if !validateConfig(os.Stdout, c) {
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	// If requested, write an execution trace until the server stops listening.
+	stopTrace := func() {}
+	if *traceOutput != "" {
+		f, err := os.Create(*traceOutput)
+		if err != nil {
+			logger.Fatalf("error creating trace output %q: %v\n", *traceOutput, err)
+		}
+		if err := trace.Start(f); err != nil {
+			logger.Fatalf("error starting trace: %v\n", err)
+		}
+		stopTrace = func() {
+			trace.Stop()
+			if err := f.Close(); err != nil {
+				logger.Printf("error closing trace output %q: %v\n", *traceOutput, err)
+			}
+		}
+	}
+
+	c, err := loadConfig()
 	if err != nil {
 		logger.Fatalf("error loading config %q: %v\n", *configFile, err)
 	}
 
 	// Create the woodwatch server
-	server, err := woodwatch.NewServer(
-		logger,
-		*verbose,
-		*listenAddress,
-		c)
+	opts := []woodwatch.ServerOption{
+		woodwatch.WithLogger(logger),
+		woodwatch.WithVerbose(*verbose),
+		woodwatch.WithListenAddress(*listenAddress),
+		woodwatch.WithConfig(c),
+		// Notify systemd of readiness when run as a Type=notify service.
+		woodwatch.WithSystemdNotify(os.Getenv("NOTIFY_SOCKET") != ""),
+	}
+	if slogger != nil {
+		opts = append(opts, woodwatch.WithSlogger(slogger))
+	}
+	if *metricsAddr != "" {
+		opts = append(opts, woodwatch.WithMetricsAddr(*metricsAddr))
+	}
+	if *healthAddr != "" {
+		opts = append(opts, woodwatch.WithHealthAddr(*healthAddr))
+	}
+	// Read the API key from the environment so it isn't visible in the process
+	// list.
+	if apiKey := os.Getenv("WOODWATCH_API_KEY"); apiKey != "" {
+		opts = append(opts, woodwatch.WithAPIKey(apiKey))
+	}
+	server, err := woodwatch.NewServer(opts...)
 	if err != nil {
 		logger.Fatalf("error creating server: %v\n", err)
 	}
+	// If requested, restore the peer states saved by a previous run.
+	if *stateFile != "" {
+		if err := loadStateFile(server, *stateFile); err != nil {
+			logger.Printf("error loading state file %q: %v\n", *stateFile, err)
+		}
+	}
 
-	// Listen for quitSignals. When one is received close the server.
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, quitSignals...)
+	// If requested, serve the expvar metrics at /debug/vars.
+	if *expvarAddr != "" {
+		l, err := net.Listen("tcp", *expvarAddr)
+		if err != nil {
+			logger.Fatalf("error listening for expvar on %q: %v\n", *expvarAddr, err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("GET /debug/vars", expvar.Handler())
+		expvarServer := &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		logger.Printf("serving expvar metrics on http://%s/debug/vars\n", l.Addr())
+		go func() {
+			if err := expvarServer.Serve(l); err != http.ErrServerClosed {
+				logger.Printf("error serving expvar metrics: %v\n", err)
+			}
+		}()
+		defer expvarServer.Close()
+	}
+
+	// Listen for quitSignals. When one is received the server finishes any
+	// in-flight webhook dispatches, waiting at most the -drain-timeout, and
+	// stops listening.
+	ctx, stop := signal.NotifyContext(context.Background(), quitSignals...)
+	defer stop()
+	listenCtx, cancelListen := context.WithCancel(context.Background())
+	defer cancelListen()
 	go func() {
-		<-sigChan
-		logger.Println("ending")
-		if err := server.Close(); err != nil {
-			logger.Fatalf("err closing: %v\n", err)
+		<-ctx.Done()
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), *drainTimeout)
+		defer cancelDrain()
+		err := server.DrainAndClose(drainCtx)
+		if errors.Is(err, context.DeadlineExceeded) {
+			logger.Printf("warning: webhook dispatches didn't finish within %s\n", *drainTimeout)
+		} else if err != nil && !errors.Is(err, woodwatch.ErrServerNotListening) {
+			logger.Printf("error closing server: %v\n", err)
+		}
+		// If the server wasn't listening yet stop it from starting.
+		cancelListen()
+	}()
+
+	// Listen for reloadSignals. When one is received reload the config file and
+	// apply it to the server, keeping the state of unchanged peers.
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, reloadSignals...)
+	go func() {
+		for range reloadChan {
+			c, err := loadConfig()
+			if err != nil {
+				logger.Printf("error reloading config %q: %v\n", *configFile, err)
+
+				continue
+			}
+			if err := server.Reload(c); err != nil {
+				logger.Printf("error reloading config %q: %v\n", *configFile, err)
+
+				continue
+			}
+			logger.Printf("reloaded config %q\n", *configFile)
 		}
 	}()
 
-	// Start listening for packets to the server. This will block until
-	// server.Close() is called by the signal handler above.
-	if err := server.Listen(); err != nil {
+	// Start listening for packets to the server. This will block until the
+	// server is closed after one of the quitSignals above.
+	err = server.Listen(listenCtx)
+	stopTrace()
+	if errors.Is(err, woodwatch.ErrServerClosed) {
+		// Save the peer states so they can be restored by the next run.
+		if *stateFile != "" {
+			if err := saveStateFile(server, *stateFile); err != nil {
+				logger.Printf("error saving state file %q: %v\n", *stateFile, err)
+			}
+		}
+		logger.Println("ending")
+
+		return
+	}
+	if err != nil {
 		logger.Fatalf("error: %v\n", err)
 	}
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// stateAPITimeout is how long exporting or importing the peer states of
+// a running woodwatch server through its HTTP API may take.
+const stateAPITimeout = 30 * time.Second
+
+// apiURL returns the URL of the given path of the HTTP API served on the given
+// -health-addr. Addresses without a host, e.g. ":8080", are on localhost.
+func apiURL(addr, path string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	return "http://" + net.JoinHostPort(host, port) + path, nil
+}
+
+// exportPeerStates writes the peer states of the woodwatch server serving its
+// HTTP API on the given address to the given io.Writer, in the same format as
+// the -state-file.
+func exportPeerStates(client *http.Client, addr string, w io.Writer) error {
+	url, err := apiURL(addr, "/state")
+	if err != nil {
+		return err
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkAPIResponse(resp); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, resp.Body)
+
+	return err
+}
+
+// importPeerStates restores the peer states read from the given io.Reader, in
+// the format written by exportPeerStates, to the woodwatch server serving its
+// HTTP API on the given address. The request is authenticated with the given
+// API key.
+func importPeerStates(client *http.Client, addr, apiKey string, r io.Reader) error {
+	url, err := apiURL(addr, "/state")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, url, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkAPIResponse(resp)
+}
+
+// checkAPIResponse returns an error with the status and body of the given HTTP
+// API response if it wasn't successful.
+func checkAPIResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	return fmt.Errorf("%s %s: %s: %s",
+		resp.Request.Method, resp.Request.URL, resp.Status, strings.TrimSpace(string(body)))
+}
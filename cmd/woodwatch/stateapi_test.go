@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAPIURL tests that apiURL builds URLs for -health-addr addresses with and
+// without a host.
+func TestAPIURL(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		Addr        string
+		ExpectedURL string
+		ExpectError bool
+	}{
+		{
+			Name:        "Host and port",
+			Addr:        "woodwatch.example.com:8080",
+			ExpectedURL: "http://woodwatch.example.com:8080/state",
+		},
+		{
+			Name:        "Port only",
+			Addr:        ":8080",
+			ExpectedURL: "http://localhost:8080/state",
+		},
+		{
+			Name:        "IPv6 host",
+			Addr:        "[::1]:8080",
+			ExpectedURL: "http://[::1]:8080/state",
+		},
+		{
+			Name:        "Missing port",
+			Addr:        "woodwatch.example.com",
+			ExpectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			url, err := apiURL(tc.Addr, "/state")
+			if tc.ExpectError {
+				if err == nil {
+					t.Errorf("expected apiURL to return err, got %q", url)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected apiURL to return nil err, got %v", err)
+			}
+			if url != tc.ExpectedURL {
+				t.Errorf("expected URL %q, got %q", tc.ExpectedURL, url)
+			}
+		})
+	}
+}
+
+// TestExportImportPeerStates tests that peer states are exported from and
+// imported to the HTTP API of a running woodwatch.
+func TestExportImportPeerStates(t *testing.T) {
+	state := `[{"name":"LAN","network":"192.168.1.0/24","state":{"state":"Up"}}]` + "\n"
+	var imported string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /state", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, state)
+	})
+	mux.HandleFunc("PUT /state", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		imported = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	var buf bytes.Buffer
+	if err := exportPeerStates(srv.Client(), addr, &buf); err != nil {
+		t.Fatalf("expected exportPeerStates to return nil err, got %v", err)
+	}
+	if buf.String() != state {
+		t.Errorf("expected exported state %q, got %q", state, buf.String())
+	}
+
+	err := importPeerStates(srv.Client(), addr, "wrong", strings.NewReader(state))
+	if err == nil || !strings.Contains(err.Error(), "401 Unauthorized: invalid API key") {
+		t.Errorf("expected importPeerStates with the wrong key to return a 401 err, got %v", err)
+	}
+	if err := importPeerStates(srv.Client(), addr, "secret", strings.NewReader(state)); err != nil {
+		t.Fatalf("expected importPeerStates to return nil err, got %v", err)
+	}
+	if imported != state {
+		t.Errorf("expected imported state %q, got %q", state, imported)
+	}
+}
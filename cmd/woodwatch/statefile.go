@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/cpu/woodwatch"
+)
+
+// loadStateFile restores the server's peer states from the state file at the
+// given path. A state file that doesn't exist yet is not an error.
+func loadStateFile(server *woodwatch.Server, path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return server.LoadState(f)
+}
+
+// saveStateFile writes the server's peer states to the state file at the given
+// path. The states are written to a temporary file in the same directory that
+// is renamed over the state file so a partially written state file is never
+// left behind.
+func saveStateFile(server *woodwatch.Server, path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := server.SaveState(f); err != nil {
+		f.Close()
+
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cpu/woodwatch"
+)
+
+// TestStateFile tests that a missing state file is not an error and that a
+// saved state file can be loaded again.
+func TestStateFile(t *testing.T) {
+	server, err := woodwatch.NewServer(
+		woodwatch.WithLogger(log.New(io.Discard, "", 0)),
+		woodwatch.WithConfig(woodwatch.Config{
+			UpThreshold:   1,
+			DownThreshold: 1,
+			MonitorCycle:  "1s",
+			PeerTimeout:   "2s",
+			Peers: []woodwatch.PeerConfig{
+				{Name: "LAN", Network: "192.168.1.0/24"},
+			},
+		}))
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := loadStateFile(server, path); err != nil {
+		t.Fatalf("expected loadStateFile of missing file to return nil err, got %v", err)
+	}
+	if err := saveStateFile(server, path); err != nil {
+		t.Fatalf("expected saveStateFile to return nil err, got %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected state file to exist, got %v", err)
+	}
+	if err := loadStateFile(server, path); err != nil {
+		t.Fatalf("expected loadStateFile to return nil err, got %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("expected ReadDir to return nil err, got %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the state file to remain, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/cpu/woodwatch"
+)
+
+// validateConfig writes a warning for each of the configWarnings for the
+// given Config and every error from woodwatch.CheckConfig to the writer, one
+// per line. It returns true if the Config is valid. Warnings don't
+// make the Config invalid.
+func validateConfig(w io.Writer, c woodwatch.Config) bool {
+	for _, warning := range configWarnings(c) {
+		fmt.Fprintf(w, "warning: %s\n", warning)
+	}
+	if err := woodwatch.CheckConfig(c); err != nil {
+		// Valid() joins every error with a newline.
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(w, "error: %s\n", line)
+		}
+
+		return false
+	}
+	fmt.Fprintln(w, "config is valid")
+
+	return true
+}
+
+// parsePeerNetworks returns the parsed Network and Networks of the given
+// PeerConfig. Networks that can't be parsed are reported by Valid() and
+// skipped.
+func parsePeerNetworks(pc woodwatch.PeerConfig) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, network := range append([]string{pc.Network}, pc.Networks...) {
+		if _, parsed, err := net.ParseCIDR(network); err == nil {
+			networks = append(networks, parsed)
+		}
+	}
+
+	return networks
+}
+
+// configWarnings returns descriptions of the parts of the given Config that are
+// valid but likely mistakes: peers with overlapping networks, which are all
+// seen by a single host, and peers with an UpThreshold or
+// DownThreshold of zero.
+func configWarnings(c woodwatch.Config) []string {
+	var warnings []string
+	networks := make([][]*net.IPNet, len(c.Peers))
+	for i, pc := range c.Peers {
+		networks[i] = parsePeerNetworks(pc)
+	}
+	for i := range c.Peers {
+		for j := i + 1; j < len(c.Peers); j++ {
+			for _, a := range networks[i] {
+				for _, b := range networks[j] {
+					if !(a.Contains(b.IP) || b.Contains(a.IP)) {
+						continue
+					}
+					warnings = append(warnings, fmt.Sprintf(
+						"peers %q and %q have overlapping networks %q and %q",
+						c.Peers[i].Name, c.Peers[j].Name, a, b))
+				}
+			}
+		}
+	}
+
+	for _, pc := range c.Peers {
+		upThreshold, downThreshold := pc.UpThreshold, pc.DownThreshold
+		if upThreshold == 0 {
+			upThreshold = c.UpThreshold
+		}
+		if downThreshold == 0 {
+			downThreshold = c.DownThreshold
+		}
+		if upThreshold == 0 {
+			warnings = append(warnings, fmt.Sprintf("peer %q has an UpThreshold of 0", pc.Name))
+		}
+		if downThreshold == 0 {
+			warnings = append(warnings, fmt.Sprintf("peer %q has a DownThreshold of 0", pc.Name))
+		}
+	}
+
+	return warnings
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cpu/woodwatch"
+)
+
+// TestConfigWarnings tests that configWarnings warns about overlapping peer
+// networks and zero thresholds.
+func TestConfigWarnings(t *testing.T) {
+	testCases := []struct {
+		Name             string
+		Config           woodwatch.Config
+		ExpectedWarnings []string
+	}{
+		{
+			Name: "No warnings",
+			Config: woodwatch.Config{
+				UpThreshold:   1,
+				DownThreshold: 1,
+				Peers: []woodwatch.PeerConfig{
+					{Name: "A", Network: "192.168.1.0/24"},
+					{Name: "B", Network: "192.168.2.0/24"},
+				},
+			},
+		},
+		{
+			Name: "Overlapping networks",
+			Config: woodwatch.Config{
+				UpThreshold:   1,
+				DownThreshold: 1,
+				Peers: []woodwatch.PeerConfig{
+					{Name: "A", Network: "192.168.0.0/16"},
+					{Name: "B", Network: "10.0.0.0/8"},
+					{Name: "C", Network: "192.168.2.1/32"},
+					{Name: "D", Network: "not a network"},
+				},
+			},
+			ExpectedWarnings: []string{
+				`peers "A" and "C" have overlapping networks "192.168.0.0/16" and "192.168.2.1/32"`,
+			},
+		},
+		{
+			Name: "Zero thresholds",
+			Config: woodwatch.Config{
+				DownThreshold: 1,
+				Peers: []woodwatch.PeerConfig{
+					{Name: "A", Network: "192.168.1.0/24", UpThreshold: 2},
+					{Name: "B", Network: "192.168.2.0/24", DownThreshold: 2},
+				},
+			},
+			ExpectedWarnings: []string{
+				`peer "B" has an UpThreshold of 0`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if warnings := configWarnings(tc.Config); !reflect.DeepEqual(warnings, tc.ExpectedWarnings) {
+				t.Errorf("expected warnings %q, got %q", tc.ExpectedWarnings, warnings)
+			}
+		})
+	}
+}
+
+// TestValidateConfig tests that validateConfig writes warnings and every error
+// and only returns true for valid configs.
+func TestValidateConfig(t *testing.T) {
+	var buf bytes.Buffer
+	if !validateConfig(&buf, exampleConfig()) {
+		t.Errorf("expected example config to be valid, got output %q", buf.String())
+	}
+
+	buf.Reset()
+	invalid := woodwatch.Config{
+		ListenNetwork: "bogus",
+		MonitorCycle:  "bogus",
+		PeerTimeout:   "2s",
+		Peers:         []woodwatch.PeerConfig{{Name: "A", Network: "192.168.1.0/24"}},
+	}
+	if validateConfig(&buf, invalid) {
+		t.Errorf("expected invalid config to be invalid")
+	}
+	output := buf.String()
+	for _, expected := range []string{
+		`warning: peer "A" has an UpThreshold of 0`,
+		`warning: peer "A" has a DownThreshold of 0`,
+		"error: " + woodwatch.ErrInvalidListenNetwork.Error(),
+		`error: time: invalid duration "bogus"`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected output to contain %q, got %q", expected, output)
+		}
+	}
+
+	// Peers with overlapping networks are valid but can't be loaded by
+	// a Server when they share a protocol.
+	buf.Reset()
+	overlapping := exampleConfig()
+	overlapping.Peers = append(overlapping.Peers, woodwatch.PeerConfig{
+		Name:    "Host",
+		Network: "192.168.1.1/32",
+	})
+	if validateConfig(&buf, overlapping) {
+		t.Errorf("expected config with overlapping networks to be invalid")
+	}
+	if expected := "error: " + woodwatch.ErrOverlappingPeerNetworks.Error(); !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected output to contain %q, got %q", expected, buf.String())
+	}
+}
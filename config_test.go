@@ -1,16 +1,35 @@
 package woodwatch
 
 import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/cpu/woodwatch/internal/webhook"
 )
 
 func TestPeerConfigValid(t *testing.T) {
 	testCases := []struct {
-		Name          string
-		InputName     string
-		InputNetwork  string
-		ExpectedError error
+		Name               string
+		InputName          string
+		InputNetwork       string
+		InputNetworks      []string
+		InputTags          []string
+		InputLabels        map[string]string
+		InputProtocols     []string
+		InputTimeout       string
+		InputInitialState  string
+		InputMonitorType   string
+		InputTCPPort       uint16
+		InputProbeMode     bool
+		InputProbeInterval string
+		ExpectedError      error
 	}{
 		{
 			Name:          "Empty peer name",
@@ -21,20 +40,297 @@ func TestPeerConfigValid(t *testing.T) {
 			InputName:     "not-empty",
 			ExpectedError: ErrNoPeerNetwork,
 		},
+		{
+			Name:          "Network without prefix length",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.1",
+			ExpectedError: ErrInvalidPeerNetwork,
+		},
+		{
+			Name:          "Malformed network",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.300.0/24",
+			ExpectedError: ErrInvalidPeerNetwork,
+		},
+		{
+			Name:          "Only empty networks",
+			InputName:     "not-empty",
+			InputNetworks: []string{""},
+			ExpectedError: ErrNoPeerNetwork,
+		},
+		{
+			Name:          "Malformed additional network",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.0/24",
+			InputNetworks: []string{"10.0.0.0/33"},
+			ExpectedError: ErrInvalidPeerNetwork,
+		},
+		{
+			Name:          "Valid peer with networks",
+			InputName:     "not-empty",
+			InputNetworks: []string{"192.168.1.0/24", "10.0.0.0/8"},
+		},
+		{
+			Name:          "Valid peer with network and networks",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.0/24",
+			InputNetworks: []string{"10.0.0.0/8"},
+		},
+		{
+			Name:          "Too many tags",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.0/24",
+			InputTags:     make([]string, maxPeerTags+1),
+			ExpectedError: ErrTooManyPeerTags,
+		},
+		{
+			Name:          "Tag with invalid characters",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.0/24",
+			InputTags:     []string{"production", "ams 1"},
+			ExpectedError: ErrInvalidPeerTag,
+		},
+		{
+			Name:          "Empty tag",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.0/24",
+			InputTags:     []string{""},
+			ExpectedError: ErrInvalidPeerTag,
+		},
+		{
+			Name:          "Tag too long",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.0/24",
+			InputTags:     []string{strings.Repeat("a", 65)},
+			ExpectedError: ErrInvalidPeerTag,
+		},
 		{
 			Name:         "Valid peer",
 			InputName:    "not-empty",
-			InputNetwork: "not-empty",
+			InputNetwork: "192.168.1.0/24",
+		},
+		{
+			Name:         "Valid peer with tags",
+			InputName:    "not-empty",
+			InputNetwork: "192.168.1.0/24",
+			InputTags:    []string{"production", "ams1", "tier_1", "tier-1"},
+		},
+		{
+			Name:         "Valid peer with labels",
+			InputName:    "not-empty",
+			InputNetwork: "192.168.1.0/24",
+			InputLabels:  map[string]string{"region": "eu-west", "_tier2": "", "ISP": "x y"},
+		},
+		{
+			Name:          "Label name with invalid characters",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.0/24",
+			InputLabels:   map[string]string{"region": "eu-west", "service-level": "gold"},
+			ExpectedError: ErrInvalidPeerLabel,
+		},
+		{
+			Name:          "Label name starting with a digit",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.0/24",
+			InputLabels:   map[string]string{"1region": "eu-west"},
+			ExpectedError: ErrInvalidPeerLabel,
+		},
+		{
+			Name:          "Reserved label name",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.0/24",
+			InputLabels:   map[string]string{"__name__": "eu-west"},
+			ExpectedError: ErrInvalidPeerLabel,
+		},
+		{
+			Name:          "Peer label name",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.0/24",
+			InputLabels:   map[string]string{"peer": "eu-west"},
+			ExpectedError: ErrInvalidPeerLabel,
+		},
+		{
+			Name:          "Empty label name",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.0/24",
+			InputLabels:   map[string]string{"": "eu-west"},
+			ExpectedError: ErrInvalidPeerLabel,
+		},
+		{
+			Name:           "Unknown protocol",
+			InputName:      "not-empty",
+			InputNetwork:   "192.168.1.0/24",
+			InputProtocols: []string{"icmp", "udp:9999"},
+			ExpectedError:  ErrInvalidPeerProtocol,
+		},
+		{
+			Name:           "TCP protocol without port",
+			InputName:      "not-empty",
+			InputNetwork:   "192.168.1.0/24",
+			InputProtocols: []string{"tcp:"},
+			ExpectedError:  ErrInvalidPeerProtocol,
+		},
+		{
+			Name:           "TCP protocol with invalid port",
+			InputName:      "not-empty",
+			InputNetwork:   "192.168.1.0/24",
+			InputProtocols: []string{"tcp:65536"},
+			ExpectedError:  ErrInvalidPeerProtocol,
+		},
+		{
+			Name:           "TCP protocol with non-host network",
+			InputName:      "not-empty",
+			InputNetwork:   "192.168.1.0/24",
+			InputProtocols: []string{"icmp", "tcp:9999"},
+			ExpectedError:  ErrTCPPeerNetworkNotHost,
+		},
+		{
+			Name:           "Valid peer with protocols",
+			InputName:      "not-empty",
+			InputNetwork:   "192.168.1.1/32",
+			InputProtocols: []string{"icmp", "tcp:9999"},
+		},
+		{
+			Name:          "Invalid peer timeout",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.0/24",
+			InputTimeout:  "soon",
+			ExpectedError: ErrInvalidPeerTimeout,
+		},
+		{
+			Name:          "Negative peer timeout",
+			InputName:     "not-empty",
+			InputNetwork:  "192.168.1.0/24",
+			InputTimeout:  "-5s",
+			ExpectedError: ErrInvalidPeerTimeout,
+		},
+		{
+			Name:         "Valid peer with timeout",
+			InputName:    "not-empty",
+			InputNetwork: "192.168.1.0/24",
+			InputTimeout: "60s",
+		},
+		{
+			Name:              "Invalid initial state",
+			InputName:         "not-empty",
+			InputNetwork:      "192.168.1.0/24",
+			InputInitialState: "sideways",
+			ExpectedError:     ErrInvalidInitialState,
+		},
+		{
+			Name:              "Valid peer with initial state up",
+			InputName:         "not-empty",
+			InputNetwork:      "192.168.1.0/24",
+			InputInitialState: InitialStateUp,
+		},
+		{
+			Name:              "Valid peer with initial state down",
+			InputName:         "not-empty",
+			InputNetwork:      "192.168.1.0/24",
+			InputInitialState: InitialStateDown,
+		},
+		{
+			Name:             "Invalid monitor type",
+			InputName:        "not-empty",
+			InputNetwork:     "192.168.1.0/24",
+			InputMonitorType: "udp",
+			ExpectedError:    ErrInvalidMonitorType,
+		},
+		{
+			Name:             "TCP monitor type without port",
+			InputName:        "not-empty",
+			InputNetwork:     "192.168.1.1/32",
+			InputMonitorType: MonitorTypeTCP,
+			ExpectedError:    ErrNoTCPPort,
+		},
+		{
+			Name:             "TCP monitor type with network",
+			InputName:        "not-empty",
+			InputNetwork:     "192.168.1.0/24",
+			InputMonitorType: MonitorTypeTCP,
+			InputTCPPort:     22,
+			ExpectedError:    ErrTCPPeerNetworkNotHost,
+		},
+		{
+			Name:             "TCP monitor type with multiple networks",
+			InputName:        "not-empty",
+			InputNetwork:     "192.168.1.1/32",
+			InputNetworks:    []string{"192.168.1.2/32"},
+			InputMonitorType: MonitorTypeTCP,
+			InputTCPPort:     22,
+			ExpectedError:    ErrTCPPeerNetworkNotHost,
+		},
+		{
+			Name:             "Valid peer with TCP monitor type",
+			InputName:        "not-empty",
+			InputNetwork:     "192.168.1.1/32",
+			InputMonitorType: MonitorTypeTCP,
+			InputTCPPort:     22,
+		},
+		{
+			Name:             "Valid IPv6 peer with TCP monitor type",
+			InputName:        "not-empty",
+			InputNetwork:     "2001:db8::1/128",
+			InputMonitorType: MonitorTypeTCP,
+			InputTCPPort:     22,
+		},
+		{
+			Name:             "Valid peer with ICMP monitor type",
+			InputName:        "not-empty",
+			InputNetwork:     "192.168.1.0/24",
+			InputMonitorType: MonitorTypeICMP,
+		},
+		{
+			Name:               "Valid peer with probe mode",
+			InputName:          "not-empty",
+			InputNetwork:       "192.168.1.1/32",
+			InputProbeMode:     true,
+			InputProbeInterval: "5s",
+		},
+		{
+			Name:               "Invalid probe interval",
+			InputName:          "not-empty",
+			InputNetwork:       "192.168.1.1/32",
+			InputProbeMode:     true,
+			InputProbeInterval: "0s",
+			ExpectedError:      ErrInvalidProbeInterval,
+		},
+		{
+			Name:             "Probe mode with TCP monitor type",
+			InputName:        "not-empty",
+			InputNetwork:     "192.168.1.1/32",
+			InputMonitorType: MonitorTypeTCP,
+			InputTCPPort:     22,
+			InputProbeMode:   true,
+			ExpectedError:    ErrProbeModeWithoutICMP,
+		},
+		{
+			Name:           "Probe mode without ICMP protocol",
+			InputName:      "not-empty",
+			InputNetwork:   "192.168.1.1/32",
+			InputProtocols: []string{"tcp:9999"},
+			InputProbeMode: true,
+			ExpectedError:  ErrProbeModeWithoutICMP,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
 			p := PeerConfig{
-				Name:    tc.InputName,
-				Network: tc.InputNetwork,
+				Name:          tc.InputName,
+				Network:       tc.InputNetwork,
+				Networks:      tc.InputNetworks,
+				Tags:          tc.InputTags,
+				Labels:        tc.InputLabels,
+				Protocols:     tc.InputProtocols,
+				PeerTimeout:   tc.InputTimeout,
+				InitialState:  tc.InputInitialState,
+				MonitorType:   tc.InputMonitorType,
+				TCPPort:       tc.InputTCPPort,
+				ProbeMode:     tc.InputProbeMode,
+				ProbeInterval: tc.InputProbeInterval,
 			}
-			if err := p.Valid(); err != tc.ExpectedError {
+			if err := p.Valid(); !errors.Is(err, tc.ExpectedError) {
 				t.Errorf("expected Valid() to return %v, got %v",
 					tc.ExpectedError, err)
 			}
@@ -46,25 +342,77 @@ func TestConfigValid(t *testing.T) {
 	validPeers := []PeerConfig{
 		{
 			Name:    "test",
-			Network: "test",
+			Network: "192.168.1.0/24",
 		},
 	}
 	testCases := []struct {
 		Name                       string
+		ListenNetwork              string
 		Peers                      []PeerConfig
 		MonitorCycle               string
+		MonitorCycleJitter         string
+		MonitorJitter              string
 		PeerTimeout                string
+		StartupGracePeriod         string
+		WebhookFormat              string
+		DedupWindow                string
+		WebhookWorkers             int
+		PacketBufSize              int
+		FilterICMPTypes            []int
+		WebhookTimeout             string
+		PeerLockTimeout            string
+		MaxReconnectAttempts       int
+		ReconnectBackoff           string
+		APIRateLimit               float64
+		APIBurstLimit              int
+		LogLevel                   string
+		WebhookProxyURL            string
+		WebhookTLSCertFile         string
 		ExpectedErrorMessagePrefix string
 	}{
 		{
 			Name:                       "No peers",
 			ExpectedErrorMessagePrefix: ErrTooFewPeers.Error(),
 		},
+		{
+			Name:                       "Invalid listen network",
+			ListenNetwork:              "udp",
+			Peers:                      validPeers,
+			ExpectedErrorMessagePrefix: ErrInvalidListenNetwork.Error(),
+		},
+		{
+			Name:                       "Invalid webhook format",
+			Peers:                      validPeers,
+			WebhookFormat:              "teams",
+			ExpectedErrorMessagePrefix: ErrInvalidWebhookFormat.Error() + `: "teams"`,
+		},
+		{
+			Name:                       "Multiple errors",
+			ListenNetwork:              "udp",
+			MonitorCycle:               "aaaa",
+			ExpectedErrorMessagePrefix: ErrInvalidListenNetwork.Error() + "\n" + ErrTooFewPeers.Error() + "\ntime: invalid duration",
+		},
+		{
+			Name:                       "IPv6 peer with IPv4 listen network",
+			Peers:                      []PeerConfig{{Name: "v6", Network: "2001:db8::/32"}},
+			ExpectedErrorMessagePrefix: ErrPeerNetworkFamily.Error(),
+		},
+		{
+			Name:                       "IPv4 peer with IPv6 listen network",
+			ListenNetwork:              ListenNetworkIPv6,
+			Peers:                      []PeerConfig{{Name: "v4", Network: "192.168.1.0/24"}},
+			ExpectedErrorMessagePrefix: ErrPeerNetworkFamily.Error(),
+		},
 		{
 			Name:                       "Invalid peer",
 			Peers:                      []PeerConfig{{}},
 			ExpectedErrorMessagePrefix: ErrNoPeerName.Error(),
 		},
+		{
+			Name:                       "Peer with malformed network",
+			Peers:                      []PeerConfig{{Name: "bad", Network: "10.0.0.0/33"}},
+			ExpectedErrorMessagePrefix: ErrInvalidPeerNetwork.Error() + `: "bad": invalid CIDR address`,
+		},
 		{
 			Name:                       "Invalid monitor cycle",
 			Peers:                      validPeers,
@@ -78,20 +426,290 @@ func TestConfigValid(t *testing.T) {
 			PeerTimeout:                "aaaa",
 			ExpectedErrorMessagePrefix: "time: invalid duration",
 		},
+		{
+			Name:                       "Monitor cycle too short",
+			Peers:                      validPeers,
+			MonitorCycle:               "99ms",
+			PeerTimeout:                "10s",
+			ExpectedErrorMessagePrefix: ErrMonitorCycleTooShort.Error() + `: "99ms" is shorter than 100ms`,
+		},
+		{
+			Name:                       "Peer timeout too short",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "999ms",
+			ExpectedErrorMessagePrefix: ErrPeerTimeoutTooShort.Error() + `: "999ms" is shorter than 1s`,
+		},
+		{
+			Name:                       "Peer with peer timeout too short",
+			Peers:                      []PeerConfig{{Name: "test", Network: "192.168.1.0/24", PeerTimeout: "500ms"}},
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			ExpectedErrorMessagePrefix: ErrPeerTimeoutTooShort.Error() + `: "500ms" is shorter than 1s`,
+		},
+		{
+			Name:         "Minimum monitor cycle and peer timeout",
+			Peers:        validPeers,
+			MonitorCycle: "100ms",
+			PeerTimeout:  "1s",
+		},
+		{
+			Name:                       "Invalid monitor cycle jitter",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			MonitorCycleJitter:         "aaaa",
+			ExpectedErrorMessagePrefix: "time: invalid duration",
+		},
+		{
+			Name:                       "Monitor cycle jitter too long",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			MonitorCycleJitter:         "1m",
+			ExpectedErrorMessagePrefix: ErrMonitorCycleJitterTooLong.Error(),
+		},
+		{
+			Name:                       "Invalid monitor jitter",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			MonitorJitter:              "aaaa",
+			ExpectedErrorMessagePrefix: "time: invalid duration",
+		},
+		{
+			Name:                       "Monitor jitter too long",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			MonitorJitter:              "2m",
+			ExpectedErrorMessagePrefix: ErrMonitorJitterTooLong.Error(),
+		},
+		{
+			Name:                       "Invalid startup grace period",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			StartupGracePeriod:         "aaaa",
+			ExpectedErrorMessagePrefix: "time: invalid duration",
+		},
 		{
 			Name:         "Valid config",
 			MonitorCycle: "1m",
 			PeerTimeout:  "10s",
 			Peers:        validPeers,
 		},
+		{
+			Name:          "Valid IPv6 config",
+			ListenNetwork: ListenNetworkIPv6,
+			MonitorCycle:  "1m",
+			PeerTimeout:   "10s",
+			Peers:         []PeerConfig{{Name: "v6", Network: "2001:db8::/32"}},
+		},
+		{
+			Name:          "Valid dual-stack config",
+			ListenNetwork: ListenNetworkBoth,
+			MonitorCycle:  "1m",
+			PeerTimeout:   "10s",
+			Peers: []PeerConfig{
+				{Name: "v4", Network: "192.168.1.0/24"},
+				{Name: "v6", Network: "2001:db8::/32"},
+			},
+		},
+		{
+			Name:               "Valid config with monitor cycle jitter",
+			MonitorCycle:       "1m",
+			MonitorCycleJitter: "10s",
+			PeerTimeout:        "10s",
+			Peers:              validPeers,
+		},
+		{
+			Name:          "Valid config with monitor jitter",
+			MonitorCycle:  "1m",
+			MonitorJitter: "10s",
+			PeerTimeout:   "10s",
+			Peers:         validPeers,
+		},
+		{
+			Name:                       "Invalid dedup window",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			DedupWindow:                "aaaa",
+			ExpectedErrorMessagePrefix: "time: invalid duration",
+		},
+		{
+			Name:                       "Negative webhook workers",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			WebhookWorkers:             -1,
+			ExpectedErrorMessagePrefix: ErrInvalidWebhookQueue.Error(),
+		},
+		{
+			Name:                       "Negative packet buffer size",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			PacketBufSize:              -1,
+			ExpectedErrorMessagePrefix: ErrInvalidPacketBufSize.Error(),
+		},
+		{
+			Name:                       "Packet buffer size too small",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			PacketBufSize:              4,
+			ExpectedErrorMessagePrefix: ErrInvalidPacketBufSize.Error(),
+		},
+		{
+			Name:                       "Invalid ICMP type",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			FilterICMPTypes:            []int{8, 256},
+			ExpectedErrorMessagePrefix: ErrInvalidICMPType.Error(),
+		},
+		{
+			Name:                       "Negative webhook timeout",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			WebhookTimeout:             "-5s",
+			ExpectedErrorMessagePrefix: ErrInvalidWebhookTimeout.Error() + `: "-5s"`,
+		},
+		{
+			Name:                       "Zero peer lock timeout",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			PeerLockTimeout:            "0s",
+			ExpectedErrorMessagePrefix: ErrInvalidPeerLockTimeout.Error() + `: "0s"`,
+		},
+		{
+			Name:            "Valid config with peer lock timeout",
+			Peers:           validPeers,
+			MonitorCycle:    "1m",
+			PeerTimeout:     "10s",
+			PeerLockTimeout: "10s",
+		},
+		{
+			Name:                       "Negative max reconnect attempts",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			MaxReconnectAttempts:       -1,
+			ExpectedErrorMessagePrefix: ErrInvalidMaxReconnectAttempts.Error(),
+		},
+		{
+			Name:                       "Invalid reconnect backoff",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			MaxReconnectAttempts:       3,
+			ReconnectBackoff:           "soon",
+			ExpectedErrorMessagePrefix: ErrInvalidReconnectBackoff.Error() + `: "soon"`,
+		},
+		{
+			Name:                 "Valid config with reconnects",
+			Peers:                validPeers,
+			MonitorCycle:         "1m",
+			PeerTimeout:          "10s",
+			MaxReconnectAttempts: 3,
+			ReconnectBackoff:     "5s",
+		},
+		{
+			Name:                       "Negative API rate limit",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			APIRateLimit:               -1,
+			ExpectedErrorMessagePrefix: ErrInvalidAPIRateLimit.Error(),
+		},
+		{
+			Name:                       "Negative API burst limit",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			APIBurstLimit:              -1,
+			ExpectedErrorMessagePrefix: ErrInvalidAPIRateLimit.Error(),
+		},
+		{
+			Name:          "Valid config with API limits",
+			Peers:         validPeers,
+			MonitorCycle:  "1m",
+			PeerTimeout:   "10s",
+			APIRateLimit:  0.5,
+			APIBurstLimit: 5,
+		},
+		{
+			Name:                       "Invalid log level",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			LogLevel:                   "loud",
+			ExpectedErrorMessagePrefix: ErrInvalidLogLevel.Error() + `: "loud"`,
+		},
+		{
+			Name:         "Valid config with log level",
+			Peers:        validPeers,
+			MonitorCycle: "1m",
+			PeerTimeout:  "10s",
+			LogLevel:     LogLevelWarn,
+		},
+		{
+			Name:                       "Relative webhook proxy URL",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			WebhookProxyURL:            "proxy.example.com:3128",
+			ExpectedErrorMessagePrefix: webhook.ErrInvalidProxyURL.Error(),
+		},
+		{
+			Name:                       "Webhook TLS certificate without key",
+			Peers:                      validPeers,
+			MonitorCycle:               "1m",
+			PeerTimeout:                "10s",
+			WebhookTLSCertFile:         "client.pem",
+			ExpectedErrorMessagePrefix: webhook.ErrIncompleteTLSKeyPair.Error(),
+		},
+		{
+			Name:            "Valid config with webhook client options",
+			MonitorCycle:    "1m",
+			PeerTimeout:     "10s",
+			WebhookTimeout:  "5s",
+			WebhookProxyURL: "http://proxy.example.com:3128",
+			Peers:           validPeers,
+		},
+		{
+			Name:          "Valid config with Slack webhook format",
+			MonitorCycle:  "1m",
+			PeerTimeout:   "10s",
+			WebhookFormat: "slack",
+			Peers:         validPeers,
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
 			c := Config{
-				Peers:        tc.Peers,
-				MonitorCycle: tc.MonitorCycle,
-				PeerTimeout:  tc.PeerTimeout,
+				ListenNetwork:        tc.ListenNetwork,
+				Peers:                tc.Peers,
+				MonitorCycle:         tc.MonitorCycle,
+				MonitorCycleJitter:   tc.MonitorCycleJitter,
+				MonitorJitter:        tc.MonitorJitter,
+				PeerTimeout:          tc.PeerTimeout,
+				StartupGracePeriod:   tc.StartupGracePeriod,
+				WebhookFormat:        tc.WebhookFormat,
+				DedupWindow:          tc.DedupWindow,
+				WebhookWorkers:       tc.WebhookWorkers,
+				PacketBufSize:        tc.PacketBufSize,
+				FilterICMPTypes:      tc.FilterICMPTypes,
+				WebhookTimeout:       tc.WebhookTimeout,
+				PeerLockTimeout:      tc.PeerLockTimeout,
+				MaxReconnectAttempts: tc.MaxReconnectAttempts,
+				ReconnectBackoff:     tc.ReconnectBackoff,
+				APIRateLimit:         tc.APIRateLimit,
+				APIBurstLimit:        tc.APIBurstLimit,
+				LogLevel:             tc.LogLevel,
+				WebhookProxyURL:      tc.WebhookProxyURL,
+				WebhookTLSCertFile:   tc.WebhookTLSCertFile,
 			}
 			if err := c.Valid(); err != nil && tc.ExpectedErrorMessagePrefix == "" {
 				t.Errorf("expected Valid() to return nil err, got %v", err)
@@ -107,6 +725,141 @@ func TestConfigValid(t *testing.T) {
 	}
 }
 
+// TestConfigValidLoweredMinimums tests that Config.Valid() accepts durations
+// shorter than the default MinMonitorCycle and MinPeerTimeout when embedders
+// lower them.
+func TestConfigValidLoweredMinimums(t *testing.T) {
+	c := Config{
+		MonitorCycle: "10ms",
+		PeerTimeout:  "10ms",
+		Peers:        []PeerConfig{{Name: "test", Network: "192.168.1.0/24"}},
+	}
+	if err := c.Valid(); !errors.Is(err, ErrMonitorCycleTooShort) || !errors.Is(err, ErrPeerTimeoutTooShort) {
+		t.Fatalf("expected Valid() to return ErrMonitorCycleTooShort and ErrPeerTimeoutTooShort, got %v", err)
+	}
+
+	allowShortDurations(t)
+	if err := c.Valid(); err != nil {
+		t.Errorf("expected Valid() to return nil err with lowered minimums, got %v", err)
+	}
+}
+
+// allowShortDurations lowers MinMonitorCycle and MinPeerTimeout for tests that
+// run a Server with short durations and restores them when the test finishes.
+func allowShortDurations(t *testing.T) {
+	t.Helper()
+	minMonitorCycle, minPeerTimeout := MinMonitorCycle, MinPeerTimeout
+	MinMonitorCycle, MinPeerTimeout = time.Millisecond, time.Millisecond
+	t.Cleanup(func() {
+		MinMonitorCycle, MinPeerTimeout = minMonitorCycle, minPeerTimeout
+	})
+}
+
+// TestConfigMarshal tests that a Config marshalled with Marshal is loaded
+// back into an equal Config by LoadConfig.
+func TestConfigMarshal(t *testing.T) {
+	recoverFromPanics := false
+	testCases := []struct {
+		Name   string
+		Config Config
+	}{
+		{
+			Name: "Empty config",
+		},
+		{
+			Name: "Full config",
+			Config: Config{
+				ListenNetwork:     ListenNetworkBoth,
+				UpThreshold:       2,
+				DownThreshold:     3,
+				FlappingThreshold: 4,
+				MonitorCycle:      "1m",
+				MonitorJitter:     "500ms",
+				PeerTimeout:       "2m",
+				Webhooks:          []string{"https://hooks.example.com/woodwatch"},
+				WebhookFormat:     "slack",
+				RecoverFromPanics: &recoverFromPanics,
+				EventDedup:        true,
+				MaxHistory:        10,
+				Peers: []PeerConfig{
+					{
+						Name:        "LAN",
+						Network:     "192.168.1.0/24",
+						Networks:    []string{"10.0.0.0/8"},
+						UpThreshold: 1,
+						Tags:        []string{"production"},
+						Protocols:   []string{"icmp", "tcp:9999"},
+						MaintenanceWindows: []MaintenanceWindow{
+							{Start: "02:00", End: "04:00", Days: []string{"Sat"}},
+						},
+					},
+					{
+						Name:        "TCP",
+						Network:     "2001:db8::1/128",
+						MonitorType: "tcp",
+						TCPPort:     443,
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			data, err := tc.Config.Marshal()
+			if err != nil {
+				t.Fatalf("expected Marshal to return nil err, got %v", err)
+			}
+			c, err := LoadConfig(data)
+			if err != nil {
+				t.Fatalf("expected LoadConfig to return nil err, got %v", err)
+			}
+			if !reflect.DeepEqual(c, tc.Config) {
+				t.Errorf("expected config %#v, got %#v", tc.Config, c)
+			}
+		})
+	}
+}
+
+// TestConfigValidMultipleErrors tests that Config.Valid() and
+// PeerConfig.Valid() return every problem at once, one per line.
+func TestConfigValidMultipleErrors(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1m",
+		PeerTimeout:  "soon",
+		Peers: []PeerConfig{
+			{Network: "192.168.1.0/24"},
+			{Name: "bad-network", Network: "10.0.0.0/33"},
+			{
+				Name:        "bad-fields",
+				Network:     "192.168.2.0/24",
+				Tags:        []string{"not a tag"},
+				PeerTimeout: "-5s",
+			},
+		},
+	}
+	err := c.Valid()
+	if err == nil {
+		t.Fatalf("expected Valid() to return err, got nil")
+	}
+	expectedErrors := []error{
+		ErrNoPeerName,
+		ErrInvalidPeerNetwork,
+		ErrInvalidPeerTag,
+		ErrInvalidPeerTimeout,
+	}
+	for _, expected := range expectedErrors {
+		if !errors.Is(err, expected) {
+			t.Errorf("expected Valid() error to include %v, got %v", expected, err)
+		}
+	}
+	// One line for each of the peer errors and one for the PeerTimeout.
+	if lines := strings.Split(err.Error(), "\n"); len(lines) != len(expectedErrors)+1 {
+		t.Errorf("expected Valid() error to have %d lines, got %d: %v",
+			len(expectedErrors)+1, len(lines), err)
+	}
+}
+
 func TestLoadConfig(t *testing.T) {
 	exampleConfig := `
 	{
@@ -165,3 +918,246 @@ func TestLoadConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadConfigJSON5(t *testing.T) {
+	exampleConfig := `
+	// A comment
+	{
+		UpThreshold: 10,
+		/* Another comment */
+		"DownThreshold": 10,
+		Peers: [
+			{
+				Name: 'ISP A',
+				Network: "8.8.8.0/24", // trailing comment
+				DownThreshold: 2,
+			},
+		],
+	}
+`
+	testCases := []struct {
+		Name           string
+		Input          []byte
+		ExpectedConfig Config
+		ExpectErr      bool
+	}{
+		{
+			Name:      "Invalid input",
+			Input:     []byte("{"),
+			ExpectErr: true,
+		},
+		{
+			Name:  "Empty input",
+			Input: []byte("{}"),
+		},
+		{
+			Name:  "Example config",
+			Input: []byte(exampleConfig),
+			ExpectedConfig: Config{
+				UpThreshold:   10,
+				DownThreshold: 10,
+				Peers: []PeerConfig{
+					{
+						Name:          "ISP A",
+						Network:       "8.8.8.0/24",
+						DownThreshold: 2,
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			c, err := LoadConfigJSON5(tc.Input)
+			if err != nil && !tc.ExpectErr {
+				t.Fatalf("Expected no err, got %v", err)
+			} else if err == nil && tc.ExpectErr {
+				t.Fatalf("Expected err got nil")
+			} else if err == nil && !reflect.DeepEqual(c, tc.ExpectedConfig) {
+				t.Errorf("Expected config %#v got %#v", tc.ExpectedConfig, c)
+			}
+		})
+	}
+}
+
+// TestLoadConfigFile tests that LoadConfigFile picks the config format based on
+// the file extension and that the JSON, JSON5, YAML and TOML example configs
+// are equal.
+func TestLoadConfigFile(t *testing.T) {
+	jsonConfig, err := LoadConfigFile("example.config.json")
+	if err != nil {
+		t.Fatalf("Expected no err loading JSON example config, got %v", err)
+	}
+	json5Config, err := LoadConfigFile("example.config.json5")
+	if err != nil {
+		t.Fatalf("Expected no err loading JSON5 example config, got %v", err)
+	}
+	if !reflect.DeepEqual(jsonConfig, json5Config) {
+		t.Errorf("Expected JSON5 example config %#v to equal JSON example config %#v",
+			json5Config, jsonConfig)
+	}
+	yamlConfig, err := LoadConfigFile("example.config.yaml")
+	if err != nil {
+		t.Fatalf("Expected no err loading YAML example config, got %v", err)
+	}
+	if !reflect.DeepEqual(jsonConfig, yamlConfig) {
+		t.Errorf("Expected YAML example config %#v to equal JSON example config %#v",
+			yamlConfig, jsonConfig)
+	}
+	tomlConfig, err := LoadConfigFile("example.config.toml")
+	if err != nil {
+		t.Fatalf("Expected no err loading TOML example config, got %v", err)
+	}
+	if !reflect.DeepEqual(jsonConfig, tomlConfig) {
+		t.Errorf("Expected TOML example config %#v to equal JSON example config %#v",
+			tomlConfig, jsonConfig)
+	}
+
+	// A JSONC file with comments should load, the same content with a JSON
+	// extension should not.
+	dir := t.TempDir()
+	data := []byte(`{ /* comment */ "UpThreshold": 1 }`)
+	for _, name := range []string{"config.jsonc", "config.json"} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatalf("error writing %q: %v", name, err)
+		}
+	}
+	if _, err := LoadConfigFile(filepath.Join(dir, "config.jsonc")); err != nil {
+		t.Errorf("Expected no err loading JSONC config, got %v", err)
+	}
+	if _, err := LoadConfigFile(filepath.Join(dir, "config.json")); err == nil {
+		t.Errorf("Expected err loading JSON config with comments, got nil")
+	}
+}
+
+// TestLoadConfigYAML tests that LoadConfigYAML loads configs with the same keys
+// as JSON configs and returns errors for invalid YAML.
+func TestLoadConfigYAML(t *testing.T) {
+	c, err := LoadConfigYAML([]byte(`
+UpThreshold: 10
+RecoverFromPanics: false
+Peers:
+  - Name: ISP A
+    Network: 8.8.8.0/24
+    Tags: [production]
+`))
+	if err != nil {
+		t.Fatalf("Expected no err loading YAML config, got %v", err)
+	}
+	recoverFromPanics := false
+	expected := Config{
+		UpThreshold:       10,
+		RecoverFromPanics: &recoverFromPanics,
+		Peers: []PeerConfig{
+			{Name: "ISP A", Network: "8.8.8.0/24", Tags: []string{"production"}},
+		},
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("Expected YAML config %#v, got %#v", expected, c)
+	}
+
+	if _, err := LoadConfigYAML([]byte("Peers: [")); err == nil {
+		t.Errorf("Expected err loading invalid YAML, got nil")
+	}
+
+	// LoadConfigFileYAML loads YAML regardless of the file extension.
+	path := filepath.Join(t.TempDir(), "config.conf")
+	if err := os.WriteFile(path, []byte("UpThreshold: 1\n"), 0600); err != nil {
+		t.Fatalf("error writing %q: %v", path, err)
+	}
+	if c, err := LoadConfigFileYAML(path); err != nil || c.UpThreshold != 1 {
+		t.Errorf("Expected YAML config file with UpThreshold 1, got %#v, %v", c, err)
+	}
+}
+
+// TestCheckConfig tests that CheckConfig returns the errors from Valid() and
+// the errors from loading the peers.
+func TestCheckConfig(t *testing.T) {
+	c := Config{
+		MonitorCycle: "2s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "First", Network: "10.0.0.0/8"},
+			{Name: "Second", Network: "192.168.1.0/24"},
+		},
+	}
+	if err := CheckConfig(c); err != nil {
+		t.Errorf("Expected no err from CheckConfig, got %v", err)
+	}
+
+	c.PeerTimeout = ""
+	if err := CheckConfig(c); err == nil {
+		t.Errorf("Expected err from CheckConfig of invalid config, got nil")
+	}
+
+	c.PeerTimeout = "2s"
+	c.Peers = append(c.Peers, PeerConfig{Name: "Third", Network: "10.1.0.0/16"})
+	if err := c.Valid(); err != nil {
+		t.Errorf("Expected no err from Valid, got %v", err)
+	}
+	if err := CheckConfig(c); !errors.Is(err, ErrOverlappingPeerNetworks) {
+		t.Errorf("Expected err %v from CheckConfig, got %v", ErrOverlappingPeerNetworks, err)
+	}
+}
+
+// TestLoadConfigTOML tests that a Config round-trips through TOML with
+// snake_case keys and that LoadConfigTOML returns errors for invalid TOML and
+// unknown keys.
+func TestLoadConfigTOML(t *testing.T) {
+	recoverFromPanics := false
+	expected := Config{
+		ListenNetwork:     ListenNetworkBoth,
+		UpThreshold:       10,
+		MonitorCycle:      "5s",
+		PeerTimeout:       "10s",
+		NATSUseJetStream:  true,
+		RecoverFromPanics: &recoverFromPanics,
+		Webhooks:          []string{"https://hooks.example.com/woodwatch"},
+		Peers: []PeerConfig{
+			{
+				Name:                "ISP A",
+				Network:             "8.8.8.0/24",
+				Tags:                []string{"production"},
+				PagerDutyRoutingKey: "key",
+				TCPPort:             443,
+				MaintenanceWindows: []MaintenanceWindow{
+					{Start: "02:00", End: "04:00", Days: []string{"Sunday"}},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(expected); err != nil {
+		t.Fatalf("Expected no err encoding TOML config, got %v", err)
+	}
+	for _, key := range []string{"up_threshold", "nats_use_jetstream", "[[peers]]", "pagerduty_routing_key", "[[peers.maintenance_windows]]"} {
+		if !strings.Contains(buf.String(), key) {
+			t.Errorf("Expected TOML config to contain %q, got:\n%s", key, buf.String())
+		}
+	}
+	c, err := LoadConfigTOML(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Expected no err loading TOML config, got %v", err)
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("Expected TOML config %#v, got %#v", expected, c)
+	}
+
+	if _, err := LoadConfigTOML([]byte("peers = [")); err == nil {
+		t.Errorf("Expected err loading invalid TOML, got nil")
+	}
+	if _, err := LoadConfigTOML([]byte("UpThreshold = 1")); !errors.Is(err, ErrUnknownTOMLKey) {
+		t.Errorf("Expected err %v loading TOML with unknown key, got %v", ErrUnknownTOMLKey, err)
+	}
+
+	// LoadConfigFileTOML loads TOML regardless of the file extension.
+	path := filepath.Join(t.TempDir(), "config.conf")
+	if err := os.WriteFile(path, []byte("up_threshold = 1\n"), 0600); err != nil {
+		t.Fatalf("error writing %q: %v", path, err)
+	}
+	if c, err := LoadConfigFileTOML(path); err != nil || c.UpThreshold != 1 {
+		t.Errorf("Expected TOML config file with UpThreshold 1, got %#v, %v", c, err)
+	}
+}
@@ -0,0 +1,90 @@
+package woodwatch
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ConfigDiff describes how the Peers of two Configs differ. Peers are matched
+// by Name.
+type ConfigDiff struct {
+	// Added are the PeerConfigs only in the new Config.
+	Added []PeerConfig
+	// Removed are the PeerConfigs only in the old Config.
+	Removed []PeerConfig
+	// Modified are the changes to PeerConfigs in both Configs that aren't
+	// equal.
+	Modified []PeerConfigChange
+}
+
+// PeerConfigChange is a PeerConfig that was changed between two Configs.
+type PeerConfigChange struct {
+	// Before is the PeerConfig in the old Config.
+	Before PeerConfig
+	// After is the PeerConfig in the new Config.
+	After PeerConfig
+}
+
+// DiffConfigs returns the peers added, removed and modified between the old
+// and new Configs, in the order they are configured.
+func DiffConfigs(old, new Config) ConfigDiff {
+	var diff ConfigDiff
+	oldPeers := make(map[string]PeerConfig, len(old.Peers))
+	for _, pc := range old.Peers {
+		oldPeers[pc.Name] = pc
+	}
+	newPeers := make(map[string]bool, len(new.Peers))
+	for _, pc := range new.Peers {
+		newPeers[pc.Name] = true
+		before, found := oldPeers[pc.Name]
+		if !found {
+			diff.Added = append(diff.Added, pc)
+		} else if !reflect.DeepEqual(before, pc) {
+			diff.Modified = append(diff.Modified, PeerConfigChange{Before: before, After: pc})
+		}
+	}
+	for _, pc := range old.Peers {
+		if !newPeers[pc.Name] {
+			diff.Removed = append(diff.Removed, pc)
+		}
+	}
+
+	return diff
+}
+
+// Lines returns a human-readable line for each peer added, removed or
+// modified, e.g. "modified Peer LAN: UpThreshold, Webhooks". Only the names of
+// the changed fields of modified peers are included since values like
+// Webhooks and WebhookSecret may be secret.
+func (d ConfigDiff) Lines() []string {
+	var lines []string
+	for _, pc := range d.Added {
+		lines = append(lines, fmt.Sprintf("added Peer %s - Network %s",
+			pc.Name, strings.Join(pc.networks(), ",")))
+	}
+	for _, pc := range d.Removed {
+		lines = append(lines, fmt.Sprintf("removed Peer %s - Network %s",
+			pc.Name, strings.Join(pc.networks(), ",")))
+	}
+	for _, change := range d.Modified {
+		lines = append(lines, fmt.Sprintf("modified Peer %s: %s",
+			change.After.Name, strings.Join(change.Fields(), ", ")))
+	}
+
+	return lines
+}
+
+// Fields returns the names of the PeerConfig fields that differ between the
+// Before and After PeerConfigs.
+func (c PeerConfigChange) Fields() []string {
+	var fields []string
+	before, after := reflect.ValueOf(c.Before), reflect.ValueOf(c.After)
+	for i := 0; i < before.NumField(); i++ {
+		if !reflect.DeepEqual(before.Field(i).Interface(), after.Field(i).Interface()) {
+			fields = append(fields, before.Type().Field(i).Name)
+		}
+	}
+
+	return fields
+}
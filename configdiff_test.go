@@ -0,0 +1,125 @@
+package woodwatch
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestDiffConfigs tests that DiffConfigs returns the peers added, removed and
+// modified between two Configs.
+func TestDiffConfigs(t *testing.T) {
+	lan := PeerConfig{Name: "LAN", Network: "192.168.1.0/24"}
+	vpn := PeerConfig{Name: "VPN", Network: "10.0.0.0/8"}
+	lanChanged := PeerConfig{
+		Name:        "LAN",
+		Network:     "192.168.1.0/24",
+		UpThreshold: 3,
+		Webhooks:    []string{"https://example.com/secret"},
+	}
+
+	testCases := []struct {
+		Name          string
+		Old           []PeerConfig
+		New           []PeerConfig
+		Expected      ConfigDiff
+		ExpectedLines []string
+	}{
+		{
+			Name: "Unchanged",
+			Old:  []PeerConfig{lan, vpn},
+			New:  []PeerConfig{lan, vpn},
+		},
+		{
+			Name:          "Added",
+			Old:           []PeerConfig{lan},
+			New:           []PeerConfig{lan, vpn},
+			Expected:      ConfigDiff{Added: []PeerConfig{vpn}},
+			ExpectedLines: []string{"added Peer VPN - Network 10.0.0.0/8"},
+		},
+		{
+			Name:          "Removed",
+			Old:           []PeerConfig{lan, vpn},
+			New:           []PeerConfig{vpn},
+			Expected:      ConfigDiff{Removed: []PeerConfig{lan}},
+			ExpectedLines: []string{"removed Peer LAN - Network 192.168.1.0/24"},
+		},
+		{
+			Name: "Modified",
+			Old:  []PeerConfig{lan, vpn},
+			New:  []PeerConfig{lanChanged, vpn},
+			Expected: ConfigDiff{
+				Modified: []PeerConfigChange{{Before: lan, After: lanChanged}},
+			},
+			ExpectedLines: []string{"modified Peer LAN: UpThreshold, Webhooks"},
+		},
+		{
+			Name: "Added, removed and modified",
+			Old:  []PeerConfig{lan},
+			New:  []PeerConfig{vpn, lanChanged},
+			Expected: ConfigDiff{
+				Added:    []PeerConfig{vpn},
+				Modified: []PeerConfigChange{{Before: lan, After: lanChanged}},
+			},
+			ExpectedLines: []string{
+				"added Peer VPN - Network 10.0.0.0/8",
+				"modified Peer LAN: UpThreshold, Webhooks",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			diff := DiffConfigs(Config{Peers: tc.Old}, Config{Peers: tc.New})
+			if !reflect.DeepEqual(diff, tc.Expected) {
+				t.Errorf("expected DiffConfigs to return %#v, got %#v", tc.Expected, diff)
+			}
+			if lines := diff.Lines(); !reflect.DeepEqual(lines, tc.ExpectedLines) {
+				t.Errorf("expected Lines to return %q, got %q", tc.ExpectedLines, lines)
+			}
+		})
+	}
+}
+
+// TestReloadLogsDiff tests that Server.Reload logs the diff between the current
+// and new Configs without logging secret values.
+func TestReloadLogsDiff(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+			{Name: "VPN", Network: "10.0.0.0/8"},
+		},
+	}
+	var buf bytes.Buffer
+	s, err := NewServerFromConfig(log.New(&buf, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	buf.Reset()
+
+	c.Peers = []PeerConfig{
+		{Name: "LAN", Network: "192.168.1.0/24", WebhookSecret: "hunter2"},
+		{Name: "Lab", Network: "172.16.0.0/12"},
+	}
+	if err := s.Reload(c); err != nil {
+		t.Fatalf("expected Reload to return nil err, got %v", err)
+	}
+
+	logged := buf.String()
+	for _, line := range []string{
+		"added Peer Lab - Network 172.16.0.0/12\n",
+		"removed Peer VPN - Network 10.0.0.0/8\n",
+		"modified Peer LAN: WebhookSecret\n",
+	} {
+		if !strings.Contains(logged, line) {
+			t.Errorf("expected Reload to log %q, got:\n%s", line, logged)
+		}
+	}
+	if strings.Contains(logged, "hunter2") {
+		t.Errorf("expected Reload not to log the WebhookSecret, got:\n%s", logged)
+	}
+}
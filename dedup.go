@@ -0,0 +1,44 @@
+package woodwatch
+
+import (
+	"time"
+
+	"github.com/cpu/woodwatch/internal/webhook"
+)
+
+// dispatchedEvent describes the most recent event dispatched for a peer.
+type dispatchedEvent struct {
+	// state is the NewState of the event.
+	state string
+	// at is when the event was dispatched.
+	at time.Time
+}
+
+// duplicateEvent returns true if event deduplication is enabled and the most
+// recent event dispatched for the event's peer had the same NewState within
+// the Server's dedupWindow, or at any time if there is no dedupWindow.
+// Otherwise the event is recorded as the most recent event dispatched for its
+// peer and false is returned.
+func (s *Server) duplicateEvent(event webhook.Event) bool {
+	if !s.eventDedup {
+		return false
+	}
+
+	s.dedupMu.Lock()
+	defer s.dedupMu.Unlock()
+
+	last, found := s.lastDispatched[event.Peer]
+	if found && last.state == event.NewState &&
+		(s.dedupWindow == 0 || event.Timestamp.Sub(last.at) < s.dedupWindow) {
+		return true
+	}
+	if s.lastDispatched == nil {
+		s.lastDispatched = make(map[string]dispatchedEvent)
+	}
+	s.lastDispatched[event.Peer] = dispatchedEvent{
+		state: event.NewState,
+		at:    event.Timestamp,
+	}
+
+	return false
+}
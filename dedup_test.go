@@ -0,0 +1,126 @@
+package woodwatch
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/cpu/woodwatch/internal/webhook"
+)
+
+// TestDuplicateEvent tests that duplicateEvent only reports events with the
+// same peer and NewState as the most recently dispatched event for the peer,
+// within the dedup window.
+func TestDuplicateEvent(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	event := func(peer, state string, after time.Duration) webhook.Event {
+		return webhook.Event{Peer: peer, NewState: state, Timestamp: start.Add(after)}
+	}
+
+	testCases := []struct {
+		Name        string
+		Dedup       bool
+		Window      time.Duration
+		Events      []webhook.Event
+		ExpectedDup []bool
+	}{
+		{
+			Name:        "Disabled",
+			Events:      []webhook.Event{event("A", "Down", 0), event("A", "Down", 0)},
+			ExpectedDup: []bool{false, false},
+		},
+		{
+			Name:  "Enabled without window",
+			Dedup: true,
+			Events: []webhook.Event{
+				event("A", "Down", 0),
+				event("A", "Down", time.Hour),
+				event("B", "Down", time.Hour),
+				event("A", "Up", time.Hour),
+				event("A", "Down", time.Hour),
+			},
+			ExpectedDup: []bool{false, true, false, false, false},
+		},
+		{
+			Name:   "Enabled with window",
+			Dedup:  true,
+			Window: time.Minute,
+			Events: []webhook.Event{
+				event("A", "Down", 0),
+				event("A", "Down", 30*time.Second),
+				event("A", "Down", 2*time.Minute),
+				event("A", "Down", 150*time.Second),
+			},
+			ExpectedDup: []bool{false, true, false, true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			s := &Server{eventDedup: tc.Dedup, dedupWindow: tc.Window}
+			for i, e := range tc.Events {
+				if dup := s.duplicateEvent(e); dup != tc.ExpectedDup[i] {
+					t.Errorf("expected event %d duplicate to be %v, got %v",
+						i, tc.ExpectedDup[i], dup)
+				}
+			}
+		})
+	}
+}
+
+// TestCheckPeerEventDedup tests that checkPeer doesn't dispatch an event
+// identical to the last event dispatched for the peer when EventDedup is
+// enabled.
+func TestCheckPeerEventDedup(t *testing.T) {
+	c := Config{
+		UpThreshold:   1,
+		DownThreshold: 1,
+		MonitorCycle:  "1s",
+		PeerTimeout:   "2s",
+		EventDedup:    true,
+		DedupWindow:   "1h",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		return now
+	}
+	events := s.Subscribe()
+
+	// An Up event was already dispatched for the peer, e.g. before a reload.
+	s.lastDispatched = map[string]dispatchedEvent{
+		"LAN": {state: "Up", at: now.Add(-time.Minute)},
+	}
+
+	// Down -> Maybe Up -> Up is suppressed.
+	p := s.peers[0]
+	p.lastSeen = now
+	s.checkPeer(context.Background(), p)
+	s.checkPeer(context.Background(), p)
+	select {
+	case event := <-events:
+		t.Fatalf("expected duplicate Up event to be suppressed, got %#v", event)
+	default:
+	}
+
+	// Up -> Maybe Down -> Down isn't.
+	now = now.Add(time.Minute)
+	s.checkPeer(context.Background(), p)
+	s.checkPeer(context.Background(), p)
+	select {
+	case event := <-events:
+		if event.NewState != "Down" {
+			t.Errorf("expected Down event, got %q", event.NewState)
+		}
+	default:
+		t.Fatalf("expected Down event, got none")
+	}
+}
@@ -0,0 +1,189 @@
+package woodwatch
+
+import (
+	"context"
+	"fmt"
+	"runtime/trace"
+
+	"github.com/cpu/woodwatch/internal/webhook"
+)
+
+var (
+	// defaultWebhookQueueDepth is how many dispatches can be queued when the
+	// Config has no WebhookQueueDepth.
+	defaultWebhookQueueDepth = 100
+	// defaultWebhookWorkers is how many dispatches are made at once when the
+	// Config has no WebhookWorkers.
+	defaultWebhookWorkers = 4
+)
+
+// dispatchJob is an event queued to be dispatched to a webhook.Dispatcher or
+// published to a webhook.Publisher.
+type dispatchJob struct {
+	// ctx is the context the dispatch was queued with. It is used for the
+	// dispatch's execution trace region.
+	ctx context.Context
+	// dispatcher is the webhook.Dispatcher the event is dispatched to. It is
+	// nil if the event is published to the publisher instead.
+	dispatcher webhook.Dispatcher
+	// publisher is the webhook.Publisher the event is published to, if the
+	// job has no dispatcher.
+	publisher webhook.Publisher
+	// target describes the dispatcher or publisher in logged dispatch errors,
+	// e.g. the webhook URL.
+	target string
+	// event is the event to dispatch.
+	event webhook.Event
+}
+
+// dispatchTo queues the event to be dispatched to the given
+// webhook.Dispatcher by the Server's dispatch workers so that a slow or
+// failing dispatcher doesn't hold up monitoring or the other dispatchers. The
+// workers are started the first time dispatchTo is called. If the queue is
+// full the dispatch is dropped, logged and counted. Dispatches are also
+// dropped while the Server is draining or closing. Dispatch errors are logged
+// with the given target describing the dispatcher.
+func (s *Server) dispatchTo(
+	ctx context.Context,
+	dispatcher webhook.Dispatcher,
+	target string,
+	event webhook.Event) {
+	s.queueDispatch(dispatchJob{
+		ctx:        ctx,
+		dispatcher: dispatcher,
+		target:     target,
+		event:      event,
+	})
+}
+
+// publishTo queues the event to be published to the given webhook.Publisher
+// by the Server's dispatch workers. Publishes share the queue and workers of
+// webhook dispatches so they are dropped and counted when the queue is full,
+// waited for when draining and stopped with the dispatch workers.
+func (s *Server) publishTo(ctx context.Context, pub webhook.Publisher, event webhook.Event) {
+	s.queueDispatch(dispatchJob{
+		ctx:       ctx,
+		publisher: pub,
+		target:    publisherTarget(pub),
+		event:     event,
+	})
+}
+
+// publisherTarget describes the given webhook.Publisher in logged dispatch
+// errors, e.g. "AMQP".
+func publisherTarget(pub webhook.Publisher) string {
+	switch pub.(type) {
+	case *webhook.AMQPPublisher:
+		return "AMQP"
+	case *webhook.NATSPublisher:
+		return "NATS"
+	default:
+		return fmt.Sprintf("%T", pub)
+	}
+}
+
+// queueDispatch queues the given dispatchJob as described by dispatchTo.
+func (s *Server) queueDispatch(job dispatchJob) {
+	s.dispatchMu.Lock()
+	defer s.dispatchMu.Unlock()
+
+	// Dispatches made while the Server is draining or closing are dropped.
+	if s.dispatchStopped || s.dispatchDraining {
+		return
+	}
+	if s.dispatchQueue == nil {
+		s.startDispatchWorkers()
+	}
+
+	s.dispatchPending.Add(1)
+	select {
+	case s.dispatchQueue <- job:
+	default:
+		s.dispatchPending.Done()
+		s.dispatchesDropped.Add(1)
+		s.warnf("webhook queue is full, dropping event %q for %q\n",
+			job.event.Title, job.target)
+	}
+}
+
+// startDispatchWorkers creates the Server's dispatch queue and starts its
+// dispatch workers. The caller must hold the dispatchMu.
+func (s *Server) startDispatchWorkers() {
+	depth := s.webhookQueueDepth
+	if depth == 0 {
+		depth = defaultWebhookQueueDepth
+	}
+	workers := s.webhookWorkers
+	if workers == 0 {
+		workers = defaultWebhookWorkers
+	}
+
+	s.dispatchQueue = make(chan dispatchJob, depth)
+	s.dispatchCtx, s.dispatchCancel = context.WithCancel(context.Background())
+	s.dispatchWG.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.dispatchWorker()
+	}
+}
+
+// dispatchWorker dispatches queued events until the Server's dispatch workers
+// are stopped.
+func (s *Server) dispatchWorker() {
+	defer s.dispatchWG.Done()
+	for {
+		select {
+		case <-s.dispatchCtx.Done():
+			return
+		case job := <-s.dispatchQueue:
+			s.dispatch(job)
+			s.dispatchPending.Done()
+		}
+	}
+}
+
+// dispatch dispatches the event of the given dispatchJob, logging any error.
+// Dispatches are cancelled when the Server's dispatch workers are stopped.
+// Jobs with a publisher publish the event with publish instead.
+func (s *Server) dispatch(job dispatchJob) {
+	if job.publisher != nil {
+		defer trace.StartRegion(job.ctx, "publish").End()
+		s.publish(job.publisher, job.event)
+
+		return
+	}
+	activity := "dispatching webhook for peer " + job.event.Peer
+	if job.event.Peer == "" {
+		activity = "dispatching all down webhook"
+	}
+	defer s.recoverPanic(activity)
+	defer trace.StartRegion(job.ctx, "webhookDispatch").End()
+
+	if err := job.dispatcher.Dispatch(s.dispatchCtx, job.event); err != nil {
+		s.logDispatchError(job.event, job.target, err)
+	}
+}
+
+// stopDispatchWorkers stops the Server's dispatch workers, cancelling any
+// dispatches in progress, and waits for them to return. Queued dispatches
+// that weren't started are dropped and logged. Later dispatches are dropped.
+func (s *Server) stopDispatchWorkers() {
+	s.dispatchMu.Lock()
+	s.dispatchStopped = true
+	started := s.dispatchQueue != nil
+	s.dispatchMu.Unlock()
+	if !started {
+		return
+	}
+
+	s.dispatchCancel()
+	s.dispatchWG.Wait()
+	if queued := len(s.dispatchQueue); queued > 0 {
+		s.warnf("dropping %d queued webhook dispatches\n", queued)
+	}
+	// No more dispatches can be queued so empty the queue, so that a drain
+	// waiting for the dropped dispatches returns.
+	for len(s.dispatchQueue) > 0 {
+		<-s.dispatchQueue
+		s.dispatchPending.Done()
+	}
+}
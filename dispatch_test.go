@@ -0,0 +1,151 @@
+package woodwatch
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cpu/woodwatch/internal/webhook"
+)
+
+// blockingDispatcher is a webhook.Dispatcher that counts its dispatches and
+// blocks each one until it is released or the context is done.
+type blockingDispatcher struct {
+	started    chan struct{}
+	release    chan struct{}
+	dispatched atomic.Int32
+}
+
+// Dispatch signals that a dispatch started and blocks until the dispatcher is
+// released or the context is done.
+func (d *blockingDispatcher) Dispatch(ctx context.Context, e webhook.Event) error {
+	d.started <- struct{}{}
+	select {
+	case <-d.release:
+		d.dispatched.Add(1)
+
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// blockingPublisher is a webhook.Publisher that counts its publishes and
+// blocks each one until it is released.
+type blockingPublisher struct {
+	started   chan struct{}
+	release   chan struct{}
+	published atomic.Int32
+}
+
+// Publish signals that a publish started and blocks until the publisher is
+// released.
+func (p *blockingPublisher) Publish(e webhook.Event) error {
+	p.started <- struct{}{}
+	<-p.release
+	p.published.Add(1)
+
+	return nil
+}
+
+// Close does nothing.
+func (p *blockingPublisher) Close() error {
+	return nil
+}
+
+// TestPublishQueue tests that publishes are made by the Server's dispatch
+// workers and are dropped and counted when the queue is full, like webhook
+// dispatches.
+func TestPublishQueue(t *testing.T) {
+	s := &Server{
+		log:               log.New(io.Discard, "", 0),
+		webhookQueueDepth: 1,
+		webhookWorkers:    1,
+	}
+	pub := &blockingPublisher{
+		started: make(chan struct{}, 3),
+		release: make(chan struct{}),
+	}
+	event := webhook.Event{Peer: "LAN", Title: "Peer LAN is Down"}
+
+	// The first publish is started by the only worker, the second is queued
+	// and the third is dropped.
+	s.publishTo(context.Background(), pub, event)
+	select {
+	case <-pub.started:
+	case <-time.After(time.Second):
+		t.Fatalf("expected publish to start, it didn't")
+	}
+	s.publishTo(context.Background(), pub, event)
+	s.publishTo(context.Background(), pub, event)
+	if dropped := s.dispatchesDropped.Load(); dropped != 1 {
+		t.Errorf("expected 1 dropped publish, got %d", dropped)
+	}
+
+	// Releasing the first publish lets the queued one start.
+	pub.release <- struct{}{}
+	select {
+	case <-pub.started:
+	case <-time.After(time.Second):
+		t.Fatalf("expected queued publish to start, it didn't")
+	}
+	pub.release <- struct{}{}
+	s.stopDispatchWorkers()
+	if published := pub.published.Load(); published != 2 {
+		t.Errorf("expected 2 publishes, got %d", published)
+	}
+}
+
+// TestDispatchQueue tests that dispatches are made by the Server's dispatch
+// workers, that dispatches are dropped and counted when the queue is full and
+// that stopping the workers cancels dispatches in progress.
+func TestDispatchQueue(t *testing.T) {
+	s := &Server{
+		log:               log.New(io.Discard, "", 0),
+		webhookQueueDepth: 1,
+		webhookWorkers:    1,
+	}
+	d := &blockingDispatcher{
+		started: make(chan struct{}, 3),
+		release: make(chan struct{}),
+	}
+	event := webhook.Event{Peer: "LAN", Title: "Peer LAN is Down"}
+
+	// The first dispatch is started by the only worker.
+	s.dispatchTo(context.Background(), d, "test", event)
+	select {
+	case <-d.started:
+	case <-time.After(time.Second):
+		t.Fatalf("expected dispatch to start, it didn't")
+	}
+	// The second is queued and the third is dropped.
+	s.dispatchTo(context.Background(), d, "test", event)
+	s.dispatchTo(context.Background(), d, "test", event)
+	if dropped := s.dispatchesDropped.Load(); dropped != 1 {
+		t.Errorf("expected 1 dropped dispatch, got %d", dropped)
+	}
+
+	// Releasing the first dispatch lets the queued one start.
+	d.release <- struct{}{}
+	select {
+	case <-d.started:
+	case <-time.After(time.Second):
+		t.Fatalf("expected queued dispatch to start, it didn't")
+	}
+
+	// Stopping the workers cancels the dispatch in progress.
+	s.stopDispatchWorkers()
+	if dispatched := d.dispatched.Load(); dispatched != 1 {
+		t.Errorf("expected 1 completed dispatch, got %d", dispatched)
+	}
+	// Dispatches after the workers are stopped are dropped.
+	s.dispatchTo(context.Background(), d, "test", event)
+	select {
+	case <-d.started:
+		t.Errorf("expected dispatch after stopping to be dropped, it started")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
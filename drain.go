@@ -0,0 +1,50 @@
+package woodwatch
+
+import "context"
+
+// DrainAndClose gracefully stops the Server. New webhook dispatches and message
+// broker publishes are dropped while the ones that are queued or in progress
+// are waited for, then the Server is closed as with Close, which closes the
+// message broker connections after the publishes. If the given context is done
+// before the dispatches finish the remaining dispatches are cancelled by
+// closing the Server and the context's error, e.g. context.DeadlineExceeded,
+// is returned.
+// Otherwise the error from Close is returned. If DrainAndClose is called
+// before Listen it will return ErrServerNotListening.
+func (s *Server) DrainAndClose(ctx context.Context) error {
+	s.listenMu.Lock()
+	listening := s.conn != nil
+	s.listenMu.Unlock()
+	if !listening {
+		return ErrServerNotListening
+	}
+
+	drainErr := s.drainDispatches(ctx)
+	closeErr := s.Close()
+	if drainErr != nil {
+		return drainErr
+	}
+
+	return closeErr
+}
+
+// drainDispatches stops new webhook dispatches being queued and waits for the
+// queued and in progress dispatches to finish. If the given context is done
+// first its error is returned.
+func (s *Server) drainDispatches(ctx context.Context) error {
+	s.dispatchMu.Lock()
+	s.dispatchDraining = true
+	s.dispatchMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.dispatchPending.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,127 @@
+package woodwatch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/cpu/woodwatch/internal/webhook"
+)
+
+// TestDrainDispatches tests that draining waits for the queued and in progress
+// dispatches to finish, drops new dispatches and returns the context's error if
+// it is done first.
+func TestDrainDispatches(t *testing.T) {
+	s := &Server{
+		log:               log.New(io.Discard, "", 0),
+		webhookQueueDepth: 1,
+		webhookWorkers:    1,
+	}
+	defer s.stopDispatchWorkers()
+	d := &blockingDispatcher{
+		started: make(chan struct{}, 3),
+		release: make(chan struct{}),
+	}
+	event := webhook.Event{Peer: "LAN", Title: "Peer LAN is Down"}
+
+	// The first dispatch is started and the second is queued.
+	s.dispatchTo(context.Background(), d, "test", event)
+	select {
+	case <-d.started:
+	case <-time.After(time.Second):
+		t.Fatalf("expected dispatch to start, it didn't")
+	}
+	s.dispatchTo(context.Background(), d, "test", event)
+
+	// Draining times out while the dispatches are blocked.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.drainDispatches(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected drainDispatches to return %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	// Dispatches made while draining are dropped.
+	s.dispatchTo(context.Background(), d, "test", event)
+
+	// Releasing the dispatches lets the drain finish.
+	drained := make(chan error, 1)
+	go func() {
+		drained <- s.drainDispatches(context.Background())
+	}()
+	d.release <- struct{}{}
+	<-d.started
+	d.release <- struct{}{}
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("expected drainDispatches to return nil, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected drainDispatches to return, it didn't")
+	}
+	if dispatched := d.dispatched.Load(); dispatched != 2 {
+		t.Errorf("expected 2 dispatches, got %d", dispatched)
+	}
+}
+
+// TestDrainPublishes tests that draining waits for the queued and in progress
+// message broker publishes to finish.
+func TestDrainPublishes(t *testing.T) {
+	s := &Server{
+		log:               log.New(io.Discard, "", 0),
+		webhookQueueDepth: 1,
+		webhookWorkers:    1,
+	}
+	defer s.stopDispatchWorkers()
+	pub := &blockingPublisher{
+		started: make(chan struct{}, 2),
+		release: make(chan struct{}),
+	}
+	event := webhook.Event{Peer: "LAN", Title: "Peer LAN is Down"}
+
+	// The first publish is started and the second is queued.
+	s.publishTo(context.Background(), pub, event)
+	select {
+	case <-pub.started:
+	case <-time.After(time.Second):
+		t.Fatalf("expected publish to start, it didn't")
+	}
+	s.publishTo(context.Background(), pub, event)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.drainDispatches(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected drainDispatches to return %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- s.drainDispatches(context.Background())
+	}()
+	pub.release <- struct{}{}
+	<-pub.started
+	pub.release <- struct{}{}
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("expected drainDispatches to return nil, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected drainDispatches to return, it didn't")
+	}
+	if published := pub.published.Load(); published != 2 {
+		t.Errorf("expected 2 publishes, got %d", published)
+	}
+}
+
+// TestDrainAndCloseNotListening tests that DrainAndClose returns
+// ErrServerNotListening when the Server isn't listening.
+func TestDrainAndCloseNotListening(t *testing.T) {
+	s := &Server{log: log.New(io.Discard, "", 0)}
+	if err := s.DrainAndClose(context.Background()); !errors.Is(err, ErrServerNotListening) {
+		t.Errorf("expected DrainAndClose to return %v, got %v", ErrServerNotListening, err)
+	}
+}
@@ -0,0 +1,77 @@
+package woodwatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	// ErrUnsetEnvVar is returned (wrapped with the variable name) from
+	// LoadConfigWithEnv when a config value references an environment variable
+	// that isn't set.
+	ErrUnsetEnvVar = errors.New("Config references an unset environment variable")
+
+	// lookupEnv looks up environment variables for LoadConfigWithEnv. Tests
+	// replace it to avoid depending on the process environment.
+	lookupEnv = os.LookupEnv
+)
+
+// LoadConfigWithEnv loads a woodwatch.Config from the given JSON data bytes
+// like LoadConfig after substituting environment variables referenced as
+// ${VAR} or $VAR in the JSON string values, e.g. a Webhook of
+// "https://hooks.slack.com/services/${SLACK_TOKEN}". This keeps secrets out of
+// the config file. Variables that are set to the empty string are substituted
+// with it. If a value references a variable that isn't set ErrUnsetEnvVar is
+// returned wrapped with the variable's name.
+func LoadConfigWithEnv(data []byte) (Config, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Keep numbers as they were written rather than converting them to floats.
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return Config{}, err
+	}
+
+	var errs []error
+	doc = expandEnv(doc, &errs)
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, err
+	}
+
+	expanded, err := json.Marshal(doc)
+	if err != nil {
+		return Config{}, err
+	}
+
+	return LoadConfig(expanded)
+}
+
+// expandEnv returns the given decoded JSON value with environment variables
+// substituted in every string value, recursing into objects and arrays. An
+// error is appended to errs for each reference to an unset variable.
+func expandEnv(value interface{}, errs *[]error) interface{} {
+	switch v := value.(type) {
+	case string:
+		return os.Expand(v, func(name string) string {
+			val, found := lookupEnv(name)
+			if !found {
+				*errs = append(*errs, fmt.Errorf("%w: %q", ErrUnsetEnvVar, name))
+			}
+
+			return val
+		})
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = expandEnv(elem, errs)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = expandEnv(elem, errs)
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,74 @@
+package woodwatch
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestLoadConfigWithEnv tests that LoadConfigWithEnv substitutes environment
+// variables in string values and returns an error for unset variables.
+func TestLoadConfigWithEnv(t *testing.T) {
+	env := map[string]string{
+		"HOOK_HOST":  "hooks.example.com",
+		"HOOK_TOKEN": "s3cr3t",
+		"EMPTY":      "",
+	}
+	origLookupEnv := lookupEnv
+	lookupEnv = func(name string) (string, bool) {
+		val, found := env[name]
+
+		return val, found
+	}
+	defer func() {
+		lookupEnv = origLookupEnv
+	}()
+
+	c, err := LoadConfigWithEnv([]byte(`{
+		"UpThreshold": 3,
+		"MonitorCycle": "5s",
+		"Webhook": "https://${HOOK_HOST}/global",
+		"Peers": [
+			{
+				"Name": "LAN$EMPTY",
+				"Network": "192.168.1.0/24",
+				"Webhook": "https://$HOOK_HOST/lan?token=${HOOK_TOKEN}"
+			}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("expected LoadConfigWithEnv to return nil err, got %v", err)
+	}
+	if c.UpThreshold != 3 {
+		t.Errorf("expected UpThreshold 3, got %d", c.UpThreshold)
+	}
+	if expected := "https://hooks.example.com/global"; c.Webhook != expected {
+		t.Errorf("expected Webhook %q, got %q", expected, c.Webhook)
+	}
+	if len(c.Peers) != 1 {
+		t.Fatalf("expected 1 peer, got %d", len(c.Peers))
+	}
+	if c.Peers[0].Name != "LAN" {
+		t.Errorf("expected peer Name %q, got %q", "LAN", c.Peers[0].Name)
+	}
+	if expected := "https://hooks.example.com/lan?token=s3cr3t"; c.Peers[0].Webhook != expected {
+		t.Errorf("expected peer Webhook %q, got %q", expected, c.Peers[0].Webhook)
+	}
+
+	_, err = LoadConfigWithEnv([]byte(`{
+		"Webhook": "https://${UNSET_HOST}/global",
+		"Peers": [{"Name": "LAN", "Webhook": "$UNSET_TOKEN"}]
+	}`))
+	if !errors.Is(err, ErrUnsetEnvVar) {
+		t.Fatalf("expected LoadConfigWithEnv to return %v, got %v", ErrUnsetEnvVar, err)
+	}
+	for _, name := range []string{"UNSET_HOST", "UNSET_TOKEN"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("expected error to mention %q, got %v", name, err)
+		}
+	}
+
+	if _, err := LoadConfigWithEnv([]byte(`{`)); err == nil {
+		t.Errorf("expected LoadConfigWithEnv of invalid JSON to return err, got nil")
+	}
+}
@@ -0,0 +1,48 @@
+package woodwatch
+
+import "fmt"
+
+var (
+	// errorsBufferSize is the buffer size of the channel returned by
+	// Server.Errors.
+	errorsBufferSize = 16
+)
+
+// FatalError is sent to the Server's Errors channel when an error stops the
+// Server listening, e.g. when the ICMP PacketConn can't be opened because of
+// missing privileges or reading from it fails. It wraps the error that Listen
+// returns.
+type FatalError struct {
+	// Err is the error that stopped the Server listening.
+	Err error
+}
+
+// Error returns a description of the FatalError including the wrapped error.
+func (e *FatalError) Error() string {
+	return fmt.Sprintf("woodwatch stopped listening: %v", e.Err)
+}
+
+// Unwrap returns the wrapped error so that FatalErrors match it with
+// errors.Is.
+func (e *FatalError) Unwrap() error {
+	return e.Err
+}
+
+// Errors returns a buffered channel that receives the Server's errors so that
+// callers running Listen in its own goroutine can observe them. Errors that
+// stop the Server listening are sent as a *FatalError. Other errors, e.g.
+// failing to reload the config or to serve health checks, are sent as they
+// are and the Server keeps running. Errors are sent without blocking and are
+// dropped when the channel buffer is full. The channel is never closed.
+func (s *Server) Errors() <-chan error {
+	return s.errs
+}
+
+// reportError sends the given error to the Server's Errors channel without
+// blocking, dropping it if the channel buffer is full.
+func (s *Server) reportError(err error) {
+	select {
+	case s.errs <- err:
+	default:
+	}
+}
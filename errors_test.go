@@ -0,0 +1,68 @@
+package woodwatch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"golang.org/x/net/icmp"
+)
+
+// TestErrors tests that errors stopping the Server listening are sent to its
+// Errors channel as FatalErrors, that ErrServerAlreadyListening isn't, and
+// that errors are dropped rather than blocking when the channel buffer is
+// full.
+func TestErrors(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	s.listenAddress = ""
+
+	if err := s.Listen(context.Background()); err != ErrEmptyListenAddress {
+		t.Fatalf("expected Listen to return %v, got %v", ErrEmptyListenAddress, err)
+	}
+	select {
+	case err := <-s.Errors():
+		var fatal *FatalError
+		if !errors.As(err, &fatal) {
+			t.Errorf("expected a *FatalError, got %T", err)
+		}
+		if !errors.Is(err, ErrEmptyListenAddress) {
+			t.Errorf("expected error to wrap %v, got %v", ErrEmptyListenAddress, err)
+		}
+	default:
+		t.Fatalf("expected an error to be sent to Errors, got none")
+	}
+
+	// Listening again while already listening doesn't stop the Server so it
+	// isn't reported.
+	s.listenAddress = "whatever"
+	s.conn = &icmp.PacketConn{}
+	if err := s.Listen(context.Background()); err != ErrServerAlreadyListening {
+		t.Fatalf("expected Listen to return %v, got %v", ErrServerAlreadyListening, err)
+	}
+	select {
+	case err := <-s.Errors():
+		t.Errorf("expected no error to be sent to Errors, got %v", err)
+	default:
+	}
+	s.conn = nil
+
+	// Filling the buffer and then some doesn't block.
+	for i := 0; i < errorsBufferSize+1; i++ {
+		s.reportError(errors.New("test"))
+	}
+	if n := len(s.Errors()); n != errorsBufferSize {
+		t.Errorf("expected %d buffered errors, got %d", errorsBufferSize, n)
+	}
+}
@@ -0,0 +1,121 @@
+package woodwatch
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/cpu/woodwatch/internal/webhook"
+)
+
+const (
+	// defaultEventLogSize is how many events are kept in the Server's event log
+	// when the Config's EventLogSize is zero.
+	defaultEventLogSize = 1000
+)
+
+// eventLog is a fixed capacity ring buffer of the most recent events
+// dispatched by a Server. Once it is full adding an event replaces the
+// oldest.
+type eventLog struct {
+	// events holds the events. Its length is the capacity of the eventLog.
+	events []webhook.Event
+	// next is the index in events the next event is added at.
+	next int
+	// full indicates whether every index of events holds an event.
+	full bool
+}
+
+// newEventLog constructs an empty eventLog that keeps at most capacity events.
+func newEventLog(capacity uint) *eventLog {
+	return &eventLog{
+		events: make([]webhook.Event, capacity),
+	}
+}
+
+// add adds the given event to the eventLog, replacing the oldest event if the
+// eventLog is full.
+func (l *eventLog) add(e webhook.Event) {
+	if len(l.events) == 0 {
+		return
+	}
+	l.events[l.next] = e
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// since returns a copy of the events in the eventLog with a Timestamp after
+// the given time, oldest first.
+func (l *eventLog) since(from time.Time) []webhook.Event {
+	events := l.events[:l.next]
+	if l.full {
+		events = append(append([]webhook.Event{}, l.events[l.next:]...), events...)
+	}
+
+	replayed := []webhook.Event{}
+	for _, e := range events {
+		if e.Timestamp.After(from) {
+			replayed = append(replayed, e)
+		}
+	}
+
+	return replayed
+}
+
+// recordEvent adds the given dispatched event to the Server's event log,
+// creating the event log with the Server's eventLogSize the first time.
+func (s *Server) recordEvent(event webhook.Event) {
+	s.eventLogMu.Lock()
+	defer s.eventLogMu.Unlock()
+	if s.eventLog == nil {
+		size := s.eventLogSize
+		if size == 0 {
+			size = defaultEventLogSize
+		}
+		s.eventLog = newEventLog(size)
+	}
+	s.eventLog.add(event)
+}
+
+// ReplayEvents returns a copy of the most recent events the Server dispatched
+// with a Timestamp after the given time, oldest first, e.g. so that a webhook
+// receiver that was down can catch up on the events it missed. Only the
+// Server's most recent EventLogSize events are kept.
+func (s *Server) ReplayEvents(from time.Time) []webhook.Event {
+	s.eventLogMu.Lock()
+	defer s.eventLogMu.Unlock()
+	if s.eventLog == nil {
+		return []webhook.Event{}
+	}
+
+	return s.eventLog.since(from)
+}
+
+// handleEvents responds with the events returned by ReplayEvents as a JSON
+// array. The time to replay events from is given by the optional RFC 3339
+// "from" query parameter. Without it every kept event is returned. An invalid
+// "from" time gets a 400 Bad Request. Requests to upgrade to a WebSocket are
+// handled by handleEventStream instead.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if isWebSocketUpgrade(r) {
+		s.handleEventStream(w, r)
+
+		return
+	}
+	var from time.Time
+	if param := r.URL.Query().Get("from"); param != "" {
+		var err error
+		if from, err = time.Parse(time.RFC3339, param); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.ReplayEvents(from)); err != nil {
+		s.errorf("error writing events: %v\n", err)
+	}
+}
@@ -0,0 +1,182 @@
+package woodwatch
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cpu/woodwatch/internal/webhook"
+)
+
+// TestEventLog tests that an eventLog keeps only its most recent events and
+// returns those after a given time, oldest first.
+func TestEventLog(t *testing.T) {
+	event := func(i int) webhook.Event {
+		return webhook.Event{Timestamp: time.Unix(int64(i), 0)}
+	}
+
+	testCases := []struct {
+		Name     string
+		Capacity uint
+		Added    int
+		From     time.Time
+		Expected []webhook.Event
+	}{
+		{
+			Name:     "Empty",
+			Capacity: 3,
+			Expected: []webhook.Event{},
+		},
+		{
+			Name:     "Not full",
+			Capacity: 3,
+			Added:    2,
+			Expected: []webhook.Event{event(0), event(1)},
+		},
+		{
+			Name:     "Exactly full",
+			Capacity: 3,
+			Added:    3,
+			Expected: []webhook.Event{event(0), event(1), event(2)},
+		},
+		{
+			Name:     "Oldest evicted",
+			Capacity: 3,
+			Added:    5,
+			Expected: []webhook.Event{event(2), event(3), event(4)},
+		},
+		{
+			Name:     "From time",
+			Capacity: 3,
+			Added:    5,
+			From:     time.Unix(3, 0),
+			Expected: []webhook.Event{event(4)},
+		},
+		{
+			Name:     "Zero capacity",
+			Capacity: 0,
+			Added:    2,
+			Expected: []webhook.Event{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			l := newEventLog(tc.Capacity)
+			for i := 0; i < tc.Added; i++ {
+				l.add(event(i))
+			}
+			if events := l.since(tc.From); !reflect.DeepEqual(events, tc.Expected) {
+				t.Errorf("expected events %v, got %v", tc.Expected, events)
+			}
+		})
+	}
+}
+
+// TestReplayEvents tests that the Server's dispatched events are kept in its
+// event log, evicting the oldest once EventLogSize is exceeded, and served
+// over HTTP.
+func TestReplayEvents(t *testing.T) {
+	c := Config{
+		UpThreshold:   1,
+		DownThreshold: 1,
+		MonitorCycle:  "1s",
+		PeerTimeout:   "2s",
+		EventLogSize:  2,
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	// Use a fake clock that only advances when told to.
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	s.now = func() time.Time {
+		return now
+	}
+	p := s.peers[0]
+
+	if events := s.ReplayEvents(time.Time{}); len(events) != 0 {
+		t.Errorf("expected no events before any were dispatched, got %v", events)
+	}
+
+	// Up, Down and Up again are three noteworthy events, but only the last two
+	// are kept.
+	for _, seen := range []bool{true, false, true} {
+		now = now.Add(time.Minute)
+		if seen {
+			p.lastSeen = now
+		}
+		s.checkPeer(context.Background(), p)
+		s.checkPeer(context.Background(), p)
+	}
+	states := func(events []webhook.Event) []string {
+		states := []string{}
+		for _, e := range events {
+			states = append(states, e.NewState)
+		}
+
+		return states
+	}
+
+	testCases := []struct {
+		Name     string
+		From     time.Time
+		Expected []string
+	}{
+		{
+			Name:     "Every kept event",
+			Expected: []string{"Down", "Up"},
+		},
+		{
+			Name:     "Events after time",
+			From:     start.Add(2 * time.Minute),
+			Expected: []string{"Up"},
+		},
+		{
+			Name:     "No events after time",
+			From:     now,
+			Expected: []string{},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", s.handleEvents)
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if events := states(s.ReplayEvents(tc.From)); !reflect.DeepEqual(events, tc.Expected) {
+				t.Errorf("expected ReplayEvents to return events %v, got %v", tc.Expected, events)
+			}
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+				"/events?from="+tc.From.Format(time.RFC3339), nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected GET events to return %d, got %d", http.StatusOK, rec.Code)
+			}
+			var served []webhook.Event
+			if err := json.NewDecoder(rec.Body).Decode(&served); err != nil {
+				t.Fatalf("expected GET events to return a JSON body, got err %v", err)
+			}
+			if events := states(served); !reflect.DeepEqual(events, tc.Expected) {
+				t.Errorf("expected GET events to return events %v, got %v", tc.Expected, events)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events?from=yesterday", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected GET events with invalid time to return %d, got %d",
+			http.StatusBadRequest, rec.Code)
+	}
+}
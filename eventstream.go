@@ -0,0 +1,59 @@
+package woodwatch
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// isWebSocketUpgrade returns true if the given request asks to upgrade the
+// connection to a WebSocket.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// handleEventStream upgrades the request to a WebSocket and streams the events
+// returned by Subscribe to it as JSON messages, for live dashboards. Any
+// Origin is accepted since the stream is read-only. The WebSocket is closed
+// with a normal closure when the client goes away or the Server stops
+// listening.
+func (s *Server) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	websocket.Server{Handler: s.streamEvents}.ServeHTTP(w, r)
+}
+
+// streamEvents sends the Server's events to the given WebSocket until the
+// client closes it or the Server stops listening.
+func (s *Server) streamEvents(ws *websocket.Conn) {
+	// Closing the WebSocket sends a close frame with a normal closure status.
+	defer ws.Close()
+	events := s.Subscribe()
+	defer s.Unsubscribe(events)
+
+	// Messages from the client are discarded. Reading fails once the client
+	// closes the WebSocket or goes away.
+	clientGone := make(chan struct{})
+	go func() {
+		defer close(clientGone)
+		var msg []byte
+		for {
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-clientGone:
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := websocket.JSON.Send(ws, event); err != nil {
+				return
+			}
+		}
+	}
+}
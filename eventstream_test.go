@@ -0,0 +1,100 @@
+package woodwatch
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cpu/woodwatch/internal/webhook"
+	"golang.org/x/net/websocket"
+)
+
+// TestEventStream tests that a WebSocket client of /events receives the
+// Server's events as JSON messages and that the WebSocket is closed cleanly
+// when the Server stops listening.
+func TestEventStream(t *testing.T) {
+	s := &Server{log: log.New(io.Discard, "", 0)}
+	srv := httptest.NewServer(http.HandlerFunc(s.handleEvents))
+	defer srv.Close()
+
+	ws, err := websocket.Dial(
+		"ws"+strings.TrimPrefix(srv.URL, "http")+"/events", "", srv.URL)
+	if err != nil {
+		t.Fatalf("expected Dial to return nil err, got %v", err)
+	}
+	defer ws.Close()
+
+	// Wait for the stream to subscribe before dispatching an event.
+	for {
+		s.subscribersMu.Lock()
+		subscribed := len(s.subscribers) == 1
+		s.subscribersMu.Unlock()
+		if subscribed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	expected := webhook.Event{
+		Peer:      "LAN",
+		Title:     "Peer LAN is Down",
+		Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NewState:  "Down",
+		PrevState: "Maybe Down (1 of 1)",
+	}
+	s.notifySubscribers(expected)
+
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event webhook.Event
+	if err := websocket.JSON.Receive(ws, &event); err != nil {
+		t.Fatalf("expected to receive an event, got err %v", err)
+	}
+	if !event.Timestamp.Equal(expected.Timestamp) || event.Peer != expected.Peer ||
+		event.Title != expected.Title || event.NewState != expected.NewState ||
+		event.PrevState != expected.PrevState {
+		t.Errorf("expected event %#v, got %#v", expected, event)
+	}
+
+	// A close frame is read as io.EOF, unlike the connection being dropped.
+	s.closeSubscribers()
+	if err := websocket.JSON.Receive(ws, &event); err != io.EOF {
+		t.Errorf("expected the WebSocket to be closed cleanly with io.EOF, got %v", err)
+	}
+}
+
+// TestEventStreamClientClose tests that the stream unsubscribes from the
+// Server's events when the client closes the WebSocket.
+func TestEventStreamClientClose(t *testing.T) {
+	s := &Server{log: log.New(io.Discard, "", 0)}
+	srv := httptest.NewServer(http.HandlerFunc(s.handleEvents))
+	defer srv.Close()
+
+	ws, err := websocket.Dial(
+		"ws"+strings.TrimPrefix(srv.URL, "http")+"/events", "", srv.URL)
+	if err != nil {
+		t.Fatalf("expected Dial to return nil err, got %v", err)
+	}
+	if err := ws.Close(); err != nil {
+		t.Fatalf("expected Close to return nil err, got %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		// The subscribers map is created by the stream subscribing, which may
+		// not have happened yet when the client closed.
+		s.subscribersMu.Lock()
+		subscribers := len(s.subscribers)
+		unsubscribed := s.subscribers != nil && subscribers == 0
+		s.subscribersMu.Unlock()
+		if unsubscribed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the stream to unsubscribe, %d subscribers remain", subscribers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
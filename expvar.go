@@ -0,0 +1,46 @@
+package woodwatch
+
+import (
+	"expvar"
+)
+
+var (
+	// expvarMetrics is the "woodwatch" expvar.Map published at /debug/vars by
+	// expvar.Handler. It is shared by every Server in the process.
+	expvarMetrics = expvar.NewMap("woodwatch")
+	// expvarPacketsReceived counts the heartbeats received from peers by
+	// protocol, e.g. "icmp" for ICMP echo requests.
+	expvarPacketsReceived = new(expvar.Map)
+	// expvarTransitions counts the state changes of each peer by peer name.
+	expvarTransitions = new(expvar.Map)
+	// expvarPeerStates holds the current state of each peer by peer name.
+	expvarPeerStates = new(expvar.Map)
+)
+
+func init() {
+	expvarMetrics.Set("packetsReceived", expvarPacketsReceived)
+	expvarMetrics.Set("transitions", expvarTransitions)
+	expvarMetrics.Set("peerStates", expvarPeerStates)
+}
+
+// expvarPeerState records the given state as the current state of the peer
+// with the given name in the "woodwatch" expvar.Map. The peer's expvar.String
+// is reused once it has been created.
+func expvarPeerState(name, state string) {
+	if s, ok := expvarPeerStates.Get(name).(*expvar.String); ok {
+		s.Set(state)
+
+		return
+	}
+	s := new(expvar.String)
+	s.Set(state)
+	expvarPeerStates.Set(name, s)
+}
+
+// expvarRemovePeer deletes the transitions and state of the peer with the
+// given name from the "woodwatch" expvar.Map, e.g. once it is no longer
+// monitored.
+func expvarRemovePeer(name string) {
+	expvarTransitions.Delete(name)
+	expvarPeerStates.Delete(name)
+}
@@ -0,0 +1,113 @@
+package woodwatch
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+// expvarInt returns the value of the expvar.Int with the given key in the
+// given expvar.Map, or zero if there is none.
+func expvarInt(m *expvar.Map, key string) int64 {
+	v, ok := m.Get(key).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+
+	return v.Value()
+}
+
+// TestExpvar tests that the woodwatch expvars are updated when heartbeats are
+// received and peers are checked.
+func TestExpvar(t *testing.T) {
+	p, err := newPeer("ExpvarPeer", []string{"192.168.1.0/24"}, 1, 1, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("newPeer returned %v expected nil", err)
+	}
+	s := Server{
+		log:         log.New(io.Discard, "", 0),
+		peerTimeout: time.Minute,
+	}
+	s.setPeers([]*peer{p})
+	t.Cleanup(func() { expvarRemovePeer(p.Name) })
+
+	packets := expvarInt(expvarPacketsReceived, protocolICMP)
+	s.updatePeer(&net.IPAddr{IP: net.ParseIP("192.168.1.1")}, protocolICMP)
+	s.updatePeer(&net.IPAddr{IP: net.ParseIP("10.0.0.1")}, protocolICMP)
+	if got := expvarInt(expvarPacketsReceived, protocolICMP); got != packets+2 {
+		t.Errorf("expected %d packets received, got %d", packets+2, got)
+	}
+
+	// Down -> Maybe Up -> Up -> Up is two state changes.
+	for i := 0; i < 3; i++ {
+		s.checkPeer(context.Background(), p)
+	}
+	if got := expvarInt(expvarTransitions, p.Name); got != 2 {
+		t.Errorf("expected 2 transitions, got %d", got)
+	}
+	state := expvarPeerStates.Get(p.Name).(*expvar.String)
+	if state.Value() != "Up" {
+		t.Errorf("expected peer state %q, got %q", "Up", state.Value())
+	}
+	expvarPeerState(p.Name, "Down")
+	if expvarPeerStates.Get(p.Name) != state || state.Value() != "Down" {
+		t.Errorf("expected peer state expvar to be reused and set to %q, got %q",
+			"Down", state.Value())
+	}
+	if expvar.Get("woodwatch") == nil {
+		t.Errorf("expected woodwatch expvar to be published")
+	}
+}
+
+// TestExpvarRemovePeer tests that the expvars of peers are deleted when they
+// are removed or dropped by a reload, and kept for peers whose name is reused.
+func TestExpvarRemovePeer(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "ExpvarKept", Network: "192.168.1.0/24"},
+			{Name: "ExpvarMoved", Network: "192.168.2.0/24"},
+			{Name: "ExpvarDropped", Network: "192.168.3.0/24"},
+			{Name: "ExpvarRemoved", Network: "192.168.4.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	for _, pc := range c.Peers {
+		expvarTransitions.Add(pc.Name, 1)
+		expvarPeerState(pc.Name, "Up")
+		t.Cleanup(func() { expvarRemovePeer(pc.Name) })
+	}
+
+	if err := s.RemovePeer("ExpvarRemoved"); err != nil {
+		t.Fatalf("expected RemovePeer to return nil err, got %v", err)
+	}
+	c.Peers = []PeerConfig{
+		{Name: "ExpvarKept", Network: "192.168.1.0/24"},
+		{Name: "ExpvarMoved", Network: "192.168.20.0/24"},
+	}
+	if err := s.Reload(c); err != nil {
+		t.Fatalf("expected Reload to return nil err, got %v", err)
+	}
+
+	for name, kept := range map[string]bool{
+		"ExpvarKept":    true,
+		"ExpvarMoved":   true,
+		"ExpvarDropped": false,
+		"ExpvarRemoved": false,
+	} {
+		if (expvarTransitions.Get(name) != nil) != kept {
+			t.Errorf("expected peer %q to keep its transitions expvar: %v", name, kept)
+		}
+		if (expvarPeerStates.Get(name) != nil) != kept {
+			t.Errorf("expected peer %q to keep its state expvar: %v", name, kept)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package woodwatch
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	// healthShutdownTimeout is how long Server.Close waits for in-flight health
+	// check requests before closing the health check HTTP server.
+	healthShutdownTimeout = 2 * time.Second
+)
+
+// healthStatus is the JSON body of a health check response.
+type healthStatus struct {
+	// Status is "ok" if the Server is healthy or ready and "not ready" if it
+	// isn't ready.
+	Status string `json:"status"`
+	// Peers is how many peers the Server is monitoring.
+	Peers int `json:"peers"`
+}
+
+// HealthReport is a summary of the states of a Server's peers.
+type HealthReport struct {
+	// Total is how many peers the Server is monitoring.
+	Total int `json:"total"`
+	// Up is how many peers are Up.
+	Up int `json:"up"`
+	// Down is how many peers are Down.
+	Down int `json:"down"`
+	// Flapping is how many peers are Flapping.
+	Flapping int `json:"flapping"`
+	// Unknown is how many peers are in an intermediate state, e.g. "Maybe Up
+	// (1 of 2)".
+	Unknown int `json:"unknown"`
+	// Since is when the Server started listening. It is zero if the Server
+	// hasn't started listening.
+	Since time.Time `json:"since"`
+}
+
+// HealthReport returns a HealthReport counting the Server's peers in each
+// state.
+func (s *Server) HealthReport() HealthReport {
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+
+	report := HealthReport{
+		Total: len(s.peers),
+		Since: s.startedAt,
+	}
+	for _, p := range s.peers {
+		// Peers whose state can't be read are Unknown.
+		if !s.rLockPeer(p) {
+			report.Unknown++
+
+			continue
+		}
+		state := p.state.String()
+		p.lastSeenMu.RUnlock()
+
+		switch state {
+		case "Up":
+			report.Up++
+		case "Down":
+			report.Down++
+		case "Flapping":
+			report.Flapping++
+		default:
+			report.Unknown++
+		}
+	}
+
+	return report
+}
+
+// writeHealthStatus writes the given status code and a healthStatus with the
+// given status and the Server's peer count to the given http.ResponseWriter.
+func (s *Server) writeHealthStatus(w http.ResponseWriter, code int, status string) {
+	s.peersMu.RLock()
+	peers := len(s.peers)
+	s.peersMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(healthStatus{
+		Status: status,
+		Peers:  peers,
+	}); err != nil {
+		s.errorf("error writing health status: %v\n", err)
+	}
+}
+
+// handleHealthz responds to liveness probes. It always responds with a 200 OK
+// while the Server is listening. If the verbose query parameter is "1" the
+// body is the Server's HealthReport.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("verbose") == "1" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.HealthReport()); err != nil {
+			s.errorf("error writing health report: %v\n", err)
+		}
+
+		return
+	}
+	s.writeHealthStatus(w, http.StatusOK, "ok")
+}
+
+// handleReadyz responds to readiness probes. It responds with a 200 OK once
+// the Server has completed its first monitor cycle and a 503 Service
+// Unavailable before then.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !s.monitorCycleCompleted.Load() {
+		s.writeHealthStatus(w, http.StatusServiceUnavailable, "not ready")
+
+		return
+	}
+	s.writeHealthStatus(w, http.StatusOK, "ok")
+}
+
+// listenHealth starts an HTTP server serving the Server's health checks at
+// /healthz and /readyz, the Server's HealthReport at /healthz?verbose=1, the
+// status of the peers at /peers, optionally filtered by ?tags=a,b, and of each
+// peer at /peers/{name}, the history of each peer at /peers/{name}/history, the
+// acknowledgement of each peer at /peers/{name}/ack, the peer states written by
+// SaveState at /state and the events returned by ReplayEvents at /events on the
+// Server's health address. WebSocket requests to /events get a live stream of
+// events instead. POST requests to /peers/{name}/ack acknowledge the peer, POST
+// requests to /peers/{name}/heartbeat update the peer's last seen time and POST
+// requests to /peers/{name}/stats/reset and /stats/reset reset the statistics
+// of the peer or all peers. PUT requests to /state restore peer states with
+// LoadState. They must be authenticated with the Server's API key. Requests to
+// /peers, /stats and /state are rate limited by client IP. The health address
+// is updated with the address that was listened on, e.g. to include the port
+// when it was zero.
+func (s *Server) listenHealth() error {
+	l, err := net.Listen("tcp", s.healthAddr)
+	if err != nil {
+		return err
+	}
+	s.healthAddr = l.Addr().String()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	// The peer API is rate limited by client IP, before requests are
+	// authenticated so that API keys can't be guessed quickly either.
+	rps, burst := s.apiRateLimit, s.apiBurstLimit
+	if rps == 0 {
+		rps = defaultAPIRateLimit
+	}
+	if burst == 0 {
+		burst = defaultAPIBurstLimit
+	}
+	limit := rateLimitMiddleware(rps, burst)
+	mux.Handle("GET /peers", limit(http.HandlerFunc(s.handlePeers)))
+	mux.Handle("GET /peers/{name}", limit(http.HandlerFunc(s.handlePeer)))
+	mux.Handle("GET /peers/{name}/history", limit(http.HandlerFunc(s.handlePeerHistory)))
+	mux.Handle("GET /peers/{name}/ack", limit(http.HandlerFunc(s.handlePeerAcknowledgement)))
+	mux.Handle("POST /peers/{name}/ack", limit(s.requireAPIKey(s.handleAcknowledgePeer)))
+	mux.Handle("POST /peers/{name}/heartbeat", limit(s.requireAPIKey(s.handleManualHeartbeat)))
+	mux.Handle("POST /peers/{name}/stats/reset", limit(s.requireAPIKey(s.handleResetPeerStats)))
+	mux.Handle("POST /stats/reset", limit(s.requireAPIKey(s.handleResetStats)))
+	mux.Handle("GET /state", limit(http.HandlerFunc(s.handleState)))
+	mux.Handle("PUT /state", limit(s.requireAPIKey(s.handleRestoreState)))
+	mux.HandleFunc("GET /events", s.handleEvents)
+	s.healthServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.infof("serving health checks on http://%s/healthz\n", s.healthAddr)
+
+	go func() {
+		if err := s.healthServer.Serve(l); err != http.ErrServerClosed {
+			s.errorf("error serving health checks: %v\n", err)
+			s.reportError(err)
+		}
+	}()
+
+	return nil
+}
+
+// closeHealth shuts down the Server's health check HTTP server if there is
+// one, waiting briefly for in-flight requests to finish.
+func (s *Server) closeHealth() {
+	if s.healthServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthShutdownTimeout)
+	defer cancel()
+	if err := s.healthServer.Shutdown(ctx); err != nil {
+		s.errorf("error shutting down health check server: %v\n", err)
+	}
+}
@@ -0,0 +1,132 @@
+package woodwatch
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cpu/woodwatch/internal/states"
+)
+
+// TestHealth tests that a Server serves the expected health check responses
+// over HTTP and stops serving them when closed.
+func TestHealth(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+			{Name: "WAN", Network: "10.0.0.0/8"},
+		},
+	}
+	s, err := NewServer(
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithConfig(c),
+		WithHealthAddr("127.0.0.1:0"))
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	if err := s.listenHealth(); err != nil {
+		t.Fatalf("expected listenHealth to return nil err, got %v", err)
+	}
+
+	get := func(path string, expectedCode int, expectedStatus healthStatus) {
+		t.Helper()
+		resp, err := http.Get("http://" + s.healthAddr + path)
+		if err != nil {
+			t.Fatalf("expected GET %s to return nil err, got %v", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != expectedCode {
+			t.Errorf("expected GET %s to return %d, got %d", path, expectedCode, resp.StatusCode)
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("expected GET %s content type %q, got %q", path, "application/json", contentType)
+		}
+		var status healthStatus
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatalf("expected GET %s to return a JSON body, got err %v", path, err)
+		}
+		if status != expectedStatus {
+			t.Errorf("expected GET %s to return %#v, got %#v", path, expectedStatus, status)
+		}
+	}
+
+	ok := healthStatus{Status: "ok", Peers: 2}
+	get("/healthz", http.StatusOK, ok)
+	// The Server isn't ready before its first monitor cycle.
+	get("/readyz", http.StatusServiceUnavailable, healthStatus{Status: "not ready", Peers: 2})
+	s.monitorCycleCompleted.Store(true)
+	get("/readyz", http.StatusOK, ok)
+
+	resp, err := http.Get("http://" + s.healthAddr + "/healthz?verbose=1")
+	if err != nil {
+		t.Fatalf("expected GET /healthz?verbose=1 to return nil err, got %v", err)
+	}
+	defer resp.Body.Close()
+	var report HealthReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("expected GET /healthz?verbose=1 to return a JSON body, got err %v", err)
+	}
+	if expected := (HealthReport{Total: 2, Down: 2}); report != expected {
+		t.Errorf("expected GET /healthz?verbose=1 to return %#v, got %#v", expected, report)
+	}
+
+	s.closeHealth()
+	if _, err := http.Get("http://" + s.healthAddr + "/healthz"); err == nil {
+		t.Errorf("expected GET /healthz after close to return err, got nil")
+	}
+}
+
+// TestHealthReport tests that Server.HealthReport counts the Server's peers in
+// each state.
+func TestHealthReport(t *testing.T) {
+	c := Config{
+		UpThreshold:   2,
+		DownThreshold: 2,
+		MonitorCycle:  "1s",
+		PeerTimeout:   "2s",
+		Peers: []PeerConfig{
+			{Name: "Up A", Network: "10.0.1.0/24"},
+			{Name: "Up B", Network: "10.0.2.0/24"},
+			{Name: "Down", Network: "10.0.3.0/24"},
+			{Name: "Flapping", Network: "10.0.4.0/24"},
+			{Name: "Maybe Up", Network: "10.0.5.0/24"},
+		},
+	}
+	s, err := NewServer(WithLogger(log.New(io.Discard, "", 0)), WithConfig(c))
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	startedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.startedAt = startedAt
+
+	s.peers[0].state = states.NewPeerUp(2, 2, 0)
+	s.peers[1].state = states.NewPeerUp(2, 2, 0)
+	// Flap the peer up and down, each transition taking two heartbeats, until
+	// it is Flapping.
+	flapping := states.NewPeer(1, 1, 3)
+	for i := 0; i < 12 && flapping.String() != "Flapping"; i++ {
+		flapping, _ = flapping.Heartbeat((i/2)%2 == 0)
+	}
+	if flapping.String() != "Flapping" {
+		t.Fatalf("expected peer to be Flapping, got %q", flapping.String())
+	}
+	s.peers[3].state = flapping
+	s.peers[4].state, _ = s.peers[4].state.Heartbeat(true)
+
+	expected := HealthReport{
+		Total:    5,
+		Up:       2,
+		Down:     1,
+		Flapping: 1,
+		Unknown:  1,
+		Since:    startedAt,
+	}
+	if report := s.HealthReport(); report != expected {
+		t.Errorf("expected HealthReport() to return %#v, got %#v", expected, report)
+	}
+}
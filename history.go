@@ -0,0 +1,103 @@
+package woodwatch
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultMaxHistory is how many state changes are kept for each peer when
+	// the Config's MaxHistory is zero.
+	defaultMaxHistory = 100
+)
+
+// StateEntry describes a change of a peer's state.
+type StateEntry struct {
+	// Timestamp is when the peer's state changed.
+	Timestamp time.Time `json:"timestamp"`
+	// OldState is the peer's state before the change.
+	OldState string `json:"oldState"`
+	// NewState is the peer's state after the change.
+	NewState string `json:"newState"`
+	// Noteworthy indicates whether the change was noteworthy and an event was
+	// dispatched for it.
+	Noteworthy bool `json:"noteworthy"`
+}
+
+// stateHistory is a fixed capacity ring buffer of a peer's most recent
+// StateEntries. Once it is full adding a StateEntry replaces the oldest.
+type stateHistory struct {
+	// entries holds the StateEntries. Its length is the capacity of the
+	// stateHistory.
+	entries []StateEntry
+	// next is the index in entries the next StateEntry is added at.
+	next int
+	// full indicates whether every index of entries holds a StateEntry.
+	full bool
+}
+
+// newStateHistory constructs an empty stateHistory that keeps at most
+// capacity StateEntries.
+func newStateHistory(capacity uint) *stateHistory {
+	return &stateHistory{
+		entries: make([]StateEntry, capacity),
+	}
+}
+
+// add adds the given StateEntry to the stateHistory, replacing the oldest
+// StateEntry if the stateHistory is full.
+func (h *stateHistory) add(e StateEntry) {
+	if len(h.entries) == 0 {
+		return
+	}
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// list returns a copy of the StateEntries in the stateHistory, oldest first.
+func (h *stateHistory) list() []StateEntry {
+	if !h.full {
+		return append([]StateEntry{}, h.entries[:h.next]...)
+	}
+
+	return append(append([]StateEntry{}, h.entries[h.next:]...), h.entries[:h.next]...)
+}
+
+// PeerHistory returns a copy of the most recent state changes of the peer
+// with the given name, oldest first. If no peer with the given name is
+// configured ErrPeerNotFound is returned. If the peer's lock can't be
+// acquired ErrPeerLockTimeout is returned.
+func (s *Server) PeerHistory(name string) ([]StateEntry, error) {
+	p := s.findPeer(name)
+	if p == nil {
+		return nil, ErrPeerNotFound
+	}
+
+	if !s.rLockPeer(p) {
+		return nil, peerLockTimeoutError(p)
+	}
+	defer p.lastSeenMu.RUnlock()
+
+	return p.history.list(), nil
+}
+
+// handlePeerHistory responds with the state history of the peer named in the
+// request path as a JSON array, or a 404 Not Found if there is no such peer.
+func (s *Server) handlePeerHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := s.PeerHistory(r.PathValue("name"))
+	if errors.Is(err, ErrPeerNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		s.errorf("error writing peer history: %v\n", err)
+	}
+}
@@ -0,0 +1,154 @@
+package woodwatch
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestStateHistory tests that a stateHistory keeps only its most recent
+// entries, oldest first.
+func TestStateHistory(t *testing.T) {
+	entry := func(i int) StateEntry {
+		return StateEntry{Timestamp: time.Unix(int64(i), 0)}
+	}
+
+	testCases := []struct {
+		Name     string
+		Capacity uint
+		Added    int
+		Expected []StateEntry
+	}{
+		{
+			Name:     "Empty",
+			Capacity: 3,
+			Expected: []StateEntry{},
+		},
+		{
+			Name:     "Not full",
+			Capacity: 3,
+			Added:    2,
+			Expected: []StateEntry{entry(0), entry(1)},
+		},
+		{
+			Name:     "Exactly full",
+			Capacity: 3,
+			Added:    3,
+			Expected: []StateEntry{entry(0), entry(1), entry(2)},
+		},
+		{
+			Name:     "Wrapped",
+			Capacity: 3,
+			Added:    5,
+			Expected: []StateEntry{entry(2), entry(3), entry(4)},
+		},
+		{
+			Name:     "Zero capacity",
+			Capacity: 0,
+			Added:    2,
+			Expected: []StateEntry{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			h := newStateHistory(tc.Capacity)
+			for i := 0; i < tc.Added; i++ {
+				h.add(entry(i))
+			}
+			if history := h.list(); !reflect.DeepEqual(history, tc.Expected) {
+				t.Errorf("expected history %v, got %v", tc.Expected, history)
+			}
+		})
+	}
+}
+
+// TestPeerHistory tests that a peer's state changes are recorded in its
+// history and served over HTTP.
+func TestPeerHistory(t *testing.T) {
+	c := Config{
+		UpThreshold:   1,
+		DownThreshold: 1,
+		MonitorCycle:  "1s",
+		PeerTimeout:   "2s",
+		MaxHistory:    2,
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		return now
+	}
+
+	if _, err := s.PeerHistory("WAN"); err != ErrPeerNotFound {
+		t.Errorf("expected PeerHistory of unknown peer to return %v, got %v",
+			ErrPeerNotFound, err)
+	}
+
+	// Down -> Maybe Up -> Up -> Up. Staying Up isn't a state change.
+	p := s.peers[0]
+	p.lastSeen = now
+	for i := 0; i < 3; i++ {
+		s.checkPeer(context.Background(), p)
+	}
+	expected := []StateEntry{
+		{Timestamp: now, OldState: "Down", NewState: "Maybe Up (1 of 1)"},
+		{Timestamp: now, OldState: "Maybe Up (1 of 1)", NewState: "Up", Noteworthy: true},
+	}
+	history, err := s.PeerHistory("LAN")
+	if err != nil {
+		t.Fatalf("expected PeerHistory to return nil err, got %v", err)
+	}
+	if !reflect.DeepEqual(history, expected) {
+		t.Errorf("expected history %v, got %v", expected, history)
+	}
+
+	// Up -> Maybe Down replaces the oldest entry.
+	now = now.Add(time.Minute)
+	s.checkPeer(context.Background(), p)
+	expected = []StateEntry{
+		expected[1],
+		{Timestamp: now, OldState: "Up", NewState: "Maybe Down (1 of 1)"},
+	}
+	history, err = s.PeerHistory("LAN")
+	if err != nil {
+		t.Fatalf("expected PeerHistory to return nil err, got %v", err)
+	}
+	if !reflect.DeepEqual(history, expected) {
+		t.Errorf("expected history %v, got %v", expected, history)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /peers/{name}/history", s.handlePeerHistory)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/peers/LAN/history", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected GET history to return %d, got %d", http.StatusOK, rec.Code)
+	}
+	var served []StateEntry
+	if err := json.NewDecoder(rec.Body).Decode(&served); err != nil {
+		t.Fatalf("expected GET history to return a JSON body, got err %v", err)
+	}
+	if !reflect.DeepEqual(served, expected) {
+		t.Errorf("expected GET history to return %v, got %v", expected, served)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/peers/WAN/history", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected GET history of unknown peer to return %d, got %d",
+			http.StatusNotFound, rec.Code)
+	}
+}
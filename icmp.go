@@ -0,0 +1,170 @@
+package woodwatch
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// protocolNumberICMP is the IP protocol number of ICMPv4.
+	protocolNumberICMP = 1
+	// protocolNumberICMPv6 is the IP protocol number of ICMPv6.
+	protocolNumberICMPv6 = 58
+)
+
+// packetReader is the part of an icmp.PacketConn used to read packets. It
+// allows reading to be tested without an ICMP socket.
+type packetReader interface {
+	ReadFrom(b []byte) (int, net.Addr, error)
+}
+
+// isRetryableError returns true if the given error from reading a packet is
+// transient, i.e. the read was interrupted by a signal (EINTR) or would have
+// blocked (EAGAIN), and reading should be retried.
+func isRetryableError(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
+}
+
+// icmpProtocolNumber returns the IP protocol number of the ICMP messages read
+// from the given PacketConn.
+func icmpProtocolNumber(conn *icmp.PacketConn) int {
+	if conn.IPv6PacketConn() != nil {
+		return protocolNumberICMPv6
+	}
+
+	return protocolNumberICMP
+}
+
+// icmpTypeNumber returns the number of the given ICMPv4 or ICMPv6 message
+// type, or -1 if it is neither.
+func icmpTypeNumber(typ icmp.Type) int {
+	switch t := typ.(type) {
+	case ipv4.ICMPType:
+		return int(t)
+	case ipv6.ICMPType:
+		return int(t)
+	default:
+		return -1
+	}
+}
+
+// seenByICMPType returns true if a peer is seen by an ICMP message with the
+// given type number. If the Server has filterICMPTypes the type must be one of
+// them, otherwise it must be an ICMPv4 or ICMPv6 echo request.
+func (s *Server) seenByICMPType(proto, typ int) bool {
+	if len(s.filterICMPTypes) == 0 {
+		if proto == protocolNumberICMPv6 {
+			return typ == int(ipv6.ICMPTypeEchoRequest)
+		}
+
+		return typ == int(ipv4.ICMPTypeEcho)
+	}
+	for _, filtered := range s.filterICMPTypes {
+		if typ == filtered {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handlePacket updates the peer that sent the given ICMP message, read from
+// a PacketConn for the given IP protocol number, if a peer is seen by its
+// type and identifier. The sequence numbers of echo requests are tracked by
+// the peer's echoTracker. Echo replies to the probes of peers in probe mode
+// are handled by handleProbeReply. Messages that can't be parsed, e.g.
+// because they were truncated, messages of other types, like destination
+// unreachable, and messages without the icmpIdentifier of a peer that has one
+// are ignored.
+func (s *Server) handlePacket(proto int, b []byte, src net.Addr) {
+	msg, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		s.debugf("ignoring ICMP message from %q: %v\n", src, err)
+
+		return
+	}
+	if s.handleProbeReply(proto, msg, src) {
+		return
+	}
+	if typ := icmpTypeNumber(msg.Type); !s.seenByICMPType(proto, typ) {
+		s.debugf("ignoring ICMP message of type %s from %q\n", icmpTypeName(msg.Type), src)
+
+		return
+	}
+	if !s.seenByICMPIdentifier(msg, src) {
+		s.debugf("ignoring ICMP message with unexpected identifier from %q\n", src)
+
+		return
+	}
+	p := s.updatePeer(src, protocolICMP)
+	if echo, ok := msg.Body.(*icmp.Echo); ok && p != nil && s.lockPeer(p) {
+		p.echo.record(echo.ID, echo.Seq)
+		p.lastSeenMu.Unlock()
+	}
+}
+
+// seenByICMPIdentifier returns true if the peer monitored by ICMP that the
+// given source address belongs to is seen by the given ICMP message's
+// identifier. Peers without an icmpIdentifier are seen by any message. Peers
+// with one are only seen by echo messages with that identifier. It returns
+// true if no peer matches so that updatePeer can log the unknown source.
+func (s *Server) seenByICMPIdentifier(msg *icmp.Message, src net.Addr) bool {
+	s.peersMu.RLock()
+	p := s.peerTrie.lookup(net.ParseIP(src.String()), protocolICMP)
+	s.peersMu.RUnlock()
+	if p == nil || p.icmpIdentifier == 0 {
+		return true
+	}
+	echo, ok := msg.Body.(*icmp.Echo)
+
+	return ok && echo.ID == int(p.icmpIdentifier)
+}
+
+// icmpTypeName returns a description of the given ICMP message type including
+// its number, e.g. "destination unreachable (3)".
+func icmpTypeName(typ icmp.Type) string {
+	return fmt.Sprintf("%v (%d)", typ, icmpTypeNumber(typ))
+}
+
+// echoTracker tracks the sequence numbers of the ICMP echo requests received
+// from a peer to count duplicate and out of order packets.
+type echoTracker struct {
+	// started indicates whether an echo request has been recorded.
+	started bool
+	// id is the identifier of the most recent echo request. Each ping process
+	// sending echo requests uses its own identifier and sequence numbers.
+	id int
+	// lastSeq is the sequence number of the most recent in order echo request.
+	lastSeq uint16
+	// duplicates is how many echo requests had the same sequence number as the
+	// lastSeq.
+	duplicates uint64
+	// outOfOrder is how many echo requests had a sequence number before the
+	// lastSeq.
+	outOfOrder uint64
+}
+
+// record records an echo request with the given identifier and sequence
+// number. Sequence numbers wrap around, so one up to half of the sequence
+// number space after the lastSeq is in order and one up to half before it is
+// out of order. When the identifier changes, e.g. because the peer restarted
+// ping, the sequence numbers start again without counting as out of order.
+func (t *echoTracker) record(id, seq int) {
+	s := uint16(seq)
+	switch {
+	case !t.started || id != t.id:
+		t.started, t.id, t.lastSeq = true, id, s
+	case s == t.lastSeq:
+		t.duplicates++
+	case s-t.lastSeq < 1<<15:
+		t.lastSeq = s
+	default:
+		t.outOfOrder++
+	}
+}
@@ -0,0 +1,400 @@
+package woodwatch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// TestHandlePacket tests that only ICMP messages of the filtered types, echo
+// requests by default, update the peer that sent them.
+func TestHandlePacket(t *testing.T) {
+	marshal := func(t *testing.T, msg icmp.Message) []byte {
+		t.Helper()
+		b, err := msg.Marshal(nil)
+		if err != nil {
+			t.Fatalf("expected Marshal to return nil err, got %v", err)
+		}
+
+		return b
+	}
+	echo := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: 1, Seq: 1},
+	}
+	unreachable := icmp.Message{
+		Type: ipv4.ICMPTypeDestinationUnreachable,
+		Body: &icmp.DstUnreach{Data: make([]byte, ipv4.HeaderLen)},
+	}
+	echo6 := icmp.Message{
+		Type: ipv6.ICMPTypeEchoRequest,
+		Body: &icmp.Echo{ID: 1, Seq: 1},
+	}
+
+	testCases := []struct {
+		Name            string
+		FilterICMPTypes []int
+		ICMPIdentifier  uint16
+		Proto           int
+		Message         icmp.Message
+		Truncate        bool
+		ExpectedSeen    bool
+	}{
+		{
+			Name:         "Echo request",
+			Proto:        protocolNumberICMP,
+			Message:      echo,
+			ExpectedSeen: true,
+		},
+		{
+			Name:    "Destination unreachable",
+			Proto:   protocolNumberICMP,
+			Message: unreachable,
+		},
+		{
+			Name:         "ICMPv6 echo request",
+			Proto:        protocolNumberICMPv6,
+			Message:      echo6,
+			ExpectedSeen: true,
+		},
+		{
+			Name:     "Truncated echo request",
+			Proto:    protocolNumberICMP,
+			Message:  echo,
+			Truncate: true,
+		},
+		{
+			Name:            "Filtered destination unreachable",
+			FilterICMPTypes: []int{3},
+			Proto:           protocolNumberICMP,
+			Message:         unreachable,
+			ExpectedSeen:    true,
+		},
+		{
+			Name:            "Echo request not filtered",
+			FilterICMPTypes: []int{3},
+			Proto:           protocolNumberICMP,
+			Message:         echo,
+		},
+		{
+			Name:           "Echo request with identifier",
+			ICMPIdentifier: 1,
+			Proto:          protocolNumberICMP,
+			Message:        echo,
+			ExpectedSeen:   true,
+		},
+		{
+			Name:           "Echo request with other identifier",
+			ICMPIdentifier: 2,
+			Proto:          protocolNumberICMP,
+			Message:        echo,
+		},
+		{
+			Name:            "Filtered destination unreachable without identifier",
+			FilterICMPTypes: []int{3},
+			ICMPIdentifier:  1,
+			Proto:           protocolNumberICMP,
+			Message:         unreachable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			c := Config{
+				ListenNetwork:   ListenNetworkBoth,
+				MonitorCycle:    "1s",
+				PeerTimeout:     "2s",
+				FilterICMPTypes: tc.FilterICMPTypes,
+				Peers: []PeerConfig{
+					{Name: "LAN", Network: "192.168.1.0/24", ICMPIdentifier: tc.ICMPIdentifier},
+					{Name: "LAN6", Network: "2001:db8::/32"},
+				},
+			}
+			s, err := NewServerFromConfig(log.New(io.Discard, "", 0), true, "whatever", c)
+			if err != nil {
+				t.Fatalf("expected NewServer to return nil err, got %v", err)
+			}
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			s.now = func() time.Time {
+				return now
+			}
+
+			src, p := &net.IPAddr{IP: net.ParseIP("192.168.1.1")}, s.peers[0]
+			if tc.Proto == protocolNumberICMPv6 {
+				src, p = &net.IPAddr{IP: net.ParseIP("2001:db8::1")}, s.peers[1]
+			}
+			b := marshal(t, tc.Message)
+			if tc.Truncate {
+				b = b[:2]
+			}
+			s.handlePacket(tc.Proto, b, src)
+
+			p.lastSeenMu.RLock()
+			seen := p.lastSeen.Equal(now)
+			p.lastSeenMu.RUnlock()
+			if seen != tc.ExpectedSeen {
+				t.Errorf("expected peer seen to be %v, got %v", tc.ExpectedSeen, seen)
+			}
+		})
+	}
+}
+
+// TestEchoTracker tests that an echoTracker counts duplicate and out of order
+// echo requests, allowing for sequence numbers wrapping around and changing
+// identifiers.
+func TestEchoTracker(t *testing.T) {
+	type echo struct {
+		id, seq int
+	}
+	testCases := []struct {
+		Name               string
+		Echoes             []echo
+		ExpectedLastSeq    uint16
+		ExpectedDuplicates uint64
+		ExpectedOutOfOrder uint64
+	}{
+		{
+			Name:            "In order",
+			Echoes:          []echo{{1, 1}, {1, 2}, {1, 3}},
+			ExpectedLastSeq: 3,
+		},
+		{
+			Name:            "Missing packets",
+			Echoes:          []echo{{1, 1}, {1, 5}, {1, 9}},
+			ExpectedLastSeq: 9,
+		},
+		{
+			Name:               "Duplicate",
+			Echoes:             []echo{{1, 1}, {1, 2}, {1, 2}},
+			ExpectedLastSeq:    2,
+			ExpectedDuplicates: 1,
+		},
+		{
+			Name:               "Out of order",
+			Echoes:             []echo{{1, 1}, {1, 3}, {1, 2}, {1, 4}},
+			ExpectedLastSeq:    4,
+			ExpectedOutOfOrder: 1,
+		},
+		{
+			Name:            "Wrapped around",
+			Echoes:          []echo{{1, 65534}, {1, 65535}, {1, 0}, {1, 1}},
+			ExpectedLastSeq: 1,
+		},
+		{
+			Name:            "New identifier",
+			Echoes:          []echo{{1, 100}, {2, 1}, {2, 2}},
+			ExpectedLastSeq: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var tracker echoTracker
+			for _, e := range tc.Echoes {
+				tracker.record(e.id, e.seq)
+			}
+			if tracker.lastSeq != tc.ExpectedLastSeq {
+				t.Errorf("expected lastSeq %d, got %d", tc.ExpectedLastSeq, tracker.lastSeq)
+			}
+			if tracker.duplicates != tc.ExpectedDuplicates {
+				t.Errorf("expected %d duplicates, got %d", tc.ExpectedDuplicates, tracker.duplicates)
+			}
+			if tracker.outOfOrder != tc.ExpectedOutOfOrder {
+				t.Errorf("expected %d out of order, got %d", tc.ExpectedOutOfOrder, tracker.outOfOrder)
+			}
+		})
+	}
+}
+
+// TestHandlePacketEchoSeq tests that the sequence numbers of the echo requests
+// a peer sends are reported in its PeerStatus.
+func TestHandlePacketEchoSeq(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	src := &net.IPAddr{IP: net.ParseIP("192.168.1.1")}
+	for _, seq := range []int{1, 2, 2, 4, 3} {
+		b, err := (&icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Body: &icmp.Echo{ID: 7, Seq: seq},
+		}).Marshal(nil)
+		if err != nil {
+			t.Fatalf("expected Marshal to return nil err, got %v", err)
+		}
+		s.handlePacket(protocolNumberICMP, b, src)
+	}
+
+	status := s.Peers()[0]
+	if status.LastSeq != 4 || status.DuplicatePackets != 1 || status.OutOfOrderPackets != 1 {
+		t.Errorf("expected LastSeq 4, 1 duplicate and 1 out of order packet, got %d, %d and %d",
+			status.LastSeq, status.DuplicatePackets, status.OutOfOrderPackets)
+	}
+}
+
+// readResult is a packet or error returned by a mockPacketReader.
+type readResult struct {
+	packet []byte
+	err    error
+}
+
+// mockPacketReader is a packetReader that returns its results in order.
+type mockPacketReader struct {
+	results []readResult
+}
+
+// ReadFrom copies the next result's packet into b or returns its error. The
+// packets are from 192.168.1.1. It returns io.EOF once the results run out.
+func (r *mockPacketReader) ReadFrom(b []byte) (int, net.Addr, error) {
+	if len(r.results) == 0 {
+		return 0, nil, io.EOF
+	}
+	result := r.results[0]
+	r.results = r.results[1:]
+	if result.err != nil {
+		return 0, nil, result.err
+	}
+
+	return copy(b, result.packet), &net.IPAddr{IP: net.ParseIP("192.168.1.1")}, nil
+}
+
+// TestIsRetryableError tests that only EINTR and EAGAIN errors, including
+// wrapped ones, are retryable.
+func TestIsRetryableError(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Err      error
+		Expected bool
+	}{
+		{
+			Name:     "EINTR",
+			Err:      syscall.EINTR,
+			Expected: true,
+		},
+		{
+			Name: "Wrapped EAGAIN",
+			Err: &net.OpError{
+				Op:  "read",
+				Net: "ip4:icmp",
+				Err: os.NewSyscallError("recvfrom", syscall.EAGAIN),
+			},
+			Expected: true,
+		},
+		{
+			Name: "Closed",
+			Err:  net.ErrClosed,
+		},
+		{
+			Name: "Other errno",
+			Err:  fmt.Errorf("read: %w", syscall.EBADF),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if retryable := isRetryableError(tc.Err); retryable != tc.Expected {
+				t.Errorf("expected isRetryableError(%v) to be %v, got %v",
+					tc.Err, tc.Expected, retryable)
+			}
+		})
+	}
+}
+
+// TestReadPackets tests that readPackets retries transient read errors, counting
+// them, and returns fatal read errors.
+func TestReadPackets(t *testing.T) {
+	echo, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: 1, Seq: 1},
+	}).Marshal(nil)
+	if err != nil {
+		t.Fatalf("expected Marshal to return nil err, got %v", err)
+	}
+	errFatal := errors.New("fatal")
+	errInterrupted := &net.OpError{
+		Op:  "read",
+		Net: "ip4:icmp",
+		Err: os.NewSyscallError("recvfrom", syscall.EINTR),
+	}
+
+	testCases := []struct {
+		Name                        string
+		Results                     []readResult
+		ExpectedSeen                bool
+		ExpectedReadErrors          uint64
+		ExpectedRetryableReadErrors uint64
+	}{
+		{
+			Name: "Retryable error",
+			Results: []readResult{
+				{err: errInterrupted},
+				{packet: echo},
+				{err: errFatal},
+			},
+			ExpectedSeen:                true,
+			ExpectedReadErrors:          2,
+			ExpectedRetryableReadErrors: 1,
+		},
+		{
+			Name: "Fatal error",
+			Results: []readResult{
+				{err: errFatal},
+				{packet: echo},
+			},
+			ExpectedReadErrors: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			c := Config{
+				MonitorCycle: "1s",
+				PeerTimeout:  "2s",
+				Peers: []PeerConfig{
+					{Name: "LAN", Network: "192.168.1.0/24"},
+				},
+			}
+			s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+			if err != nil {
+				t.Fatalf("expected NewServer to return nil err, got %v", err)
+			}
+
+			err = s.readPackets(&mockPacketReader{results: tc.Results}, protocolNumberICMP)
+			if !errors.Is(err, errFatal) {
+				t.Errorf("expected readPackets to return %v, got %v", errFatal, err)
+			}
+
+			p := s.peers[0]
+			p.lastSeenMu.RLock()
+			seen := !p.lastSeen.IsZero()
+			p.lastSeenMu.RUnlock()
+			if seen != tc.ExpectedSeen {
+				t.Errorf("expected peer seen to be %v, got %v", tc.ExpectedSeen, seen)
+			}
+			if readErrors := s.readErrors.Load(); readErrors != tc.ExpectedReadErrors {
+				t.Errorf("expected %d read errors, got %d", tc.ExpectedReadErrors, readErrors)
+			}
+			if retryable := s.retryableReadErrors.Load(); retryable != tc.ExpectedRetryableReadErrors {
+				t.Errorf("expected %d retryable read errors, got %d",
+					tc.ExpectedRetryableReadErrors, retryable)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package states
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnknownState is returned (wrapped with the state) when a PeerState
+	// can't be marshalled to or unmarshalled from JSON or text.
+	ErrUnknownState = errors.New("unknown PeerState")
+	// ErrUnexpectedState is returned from UnmarshalJSON when the JSON describes
+	// a different type of PeerState than the one being unmarshalled.
+	ErrUnexpectedState = errors.New("unexpected PeerState")
+)
+
+// stateJSON is the JSON representation of a PeerState.
+type stateJSON struct {
+	// State is the name of the state, e.g. "Up" or "Maybe Down". For a flapping
+	// state it is the name of the wrapped state.
+	State string `json:"state"`
+	// Count is how many correct observations a maybe state has seen.
+	Count uint `json:"count,omitempty"`
+	// UpThreshold is the state's upThreshold.
+	UpThreshold uint `json:"upThreshold"`
+	// DownThreshold is the state's downThreshold.
+	DownThreshold uint `json:"downThreshold"`
+	// Flapping indicates whether the state is flapping.
+	Flapping bool `json:"flapping,omitempty"`
+	// FlapWindow is the state's flapWindow if it detects flapping.
+	FlapWindow *flapWindowJSON `json:"flapWindow,omitempty"`
+}
+
+// flapWindowJSON is the JSON representation of a flapWindow.
+type flapWindowJSON struct {
+	// Threshold is the flapWindow's threshold. The window size is always twice
+	// the threshold.
+	Threshold uint `json:"threshold"`
+	// Heartbeats is how many heartbeats the flapWindow has recorded.
+	Heartbeats uint `json:"heartbeats"`
+	// Transitions are the heartbeat numbers of the transitions within the window.
+	Transitions []uint `json:"transitions,omitempty"`
+}
+
+// toJSON returns the JSON representation of the given PeerState.
+func toJSON(state PeerState) (stateJSON, error) {
+	switch s := state.(type) {
+	case upState:
+		return stateJSON{State: up, UpThreshold: s.upThreshold, DownThreshold: s.downThreshold}, nil
+	case downState:
+		return stateJSON{State: down, UpThreshold: s.upThreshold, DownThreshold: s.downThreshold}, nil
+	case maybeState:
+		return stateJSON{
+			State:         s.name,
+			Count:         s.count,
+			UpThreshold:   s.upThreshold,
+			DownThreshold: s.downThreshold,
+		}, nil
+	case flapDetectingState:
+		return s.window.toJSON(s.state, false)
+	case flappingState:
+		return s.window.toJSON(s.state, true)
+	default:
+		return stateJSON{}, fmt.Errorf("%w: %T", ErrUnknownState, state)
+	}
+}
+
+// toJSON returns the JSON representation of a flapping detecting state wrapping
+// the given PeerState with the flapWindow.
+func (w flapWindow) toJSON(state PeerState, flapping bool) (stateJSON, error) {
+	j, err := toJSON(state)
+	if err != nil {
+		return j, err
+	}
+	if j.FlapWindow != nil {
+		return j, fmt.Errorf("%w: nested flapping states", ErrUnknownState)
+	}
+	j.Flapping = flapping
+	j.FlapWindow = &flapWindowJSON{
+		Threshold:   w.threshold,
+		Heartbeats:  w.heartbeats,
+		Transitions: w.transitions,
+	}
+
+	return j, nil
+}
+
+// fromJSON returns the PeerState described by the given JSON representation.
+func fromJSON(j stateJSON) (PeerState, error) {
+	lim := limits{
+		upThreshold:   j.UpThreshold,
+		downThreshold: j.DownThreshold,
+	}
+	var state PeerState
+	switch j.State {
+	case up:
+		state = upState{lim}
+	case down:
+		state = downState{lim}
+	case maybeUpState(lim).name:
+		m := maybeUpState(lim)
+		m.count = j.Count
+		state = m
+	case maybeDownState(lim).name:
+		m := maybeDownState(lim)
+		m.count = j.Count
+		state = m
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownState, j.State)
+	}
+
+	if j.FlapWindow == nil {
+		if j.Flapping {
+			return nil, fmt.Errorf("%w: flapping without a flapWindow", ErrUnknownState)
+		}
+
+		return state, nil
+	}
+	window := flapWindow{
+		threshold:   j.FlapWindow.Threshold,
+		size:        2 * j.FlapWindow.Threshold,
+		heartbeats:  j.FlapWindow.Heartbeats,
+		transitions: j.FlapWindow.Transitions,
+	}
+	if j.Flapping {
+		return flappingState{state: state, window: window}, nil
+	}
+
+	return flapDetectingState{state: state, window: window}, nil
+}
+
+// marshalState returns the JSON encoding of the given PeerState.
+func marshalState(state PeerState) ([]byte, error) {
+	j, err := toJSON(state)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(j)
+}
+
+// unmarshalState returns the PeerState described by the given JSON.
+func unmarshalState(data []byte) (PeerState, error) {
+	var j stateJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+
+	return fromJSON(j)
+}
+
+// unmarshalStateInto unmarshals the PeerState described by the given JSON into
+// dst. If the JSON describes a different type of PeerState ErrUnexpectedState
+// is returned.
+func unmarshalStateInto[T PeerState](data []byte, dst *T) error {
+	state, err := unmarshalState(data)
+	if err != nil {
+		return err
+	}
+	typed, ok := state.(T)
+	if !ok {
+		return fmt.Errorf("%w: %q is not a %T", ErrUnexpectedState, state, *dst)
+	}
+	*dst = typed
+
+	return nil
+}
+
+// NewPeerFromJSON returns the PeerState described by the given JSON, as
+// produced by marshalling a PeerState returned from NewPeer, so that a peer's
+// state can be restored after a restart. The given thresholds replace those in
+// the JSON so that the restored PeerState uses the current configuration. If
+// flappingThreshold is zero flapping isn't detected, even if the JSON describes
+// a flapping state.
+func NewPeerFromJSON(upThreshold, downThreshold, flappingThreshold uint, data []byte) (PeerState, error) {
+	var j stateJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+	j.UpThreshold = upThreshold
+	j.DownThreshold = downThreshold
+	switch {
+	case flappingThreshold == 0:
+		j.Flapping = false
+		j.FlapWindow = nil
+	case j.FlapWindow == nil:
+		j.FlapWindow = &flapWindowJSON{Threshold: flappingThreshold}
+	default:
+		j.FlapWindow.Threshold = flappingThreshold
+	}
+
+	return fromJSON(j)
+}
+
+// MarshalJSON returns the JSON encoding of the upState.
+func (s upState) MarshalJSON() ([]byte, error) {
+	return marshalState(s)
+}
+
+// UnmarshalJSON sets the upState from its JSON encoding.
+func (s *upState) UnmarshalJSON(data []byte) error {
+	return unmarshalStateInto(data, s)
+}
+
+// MarshalJSON returns the JSON encoding of the downState.
+func (s downState) MarshalJSON() ([]byte, error) {
+	return marshalState(s)
+}
+
+// UnmarshalJSON sets the downState from its JSON encoding.
+func (s *downState) UnmarshalJSON(data []byte) error {
+	return unmarshalStateInto(data, s)
+}
+
+// MarshalJSON returns the JSON encoding of the maybeState.
+func (s maybeState) MarshalJSON() ([]byte, error) {
+	return marshalState(s)
+}
+
+// UnmarshalJSON sets the maybeState from its JSON encoding.
+func (s *maybeState) UnmarshalJSON(data []byte) error {
+	return unmarshalStateInto(data, s)
+}
+
+// MarshalJSON returns the JSON encoding of the flapDetectingState.
+func (s flapDetectingState) MarshalJSON() ([]byte, error) {
+	return marshalState(s)
+}
+
+// UnmarshalJSON sets the flapDetectingState from its JSON encoding.
+func (s *flapDetectingState) UnmarshalJSON(data []byte) error {
+	return unmarshalStateInto(data, s)
+}
+
+// MarshalJSON returns the JSON encoding of the flappingState.
+func (s flappingState) MarshalJSON() ([]byte, error) {
+	return marshalState(s)
+}
+
+// UnmarshalJSON sets the flappingState from its JSON encoding.
+func (s *flappingState) UnmarshalJSON(data []byte) error {
+	return unmarshalStateInto(data, s)
+}
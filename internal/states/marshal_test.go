@@ -0,0 +1,106 @@
+package states
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestMarshalRoundTrip tests that each PeerState is unchanged by marshalling
+// it to JSON and unmarshalling it again.
+func TestMarshalRoundTrip(t *testing.T) {
+	lim := limits{upThreshold: 3, downThreshold: 2}
+	maybeUp := maybeUpState(lim)
+	maybeUp.count = 2
+	window := flapWindow{threshold: 2, size: 4, heartbeats: 9, transitions: []uint{7, 9}}
+
+	testCases := []struct {
+		Name  string
+		State PeerState
+	}{
+		{Name: "Up", State: upState{lim}},
+		{Name: "Down", State: downState{lim}},
+		{Name: "Maybe Up", State: maybeUp},
+		{Name: "Maybe Down", State: maybeDownState(lim)},
+		{
+			Name:  "Flap detecting",
+			State: flapDetectingState{state: maybeUp, window: window},
+		},
+		{
+			Name:  "Flapping",
+			State: flappingState{state: upState{lim}, window: window},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			data, err := json.Marshal(tc.State)
+			if err != nil {
+				t.Fatalf("expected Marshal to return nil err, got %v", err)
+			}
+			// Unmarshal into a new value of the same concrete type.
+			dst := reflect.New(reflect.TypeOf(tc.State))
+			if err := json.Unmarshal(data, dst.Interface()); err != nil {
+				t.Fatalf("expected Unmarshal to return nil err, got %v", err)
+			}
+			if state := dst.Elem().Interface(); !reflect.DeepEqual(state, tc.State) {
+				t.Errorf("expected %#v after round trip, got %#v", tc.State, state)
+			}
+		})
+	}
+}
+
+// TestUnmarshalErrors tests that unmarshalling JSON for an unknown or
+// different type of PeerState fails.
+func TestUnmarshalErrors(t *testing.T) {
+	var u upState
+	if err := json.Unmarshal([]byte(`{"state":"Sideways"}`), &u); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("expected err %v, got %v", ErrUnknownState, err)
+	}
+	if err := json.Unmarshal([]byte(`{"state":"Down"}`), &u); !errors.Is(err, ErrUnexpectedState) {
+		t.Errorf("expected err %v, got %v", ErrUnexpectedState, err)
+	}
+}
+
+// TestNewPeerFromJSON tests that NewPeerFromJSON restores a PeerState with the
+// given thresholds.
+func TestNewPeerFromJSON(t *testing.T) {
+	old := maybeDownState(limits{upThreshold: 1, downThreshold: 5})
+	old.count = 1
+	data, err := json.Marshal(old)
+	if err != nil {
+		t.Fatalf("expected Marshal to return nil err, got %v", err)
+	}
+
+	state, err := NewPeerFromJSON(2, 3, 0, data)
+	if err != nil {
+		t.Fatalf("expected NewPeerFromJSON to return nil err, got %v", err)
+	}
+	expected := "Maybe Down (2 of 3)"
+	if state.String() != expected {
+		t.Errorf("expected restored state %q, got %q", expected, state)
+	}
+	// The restored state uses the new thresholds.
+	state, _ = state.Heartbeat(false)
+	state, noteworthy := state.Heartbeat(false)
+	if state.String() != down || !noteworthy {
+		t.Errorf("expected noteworthy %q state, got %q (noteworthy %v)", down, state, noteworthy)
+	}
+	if state.(downState).upThreshold != 2 {
+		t.Errorf("expected restored upThreshold 2, got %d", state.(downState).upThreshold)
+	}
+
+	// A flapping threshold wraps the restored state.
+	state, err = NewPeerFromJSON(2, 3, 4, data)
+	if err != nil {
+		t.Fatalf("expected NewPeerFromJSON to return nil err, got %v", err)
+	}
+	if _, ok := state.(flapDetectingState); !ok {
+		t.Errorf("expected flapDetectingState, got %T", state)
+	}
+
+	if _, err := NewPeerFromJSON(1, 1, 0, []byte(`{`)); err == nil {
+		t.Errorf("expected NewPeerFromJSON of invalid JSON to return err, got nil")
+	}
+}
@@ -4,9 +4,10 @@ package states
 import "fmt"
 
 const (
-	down  = "Down"
-	up    = "Up"
-	maybe = "Maybe"
+	down     = "Down"
+	up       = "Up"
+	maybe    = "Maybe"
+	flapping = "Flapping"
 )
 
 // PeerState is an interface describing a peer that responds to heartbeats by
@@ -29,15 +30,58 @@ type PeerState interface {
 // provided thresholds. The returned PeerState represents a down connection that
 // must receive downThreshold seen events to transition to up.
 //
+// If flappingThreshold is not zero the PeerState will enter a flapping state
+// after flappingThreshold up/down transitions within 2*flappingThreshold
+// heartbeats. While flapping individual up/down transitions are not
+// noteworthy. The flapping state is left once there have been no up/down
+// transitions for 2*flappingThreshold heartbeats and the peer is up or down.
+//
+// If upThreshold or downThreshold is zero the PeerState transitions
+// immediately: a single seen event makes a noteworthy transition from down to
+// up, or a single not seen event from up to down, without an intermediate
+// maybe state.
+//
 // TODO(@cpu): Describe lifecycle based on upThreshold/downThreshold.
-func NewPeer(upThreshold, downThreshold uint) PeerState {
+func NewPeer(upThreshold, downThreshold, flappingThreshold uint) PeerState {
 	// NOTE(@cpu): By default we start in down state
-	return downState{
+	state := downState{
+		limits: limits{
+			upThreshold:   upThreshold,
+			downThreshold: downThreshold,
+		},
+	}
+
+	return detectFlapping(state, flappingThreshold)
+}
+
+// NewPeerUp returns a PeerState like NewPeer except that it represents an up
+// connection that must receive downThreshold not seen events to transition to
+// down. It is useful for peers known to be up at startup.
+func NewPeerUp(upThreshold, downThreshold, flappingThreshold uint) PeerState {
+	state := upState{
 		limits: limits{
 			upThreshold:   upThreshold,
 			downThreshold: downThreshold,
 		},
 	}
+
+	return detectFlapping(state, flappingThreshold)
+}
+
+// detectFlapping wraps the given PeerState with flap detection if
+// flappingThreshold is not zero. Otherwise the PeerState is returned unchanged.
+func detectFlapping(state PeerState, flappingThreshold uint) PeerState {
+	if flappingThreshold == 0 {
+		return state
+	}
+
+	return flapDetectingState{
+		state: state,
+		window: flapWindow{
+			threshold: flappingThreshold,
+			size:      2 * flappingThreshold,
+		},
+	}
 }
 
 // limits is a struct for holding the upThreshold and downThreshold used by
@@ -60,8 +104,12 @@ type upState struct {
 }
 
 // Heartbeat for upState stays in downState until there is a timeout, then it
-// makes an unnotable transition to the maybeDownState.
+// makes an unnotable transition to the maybeDownState. If the downThreshold is
+// zero it makes a notable transition straight to the downState instead.
 func (s upState) Heartbeat(seen bool) (PeerState, bool) {
+	if !seen && s.downThreshold == 0 {
+		return downState(s), true
+	}
 	if !seen {
 		return maybeDownState(s.limits), false
 	}
@@ -83,8 +131,13 @@ type downState struct {
 }
 
 // Heartbeat for downState stays in downState until the peer stops timing out,
-// then it makes an unnotable transition to the maybeUpState.
+// then it makes an unnotable transition to the maybeUpState. If the
+// upThreshold is zero it makes a notable transition straight to the upState
+// instead.
 func (s downState) Heartbeat(seen bool) (PeerState, bool) {
+	if seen && s.upThreshold == 0 {
+		return upState(s), true
+	}
 	if seen {
 		return maybeUpState(s.limits), false
 	}
@@ -102,6 +155,8 @@ func (s downState) String() string {
 // as an unnotable event, before finally considering the Peer in a new state as
 // a notable event.
 type maybeState struct {
+	// limits are the thresholds of the returnState and nextState.
+	limits
 	// name is the name of the state for use in String()
 	name string
 	// returnSeen indicates whether the maybe state should return to the
@@ -150,6 +205,7 @@ func (s maybeState) String() string {
 // transition to the upState.
 func maybeUpState(lim limits) maybeState {
 	return maybeState{
+		limits:      lim,
 		name:        fmt.Sprintf("%s %s", maybe, up),
 		returnSeen:  true,
 		returnState: downState{lim},
@@ -164,6 +220,7 @@ func maybeUpState(lim limits) maybeState {
 // make a notable transition to the downState.
 func maybeDownState(lim limits) maybeState {
 	return maybeState{
+		limits:      lim,
 		name:        fmt.Sprintf("%s %s", maybe, down),
 		returnSeen:  false,
 		returnState: upState{lim},
@@ -171,3 +228,100 @@ func maybeDownState(lim limits) maybeState {
 		threshold:   lim.downThreshold,
 	}
 }
+
+// flapWindow records which of the most recent heartbeats were up/down
+// transitions.
+type flapWindow struct {
+	// threshold is how many transitions within the window make a peer flapping.
+	threshold uint
+	// size is how many heartbeats the window covers.
+	size uint
+	// heartbeats is how many heartbeats have been recorded.
+	heartbeats uint
+	// transitions are the heartbeat numbers of the transitions within the window.
+	transitions []uint
+}
+
+// record returns a new flapWindow with the next heartbeat recorded, dropping
+// transitions that are no longer within the window. The receiver isn't
+// modified.
+func (w flapWindow) record(transition bool) flapWindow {
+	w.heartbeats++
+	var transitions []uint
+	for _, heartbeat := range w.transitions {
+		if w.heartbeats-heartbeat < w.size {
+			transitions = append(transitions, heartbeat)
+		}
+	}
+	if transition {
+		transitions = append(transitions, w.heartbeats)
+	}
+	w.transitions = transitions
+
+	return w
+}
+
+// flapDetectingState describes the state when the Peer isn't flapping. It
+// wraps another PeerState, counting its noteworthy up/down transitions. If
+// there are too many transitions within the window the flapDetectingState
+// makes a notable transition to the flappingState.
+type flapDetectingState struct {
+	// state is the wrapped PeerState.
+	state PeerState
+	// window records the wrapped PeerState's recent transitions.
+	window flapWindow
+}
+
+// Heartbeat for flapDetectingState passes the heartbeat to the wrapped state.
+// If the wrapped state's transition is one too many for the window it makes a
+// notable transition to the flappingState. Otherwise the wrapped state's
+// transition and its noteworthy-ness are returned unchanged.
+func (s flapDetectingState) Heartbeat(seen bool) (PeerState, bool) {
+	next, noteworthy := s.state.Heartbeat(seen)
+	window := s.window.record(noteworthy)
+	if uint(len(window.transitions)) >= window.threshold {
+		return flappingState{state: next, window: window}, true
+	}
+
+	return flapDetectingState{state: next, window: window}, noteworthy
+}
+
+// String for flapDetectingState returns the wrapped state's description.
+func (s flapDetectingState) String() string {
+	return s.state.String()
+}
+
+// flappingState describes the state when the Peer has been rapidly changing
+// between up and down. Transitions of the wrapped PeerState aren't notable
+// while flapping. Once the wrapped PeerState has been up or down without
+// a transition for the whole window the flappingState makes a notable
+// transition back to the flapDetectingState.
+type flappingState struct {
+	// state is the wrapped PeerState.
+	state PeerState
+	// window records the wrapped PeerState's recent transitions.
+	window flapWindow
+}
+
+// Heartbeat for flappingState passes the heartbeat to the wrapped state
+// without its transitions being notable. If the wrapped state is up or down
+// and there have been no transitions within the window it makes a notable
+// transition to the flapDetectingState.
+func (s flappingState) Heartbeat(seen bool) (PeerState, bool) {
+	next, noteworthy := s.state.Heartbeat(seen)
+	window := s.window.record(noteworthy)
+	if len(window.transitions) > 0 {
+		return flappingState{state: next, window: window}, false
+	}
+	switch next.(type) {
+	case upState, downState:
+		return flapDetectingState{state: next, window: window}, true
+	}
+
+	return flappingState{state: next, window: window}, false
+}
+
+// String for flappingState returns flapping.
+func (s flappingState) String() string {
+	return flapping
+}
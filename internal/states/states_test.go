@@ -39,7 +39,7 @@ func TestNewPeer(t *testing.T) {
 		t.Run(
 			fmt.Sprintf("NewPeer(%d, %d)", tc.Up, tc.Down),
 			func(t *testing.T) {
-				state := NewPeer(tc.Up, tc.Down)
+				state := NewPeer(tc.Up, tc.Down, 0)
 				if _, ok := state.(downState); !ok {
 					t.Fatalf("expected NewPeer(%d,%d) to be downState not %T",
 						tc.Up, tc.Down, state)
@@ -48,6 +48,34 @@ func TestNewPeer(t *testing.T) {
 	}
 }
 
+// TestNewPeerUp tests that the NewPeerUp function returns an upState with the
+// provided thresholds, wrapped with flap detection when a flapping threshold is
+// provided.
+func TestNewPeerUp(t *testing.T) {
+	state := NewPeerUp(3, 4, 0)
+	expected := upState{
+		limits{
+			upThreshold:   3,
+			downThreshold: 4,
+		},
+	}
+	if state != expected {
+		t.Fatalf("expected NewPeerUp(3, 4, 0) to be %#v not %#v", expected, state)
+	}
+
+	state = NewPeerUp(3, 4, 2)
+	flapState, ok := state.(flapDetectingState)
+	if !ok {
+		t.Fatalf("expected NewPeerUp(3, 4, 2) to be flapDetectingState not %T", state)
+	}
+	if flapState.state != expected {
+		t.Errorf("expected NewPeerUp(3, 4, 2) to wrap %#v not %#v", expected, flapState.state)
+	}
+	if flapState.String() != up {
+		t.Errorf("expected NewPeerUp(3, 4, 2) to be %q not %q", up, flapState.String())
+	}
+}
+
 // statePair structs describe an observation and the expected next state and
 // noteworthy bool pair.
 type statePair struct {
@@ -161,6 +189,45 @@ func TestPeerStates(t *testing.T) {
 				{true, up, true},
 			},
 		},
+		{
+			Name:         "Zero thresholds down to up to down cycle",
+			InitialState: downState{limits{}},
+			Expected: []statePair{
+				{false, down, false},
+				{true, up, true},
+				{true, up, false},
+				{false, down, true},
+				{false, down, false},
+			},
+		},
+		{
+			Name:         "Zero up threshold",
+			InitialState: downState{limits{downThreshold: 2}},
+			Expected: []statePair{
+				{true, up, true},
+				{false, maybeDesc(down, 1, 2), false},
+				{false, maybeDesc(down, 2, 2), false},
+				{false, down, true},
+			},
+		},
+		{
+			Name:         "Zero down threshold",
+			InitialState: upState{limits{upThreshold: 2}},
+			Expected: []statePair{
+				{false, down, true},
+				{true, maybeDesc(up, 1, 2), false},
+				{true, maybeDesc(up, 2, 2), false},
+				{true, up, true},
+			},
+		},
+		{
+			Name:         "Zero thresholds from NewPeer",
+			InitialState: NewPeer(0, 0, 0),
+			Expected: []statePair{
+				{true, up, true},
+				{false, down, true},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -181,3 +248,176 @@ func TestPeerStates(t *testing.T) {
 		})
 	}
 }
+
+// TestFlappingStates tests that a PeerState with a flapping threshold enters
+// and leaves the flapping state and that only doing so is noteworthy.
+func TestFlappingStates(t *testing.T) {
+	maybeDesc := func(x string, i, max uint) string {
+		return fmt.Sprintf("%s %s (%d of %d)", maybe, x, i, max)
+	}
+
+	// With thresholds of 1 each up/down transition takes two heartbeats and
+	// with a flapping threshold of 3 the window is 6 heartbeats.
+	testCases := []struct {
+		Name     string
+		Expected []statePair
+	}{
+		{
+			Name: "Transitions below threshold aren't flapping",
+			Expected: []statePair{
+				{true, maybeDesc(up, 1, 1), false},
+				{true, up, true},
+				{false, maybeDesc(down, 1, 1), false},
+				{false, down, true},
+				{false, down, false},
+				{false, down, false},
+				{false, down, false},
+				{false, down, false},
+				{false, down, false},
+				{true, maybeDesc(up, 1, 1), false},
+				{true, up, true},
+			},
+		},
+		{
+			Name: "Flapping after threshold transitions within window",
+			Expected: []statePair{
+				{true, maybeDesc(up, 1, 1), false},
+				{true, up, true},
+				{false, maybeDesc(down, 1, 1), false},
+				{false, down, true},
+				{true, maybeDesc(up, 1, 1), false},
+				{true, flapping, true},
+				{false, flapping, false},
+				{false, flapping, false},
+				{true, flapping, false},
+			},
+		},
+		{
+			Name: "Flapping stops after window without transitions",
+			Expected: []statePair{
+				{true, maybeDesc(up, 1, 1), false},
+				{true, up, true},
+				{false, maybeDesc(down, 1, 1), false},
+				{false, down, true},
+				{true, maybeDesc(up, 1, 1), false},
+				{true, flapping, true},
+				{true, flapping, false},
+				{true, flapping, false},
+				{true, flapping, false},
+				{true, flapping, false},
+				{true, flapping, false},
+				{true, up, true},
+				{false, maybeDesc(down, 1, 1), false},
+				{false, down, true},
+			},
+		},
+		{
+			Name: "Flapping doesn't stop in a maybe state",
+			Expected: []statePair{
+				{true, maybeDesc(up, 1, 1), false},
+				{true, up, true},
+				{false, maybeDesc(down, 1, 1), false},
+				{false, down, true},
+				{true, maybeDesc(up, 1, 1), false},
+				{true, flapping, true},
+				{false, flapping, false},
+				{false, flapping, false},
+				{false, flapping, false},
+				{false, flapping, false},
+				{false, flapping, false},
+				{false, flapping, false},
+				{false, flapping, false},
+				{true, flapping, false},
+				{false, down, true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var noteworthy bool
+			state := NewPeer(1, 1, 3)
+			for i, pair := range tc.Expected {
+				state, noteworthy = state.Heartbeat(pair.observation)
+				if state.String() != pair.newState {
+					t.Errorf("after observation %d (%v) state was %q not %q",
+						i, pair.observation, state, pair.newState)
+				}
+				if noteworthy != pair.noteworthy {
+					t.Errorf("after observation %d (%v) noteworthy bool was %v not %v",
+						i, pair.observation, noteworthy, pair.noteworthy)
+				}
+			}
+		})
+	}
+
+	// With a flapping threshold of 1 the first up transition is flapping.
+	state := NewPeer(2, 2, 1)
+	state, _ = state.Heartbeat(true)
+	state, _ = state.Heartbeat(true)
+	if state.String() != maybeDesc(up, 2, 2) {
+		t.Errorf("expected state %q, got %q", maybeDesc(up, 2, 2), state)
+	}
+	state, noteworthy := state.Heartbeat(true)
+	if state.String() != flapping || !noteworthy {
+		t.Errorf("expected noteworthy state %q, got %q (noteworthy %v)",
+			flapping, state, noteworthy)
+	}
+}
+
+// maxHeartbeatAllocs is the most allocations BenchmarkStateHeartbeat allows
+// a heartbeat to make.
+const maxHeartbeatAllocs = 4
+
+// BenchmarkStateHeartbeat measures the overhead of a peer's state handling
+// a heartbeat, for a peer that stays up, a peer that goes up and down and
+// a peer that goes up and down while flapping is detected. It fails if
+// a heartbeat makes more than maxHeartbeatAllocs allocations, averaged over
+// 1000 heartbeats.
+func BenchmarkStateHeartbeat(b *testing.B) {
+	testCases := []struct {
+		Name  string
+		State PeerState
+		// Period is how many heartbeats are seen and then missed in turn. If
+		// zero every heartbeat is seen.
+		Period int
+	}{
+		{
+			Name:  "Steady up",
+			State: NewPeerUp(3, 3, 0),
+		},
+		{
+			Name:   "Up and down",
+			State:  NewPeer(3, 3, 0),
+			Period: 4,
+		},
+		{
+			Name:   "Flap detecting",
+			State:  NewPeer(3, 3, 3),
+			Period: 4,
+		},
+	}
+
+	for _, tc := range testCases {
+		b.Run(tc.Name, func(b *testing.B) {
+			state := tc.State
+			i := 0
+			heartbeat := func() {
+				seen := tc.Period == 0 || (i/tc.Period)%2 == 0
+				state, _ = state.Heartbeat(seen)
+				i++
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				heartbeat()
+			}
+			b.StopTimer()
+
+			if allocs := testing.AllocsPerRun(1000, heartbeat); allocs > maxHeartbeatAllocs {
+				b.Errorf("expected Heartbeat to make at most %d allocations, got %.1f",
+					maxHeartbeatAllocs, allocs)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package states
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// textUp is the text form of an up state.
+	textUp = "up"
+	// textDown is the text form of a down state.
+	textDown = "down"
+	// textMaybeUp is the name of a maybe up state in its text form.
+	textMaybeUp = "maybe-up"
+	// textMaybeDown is the name of a maybe down state in its text form.
+	textMaybeDown = "maybe-down"
+	// textFlappingPrefix prefixes the text form of the state wrapped by
+	// a flapping state.
+	textFlappingPrefix = "flapping:"
+)
+
+// TextPeerState wraps a PeerState so that it can be marshalled to and
+// unmarshalled from short text, e.g. for flag.TextVar. The text is "up",
+// "down", or for a maybe state its name, the number of the heartbeat in
+// progress and its threshold, e.g. "maybe-up:1/3" for a PeerState described as
+// "Maybe Up (1 of 3)". A flapping state is the text of the state it wraps
+// prefixed with "flapping:", e.g. "flapping:up". The flap detection window
+// isn't included so it restarts when the text is unmarshalled.
+type TextPeerState struct {
+	PeerState
+	// UpThreshold, DownThreshold and FlappingThreshold are the thresholds of
+	// the PeerStates unmarshalled by UnmarshalText, as with NewPeer. The
+	// threshold of a maybe state's text replaces the UpThreshold or
+	// DownThreshold it counts towards.
+	UpThreshold       uint
+	DownThreshold     uint
+	FlappingThreshold uint
+}
+
+// MarshalText returns the text form of the wrapped PeerState. If it isn't
+// a PeerState returned from NewPeer or one of its transitions ErrUnknownState
+// is returned wrapped with its type.
+func (t TextPeerState) MarshalText() ([]byte, error) {
+	text, err := stateText(t.PeerState)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(text), nil
+}
+
+// stateText returns the text form of the given PeerState.
+func stateText(state PeerState) (string, error) {
+	switch s := state.(type) {
+	case upState:
+		return textUp, nil
+	case downState:
+		return textDown, nil
+	case maybeState:
+		name := textMaybeDown
+		if s.returnSeen {
+			name = textMaybeUp
+		}
+
+		return fmt.Sprintf("%s:%d/%d", name, s.count+1, s.threshold), nil
+	case flapDetectingState:
+		return stateText(s.state)
+	case flappingState:
+		text, err := stateText(s.state)
+
+		return textFlappingPrefix + text, err
+	default:
+		return "", fmt.Errorf("%w: %T", ErrUnknownState, state)
+	}
+}
+
+// UnmarshalText sets the wrapped PeerState from its text form, using the
+// TextPeerState's thresholds. If FlappingThreshold is zero flapping isn't
+// detected, even if the text describes a flapping state. If the text isn't
+// a text form described by TextPeerState, or a maybe state's heartbeat number
+// isn't between one and its threshold, ErrUnknownState is returned wrapped
+// with the text.
+func (t *TextPeerState) UnmarshalText(text []byte) error {
+	lim := limits{
+		upThreshold:   t.UpThreshold,
+		downThreshold: t.DownThreshold,
+	}
+	s := string(text)
+	flapping := strings.HasPrefix(s, textFlappingPrefix)
+	state, ok := parseStateText(strings.TrimPrefix(s, textFlappingPrefix), lim)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownState, s)
+	}
+
+	if flapping && t.FlappingThreshold != 0 {
+		t.PeerState = flappingState{
+			state: state,
+			window: flapWindow{
+				threshold: t.FlappingThreshold,
+				size:      2 * t.FlappingThreshold,
+			},
+		}
+	} else {
+		t.PeerState = detectFlapping(state, t.FlappingThreshold)
+	}
+
+	return nil
+}
+
+// parseStateText returns the PeerState described by the given text form of an
+// up, down or maybe state with the given limits. It returns false if the text
+// isn't valid.
+func parseStateText(text string, lim limits) (PeerState, bool) {
+	switch text {
+	case textUp:
+		return upState{lim}, true
+	case textDown:
+		return downState{lim}, true
+	}
+
+	name, progress, _ := strings.Cut(text, ":")
+	heartbeat, threshold, _ := strings.Cut(progress, "/")
+	n, err := strconv.ParseUint(heartbeat, 10, 0)
+	if err != nil {
+		return nil, false
+	}
+	limit, err := strconv.ParseUint(threshold, 10, 0)
+	if err != nil || n < 1 || n > limit {
+		return nil, false
+	}
+
+	var state maybeState
+	switch name {
+	case textMaybeUp:
+		lim.upThreshold = uint(limit)
+		state = maybeUpState(lim)
+	case textMaybeDown:
+		lim.downThreshold = uint(limit)
+		state = maybeDownState(lim)
+	default:
+		return nil, false
+	}
+	state.count = uint(n) - 1
+
+	return state, true
+}
@@ -0,0 +1,124 @@
+package states
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestTextPeerStateRoundTrip tests that each PeerState is marshalled to the
+// expected text and unmarshalled from it again with the same thresholds.
+func TestTextPeerStateRoundTrip(t *testing.T) {
+	lim := limits{upThreshold: 3, downThreshold: 2}
+	maybeUp := maybeUpState(lim)
+	maybeDown := maybeDownState(lim)
+	maybeDown.count = 1
+
+	testCases := []struct {
+		Name              string
+		State             PeerState
+		FlappingThreshold uint
+		ExpectedText      string
+	}{
+		{Name: "Up", State: upState{lim}, ExpectedText: "up"},
+		{Name: "Down", State: downState{lim}, ExpectedText: "down"},
+		{Name: "Maybe Up", State: maybeUp, ExpectedText: "maybe-up:1/3"},
+		{Name: "Maybe Down", State: maybeDown, ExpectedText: "maybe-down:2/2"},
+		{
+			Name:              "Flap detecting",
+			State:             detectFlapping(maybeUp, 2),
+			FlappingThreshold: 2,
+			ExpectedText:      "maybe-up:1/3",
+		},
+		{
+			Name: "Flapping",
+			State: flappingState{
+				state:  upState{lim},
+				window: flapWindow{threshold: 2, size: 4},
+			},
+			FlappingThreshold: 2,
+			ExpectedText:      "flapping:up",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			text, err := TextPeerState{PeerState: tc.State}.MarshalText()
+			if err != nil {
+				t.Fatalf("expected MarshalText to return nil err, got %v", err)
+			}
+			if string(text) != tc.ExpectedText {
+				t.Errorf("expected text %q, got %q", tc.ExpectedText, text)
+			}
+
+			unmarshalled := TextPeerState{
+				UpThreshold:       lim.upThreshold,
+				DownThreshold:     lim.downThreshold,
+				FlappingThreshold: tc.FlappingThreshold,
+			}
+			if err := unmarshalled.UnmarshalText(text); err != nil {
+				t.Fatalf("expected UnmarshalText to return nil err, got %v", err)
+			}
+			if !reflect.DeepEqual(unmarshalled.PeerState, tc.State) {
+				t.Errorf("expected %#v after round trip, got %#v", tc.State, unmarshalled.PeerState)
+			}
+		})
+	}
+}
+
+// TestTextPeerStateUnmarshal tests that UnmarshalText uses the thresholds of
+// the TextPeerState and of maybe state text and rejects invalid text.
+func TestTextPeerStateUnmarshal(t *testing.T) {
+	testCases := []struct {
+		Text              string
+		FlappingThreshold uint
+		ExpectedState     string
+		ExpectedErr       error
+	}{
+		{Text: "up", ExpectedState: "Up"},
+		{Text: "maybe-up:2/5", ExpectedState: "Maybe Up (2 of 5)"},
+		{Text: "maybe-down:1/1", ExpectedState: "Maybe Down (1 of 1)"},
+		{Text: "flapping:down", FlappingThreshold: 3, ExpectedState: "Flapping"},
+		{Text: "flapping:down", ExpectedState: "Down"},
+		{Text: "sideways", ExpectedErr: ErrUnknownState},
+		{Text: "Up", ExpectedErr: ErrUnknownState},
+		{Text: "maybe-up", ExpectedErr: ErrUnknownState},
+		{Text: "maybe-up:0/3", ExpectedErr: ErrUnknownState},
+		{Text: "maybe-up:4/3", ExpectedErr: ErrUnknownState},
+		{Text: "maybe-sideways:1/3", ExpectedErr: ErrUnknownState},
+		{Text: "flapping:flapping:up", FlappingThreshold: 3, ExpectedErr: ErrUnknownState},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Text, func(t *testing.T) {
+			state := TextPeerState{UpThreshold: 2, DownThreshold: 2, FlappingThreshold: tc.FlappingThreshold}
+			err := state.UnmarshalText([]byte(tc.Text))
+			if !errors.Is(err, tc.ExpectedErr) {
+				t.Fatalf("expected UnmarshalText to return %v, got %v", tc.ExpectedErr, err)
+			}
+			if err == nil && state.String() != tc.ExpectedState {
+				t.Errorf("expected state %q, got %q", tc.ExpectedState, state.String())
+			}
+		})
+	}
+}
+
+// TestTextPeerStateFlag tests that a TextPeerState can be set by a flag.
+func TestTextPeerStateFlag(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	state := TextPeerState{UpThreshold: 3, DownThreshold: 3}
+	fs.TextVar(&state, "state", TextPeerState{PeerState: NewPeer(3, 3, 0)}, "initial state")
+
+	if err := fs.Parse([]string{"-state", "maybe-down:2/3"}); err != nil {
+		t.Fatalf("expected Parse to return nil err, got %v", err)
+	}
+	if expected := "Maybe Down (2 of 3)"; state.String() != expected {
+		t.Errorf("expected state %q, got %q", expected, state.String())
+	}
+	if err := fs.Parse([]string{"-state", "sideways"}); err == nil {
+		t.Errorf("expected Parse to return an err for an unknown state")
+	}
+}
@@ -0,0 +1,201 @@
+package webhook
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// defaultFailureThreshold is the FailureThreshold of a CircuitBreaker when
+	// it is zero.
+	defaultFailureThreshold uint = 5
+	// defaultSuccessThreshold is the SuccessThreshold of a CircuitBreaker when
+	// it is zero.
+	defaultSuccessThreshold uint = 1
+	// defaultOpenDuration is the OpenDuration of a CircuitBreaker when it is
+	// zero.
+	defaultOpenDuration = time.Minute
+
+	// ErrCircuitOpen is returned from Hook.Dispatch without POSTing when the
+	// Hook's CircuitBreaker is open.
+	ErrCircuitOpen = errors.New("Webhook circuit breaker is open")
+)
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed is the state of a CircuitBreaker that allows every request.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen is the state of a CircuitBreaker that rejects every request
+	// until its OpenDuration has passed.
+	CircuitOpen
+	// CircuitHalfOpen is the state of a CircuitBreaker that allows one probe
+	// request at a time to check if the endpoint has recovered.
+	CircuitHalfOpen
+)
+
+// String returns the name of the CircuitState.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "Closed"
+	case CircuitOpen:
+		return "Open"
+	case CircuitHalfOpen:
+		return "Half Open"
+	default:
+		return "Unknown"
+	}
+}
+
+// CircuitBreaker stops requests being made to an endpoint that keeps failing.
+// It starts closed, allowing every request. After FailureThreshold
+// consecutive failed requests it opens and rejects every request with
+// ErrCircuitOpen. Once it has been open for OpenDuration it is half-open and
+// allows one probe request at a time. After SuccessThreshold consecutive
+// successful probes it closes again. A failed probe opens it again.
+type CircuitBreaker struct {
+	// FailureThreshold is how many consecutive requests must fail before the
+	// CircuitBreaker opens. If zero 5 is used.
+	FailureThreshold uint
+	// SuccessThreshold is how many consecutive probe requests must succeed while
+	// the CircuitBreaker is half-open before it closes. If zero 1 is used.
+	SuccessThreshold uint
+	// OpenDuration is how long the CircuitBreaker stays open before it is
+	// half-open. If zero one minute is used.
+	OpenDuration time.Duration
+
+	// now returns the current time. If nil time.Now is used. Tests replace it
+	// with a fake clock.
+	now func() time.Time
+	// mu guards the fields below.
+	mu sync.Mutex
+	// state is the CircuitBreaker's state. When it is CircuitOpen and the
+	// OpenDuration has passed since openedAt the CircuitBreaker is half-open.
+	state CircuitState
+	// failures is how many consecutive requests have failed while closed.
+	failures uint
+	// successes is how many consecutive probes have succeeded while half-open.
+	successes uint
+	// openedAt is when the CircuitBreaker last opened.
+	openedAt time.Time
+	// probing is true while a probe request allowed in the half-open state is
+	// in progress.
+	probing bool
+}
+
+// NewCircuitBreaker returns a closed CircuitBreaker with the given thresholds
+// and open duration.
+func NewCircuitBreaker(
+	failureThreshold, successThreshold uint,
+	openDuration time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureThreshold: failureThreshold,
+		SuccessThreshold: successThreshold,
+		OpenDuration:     openDuration,
+	}
+}
+
+// State returns the CircuitBreaker's current CircuitState.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return cb.currentState()
+}
+
+// currentState returns the CircuitBreaker's state, moving it from open to
+// half-open once the OpenDuration has passed. The caller must hold the mu.
+func (cb *CircuitBreaker) currentState() CircuitState {
+	openDuration := cb.OpenDuration
+	if openDuration <= 0 {
+		openDuration = defaultOpenDuration
+	}
+	if cb.state == CircuitOpen && cb.currentTime().Sub(cb.openedAt) >= openDuration {
+		cb.state = CircuitHalfOpen
+		cb.successes = 0
+		cb.probing = false
+	}
+
+	return cb.state
+}
+
+// allow returns nil if a request may be made or ErrCircuitOpen if it may not.
+// Every allowed request must be followed by a call to record with its result.
+func (cb *CircuitBreaker) allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.currentState() {
+	case CircuitOpen:
+		return ErrCircuitOpen
+	case CircuitHalfOpen:
+		// Only allow one probe at a time.
+		if cb.probing {
+			return ErrCircuitOpen
+		}
+		cb.probing = true
+	}
+
+	return nil
+}
+
+// record updates the CircuitBreaker with the result of a request allowed by
+// allow.
+func (cb *CircuitBreaker) record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitClosed:
+		if err == nil {
+			cb.failures = 0
+
+			return
+		}
+		cb.failures++
+		failureThreshold := cb.FailureThreshold
+		if failureThreshold == 0 {
+			failureThreshold = defaultFailureThreshold
+		}
+		if cb.failures >= failureThreshold {
+			cb.open()
+		}
+	case CircuitHalfOpen:
+		cb.probing = false
+		if err != nil {
+			cb.open()
+
+			return
+		}
+		cb.successes++
+		successThreshold := cb.SuccessThreshold
+		if successThreshold == 0 {
+			successThreshold = defaultSuccessThreshold
+		}
+		if cb.successes >= successThreshold {
+			cb.state = CircuitClosed
+			cb.failures = 0
+		}
+	}
+}
+
+// open moves the CircuitBreaker to the open state. The caller must hold the mu.
+func (cb *CircuitBreaker) open() {
+	cb.state = CircuitOpen
+	cb.openedAt = cb.currentTime()
+	cb.failures = 0
+	cb.successes = 0
+	cb.probing = false
+}
+
+// currentTime returns the current time from the CircuitBreaker's clock.
+func (cb *CircuitBreaker) currentTime() time.Time {
+	if cb.now == nil {
+		return time.Now()
+	}
+
+	return cb.now()
+}
@@ -0,0 +1,132 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// errTestFailure is a request failure used in tests.
+var errTestFailure = errors.New("test failure")
+
+// TestCircuitBreakerStates tests that a CircuitBreaker moves between the
+// closed, open and half-open states.
+func TestCircuitBreakerStates(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cb := NewCircuitBreaker(2, 2, time.Minute)
+	cb.now = func() time.Time {
+		return now
+	}
+
+	// request makes a request through the CircuitBreaker with the given result,
+	// returning the error from allow if it isn't allowed.
+	request := func(result error) error {
+		if err := cb.allow(); err != nil {
+			return err
+		}
+		cb.record(result)
+
+		return nil
+	}
+	expectState := func(expected CircuitState) {
+		t.Helper()
+		if state := cb.State(); state != expected {
+			t.Fatalf("expected state %s, got %s", expected, state)
+		}
+	}
+
+	// Closed: a success resets the consecutive failures.
+	expectState(CircuitClosed)
+	for _, result := range []error{errTestFailure, nil, errTestFailure} {
+		if err := request(result); err != nil {
+			t.Fatalf("expected closed circuit to allow request, got %v", err)
+		}
+	}
+	expectState(CircuitClosed)
+
+	// Open: a second consecutive failure opens the circuit.
+	if err := request(errTestFailure); err != nil {
+		t.Fatalf("expected closed circuit to allow request, got %v", err)
+	}
+	expectState(CircuitOpen)
+	if err := request(nil); err != ErrCircuitOpen {
+		t.Fatalf("expected open circuit to return %v, got %v", ErrCircuitOpen, err)
+	}
+
+	// Half-open: after the OpenDuration one probe is allowed at a time and
+	// a failed probe opens the circuit again.
+	now = now.Add(time.Minute)
+	expectState(CircuitHalfOpen)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("expected half-open circuit to allow a probe, got %v", err)
+	}
+	if err := cb.allow(); err != ErrCircuitOpen {
+		t.Fatalf("expected half-open circuit to return %v during a probe, got %v",
+			ErrCircuitOpen, err)
+	}
+	cb.record(errTestFailure)
+	expectState(CircuitOpen)
+
+	// Closed again: SuccessThreshold successful probes close the circuit.
+	now = now.Add(time.Minute)
+	if err := request(nil); err != nil {
+		t.Fatalf("expected half-open circuit to allow a probe, got %v", err)
+	}
+	expectState(CircuitHalfOpen)
+	if err := request(nil); err != nil {
+		t.Fatalf("expected half-open circuit to allow a probe, got %v", err)
+	}
+	expectState(CircuitClosed)
+}
+
+// TestDispatchCircuitBreaker tests that Dispatch doesn't POST while the Hook's
+// CircuitBreaker is open.
+func TestDispatchCircuitBreaker(t *testing.T) {
+	var requests atomic.Int32
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHook(srv.URL)
+	h.MaxRetries = 0
+	h.CircuitBreaker = NewCircuitBreaker(2, 1, time.Minute)
+	h.CircuitBreaker.now = func() time.Time {
+		return now
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := h.Dispatch(context.Background(), testEvent); !errors.Is(err, ErrDispatchHTTPFailure) {
+			t.Fatalf("expected Dispatch to return %v, got %v", ErrDispatchHTTPFailure, err)
+		}
+	}
+	if err := h.Dispatch(context.Background(), testEvent); err != ErrCircuitOpen {
+		t.Fatalf("expected Dispatch to return %v, got %v", ErrCircuitOpen, err)
+	}
+	if count := requests.Load(); count != 2 {
+		t.Errorf("expected 2 requests before the circuit opened, got %d", count)
+	}
+
+	// Once the endpoint is healthy again the probe closes the circuit.
+	healthy.Store(true)
+	now = now.Add(time.Minute)
+	if err := h.Dispatch(context.Background(), testEvent); err != nil {
+		t.Fatalf("expected probe Dispatch to return nil err, got %v", err)
+	}
+	if state := h.State(); state != CircuitClosed {
+		t.Errorf("expected circuit to be %s after a successful probe, got %s",
+			CircuitClosed, state)
+	}
+	if count := requests.Load(); count != 3 {
+		t.Errorf("expected 3 requests, got %d", count)
+	}
+}
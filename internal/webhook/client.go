@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	// ErrInvalidProxyURL is returned (wrapped with the URL) from Hook.Dispatch
+	// and ValidProxyURL when a Hook's ProxyURL isn't an absolute URL.
+	ErrInvalidProxyURL = errors.New("Webhook ProxyURL must be an absolute URL")
+	// ErrIncompleteTLSKeyPair is returned from Hook.Dispatch and ValidTLSFiles
+	// when only one of a Hook's TLSCertFile and TLSKeyFile is set.
+	ErrIncompleteTLSKeyPair = errors.New(
+		"Webhook TLSCertFile and TLSKeyFile must be set together")
+	// ErrLoadTLSFiles is returned (wrapped with the cause) from Hook.Dispatch
+	// and ValidTLSFiles when a Hook's TLSCertFile, TLSKeyFile or TLSCACertFile
+	// can't be loaded.
+	ErrLoadTLSFiles = errors.New("Webhook TLS files could not be loaded")
+)
+
+// ValidProxyURL returns nil if the given proxy URL is empty or an absolute
+// URL with a host, e.g. "http://proxy.example.com:3128". Otherwise it returns
+// ErrInvalidProxyURL wrapped with the URL.
+func ValidProxyURL(proxyURL string) error {
+	_, err := parseProxyURL(proxyURL)
+
+	return err
+}
+
+// parseProxyURL parses the given proxy URL as described by ValidProxyURL. It
+// returns nil if the proxy URL is empty.
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	if proxyURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidProxyURL, proxyURL)
+	}
+
+	return u, nil
+}
+
+// ValidTLSFiles returns nil if the given client certificate, key and CA
+// certificate files are empty or can be loaded. If only one of the certFile
+// and keyFile is set it returns ErrIncompleteTLSKeyPair. If one of the files
+// can't be loaded it returns ErrLoadTLSFiles wrapped with the cause.
+func ValidTLSFiles(certFile, keyFile, caCertFile string) error {
+	_, err := loadTLSConfig(certFile, keyFile, caCertFile)
+
+	return err
+}
+
+// loadTLSConfig returns a tls.Config with the client certificate loaded from
+// the given certFile and keyFile and the root CAs loaded from the given
+// caCertFile, returning the errors described by ValidTLSFiles. Without
+// a certFile and keyFile no client certificate is presented and without
+// a caCertFile the system root CAs are used. It returns nil if every file is
+// empty.
+func loadTLSConfig(certFile, keyFile, caCertFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" && caCertFile == "" {
+		return nil, nil
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, ErrIncompleteTLSKeyPair
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrLoadTLSFiles, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caCertFile != "" {
+		caCerts, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrLoadTLSFiles, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCerts) {
+			return nil, fmt.Errorf("%w: no PEM certificates in %q", ErrLoadTLSFiles, caCertFile)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+// httpClient returns the http.Client a Hook POSTs with. Each request times out
+// after the given timeout. If the Hook has a ProxyURL or TLS files, or
+// InsecureSkipVerify is set, the client has its own http.Transport, which the
+// caller should close the idle connections of when it is done. Otherwise the
+// client's Transport is nil so that it shares http.DefaultTransport, which uses
+// the proxy from the environment.
+func (h Hook) httpClient(timeout time.Duration) (*http.Client, error) {
+	client := &http.Client{Timeout: timeout}
+	if h.ProxyURL == "" && !h.InsecureSkipVerify &&
+		h.TLSCertFile == "" && h.TLSKeyFile == "" && h.TLSCACertFile == "" {
+		return client, nil
+	}
+
+	proxyURL, err := parseProxyURL(h.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := loadTLSConfig(h.TLSCertFile, h.TLSKeyFile, h.TLSCACertFile)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyURL != nil {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	if h.InsecureSkipVerify {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+	client.Transport = transport
+
+	return client, nil
+}
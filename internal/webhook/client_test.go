@@ -0,0 +1,259 @@
+package webhook
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestDispatchTimeout tests that Dispatch gives up on a webhook server that
+// responds slower than the Hook's Timeout.
+func TestDispatchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	h := Hook{
+		URL:     srv.URL,
+		Timeout: 50 * time.Millisecond,
+	}
+	start := time.Now()
+	if err := h.Dispatch(context.Background(), testEvent); err == nil {
+		t.Errorf("expected Dispatch to a slow server to return err, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Dispatch to time out after %v, took %v", h.Timeout, elapsed)
+	}
+}
+
+// TestDispatchProxyURL tests that Dispatch POSTs through the Hook's ProxyURL.
+func TestDispatchProxyURL(t *testing.T) {
+	var proxied atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Proxied requests have the absolute URL of the webhook.
+		proxied.Store(r.URL.String())
+	}))
+	defer proxy.Close()
+
+	h := Hook{
+		URL:      "http://hooks.example.com/woodwatch",
+		ProxyURL: proxy.URL,
+	}
+	if err := h.Dispatch(context.Background(), testEvent); err != nil {
+		t.Fatalf("expected Dispatch through proxy to return nil err, got %v", err)
+	}
+	if url, _ := proxied.Load().(string); url != h.URL {
+		t.Errorf("expected proxy to receive POST to %q, got %q", h.URL, url)
+	}
+
+	h.ProxyURL = "proxy.example.com:3128"
+	if err := h.Dispatch(context.Background(), testEvent); !errors.Is(err, ErrInvalidProxyURL) {
+		t.Errorf("expected Dispatch with invalid proxy URL to return %v, got %v", ErrInvalidProxyURL, err)
+	}
+}
+
+// TestDispatchInsecureSkipVerify tests that Dispatch only POSTs to a webhook
+// server with an untrusted certificate when InsecureSkipVerify is set.
+func TestDispatchInsecureSkipVerify(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	h := Hook{URL: srv.URL}
+	if err := h.Dispatch(context.Background(), testEvent); err == nil {
+		t.Errorf("expected Dispatch to untrusted server to return err, got nil")
+	}
+	h.InsecureSkipVerify = true
+	if err := h.Dispatch(context.Background(), testEvent); err != nil {
+		t.Errorf("expected Dispatch with InsecureSkipVerify to return nil err, got %v", err)
+	}
+}
+
+// TestValidProxyURL tests that ValidProxyURL only accepts empty and absolute
+// proxy URLs.
+func TestValidProxyURL(t *testing.T) {
+	testCases := []struct {
+		ProxyURL    string
+		ExpectError bool
+	}{
+		{ProxyURL: ""},
+		{ProxyURL: "http://proxy.example.com:3128"},
+		{ProxyURL: "socks5://127.0.0.1:1080"},
+		{ProxyURL: "proxy.example.com:3128", ExpectError: true},
+		{ProxyURL: "http://", ExpectError: true},
+		{ProxyURL: "://bad", ExpectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.ProxyURL, func(t *testing.T) {
+			err := ValidProxyURL(tc.ProxyURL)
+			if tc.ExpectError && !errors.Is(err, ErrInvalidProxyURL) {
+				t.Errorf("expected ValidProxyURL(%q) to return %v, got %v", tc.ProxyURL, ErrInvalidProxyURL, err)
+			} else if !tc.ExpectError && err != nil {
+				t.Errorf("expected ValidProxyURL(%q) to return nil err, got %v", tc.ProxyURL, err)
+			}
+		})
+	}
+}
+
+// writeTestClientCertificate writes a new self-signed PEM client certificate
+// and its private key to the given directory, returning the certificate and
+// the paths of the files.
+func writeTestClientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected GenerateKey to return nil err, got %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "woodwatch"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("expected CreateCertificate to return nil err, got %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("expected ParseCertificate to return nil err, got %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("expected MarshalECPrivateKey to return nil err, got %v", err)
+	}
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+
+	return cert, certFile, keyFile
+}
+
+// writePEM writes the given DER bytes to the given file as a PEM block of the
+// given type.
+func writePEM(t *testing.T, filename, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(filename, data, 0o600); err != nil {
+		t.Fatalf("expected WriteFile to return nil err, got %v", err)
+	}
+}
+
+// TestDispatchMutualTLS tests that Dispatch presents the Hook's client
+// certificate and verifies the webhook server with the Hook's CA certificates.
+func TestDispatchMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, certFile, keyFile := writeTestClientCertificate(t, dir)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+	caCertFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caCertFile, "CERTIFICATE", srv.Certificate().Raw)
+
+	h := Hook{URL: srv.URL, TLSCACertFile: caCertFile}
+	if err := h.Dispatch(context.Background(), testEvent); err == nil {
+		t.Errorf("expected Dispatch without a client certificate to return err, got nil")
+	}
+	h.TLSCertFile, h.TLSKeyFile = certFile, keyFile
+	if err := h.Dispatch(context.Background(), testEvent); err != nil {
+		t.Errorf("expected Dispatch with a client certificate to return nil err, got %v", err)
+	}
+}
+
+// TestValidTLSFiles tests that ValidTLSFiles returns the expected errors for
+// TLS files that can't be loaded.
+func TestValidTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := writeTestClientCertificate(t, dir)
+	notPEMFile := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEMFile, []byte("not PEM"), 0o600); err != nil {
+		t.Fatalf("expected WriteFile to return nil err, got %v", err)
+	}
+
+	testCases := []struct {
+		Name          string
+		CertFile      string
+		KeyFile       string
+		CACertFile    string
+		ExpectedError error
+	}{
+		{
+			Name: "No files",
+		},
+		{
+			Name:     "Key pair",
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		},
+		{
+			Name:       "CA certificates",
+			CACertFile: certFile,
+		},
+		{
+			Name:          "Certificate without key",
+			CertFile:      certFile,
+			ExpectedError: ErrIncompleteTLSKeyPair,
+		},
+		{
+			Name:          "Missing certificate",
+			CertFile:      filepath.Join(dir, "missing.pem"),
+			KeyFile:       keyFile,
+			ExpectedError: ErrLoadTLSFiles,
+		},
+		{
+			Name:          "Missing CA certificates",
+			CACertFile:    filepath.Join(dir, "missing.pem"),
+			ExpectedError: ErrLoadTLSFiles,
+		},
+		{
+			Name:          "CA certificates without PEM",
+			CACertFile:    notPEMFile,
+			ExpectedError: ErrLoadTLSFiles,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := ValidTLSFiles(tc.CertFile, tc.KeyFile, tc.CACertFile)
+			if tc.ExpectedError == nil && err != nil {
+				t.Errorf("expected ValidTLSFiles to return nil err, got %v", err)
+			} else if !errors.Is(err, tc.ExpectedError) {
+				t.Errorf("expected ValidTLSFiles to return %v, got %v", tc.ExpectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// FormatJSON is the HookFormat for POSTing Events as plain JSON objects.
+	FormatJSON = "json"
+	// FormatSlack is the HookFormat for POSTing Events as Slack Block Kit
+	// messages to a Slack incoming webhook.
+	FormatSlack = "slack"
+	// FormatDiscord is the HookFormat for POSTing Events as Discord embeds to
+	// a Discord webhook.
+	FormatDiscord = "discord"
+
+	// colorUp is the color of events for peers that are now up.
+	colorUp = 0x2eb886
+	// colorDown is the color of events for peers that are now down.
+	colorDown = 0xa30200
+	// colorOther is the color of events for peers in any other state, e.g.
+	// flapping.
+	colorOther = 0xdaa038
+
+	// discordColorUp is the color of Discord embeds for peers that are now up.
+	discordColorUp = 0x00ff00
+	// discordColorDown is the color of Discord embeds for peers that are now
+	// down.
+	discordColorDown = 0xff0000
+	// discordColorFlapping is the color of Discord embeds for peers that are
+	// now flapping.
+	discordColorFlapping = 0xffff00
+)
+
+// ValidFormat returns true if the given HookFormat is supported. The empty
+// HookFormat is supported and is the same as FormatJSON.
+func ValidFormat(format string) bool {
+	switch format {
+	case "", FormatJSON, FormatSlack, FormatDiscord:
+		return true
+	default:
+		return false
+	}
+}
+
+// eventColor returns the color an Event is shown with: green when a peer is
+// now up, red when it is now down and amber otherwise.
+func eventColor(e Event) int {
+	switch e.NewState {
+	case "Up", "Not All Down":
+		return colorUp
+	case "Down", "All Down":
+		return colorDown
+	default:
+		return colorOther
+	}
+}
+
+// slackText is a Slack Block Kit text object.
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// slackBlock is a Slack Block Kit layout block. Section blocks may have Fields
+// instead of Text.
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+// slackAttachment is a Slack message attachment. Attachments are used to show
+// the blocks with a colored bar.
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+// slackMessage is the body of a Slack incoming webhook POST.
+type slackMessage struct {
+	// Text is shown in notifications.
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+// peerAnnotation is a named annotation of an Event's Peer.
+type peerAnnotation struct {
+	Name  string
+	Value string
+}
+
+// peerAnnotations returns the Event's PeerDescription, PeerOwner and PeerURL
+// that aren't empty, named "Description", "Owner" and "URL".
+func peerAnnotations(e Event) []peerAnnotation {
+	var annotations []peerAnnotation
+	for _, a := range []peerAnnotation{
+		{Name: "Description", Value: e.PeerDescription},
+		{Name: "Owner", Value: e.PeerOwner},
+		{Name: "URL", Value: e.PeerURL},
+	} {
+		if a.Value != "" {
+			annotations = append(annotations, a)
+		}
+	}
+
+	return annotations
+}
+
+// sortedLabels returns the names of the given Event Labels in sorted order.
+func sortedLabels(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// slackPayload returns a Slack Block Kit message for the Event with the Event
+// Title as a header block and the Event Text as a section block. If the Event
+// has peer annotations, e.g. a PeerOwner, they are shown as the fields of
+// another section block and if it has Labels they are shown as the fields of
+// the last section block.
+func slackPayload(e Event) ([]byte, error) {
+	blocks := []slackBlock{
+		{
+			Type: "header",
+			Text: &slackText{Type: "plain_text", Text: e.Title},
+		},
+		{
+			Type: "section",
+			Text: &slackText{Type: "mrkdwn", Text: e.Text},
+		},
+	}
+	if annotations := peerAnnotations(e); len(annotations) > 0 {
+		fields := slackBlock{Type: "section"}
+		for _, a := range annotations {
+			fields.Fields = append(fields.Fields, slackText{
+				Type: "mrkdwn",
+				Text: fmt.Sprintf("*%s*\n%s", a.Name, a.Value),
+			})
+		}
+		blocks = append(blocks, fields)
+	}
+	if len(e.Labels) > 0 {
+		labels := slackBlock{Type: "section"}
+		for _, name := range sortedLabels(e.Labels) {
+			labels.Fields = append(labels.Fields, slackText{
+				Type: "mrkdwn",
+				Text: fmt.Sprintf("*%s*\n%s", name, e.Labels[name]),
+			})
+		}
+		blocks = append(blocks, labels)
+	}
+
+	return json.MarshalIndent(slackMessage{
+		Text: e.Title,
+		Attachments: []slackAttachment{
+			{
+				Color:  fmt.Sprintf("#%06x", eventColor(e)),
+				Blocks: blocks,
+			},
+		},
+	}, "", "  ")
+}
+
+// discordColor returns the color a Discord embed for an Event is shown with:
+// green when a peer is now up, red when it is now down, yellow when it is now
+// flapping and amber otherwise.
+func discordColor(e Event) int {
+	switch e.NewState {
+	case "Up", "Not All Down":
+		return discordColorUp
+	case "Down", "All Down":
+		return discordColorDown
+	case "Flapping":
+		return discordColorFlapping
+	default:
+		return colorOther
+	}
+}
+
+// discordField is a field of a Discord message embed.
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// discordEmbed is a Discord message embed.
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	URL         string         `json:"url,omitempty"`
+	Description string         `json:"description"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields,omitempty"`
+	Timestamp   string         `json:"timestamp,omitempty"`
+}
+
+// discordMessage is the body of a Discord webhook POST.
+type discordMessage struct {
+	// Content is the plain text of the message, shown in notifications.
+	Content string         `json:"content"`
+	Embeds  []discordEmbed `json:"embeds"`
+}
+
+// discordTimestamp returns the given time in Discord timestamp syntax so that
+// it is shown relative to the reader's clock, e.g. "3 minutes ago". The zero
+// time is shown as "Never".
+func discordTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return "Never"
+	}
+
+	return fmt.Sprintf("<t:%d:R>", t.Unix())
+}
+
+// discordPayload returns a Discord message for the Event with the Event Title
+// as its content and an embed that has the Event Title as its title, the Event
+// Text as its description and fields for the peer, its previous and current
+// state, when it was last seen, each of its peer annotations and each of its
+// Labels. If the Event has a PeerURL the embed title links to it.
+func discordPayload(e Event) ([]byte, error) {
+	embed := discordEmbed{
+		Title:       e.Title,
+		URL:         e.PeerURL,
+		Description: e.Text,
+		Color:       discordColor(e),
+		Fields: []discordField{
+			{Name: "Peer", Value: e.Peer},
+			{Name: "Previous State", Value: e.PrevState, Inline: true},
+			{Name: "Current State", Value: e.NewState, Inline: true},
+			{Name: "Last Seen", Value: discordTimestamp(e.LastSeen)},
+		},
+	}
+	for _, a := range peerAnnotations(e) {
+		embed.Fields = append(embed.Fields, discordField{Name: a.Name, Value: a.Value})
+	}
+	for _, name := range sortedLabels(e.Labels) {
+		embed.Fields = append(embed.Fields,
+			discordField{Name: name, Value: e.Labels[name], Inline: true})
+	}
+	if !e.Timestamp.IsZero() {
+		embed.Timestamp = e.Timestamp.Format(time.RFC3339)
+	}
+
+	return json.MarshalIndent(discordMessage{
+		Content: e.Title,
+		Embeds:  []discordEmbed{embed},
+	}, "", "  ")
+}
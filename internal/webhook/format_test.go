@@ -0,0 +1,251 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestDispatchFormat tests that Dispatch POSTs Events in the Hook's
+// HookFormat.
+func TestDispatchFormat(t *testing.T) {
+	downEvent := Event{
+		Peer:      "test",
+		Title:     "Peer test is Down",
+		Text:      "test was previously Maybe Down (1 of 1) and is now Down",
+		Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NewState:  "Down",
+		PrevState: "Maybe Down (1 of 1)",
+	}
+	flappingEvent := Event{
+		Peer:      "test",
+		Title:     "Peer test is Flapping",
+		LastSeen:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NewState:  "Flapping",
+		PrevState: "Up",
+	}
+	labelledEvent := Event{
+		Peer:      "test",
+		Labels:    map[string]string{"tier": "gold", "region": "eu-west"},
+		Title:     "Peer test is Up",
+		NewState:  "Up",
+		PrevState: "Down",
+	}
+	annotatedEvent := Event{
+		Peer:            "test",
+		PeerDescription: "Office uplink",
+		PeerOwner:       "network-team",
+		PeerURL:         "https://wiki.example.com/office",
+		Title:           "Peer test is Up",
+		NewState:        "Up",
+		PrevState:       "Down",
+	}
+
+	testCases := []struct {
+		Name         string
+		Format       string
+		Event        Event
+		ExpectedBody string
+	}{
+		{
+			Name:  "Default JSON",
+			Event: downEvent,
+			ExpectedBody: `{"peer":"test","title":"Peer test is Down",` +
+				`"text":"test was previously Maybe Down (1 of 1) and is now Down",` +
+				`"timestamp":"2024-01-01T00:00:00Z","lastSeen":"0001-01-01T00:00:00Z",` +
+				`"newState":"Down","prevState":"Maybe Down (1 of 1)","stateDuration":0}`,
+		},
+		{
+			Name:   "Slack down",
+			Format: FormatSlack,
+			Event:  downEvent,
+			ExpectedBody: `{"text":"Peer test is Down","attachments":[{"color":"#a30200","blocks":[` +
+				`{"type":"header","text":{"type":"plain_text","text":"Peer test is Down"}},` +
+				`{"type":"section","text":{"type":"mrkdwn",` +
+				`"text":"test was previously Maybe Down (1 of 1) and is now Down"}}]}]}`,
+		},
+		{
+			Name:   "Slack up",
+			Format: FormatSlack,
+			Event:  testEvent,
+			ExpectedBody: `{"text":"Peer test is Up","attachments":[{"color":"#2eb886","blocks":[` +
+				`{"type":"header","text":{"type":"plain_text","text":"Peer test is Up"}},` +
+				`{"type":"section","text":{"type":"mrkdwn","text":""}}]}]}`,
+		},
+		{
+			Name:   "Discord down",
+			Format: FormatDiscord,
+			Event:  downEvent,
+			ExpectedBody: `{"content":"Peer test is Down","embeds":[{"title":"Peer test is Down",` +
+				`"description":"test was previously Maybe Down (1 of 1) and is now Down",` +
+				`"color":16711680,"fields":[` +
+				`{"name":"Peer","value":"test","inline":false},` +
+				`{"name":"Previous State","value":"Maybe Down (1 of 1)","inline":true},` +
+				`{"name":"Current State","value":"Down","inline":true},` +
+				`{"name":"Last Seen","value":"Never","inline":false}],` +
+				`"timestamp":"2024-01-01T00:00:00Z"}]}`,
+		},
+		{
+			Name:   "Discord up",
+			Format: FormatDiscord,
+			Event:  testEvent,
+			ExpectedBody: `{"content":"Peer test is Up","embeds":[{"title":"Peer test is Up",` +
+				`"description":"","color":65280,"fields":[` +
+				`{"name":"Peer","value":"test","inline":false},` +
+				`{"name":"Previous State","value":"Maybe Up (1 of 1)","inline":true},` +
+				`{"name":"Current State","value":"Up","inline":true},` +
+				`{"name":"Last Seen","value":"Never","inline":false}]}]}`,
+		},
+		{
+			Name:   "Discord flapping",
+			Format: FormatDiscord,
+			Event:  flappingEvent,
+			ExpectedBody: `{"content":"Peer test is Flapping","embeds":[{"title":"Peer test is Flapping",` +
+				`"description":"","color":16776960,"fields":[` +
+				`{"name":"Peer","value":"test","inline":false},` +
+				`{"name":"Previous State","value":"Up","inline":true},` +
+				`{"name":"Current State","value":"Flapping","inline":true},` +
+				`{"name":"Last Seen","value":"<t:1704067200:R>","inline":false}]}]}`,
+		},
+		{
+			Name:  "JSON labels",
+			Event: labelledEvent,
+			ExpectedBody: `{"peer":"test","labels":{"region":"eu-west","tier":"gold"},` +
+				`"title":"Peer test is Up","text":"",` +
+				`"timestamp":"0001-01-01T00:00:00Z","lastSeen":"0001-01-01T00:00:00Z",` +
+				`"newState":"Up","prevState":"Down","stateDuration":0}`,
+		},
+		{
+			Name:   "Slack labels",
+			Format: FormatSlack,
+			Event:  labelledEvent,
+			ExpectedBody: `{"text":"Peer test is Up","attachments":[{"color":"#2eb886","blocks":[` +
+				`{"type":"header","text":{"type":"plain_text","text":"Peer test is Up"}},` +
+				`{"type":"section","text":{"type":"mrkdwn","text":""}},` +
+				`{"type":"section","fields":[` +
+				`{"type":"mrkdwn","text":"*region*\neu-west"},` +
+				`{"type":"mrkdwn","text":"*tier*\ngold"}]}]}]}`,
+		},
+		{
+			Name:   "Discord labels",
+			Format: FormatDiscord,
+			Event:  labelledEvent,
+			ExpectedBody: `{"content":"Peer test is Up","embeds":[{"title":"Peer test is Up",` +
+				`"description":"","color":65280,"fields":[` +
+				`{"name":"Peer","value":"test","inline":false},` +
+				`{"name":"Previous State","value":"Down","inline":true},` +
+				`{"name":"Current State","value":"Up","inline":true},` +
+				`{"name":"Last Seen","value":"Never","inline":false},` +
+				`{"name":"region","value":"eu-west","inline":true},` +
+				`{"name":"tier","value":"gold","inline":true}]}]}`,
+		},
+		{
+			Name:  "JSON peer annotations",
+			Event: annotatedEvent,
+			ExpectedBody: `{"peer":"test","peerDescription":"Office uplink",` +
+				`"peerOwner":"network-team","peerURL":"https://wiki.example.com/office",` +
+				`"title":"Peer test is Up","text":"",` +
+				`"timestamp":"0001-01-01T00:00:00Z","lastSeen":"0001-01-01T00:00:00Z",` +
+				`"newState":"Up","prevState":"Down","stateDuration":0}`,
+		},
+		{
+			Name:   "Slack peer annotations",
+			Format: FormatSlack,
+			Event:  annotatedEvent,
+			ExpectedBody: `{"text":"Peer test is Up","attachments":[{"color":"#2eb886","blocks":[` +
+				`{"type":"header","text":{"type":"plain_text","text":"Peer test is Up"}},` +
+				`{"type":"section","text":{"type":"mrkdwn","text":""}},` +
+				`{"type":"section","fields":[` +
+				`{"type":"mrkdwn","text":"*Description*\nOffice uplink"},` +
+				`{"type":"mrkdwn","text":"*Owner*\nnetwork-team"},` +
+				`{"type":"mrkdwn","text":"*URL*\nhttps://wiki.example.com/office"}]}]}]}`,
+		},
+		{
+			Name:   "Discord peer annotations",
+			Format: FormatDiscord,
+			Event:  annotatedEvent,
+			ExpectedBody: `{"content":"Peer test is Up","embeds":[{"title":"Peer test is Up",` +
+				`"url":"https://wiki.example.com/office",` +
+				`"description":"","color":65280,"fields":[` +
+				`{"name":"Peer","value":"test","inline":false},` +
+				`{"name":"Previous State","value":"Down","inline":true},` +
+				`{"name":"Current State","value":"Up","inline":true},` +
+				`{"name":"Last Seen","value":"Never","inline":false},` +
+				`{"name":"Description","value":"Office uplink","inline":false},` +
+				`{"name":"Owner","value":"network-team","inline":false},` +
+				`{"name":"URL","value":"https://wiki.example.com/office","inline":false}]}]}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			bodies := make(chan []byte, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies <- body
+			}))
+			defer srv.Close()
+
+			h := Hook{URL: srv.URL, HookFormat: tc.Format}
+			if err := h.Dispatch(context.Background(), tc.Event); err != nil {
+				t.Fatalf("expected Dispatch to return nil err, got %v", err)
+			}
+			var actual, expected any
+			if err := json.Unmarshal(<-bodies, &actual); err != nil {
+				t.Fatalf("expected a JSON body, got err %v", err)
+			}
+			if err := json.Unmarshal([]byte(tc.ExpectedBody), &expected); err != nil {
+				t.Fatalf("expected body is not JSON: %v", err)
+			}
+			actualBytes, _ := json.Marshal(actual)
+			expectedBytes, _ := json.Marshal(expected)
+			if string(actualBytes) != string(expectedBytes) {
+				t.Errorf("expected body %s, got %s", expectedBytes, actualBytes)
+			}
+		})
+	}
+}
+
+// TestValidFormat tests that ValidFormat accepts only the supported
+// HookFormats.
+func TestValidFormat(t *testing.T) {
+	for _, format := range []string{"", FormatJSON, FormatSlack, FormatDiscord} {
+		if !ValidFormat(format) {
+			t.Errorf("expected ValidFormat(%q) to be true", format)
+		}
+	}
+	if ValidFormat("teams") {
+		t.Errorf("expected ValidFormat(%q) to be false", "teams")
+	}
+}
+
+// TestDiscordDispatch tests that DiscordDispatch POSTs Discord messages
+// whatever the Hook's HookFormat and validates Events first.
+func TestDiscordDispatch(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	h := Hook{URL: srv.URL, HookFormat: FormatSlack}
+	if err := h.DiscordDispatch(context.Background(), Event{}); !errors.Is(err, ErrEmptyEventTitle) {
+		t.Errorf("expected DiscordDispatch to return %v, got %v", ErrEmptyEventTitle, err)
+	}
+	if err := h.DiscordDispatch(context.Background(), testEvent); err != nil {
+		t.Fatalf("expected DiscordDispatch to return nil err, got %v", err)
+	}
+	var msg discordMessage
+	if err := json.Unmarshal(<-bodies, &msg); err != nil {
+		t.Fatalf("expected a JSON body, got err %v", err)
+	}
+	if msg.Content != testEvent.Title || len(msg.Embeds) != 1 {
+		t.Errorf("expected a Discord message for %q, got %+v", testEvent.Title, msg)
+	}
+}
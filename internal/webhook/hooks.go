@@ -9,13 +9,20 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand/v2"
 	"net/http"
-	"runtime"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	defaultTimeout = time.Second * 30
+	// defaultMaxRetries is the MaxRetries of a Hook constructed with NewHook.
+	defaultMaxRetries = 3
+	// defaultInitialBackoff is the InitialBackoff of a Hook constructed with
+	// NewHook.
+	defaultInitialBackoff = time.Second
 
 	// ErrEmptyEventTitle is returned from Hook.Dispatch when the provided Event
 	// has no title.
@@ -26,14 +33,130 @@ var (
 	// ErrEmptyPrevState is returned from Hook.Dispatch when the provided Event
 	// has no PrevState.
 	ErrEmptyPrevState = errors.New("Event PrevState must not be empty")
+	// ErrDispatchHTTPFailure is wrapped by the HTTPError returned from
+	// Hook.Dispatch when the Hook URL responds with a non-2xx HTTP status.
+	ErrDispatchHTTPFailure = errors.New("Webhook responded with a non-2xx HTTP status")
 )
 
-// Hook is a URL for Event's to be POSTed to as JSON objects.
-type Hook string
+// HTTPError is returned from Hook.Dispatch when the Hook URL responds with
+// a non-2xx HTTP status. It wraps ErrDispatchHTTPFailure.
+type HTTPError struct {
+	// URL is the Hook URL that was POSTed to.
+	URL string
+	// StatusCode is the HTTP status code of the final response.
+	StatusCode int
+}
+
+// Error returns a description of the HTTPError including the status code.
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s: %q responded with HTTP status %d",
+		ErrDispatchHTTPFailure, e.URL, e.StatusCode)
+}
+
+// Unwrap returns ErrDispatchHTTPFailure so that HTTPErrors match it with
+// errors.Is.
+func (e *HTTPError) Unwrap() error {
+	return ErrDispatchHTTPFailure
+}
+
+// Dispatcher is implemented by the types that Events can be dispatched to,
+// e.g. Hook and PagerDutyHook.
+type Dispatcher interface {
+	// Dispatch sends the Event, returning an error if it couldn't be sent.
+	Dispatch(ctx context.Context, e Event) error
+}
+
+// Hook is a URL for Event's to be POSTed to as JSON objects, or as Slack or
+// Discord messages, along with how failed POSTs are retried.
+type Hook struct {
+	// URL is the URL Events are POSTed to.
+	URL string
+	// MaxRetries is how many times a failed POST is retried. If zero failed POSTs
+	// aren't retried.
+	MaxRetries int
+	// InitialBackoff is how long to wait before the first retry. The wait is
+	// doubled, plus some jitter, before each following retry. If zero one second
+	// is used.
+	InitialBackoff time.Duration
+	// Timeout is how long each POST may take, including connecting and reading
+	// the response, so that a slow webhook server can't hold up dispatching. If
+	// zero 30 seconds is used.
+	Timeout time.Duration
+	// ProxyURL is the optional URL of an HTTP proxy POSTs are made through,
+	// e.g. "http://proxy.example.com:3128". If empty the proxy from the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables is used.
+	ProxyURL string
+	// InsecureSkipVerify disables verifying the TLS certificate of the Hook
+	// URL, e.g. for an internal webhook server with a self-signed certificate.
+	// It makes POSTs vulnerable to interception and should only be used for
+	// testing.
+	InsecureSkipVerify bool
+	// TLSCertFile is the optional path of a PEM client certificate presented
+	// to the webhook server for mutual TLS. It must be set with TLSKeyFile.
+	TLSCertFile string
+	// TLSKeyFile is the optional path of the PEM private key of the
+	// TLSCertFile.
+	TLSKeyFile string
+	// TLSCACertFile is the optional path of PEM CA certificates the webhook
+	// server's certificate is verified with instead of the system root CAs.
+	TLSCACertFile string
+	// HookFormat is the format Events are POSTed in: FormatJSON, FormatSlack
+	// or FormatDiscord. If empty FormatJSON is used.
+	HookFormat string
+	// Secret is an optional secret POSTs are signed with. If it isn't empty
+	// each POST has a SignatureHeader with the signature of the body so that
+	// the receiver can verify it came from woodwatch with VerifySignature.
+	Secret string
+	// CircuitBreaker is an optional CircuitBreaker that stops Events being
+	// POSTed to a URL that keeps failing. While it is open Dispatch returns
+	// ErrCircuitOpen immediately. If nil every Event is POSTed.
+	*CircuitBreaker
+	// Tracer is an optional OpenTelemetry Tracer. If it isn't nil each POST is
+	// traced with a span recording the HTTP status code, the redacted URL and
+	// any error. If nil POSTs aren't traced.
+	Tracer trace.Tracer
+}
+
+// HookOption is an option applied to a Hook constructed with NewHook.
+type HookOption func(*Hook)
+
+// NewHook returns a Hook for the given URL that retries failed POSTs 3 times
+// starting with a one second backoff and times out each POST after 30
+// seconds. After 5 consecutive failed dispatches its CircuitBreaker opens for
+// a minute. The given HookOptions are applied in order.
+func NewHook(url string, opts ...HookOption) *Hook {
+	h := &Hook{
+		URL:            url,
+		MaxRetries:     defaultMaxRetries,
+		InitialBackoff: defaultInitialBackoff,
+		Timeout:        defaultTimeout,
+		CircuitBreaker: NewCircuitBreaker(
+			defaultFailureThreshold, defaultSuccessThreshold, defaultOpenDuration),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
 
 // Event is a struct for describing a state change event observed for
 // a woodwatch Peer.
 type Event struct {
+	// Peer is the name of the Peer the event is for.
+	Peer string `json:"peer"`
+	// Tags are the optional labels configured for the Peer.
+	Tags []string `json:"tags,omitempty"`
+	// Labels are the optional label names and values configured for the Peer,
+	// e.g. {"region": "eu-west"}.
+	Labels map[string]string `json:"labels,omitempty"`
+	// PeerDescription is the optional description configured for the Peer.
+	PeerDescription string `json:"peerDescription,omitempty"`
+	// PeerOwner is the optional owner configured for the Peer.
+	PeerOwner string `json:"peerOwner,omitempty"`
+	// PeerURL is the optional URL with more information configured for the
+	// Peer.
+	PeerURL string `json:"peerURL,omitempty"`
 	// Title is the title of the event.
 	Title string `json:"title"`
 	// Text is a textual description of the event.
@@ -47,6 +170,10 @@ type Event struct {
 	NewState string `json:"newState"`
 	// PrevState is the state the Peer was previously in.
 	PrevState string `json:"prevState"`
+	// StateDuration is how long the Peer was in its last Up, Down or Flapping
+	// state before the event, including any Maybe states it went through
+	// since, e.g. 3h24m for a Peer that is Up after being Down for 3h24m.
+	StateDuration time.Duration `json:"stateDuration"`
 }
 
 // Valid checks that an Event has a Title, a NewState and a PrevState. Otherwise
@@ -65,38 +192,157 @@ func (e Event) Valid() error {
 	return nil
 }
 
-// Dispatch POSTs the provided Event to the Hook URL as a JSON object. Errors
-// with the event, marshaling, POSTing, or from the server are presently
-// ignored.
-//
-// TODO(@cpu): Figure out error handling for things that go wrong during dispatch.
-func (h Hook) Dispatch(e Event) {
+// Dispatch POSTs the provided Event to the Hook URL in the Hook's HookFormat.
+// If the POST fails because of a network error, or the server responds with
+// a 429 or 5xx status, it is retried up to MaxRetries times with exponential
+// backoff.
+// Retries stop early when the context is done. If the Event is not valid the
+// error from Event.Valid() is returned. If the Hook has a CircuitBreaker that
+// is open ErrCircuitOpen is returned without POSTing. If the Hook's ProxyURL
+// isn't valid or its TLS files can't be loaded ErrInvalidProxyURL,
+// ErrIncompleteTLSKeyPair or ErrLoadTLSFiles is returned. If the final POST
+// fails its error is returned. If the final response has a non-2xx status an
+// *HTTPError wrapping ErrDispatchHTTPFailure is returned. Events for a Hook
+// with FormatDiscord are dispatched with DiscordDispatch.
+func (h Hook) Dispatch(ctx context.Context, e Event) error {
+	if h.HookFormat == FormatDiscord {
+		return h.DiscordDispatch(ctx, e)
+	}
 	if err := e.Valid(); err != nil {
-		return
+		return err
 	}
 
-	eventBytes, err := json.MarshalIndent(e, "", "  ")
+	eventBytes, err := h.payload(e)
 	if err != nil {
-		return
+		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	return h.dispatch(ctx, eventBytes)
+}
+
+// DiscordDispatch POSTs the provided Event to the Hook URL as a Discord
+// message with an embed colored green when the peer is now up, red when it
+// is now down and yellow when it is flapping. The embed has fields for the
+// peer, its previous and current state and when it was last seen. Dispatch
+// uses it for Hooks with FormatDiscord but it can be used with any HookFormat.
+// POSTs are retried and errors returned as described by Dispatch.
+func (h Hook) DiscordDispatch(ctx context.Context, e Event) error {
+	if err := e.Valid(); err != nil {
+		return err
+	}
+
+	eventBytes, err := discordPayload(e)
+	if err != nil {
+		return err
+	}
+
+	return h.dispatch(ctx, eventBytes)
+}
+
+// dispatch sends the given body to the Hook URL through the Hook's
+// CircuitBreaker, if it has one.
+func (h Hook) dispatch(ctx context.Context, eventBytes []byte) error {
+	if h.CircuitBreaker == nil {
+		return h.send(ctx, eventBytes)
+	}
+	if err := h.CircuitBreaker.allow(); err != nil {
+		return err
+	}
+	err := h.send(ctx, eventBytes)
+	h.CircuitBreaker.record(err)
+
+	return err
+}
+
+// send POSTs the given body to the Hook URL, retrying failed POSTs as
+// described by Dispatch.
+func (h Hook) send(ctx context.Context, eventBytes []byte) error {
+	backoff := h.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultInitialBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := h.post(ctx, eventBytes)
+		if err == nil || !retry || attempt >= h.MaxRetries {
+			return err
+		}
+
+		// Wait for the backoff plus up to half of it again as jitter before
+		// retrying, unless the context is done first.
+		wait := backoff + time.Duration(rand.Int64N(int64(backoff)/2+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return fmt.Errorf("%w (last error: %w)", ctx.Err(), err)
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// payload returns the body POSTed to the Hook URL for the given Event in the
+// Hook's HookFormat. FormatDiscord Events are POSTed by DiscordDispatch.
+func (h Hook) payload(e Event) ([]byte, error) {
+	switch h.HookFormat {
+	case FormatSlack:
+		return slackPayload(e)
+	default:
+		return json.MarshalIndent(e, "", "  ")
+	}
+}
+
+// post makes a single POST of the given event bytes to the Hook URL, traced
+// with a span if the Hook has a Tracer. It returns an error if the POST failed
+// and whether the POST should be retried.
+func (h Hook) post(ctx context.Context, eventBytes []byte) (bool, error) {
+	ctx, span := h.startSpan(ctx)
+	statusCode, retry, err := h.postOnce(ctx, eventBytes)
+	h.endSpan(span, statusCode, err)
+
+	return retry, err
+}
+
+// postOnce makes the POST described by post. It also returns the HTTP status
+// code of the response, or zero if there was no response.
+func (h Hook) postOnce(ctx context.Context, eventBytes []byte) (int, bool, error) {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "POST", string(h), bytes.NewBuffer(eventBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", h.URL, bytes.NewBuffer(eventBytes))
 	if err != nil {
-		return
+		return 0, false, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", fmt.Sprintf(
-		"cpu.woodwatch 0.0.1 (%s; %s)",
-		runtime.GOOS, runtime.GOARCH))
+	if h.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign([]byte(h.Secret), eventBytes))
+	}
+	req.Header.Set("User-Agent", userAgent())
 
-	client := &http.Client{}
+	client, err := h.httpClient(timeout)
+	if err != nil {
+		return 0, false, err
+	}
+	if client.Transport != nil {
+		defer client.CloseIdleConnections()
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return
+		return 0, true, err
 	}
 	defer resp.Body.Close()
 	_, _ = ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+
+		return resp.StatusCode, retry, &HTTPError{URL: h.URL, StatusCode: resp.StatusCode}
+	}
+
+	return resp.StatusCode, false, nil
 }
@@ -1 +1,189 @@
 package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testEvent is a valid Event used in tests.
+var testEvent = Event{
+	Peer:      "test",
+	Title:     "Peer test is Up",
+	NewState:  "Up",
+	PrevState: "Maybe Up (1 of 1)",
+}
+
+// TestDispatchRetries tests that Dispatch retries failed POSTs that can be
+// retried up to the Hook's MaxRetries.
+func TestDispatchRetries(t *testing.T) {
+	testCases := []struct {
+		Name             string
+		Statuses         []int
+		MaxRetries       int
+		ExpectedRequests int32
+		ExpectError      bool
+	}{
+		{
+			Name:             "Success",
+			Statuses:         []int{http.StatusOK},
+			MaxRetries:       3,
+			ExpectedRequests: 1,
+		},
+		{
+			Name:             "Success after retries",
+			Statuses:         []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusOK},
+			MaxRetries:       3,
+			ExpectedRequests: 3,
+		},
+		{
+			Name:             "Retries exhausted",
+			Statuses:         []int{http.StatusInternalServerError},
+			MaxRetries:       2,
+			ExpectedRequests: 3,
+			ExpectError:      true,
+		},
+		{
+			Name:             "No retries",
+			Statuses:         []int{http.StatusInternalServerError},
+			ExpectedRequests: 1,
+			ExpectError:      true,
+		},
+		{
+			Name:             "Client error isn't retried",
+			Statuses:         []int{http.StatusBadRequest},
+			MaxRetries:       3,
+			ExpectedRequests: 1,
+			ExpectError:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := int(requests.Add(1)) - 1
+				if i >= len(tc.Statuses) {
+					i = len(tc.Statuses) - 1
+				}
+				w.WriteHeader(tc.Statuses[i])
+			}))
+			defer srv.Close()
+
+			h := Hook{
+				URL:            srv.URL,
+				MaxRetries:     tc.MaxRetries,
+				InitialBackoff: time.Millisecond,
+			}
+			err := h.Dispatch(context.Background(), testEvent)
+			if (err != nil) != tc.ExpectError {
+				t.Errorf("expected Dispatch error: %v, got %v", tc.ExpectError, err)
+			}
+			if count := requests.Load(); count != tc.ExpectedRequests {
+				t.Errorf("expected %d requests, got %d", tc.ExpectedRequests, count)
+			}
+		})
+	}
+}
+
+// TestDispatchContext tests that Dispatch stops retrying when the context is
+// done.
+func TestDispatchContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	h := Hook{
+		URL:            srv.URL,
+		MaxRetries:     10,
+		InitialBackoff: time.Hour,
+	}
+	start := time.Now()
+	if err := h.Dispatch(ctx, testEvent); err == nil {
+		t.Errorf("expected Dispatch to return err when context is done, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Dispatch to return when the context was done, took %v", elapsed)
+	}
+}
+
+// TestDispatchErrors tests that Dispatch returns the expected error for each
+// way dispatching can fail.
+func TestDispatchErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	closedSrv := httptest.NewServer(http.NotFoundHandler())
+	closedSrv.Close()
+
+	testCases := []struct {
+		Name               string
+		URL                string
+		Event              Event
+		ExpectedError      error
+		ExpectedStatusCode int
+	}{
+		{
+			Name:          "Empty title",
+			URL:           srv.URL,
+			Event:         Event{NewState: "Up", PrevState: "Down"},
+			ExpectedError: ErrEmptyEventTitle,
+		},
+		{
+			Name:          "Empty new state",
+			URL:           srv.URL,
+			Event:         Event{Title: "Title", PrevState: "Down"},
+			ExpectedError: ErrEmptyNewState,
+		},
+		{
+			Name:          "Empty previous state",
+			URL:           srv.URL,
+			Event:         Event{Title: "Title", NewState: "Up"},
+			ExpectedError: ErrEmptyPrevState,
+		},
+		{
+			Name:               "HTTP failure",
+			URL:                srv.URL,
+			Event:              testEvent,
+			ExpectedError:      ErrDispatchHTTPFailure,
+			ExpectedStatusCode: http.StatusNotFound,
+		},
+		{
+			Name:  "Network failure",
+			URL:   closedSrv.URL,
+			Event: testEvent,
+		},
+		{
+			Name:  "Invalid URL",
+			URL:   "://not a url",
+			Event: testEvent,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := Hook{URL: tc.URL}.Dispatch(context.Background(), tc.Event)
+			if err == nil {
+				t.Fatalf("expected Dispatch to return err, got nil")
+			}
+			if tc.ExpectedError != nil && !errors.Is(err, tc.ExpectedError) {
+				t.Errorf("expected err %v, got %v", tc.ExpectedError, err)
+			}
+			var httpErr *HTTPError
+			if isHTTPErr := errors.As(err, &httpErr); isHTTPErr != (tc.ExpectedStatusCode != 0) {
+				t.Errorf("expected HTTPError: %v, got %v", tc.ExpectedStatusCode != 0, err)
+			} else if isHTTPErr && httpErr.StatusCode != tc.ExpectedStatusCode {
+				t.Errorf("expected HTTP status %d, got %d",
+					tc.ExpectedStatusCode, httpErr.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var (
+	// defaultNATSSubject is the subject prefix used when a NATSPublisher is
+	// constructed without one.
+	defaultNATSSubject = "woodwatch"
+	// natsSubjectReplacer replaces characters that have a special meaning in
+	// NATS subjects, or that are not allowed in them, with underscores.
+	natsSubjectReplacer = strings.NewReplacer(
+		".", "_",
+		"*", "_",
+		">", "_",
+		" ", "_",
+		"\t", "_")
+)
+
+// NATSPublisher is a Publisher that sends Events as JSON to a NATS server.
+// Each Event is published to the subject `{subject}.{peer}`. If JetStream is
+// enabled Events are published with JetStream and the publish is acknowledged
+// by the server, giving at-least-once delivery for subjects bound to a stream.
+type NATSPublisher struct {
+	// subject is the prefix of the subject used for each Event.
+	subject string
+	// conn is the connection to the NATS server.
+	conn *nats.Conn
+	// js is the JetStream context used to publish when JetStream is enabled. It
+	// is nil otherwise.
+	js jetstream.JetStream
+	// closed is closed by the connection's closed handler once the connection
+	// has finished draining.
+	closed chan struct{}
+}
+
+// NewNATSPublisher constructs a NATSPublisher for the given NATS server URL,
+// subject prefix and optional credentials file. If the subject prefix is empty
+// "woodwatch" is used. If useJetStream is true Events are published with
+// JetStream. The NATS server doesn't need to be reachable when the
+// NATSPublisher is constructed: the connection is retried in the background
+// and Events published in the meantime are buffered.
+func NewNATSPublisher(
+	server string,
+	subject string,
+	credentials string,
+	useJetStream bool) (*NATSPublisher, error) {
+	if subject == "" {
+		subject = defaultNATSSubject
+	}
+	p := &NATSPublisher{
+		subject: subject,
+		closed:  make(chan struct{}),
+	}
+
+	opts := []nats.Option{
+		nats.Name("woodwatch"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.DrainTimeout(defaultTimeout),
+		nats.ClosedHandler(func(*nats.Conn) {
+			close(p.closed)
+		}),
+	}
+	if credentials != "" {
+		opts = append(opts, nats.UserCredentials(credentials))
+	}
+
+	conn, err := nats.Connect(server, opts...)
+	if err != nil {
+		return nil, err
+	}
+	p.conn = conn
+
+	if useJetStream {
+		js, err := jetstream.New(conn)
+		if err != nil {
+			conn.Close()
+
+			return nil, err
+		}
+		p.js = js
+	}
+
+	return p, nil
+}
+
+// eventSubject returns the subject for the given Event.
+func (p *NATSPublisher) eventSubject(e Event) string {
+	return fmt.Sprintf("%s.%s", p.subject, natsSubjectReplacer.Replace(e.Peer))
+}
+
+// Publish sends the Event to the NATSPublisher's subject as a JSON object. When
+// JetStream is enabled Publish blocks until the server acknowledges the
+// message.
+func (p *NATSPublisher) Publish(e Event) error {
+	if err := e.Valid(); err != nil {
+		return err
+	}
+
+	eventBytes, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	subject := p.eventSubject(e)
+	if p.js == nil {
+		return p.conn.Publish(subject, eventBytes)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	_, err = p.js.Publish(ctx, subject, eventBytes)
+
+	return err
+}
+
+// Close drains the NATSPublisher's connection, waiting for in-flight messages to
+// be delivered to the server before the connection is closed.
+func (p *NATSPublisher) Close() error {
+	err := p.conn.Drain()
+	// If the connection was still (re)connecting Drain closes it immediately.
+	// There is nothing more to wait for in that case.
+	if err == nats.ErrConnectionReconnecting {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	<-p.closed
+
+	return nil
+}
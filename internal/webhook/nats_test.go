@@ -0,0 +1,86 @@
+package webhook
+
+import (
+	"testing"
+)
+
+// testNATSServer is a NATS server URL that nothing is listening on.
+const testNATSServer = "nats://127.0.0.1:1"
+
+// TestNATSSubject tests that NATSPublisher builds the expected subject for
+// events.
+func TestNATSSubject(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		Prefix          string
+		Event           Event
+		ExpectedSubject string
+	}{
+		{
+			Name:            "Default prefix",
+			Event:           Event{Peer: "ISP-A"},
+			ExpectedSubject: "woodwatch.ISP-A",
+		},
+		{
+			Name:            "Custom prefix",
+			Prefix:          "monitoring.peers",
+			Event:           Event{Peer: "ISP-B"},
+			ExpectedSubject: "monitoring.peers.ISP-B",
+		},
+		{
+			Name:            "Peer name with special characters",
+			Event:           Event{Peer: "Cocego :fire: *.example>"},
+			ExpectedSubject: "woodwatch.Cocego_:fire:___example_",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p, err := NewNATSPublisher(testNATSServer, tc.Prefix, "", false)
+			if err != nil {
+				t.Fatalf("expected NewNATSPublisher to return nil err, got %v", err)
+			}
+			defer p.Close()
+			if subject := p.eventSubject(tc.Event); subject != tc.ExpectedSubject {
+				t.Errorf("expected subject %q, got %q", tc.ExpectedSubject, subject)
+			}
+		})
+	}
+}
+
+// TestNATSPublishDisconnected tests that events can be published before the
+// NATS server is reachable and that Close returns when the connection was
+// never established.
+func TestNATSPublishDisconnected(t *testing.T) {
+	p, err := NewNATSPublisher(testNATSServer, "", "", false)
+	if err != nil {
+		t.Fatalf("expected NewNATSPublisher to return nil err, got %v", err)
+	}
+
+	if err := p.Publish(Event{}); err != ErrEmptyEventTitle {
+		t.Errorf("expected Publish of invalid event to return %v, got %v",
+			ErrEmptyEventTitle, err)
+	}
+
+	event := Event{
+		Peer:      "test",
+		Title:     "Peer test is Up",
+		NewState:  "Up",
+		PrevState: "Maybe Up (1 of 1)",
+	}
+	if err := p.Publish(event); err != nil {
+		t.Errorf("expected Publish while disconnected to return nil, got %v", err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Errorf("expected Close to return nil, got %v", err)
+	}
+}
+
+// TestNATSBadCredentials tests that NewNATSPublisher returns an error when the
+// credentials file can't be read.
+func TestNATSBadCredentials(t *testing.T) {
+	if _, err := NewNATSPublisher(testNATSServer, "", "/does/not/exist.creds", false); err == nil {
+		t.Errorf("expected NewNATSPublisher with missing credentials to return err, got nil")
+	}
+}
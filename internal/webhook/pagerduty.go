@@ -0,0 +1,118 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	// PagerDutyEventsURL is the PagerDuty Events API v2 endpoint that
+	// PagerDutyHooks POST to.
+	PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+	// pagerDutyTrigger is the event action that opens a PagerDuty incident.
+	pagerDutyTrigger = "trigger"
+	// pagerDutyResolve is the event action that resolves a PagerDuty incident.
+	pagerDutyResolve = "resolve"
+)
+
+var (
+	// ErrEmptyRoutingKey is returned from PagerDutyHook.Dispatch when the
+	// PagerDutyHook has no RoutingKey.
+	ErrEmptyRoutingKey = errors.New("PagerDuty RoutingKey must not be empty")
+)
+
+// PagerDutyHook is a Dispatcher that sends Events to the PagerDuty Events API
+// v2. A peer going Down triggers an incident and the peer coming back Up
+// resolves it. Events for other states aren't sent.
+type PagerDutyHook struct {
+	// RoutingKey is the integration key of the PagerDuty service incidents are
+	// opened for.
+	RoutingKey string
+	// hook POSTs the PagerDuty events and retries failed POSTs.
+	hook Hook
+}
+
+// NewPagerDutyHook returns a PagerDutyHook for the given routing key that
+// POSTs to PagerDutyEventsURL, retrying failed POSTs like a Hook constructed
+// with NewHook.
+func NewPagerDutyHook(routingKey string) *PagerDutyHook {
+	return &PagerDutyHook{
+		RoutingKey: routingKey,
+		hook:       *NewHook(PagerDutyEventsURL),
+	}
+}
+
+// pagerDutyPayload describes the incident of a PagerDuty trigger event.
+type pagerDutyPayload struct {
+	Summary       string `json:"summary"`
+	Source        string `json:"source"`
+	Severity      string `json:"severity"`
+	Timestamp     string `json:"timestamp,omitempty"`
+	CustomDetails Event  `json:"custom_details"`
+}
+
+// pagerDutyLink is a link shown with a PagerDuty incident.
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text,omitempty"`
+}
+
+// pagerDutyEvent is the body of a PagerDuty Events API v2 POST.
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Links       []pagerDutyLink   `json:"links,omitempty"`
+}
+
+// Dispatch sends a trigger event to PagerDuty when the Event is for a peer that
+// is now Down and a resolve event when the Event is for a peer that is now Up.
+// Both use a dedup key derived from the peer name so that the resolve event
+// resolves the triggered incident. The trigger event's custom details are the
+// Event, including its peer annotations, and it links to the Event's PeerURL
+// if it has one. Events for other states are ignored. Errors
+// are returned like Hook.Dispatch.
+func (h PagerDutyHook) Dispatch(ctx context.Context, e Event) error {
+	if err := e.Valid(); err != nil {
+		return err
+	}
+	if h.RoutingKey == "" {
+		return ErrEmptyRoutingKey
+	}
+
+	pdEvent := pagerDutyEvent{
+		RoutingKey: h.RoutingKey,
+		DedupKey:   "woodwatch/" + e.Peer,
+	}
+	switch e.NewState {
+	case "Down":
+		pdEvent.EventAction = pagerDutyTrigger
+		pdEvent.Payload = &pagerDutyPayload{
+			Summary:       e.Title,
+			Source:        e.Peer,
+			Severity:      "critical",
+			CustomDetails: e,
+		}
+		if e.PeerURL != "" {
+			pdEvent.Links = []pagerDutyLink{{Href: e.PeerURL, Text: e.Peer}}
+		}
+		if !e.Timestamp.IsZero() {
+			pdEvent.Payload.Timestamp = e.Timestamp.Format(time.RFC3339)
+		}
+	case "Up":
+		pdEvent.EventAction = pagerDutyResolve
+	default:
+		return nil
+	}
+
+	eventBytes, err := json.Marshal(pdEvent)
+	if err != nil {
+		return err
+	}
+
+	return h.hook.send(ctx, eventBytes)
+}
@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestPagerDutyDispatch tests that a PagerDutyHook triggers an incident when
+// a peer goes Down, resolves it when the peer comes back Up and ignores other
+// events.
+func TestPagerDutyDispatch(t *testing.T) {
+	events := make(chan pagerDutyEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event pagerDutyEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("expected a JSON body, got err %v", err)
+		}
+		events <- event
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	h := NewPagerDutyHook("routing-key")
+	h.hook.URL = srv.URL
+
+	testCases := []struct {
+		Name           string
+		Event          Event
+		ExpectedAction string
+		ExpectedLinks  []pagerDutyLink
+	}{
+		{
+			Name: "Down triggers",
+			Event: Event{
+				Peer:      "test",
+				Title:     "Peer test is Down",
+				Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				NewState:  "Down",
+				PrevState: "Maybe Down (1 of 1)",
+			},
+			ExpectedAction: pagerDutyTrigger,
+		},
+		{
+			Name: "Down with peer URL links to it",
+			Event: Event{
+				Peer:      "test",
+				PeerOwner: "network-team",
+				PeerURL:   "https://wiki.example.com/test",
+				Title:     "Peer test is Down",
+				Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				NewState:  "Down",
+				PrevState: "Maybe Down (1 of 1)",
+			},
+			ExpectedAction: pagerDutyTrigger,
+			ExpectedLinks:  []pagerDutyLink{{Href: "https://wiki.example.com/test", Text: "test"}},
+		},
+		{
+			Name:           "Up resolves",
+			Event:          testEvent,
+			ExpectedAction: pagerDutyResolve,
+		},
+		{
+			Name: "Flapping is ignored",
+			Event: Event{
+				Peer:      "test",
+				Title:     "Peer test is Flapping",
+				NewState:  "Flapping",
+				PrevState: "Maybe Up (1 of 1)",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if err := h.Dispatch(context.Background(), tc.Event); err != nil {
+				t.Fatalf("expected Dispatch to return nil err, got %v", err)
+			}
+			if tc.ExpectedAction == "" {
+				select {
+				case event := <-events:
+					t.Fatalf("expected no PagerDuty event, got %#v", event)
+				default:
+				}
+
+				return
+			}
+			event := <-events
+			if event.EventAction != tc.ExpectedAction {
+				t.Errorf("expected event action %q, got %q", tc.ExpectedAction, event.EventAction)
+			}
+			if event.RoutingKey != "routing-key" {
+				t.Errorf("expected routing key %q, got %q", "routing-key", event.RoutingKey)
+			}
+			if event.DedupKey != "woodwatch/test" {
+				t.Errorf("expected dedup key %q, got %q", "woodwatch/test", event.DedupKey)
+			}
+			if tc.ExpectedAction == pagerDutyTrigger {
+				if event.Payload == nil {
+					t.Fatalf("expected trigger event to have a payload")
+				}
+				if event.Payload.Summary != tc.Event.Title {
+					t.Errorf("expected summary %q, got %q", tc.Event.Title, event.Payload.Summary)
+				}
+				if event.Payload.Timestamp != "2024-01-01T00:00:00Z" {
+					t.Errorf("expected timestamp %q, got %q",
+						"2024-01-01T00:00:00Z", event.Payload.Timestamp)
+				}
+				if owner := event.Payload.CustomDetails.PeerOwner; owner != tc.Event.PeerOwner {
+					t.Errorf("expected custom details peer owner %q, got %q", tc.Event.PeerOwner, owner)
+				}
+				if !reflect.DeepEqual(event.Links, tc.ExpectedLinks) {
+					t.Errorf("expected links %#v, got %#v", tc.ExpectedLinks, event.Links)
+				}
+			} else if event.Payload != nil {
+				t.Errorf("expected resolve event to have no payload, got %#v", event.Payload)
+			}
+		})
+	}
+}
+
+// TestPagerDutyDispatchErrors tests that a PagerDutyHook returns an error for
+// invalid events and a missing routing key.
+func TestPagerDutyDispatchErrors(t *testing.T) {
+	if err := NewPagerDutyHook("routing-key").Dispatch(context.Background(), Event{}); err != ErrEmptyEventTitle {
+		t.Errorf("expected Dispatch of invalid event to return %v, got %v",
+			ErrEmptyEventTitle, err)
+	}
+	if err := NewPagerDutyHook("").Dispatch(context.Background(), testEvent); err != ErrEmptyRoutingKey {
+		t.Errorf("expected Dispatch without routing key to return %v, got %v",
+			ErrEmptyRoutingKey, err)
+	}
+}
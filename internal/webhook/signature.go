@@ -0,0 +1,45 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// SignatureHeader is the HTTP header a Hook with a Secret sets to the
+	// signature of the POSTed body.
+	SignatureHeader = "X-Woodwatch-Signature"
+
+	// signaturePrefix prefixes the hex encoded HMAC-SHA256 in a signature.
+	signaturePrefix = "sha256="
+)
+
+// Sign returns the signature of the given body for the given secret in the
+// format of the SignatureHeader: "sha256=" followed by the hex encoded
+// HMAC-SHA256 of the body keyed with the secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature returns true if the given signature, e.g. the value of the
+// SignatureHeader of a received POST, is the signature of the given body for
+// the given secret. The comparison is done in constant time.
+func VerifySignature(secret, body []byte, signature string) bool {
+	sigHex, ok := strings.CutPrefix(signature, signaturePrefix)
+	if !ok {
+		return false
+	}
+	sig, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return hmac.Equal(sig, mac.Sum(nil))
+}
@@ -0,0 +1,106 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSign tests that Sign computes the expected HMAC-SHA256 signature for
+// a known test vector.
+func TestSign(t *testing.T) {
+	expected := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	sig := Sign([]byte("key"), []byte("The quick brown fox jumps over the lazy dog"))
+	if sig != expected {
+		t.Errorf("expected signature %q, got %q", expected, sig)
+	}
+}
+
+// TestVerifySignature tests that VerifySignature accepts only the signature
+// of the body for the secret.
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("key")
+	body := []byte("The quick brown fox jumps over the lazy dog")
+
+	testCases := []struct {
+		Name      string
+		Secret    []byte
+		Body      []byte
+		Signature string
+		Expected  bool
+	}{
+		{
+			Name:      "Valid signature",
+			Secret:    secret,
+			Body:      body,
+			Signature: Sign(secret, body),
+			Expected:  true,
+		},
+		{
+			Name:      "Wrong secret",
+			Secret:    []byte("other"),
+			Body:      body,
+			Signature: Sign(secret, body),
+		},
+		{
+			Name:      "Modified body",
+			Secret:    secret,
+			Body:      []byte("The quick brown fox jumps over the lazy cat"),
+			Signature: Sign(secret, body),
+		},
+		{
+			Name:      "Missing prefix",
+			Secret:    secret,
+			Body:      body,
+			Signature: Sign(secret, body)[len(signaturePrefix):],
+		},
+		{
+			Name:      "Not hex",
+			Secret:    secret,
+			Body:      body,
+			Signature: "sha256=not-hex",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if valid := VerifySignature(tc.Secret, tc.Body, tc.Signature); valid != tc.Expected {
+				t.Errorf("expected VerifySignature to return %v, got %v", tc.Expected, valid)
+			}
+		})
+	}
+}
+
+// TestDispatchSignature tests that Dispatch signs POSTs only when the Hook has
+// a Secret.
+func TestDispatchSignature(t *testing.T) {
+	type request struct {
+		body      []byte
+		signature string
+	}
+	requests := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{body: body, signature: r.Header.Get(SignatureHeader)}
+	}))
+	defer srv.Close()
+
+	h := Hook{URL: srv.URL, Secret: "secret"}
+	if err := h.Dispatch(context.Background(), testEvent); err != nil {
+		t.Fatalf("expected Dispatch to return nil err, got %v", err)
+	}
+	req := <-requests
+	if !VerifySignature([]byte("secret"), req.body, req.signature) {
+		t.Errorf("expected valid signature for body, got %q", req.signature)
+	}
+
+	h.Secret = ""
+	if err := h.Dispatch(context.Background(), testEvent); err != nil {
+		t.Fatalf("expected Dispatch to return nil err, got %v", err)
+	}
+	if req := <-requests; req.signature != "" {
+		t.Errorf("expected no signature without a secret, got %q", req.signature)
+	}
+}
@@ -0,0 +1,114 @@
+// Package testing provides a mock webhook receiver for tests that dispatch
+// woodwatch events.
+package testing
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/cpu/woodwatch/internal/webhook"
+)
+
+// MockHookServer is an HTTP server that webhook.Hooks can dispatch Events to.
+// It records the Events POSTed to it as JSON objects and responds with
+// a configurable HTTP status code after a configurable delay. It is safe for
+// concurrent use.
+type MockHookServer struct {
+	// srv is the httptest.Server Events are POSTed to.
+	srv *httptest.Server
+	// mu guards the fields below.
+	mu sync.Mutex
+	// events are the Events received, oldest first.
+	events []webhook.Event
+	// statusCode is the HTTP status code responses have.
+	statusCode int
+	// delay is how long to wait before responding.
+	delay time.Duration
+}
+
+// NewMockHookServer starts and returns a MockHookServer that responds to every
+// POST with HTTP status 200 straight away. The caller should Close it when it
+// is done.
+func NewMockHookServer() *MockHookServer {
+	m := &MockHookServer{statusCode: http.StatusOK}
+	m.srv = httptest.NewServer(http.HandlerFunc(m.handle))
+
+	return m
+}
+
+// handle records the Event in the body of the request and responds with the
+// MockHookServer's status code after its delay. Requests with a body that
+// isn't a JSON Event, e.g. Slack or Discord messages, are responded to with
+// HTTP status 400 and aren't recorded.
+func (m *MockHookServer) handle(w http.ResponseWriter, r *http.Request) {
+	// The body is read to the end so that the request's context is cancelled
+	// if the client goes away during the delay.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	var e webhook.Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	m.mu.Lock()
+	m.events = append(m.events, e)
+	statusCode, delay := m.statusCode, m.delay
+	m.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	w.WriteHeader(statusCode)
+}
+
+// URL returns the URL of the MockHookServer, for use as a webhook.Hook URL.
+func (m *MockHookServer) URL() string {
+	return m.srv.URL
+}
+
+// Events returns a copy of the Events received by the MockHookServer, oldest
+// first.
+func (m *MockHookServer) Events() []webhook.Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]webhook.Event(nil), m.events...)
+}
+
+// RespondWith sets the HTTP status code of the MockHookServer's later
+// responses and how long to wait before sending them. A POST whose request is
+// cancelled while waiting gets no response.
+func (m *MockHookServer) RespondWith(statusCode int, delay time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statusCode, m.delay = statusCode, delay
+}
+
+// Reset forgets the Events received by the MockHookServer and restores
+// responding with HTTP status 200 straight away.
+func (m *MockHookServer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = nil
+	m.statusCode, m.delay = http.StatusOK, 0
+}
+
+// Close shuts the MockHookServer down, blocking until its outstanding requests
+// have completed.
+func (m *MockHookServer) Close() {
+	m.srv.Close()
+}
@@ -0,0 +1,62 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cpu/woodwatch/internal/webhook"
+)
+
+var testEvent = webhook.Event{
+	Peer:      "test",
+	Title:     "Peer test is Up",
+	NewState:  "Up",
+	PrevState: "Maybe Up (1 of 1)",
+}
+
+// TestMockHookServer tests that a MockHookServer records the Events dispatched
+// to it, responds as configured with RespondWith and forgets both on Reset.
+func TestMockHookServer(t *testing.T) {
+	m := NewMockHookServer()
+	defer m.Close()
+	h := webhook.Hook{URL: m.URL()}
+
+	if err := h.Dispatch(context.Background(), testEvent); err != nil {
+		t.Fatalf("expected Dispatch to return nil err, got %v", err)
+	}
+	events := m.Events()
+	if len(events) != 1 || events[0].Title != testEvent.Title {
+		t.Fatalf("expected 1 event %q, got %v", testEvent.Title, events)
+	}
+
+	// Failed dispatches are still recorded.
+	m.RespondWith(http.StatusServiceUnavailable, 0)
+	var httpErr *webhook.HTTPError
+	err := h.Dispatch(context.Background(), testEvent)
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected Dispatch to return a %d HTTPError, got %v",
+			http.StatusServiceUnavailable, err)
+	}
+	if events := m.Events(); len(events) != 2 {
+		t.Errorf("expected 2 events, got %d", len(events))
+	}
+
+	// Dispatches time out waiting for a delayed response.
+	m.RespondWith(http.StatusOK, time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := h.Dispatch(ctx, testEvent); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Dispatch to return %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	m.Reset()
+	if events := m.Events(); len(events) != 0 {
+		t.Errorf("expected no events after Reset, got %d", len(events))
+	}
+	if err := h.Dispatch(context.Background(), testEvent); err != nil {
+		t.Errorf("expected Dispatch to return nil err after Reset, got %v", err)
+	}
+}
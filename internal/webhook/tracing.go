@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// postSpanName is the name of the span started for each POST made by a Hook
+// with a Tracer.
+const postSpanName = "webhook POST"
+
+// redactedURL replaces a Hook URL that can't be parsed in span attributes.
+const redactedURL = "REDACTED"
+
+// WithTracer returns a HookOption that sets the Hook's Tracer so that each POST
+// is traced with an OpenTelemetry span.
+func WithTracer(t trace.Tracer) HookOption {
+	return func(h *Hook) {
+		h.Tracer = t
+	}
+}
+
+// startSpan starts a span for a POST to the Hook URL as a child of any span in
+// the given context. It returns the context for the POST and the span, or the
+// given context and a nil span if the Hook has no Tracer.
+func (h Hook) startSpan(ctx context.Context) (context.Context, trace.Span) {
+	if h.Tracer == nil {
+		return ctx, nil
+	}
+
+	return h.Tracer.Start(ctx, postSpanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", "POST"),
+			attribute.String("url.full", redactURL(h.URL))))
+}
+
+// endSpan records the HTTP status code and error of a POST on the given span
+// and ends it. A status code of zero means no response was received. The
+// Hook URL is redacted from the error. It does nothing if the span is nil.
+func (h Hook) endSpan(span trace.Span, statusCode int, err error) {
+	if span == nil {
+		return
+	}
+	defer span.End()
+
+	if statusCode != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+	}
+	if err != nil {
+		// Errors like HTTPError and *url.Error include the Hook URL, which may
+		// contain a secret.
+		msg := strings.ReplaceAll(err.Error(), h.URL, redactURL(h.URL))
+		span.SetAttributes(attribute.String("error.message", msg))
+		span.SetStatus(codes.Error, msg)
+	}
+}
+
+// redactURL returns the scheme and host of the given URL without any user
+// info, path or query since webhook URLs often contain secrets, e.g. Slack
+// incoming webhook URLs.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return redactedURL
+	}
+
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host}).String()
+}
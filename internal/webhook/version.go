@@ -0,0 +1,17 @@
+package webhook
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Version is the woodwatch version sent in the User-Agent of webhook POSTs.
+// Release builds set it with -ldflags, e.g.
+// "-X github.com/cpu/woodwatch/internal/webhook.Version=1.2.3".
+var Version = "dev"
+
+// userAgent returns the User-Agent of webhook POSTs, e.g.
+// "cpu.woodwatch 1.2.3 (linux; amd64)".
+func userAgent() string {
+	return fmt.Sprintf("cpu.woodwatch %s (%s; %s)", Version, runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,31 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+// TestDispatchUserAgent tests that Dispatch POSTs with a User-Agent containing
+// the Version set at build time.
+func TestDispatchUserAgent(t *testing.T) {
+	defer func(v string) { Version = v }(Version)
+	Version = "1.2.3"
+
+	var userAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+	}))
+	defer srv.Close()
+
+	h := NewHook(srv.URL)
+	if err := h.Dispatch(context.Background(), testEvent); err != nil {
+		t.Fatalf("expected Dispatch to return nil, got %v", err)
+	}
+	expected := "cpu.woodwatch 1.2.3 (" + runtime.GOOS + "; " + runtime.GOARCH + ")"
+	if userAgent != expected {
+		t.Errorf("expected User-Agent %q, got %q", expected, userAgent)
+	}
+}
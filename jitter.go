@@ -0,0 +1,66 @@
+package woodwatch
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// peerJitter returns the delay in [0, jitter) of the check of the peer with
+// the given name from the start of a monitor cycle. It is derived from a hash
+// of the name so that a peer is delayed by the same amount every cycle, and in
+// every test, while peers with different names are spread across the jitter.
+// If the jitter isn't positive zero is returned.
+func peerJitter(name string, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+
+	return time.Duration(h.Sum64() % uint64(jitter))
+}
+
+// checkPeers checks each of the given peers for a monitor cycle. If the Server
+// has a monitorJitter each peer is checked once its peerJitter has passed since
+// the start of the cycle, in order of delay. It returns false without checking
+// the remaining peers if the context is done while waiting, otherwise true.
+func (s *Server) checkPeers(ctx context.Context, peers []*peer) bool {
+	if s.monitorJitter <= 0 {
+		for _, p := range peers {
+			s.checkPeer(ctx, p)
+		}
+
+		return true
+	}
+
+	type delayedPeer struct {
+		peer  *peer
+		delay time.Duration
+	}
+	delayed := make([]delayedPeer, 0, len(peers))
+	for _, p := range peers {
+		delayed = append(delayed, delayedPeer{peer: p, delay: peerJitter(p.Name, s.monitorJitter)})
+	}
+	sort.SliceStable(delayed, func(i, j int) bool {
+		return delayed[i].delay < delayed[j].delay
+	})
+
+	start := time.Now()
+	for _, d := range delayed {
+		if wait := d.delay - time.Since(start); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+
+				return false
+			case <-timer.C:
+			}
+		}
+		s.checkPeer(ctx, d.peer)
+	}
+
+	return true
+}
@@ -0,0 +1,85 @@
+package woodwatch
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// TestPeerJitter tests that peerJitter returns the same delay within the
+// jitter for the same peer name and spreads different names across it.
+func TestPeerJitter(t *testing.T) {
+	jitter := 500 * time.Millisecond
+	delays := make(map[time.Duration]bool)
+	for _, name := range []string{"LAN", "WAN", "VPN", "DMZ", "Lab"} {
+		delay := peerJitter(name, jitter)
+		if delay < 0 || delay >= jitter {
+			t.Errorf("expected peerJitter(%q) in [0, %v), got %v", name, jitter, delay)
+		}
+		if again := peerJitter(name, jitter); again != delay {
+			t.Errorf("expected peerJitter(%q) to be deterministic, got %v then %v",
+				name, delay, again)
+		}
+		delays[delay] = true
+	}
+	if len(delays) < 2 {
+		t.Errorf("expected peers to have different delays, got %v", delays)
+	}
+	if delay := peerJitter("LAN", 0); delay != 0 {
+		t.Errorf("expected peerJitter without jitter to be 0, got %v", delay)
+	}
+}
+
+// TestCheckPeersJitter tests that checkPeers checks every peer, waiting up to
+// the monitorJitter, unless the context is done while waiting.
+func TestCheckPeersJitter(t *testing.T) {
+	c := Config{
+		UpThreshold:   1,
+		DownThreshold: 1,
+		MonitorCycle:  "1s",
+		MonitorJitter: "20ms",
+		PeerTimeout:   "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+			{Name: "WAN", Network: "192.168.2.0/24"},
+			{Name: "VPN", Network: "192.168.3.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	if s.monitorJitter != 20*time.Millisecond {
+		t.Fatalf("expected monitorJitter %v, got %v", 20*time.Millisecond, s.monitorJitter)
+	}
+	for _, p := range s.peers {
+		p.lastSeen = time.Now()
+	}
+
+	start := time.Now()
+	if !s.checkPeers(context.Background(), s.peers) {
+		t.Fatalf("expected checkPeers to return true")
+	}
+	var maxDelay time.Duration
+	for _, p := range s.peers {
+		if state := p.state.String(); state != "Maybe Up (1 of 1)" {
+			t.Errorf("expected peer %s to have been checked, got state %q", p.Name, state)
+		}
+		if delay := peerJitter(p.Name, s.monitorJitter); delay > maxDelay {
+			maxDelay = delay
+		}
+	}
+	if elapsed := time.Since(start); elapsed < maxDelay {
+		t.Errorf("expected checkPeers to take at least %v, took %v", maxDelay, elapsed)
+	}
+
+	// Peers delayed past the start of the cycle aren't checked once the
+	// context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if s.checkPeers(ctx, s.peers) && maxDelay > 0 {
+		t.Errorf("expected checkPeers with a done context to return false")
+	}
+}
@@ -0,0 +1,30 @@
+package woodwatch
+
+import (
+	"sort"
+	"strings"
+)
+
+// peerLabelName is the Prometheus label every peer metric has for the peer's
+// name. PeerConfig Labels can't use it.
+const peerLabelName = "peer"
+
+// validLabelName returns true if the given PeerConfig Label name is a valid
+// Prometheus label name that isn't reserved, i.e. doesn't start with "__", and
+// isn't peerLabelName.
+func validLabelName(name string) bool {
+	return peerLabelPattern.MatchString(name) &&
+		!strings.HasPrefix(name, "__") &&
+		name != peerLabelName
+}
+
+// labelNames returns the names of the given labels in sorted order.
+func labelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
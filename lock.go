@@ -0,0 +1,68 @@
+package woodwatch
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultPeerLockTimeout is how long to wait for the lastSeenMu of a peer when
+// the Server has no peerLockTimeout.
+const defaultPeerLockTimeout = 5 * time.Second
+
+// peerLockRetryInterval is how long to wait between attempts to acquire the
+// lastSeenMu of a peer that is held.
+var peerLockRetryInterval = time.Millisecond
+
+// ErrPeerLockTimeout is returned (wrapped with the peer name) when the state
+// of a peer can't be accessed because its lock wasn't acquired within the
+// PeerLockTimeout.
+var ErrPeerLockTimeout = errors.New("Timed out waiting for the lock of peer")
+
+// lockPeer locks the lastSeenMu of the given peer for writing. It returns
+// false if the lock couldn't be acquired within the Server's peerLockTimeout,
+// in which case the caller must not access the peer's guarded fields or unlock
+// the lastSeenMu.
+func (s *Server) lockPeer(p *peer) bool {
+	return s.tryLockPeer(p, p.lastSeenMu.TryLock, "write")
+}
+
+// rLockPeer locks the lastSeenMu of the given peer for reading. Like lockPeer
+// it returns false if the lock couldn't be acquired within the Server's
+// peerLockTimeout.
+func (s *Server) rLockPeer(p *peer) bool {
+	return s.tryLockPeer(p, p.lastSeenMu.TryRLock, "read")
+}
+
+// tryLockPeer calls the given tryLock function until it returns true or the
+// Server's peerLockTimeout passes. A peer whose lock was never released, e.g.
+// by a goroutine that panicked while holding it, would otherwise hang the
+// Server. When the timeout passes a warning is logged, the peerLockTimeouts
+// are incremented and false is returned.
+func (s *Server) tryLockPeer(p *peer, tryLock func() bool, kind string) bool {
+	if tryLock() {
+		return true
+	}
+	timeout := s.peerLockTimeout
+	if timeout == 0 {
+		timeout = defaultPeerLockTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(peerLockRetryInterval)
+		if tryLock() {
+			return true
+		}
+	}
+	s.peerLockTimeouts.Add(1)
+	s.warnf("timed out after %v waiting for the %s lock of Peer %s, it may be deadlocked\n",
+		timeout, kind, p.Name)
+
+	return false
+}
+
+// peerLockTimeoutError returns ErrPeerLockTimeout wrapped with the name of the
+// given peer.
+func peerLockTimeoutError(p *peer) error {
+	return fmt.Errorf("%w: %q", ErrPeerLockTimeout, p.Name)
+}
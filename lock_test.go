@@ -0,0 +1,66 @@
+package woodwatch
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPeerLockTimeout tests that a peer whose lock is never released doesn't
+// hang the Server: acquiring it times out after the PeerLockTimeout, logs
+// a warning and is counted, and the lock can be acquired once it is released.
+func TestPeerLockTimeout(t *testing.T) {
+	c := Config{
+		MonitorCycle:    "1s",
+		PeerTimeout:     "2s",
+		PeerLockTimeout: "20ms",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	var buf bytes.Buffer
+	s, err := NewServerFromConfig(log.New(&buf, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	p := s.peers[0]
+
+	// Hold the lock as a goroutine that panicked without unlocking would.
+	p.lastSeenMu.Lock()
+	start := time.Now()
+	if err := s.ManualHeartbeat(p.Name); !errors.Is(err, ErrPeerLockTimeout) {
+		t.Errorf("expected ManualHeartbeat to return %v, got %v", ErrPeerLockTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("expected ManualHeartbeat to give up after the PeerLockTimeout, took %v", elapsed)
+	}
+	if _, err := s.PeerHistory(p.Name); !errors.Is(err, ErrPeerLockTimeout) {
+		t.Errorf("expected PeerHistory to return %v, got %v", ErrPeerLockTimeout, err)
+	}
+	if statuses := s.Peers(); len(statuses) != 0 {
+		t.Errorf("expected Peers to leave out the locked peer, got %v", statuses)
+	}
+	if timeouts := s.peerLockTimeouts.Load(); timeouts != 3 {
+		t.Errorf("expected 3 peer lock timeouts, got %d", timeouts)
+	}
+	if !strings.Contains(buf.String(), "waiting for the write lock of Peer LAN") {
+		t.Errorf("expected a warning to be logged, got %q", buf.String())
+	}
+	p.lastSeenMu.Unlock()
+
+	// Once released the lock is acquired while it is briefly held.
+	p.lastSeenMu.RLock()
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		p.lastSeenMu.RUnlock()
+	}()
+	if err := s.ManualHeartbeat(p.Name); err != nil {
+		t.Errorf("expected ManualHeartbeat to return nil err, got %v", err)
+	}
+	if timeouts := s.peerLockTimeouts.Load(); timeouts != 3 {
+		t.Errorf("expected 3 peer lock timeouts, got %d", timeouts)
+	}
+}
@@ -0,0 +1,145 @@
+package woodwatch
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/cpu/woodwatch/internal/webhook"
+)
+
+// parseLogLevel returns the slog.Level of the given LogLevel. An empty
+// LogLevel is LogLevelInfo. If the LogLevel isn't supported it returns
+// ErrInvalidLogLevel wrapped with the LogLevel.
+func parseLogLevel(level string) (slog.Level, error) {
+	switch level {
+	case LogLevelDebug:
+		return slog.LevelDebug, nil
+	case "", LogLevelInfo:
+		return slog.LevelInfo, nil
+	case LogLevelWarn:
+		return slog.LevelWarn, nil
+	case LogLevelError:
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrInvalidLogLevel, level)
+	}
+}
+
+// logEnabled returns true if messages of the given level are logged by the
+// Server: those at or above its logLevel. Debug messages are also logged when
+// the Server is verbose.
+func (s *Server) logEnabled(level slog.Level) bool {
+	return level >= s.logLevel || (level == slog.LevelDebug && s.verbose)
+}
+
+// logf logs the message formatted from the given format and args at the given
+// level if logEnabled. With a slog.Logger the message is logged at that level,
+// otherwise it is printed to the log.Logger with warnings prefixed by
+// "warning: ".
+func (s *Server) logf(level slog.Level, format string, args ...any) {
+	if !s.logEnabled(level) {
+		return
+	}
+	if s.slog != nil {
+		msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+		s.slog.Log(context.Background(), level, msg)
+
+		return
+	}
+	if level == slog.LevelWarn {
+		format = "warning: " + format
+	}
+	s.log.Printf(format, args...)
+}
+
+// debugf logs a debug message, e.g. about a single packet, with logf.
+func (s *Server) debugf(format string, args ...any) {
+	s.logf(slog.LevelDebug, format, args...)
+}
+
+// infof logs an info message with logf.
+func (s *Server) infof(format string, args ...any) {
+	s.logf(slog.LevelInfo, format, args...)
+}
+
+// warnf logs a warning with logf.
+func (s *Server) warnf(format string, args ...any) {
+	s.logf(slog.LevelWarn, format, args...)
+}
+
+// errorf logs an error message with logf.
+func (s *Server) errorf(format string, args ...any) {
+	s.logf(slog.LevelError, format, args...)
+}
+
+// logEvent logs the given event at the info level. With a slog.Logger the
+// event's peer, states and last seen time are logged as structured fields
+// alongside its title, otherwise only its title is logged.
+func (s *Server) logEvent(event webhook.Event) {
+	if !s.logEnabled(slog.LevelInfo) {
+		return
+	}
+	if s.slog == nil {
+		s.log.Print(event.Title)
+
+		return
+	}
+	s.slog.Info(event.Title, eventAttrs(event)...)
+}
+
+// logAcknowledgedEvent logs the given event for a peer that is acknowledged
+// until the given time with the given message at the info level.
+func (s *Server) logAcknowledgedEvent(event webhook.Event, ackUntil time.Time, ackMessage string) {
+	if !s.logEnabled(slog.LevelInfo) {
+		return
+	}
+	if s.slog == nil {
+		s.log.Printf("%s (acknowledged until %s: %s)\n", event.Title,
+			ackUntil.Format(time.RFC3339), ackMessage)
+
+		return
+	}
+	s.slog.Info(event.Title, append(eventAttrs(event),
+		"acknowledgedUntil", ackUntil,
+		"acknowledgement", ackMessage)...)
+}
+
+// logDispatchError logs the given error from dispatching the given event to
+// the given webhook URL as a warning.
+func (s *Server) logDispatchError(event webhook.Event, hookURL string, err error) {
+	if !s.logEnabled(slog.LevelWarn) {
+		return
+	}
+	if s.slog == nil {
+		if event.Peer == "" {
+			s.log.Printf("warning: error dispatching all down webhook: %v\n", err)
+		} else {
+			s.log.Printf("warning: error dispatching webhook %q for peer %s: %v\n",
+				hookURL, event.Peer, err)
+		}
+
+		return
+	}
+	s.slog.Warn("error dispatching webhook", append(eventAttrs(event),
+		"webhook", hookURL,
+		"error", err)...)
+}
+
+// eventAttrs returns the structured slog fields describing the given event.
+func eventAttrs(event webhook.Event) []any {
+	attrs := []any{
+		"state", event.NewState,
+		"prevState", event.PrevState,
+	}
+	if event.Peer != "" {
+		attrs = append([]any{"peer", event.Peer}, attrs...)
+	}
+	if !event.LastSeen.IsZero() {
+		attrs = append(attrs, "lastSeen", event.LastSeen)
+	}
+
+	return attrs
+}
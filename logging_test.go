@@ -0,0 +1,188 @@
+package woodwatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLogEvent tests that events are logged with structured fields when the
+// Server has a slog.Logger and as plain text otherwise.
+func TestLogEvent(t *testing.T) {
+	c := Config{
+		UpThreshold:  1,
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	// bringUp makes the Server's peer Up, logging the noteworthy event.
+	bringUp := func(t *testing.T, s *Server) time.Time {
+		t.Helper()
+		p := s.peers[0]
+		lastSeen := time.Now().Truncate(time.Second)
+		for i := 0; i < 2; i++ {
+			p.lastSeen = lastSeen
+			s.checkPeer(context.Background(), p)
+		}
+
+		return lastSeen
+	}
+
+	t.Run("slog", func(t *testing.T) {
+		var buf bytes.Buffer
+		s, err := NewServer(
+			WithSlogger(slog.New(slog.NewJSONHandler(&buf, nil))),
+			WithConfig(c))
+		if err != nil {
+			t.Fatalf("expected NewServer to return nil err, got %v", err)
+		}
+		buf.Reset()
+		lastSeen := bringUp(t, s)
+
+		var entry struct {
+			Msg       string    `json:"msg"`
+			Peer      string    `json:"peer"`
+			State     string    `json:"state"`
+			PrevState string    `json:"prevState"`
+			LastSeen  time.Time `json:"lastSeen"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("expected a JSON log entry, got err %v for %q", err, buf.String())
+		}
+		if entry.Msg != "Peer LAN is Up" {
+			t.Errorf("expected msg %q, got %q", "Peer LAN is Up", entry.Msg)
+		}
+		if entry.Peer != "LAN" {
+			t.Errorf("expected peer %q, got %q", "LAN", entry.Peer)
+		}
+		if entry.State != "Up" {
+			t.Errorf("expected state %q, got %q", "Up", entry.State)
+		}
+		if entry.PrevState != "Maybe Up (1 of 1)" {
+			t.Errorf("expected prevState %q, got %q", "Maybe Up (1 of 1)", entry.PrevState)
+		}
+		if !entry.LastSeen.Equal(lastSeen) {
+			t.Errorf("expected lastSeen %v, got %v", lastSeen, entry.LastSeen)
+		}
+	})
+
+	t.Run("log", func(t *testing.T) {
+		var buf bytes.Buffer
+		s, err := NewServer(
+			WithSlogger(slog.New(slog.NewJSONHandler(&buf, nil))),
+			WithLogger(log.New(&buf, "", 0)),
+			WithConfig(c))
+		if err != nil {
+			t.Fatalf("expected NewServer to return nil err, got %v", err)
+		}
+		buf.Reset()
+		bringUp(t, s)
+
+		if line := strings.TrimSpace(buf.String()); line != "Peer LAN is Up" {
+			t.Errorf("expected log line %q, got %q", "Peer LAN is Up", line)
+		}
+	})
+}
+
+// TestLogLevel tests that the Server only logs messages at or above its log
+// level, and debug messages when it is verbose.
+func TestLogLevel(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		LogLevel string
+		Verbose  bool
+		Expected []string
+	}{
+		{
+			Name:     "Default",
+			Expected: []string{"info", "warning: warn", "error"},
+		},
+		{
+			Name:     "Debug",
+			LogLevel: LogLevelDebug,
+			Expected: []string{"debug", "info", "warning: warn", "error"},
+		},
+		{
+			Name:     "Warn",
+			LogLevel: LogLevelWarn,
+			Expected: []string{"warning: warn", "error"},
+		},
+		{
+			Name:     "Error",
+			LogLevel: LogLevelError,
+			Expected: []string{"error"},
+		},
+		{
+			Name:     "Error verbose",
+			LogLevel: LogLevelError,
+			Verbose:  true,
+			Expected: []string{"debug", "error"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			s := &Server{}
+			for _, opt := range []ServerOption{
+				WithLogger(log.New(&buf, "", 0)),
+				WithLogLevel(tc.LogLevel),
+				WithVerbose(tc.Verbose),
+			} {
+				if err := opt(s); err != nil {
+					t.Fatalf("expected option to return nil err, got %v", err)
+				}
+			}
+			s.debugf("debug\n")
+			s.infof("info\n")
+			s.warnf("warn\n")
+			s.errorf("error\n")
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if strings.Join(lines, ",") != strings.Join(tc.Expected, ",") {
+				t.Errorf("expected log lines %q, got %q", tc.Expected, lines)
+			}
+		})
+	}
+
+	t.Run("slog", func(t *testing.T) {
+		var buf bytes.Buffer
+		s := &Server{}
+		for _, opt := range []ServerOption{
+			WithSlogger(slog.New(slog.NewJSONHandler(&buf,
+				&slog.HandlerOptions{Level: slog.LevelDebug}))),
+			WithLogLevel(LogLevelWarn),
+		} {
+			if err := opt(s); err != nil {
+				t.Fatalf("expected option to return nil err, got %v", err)
+			}
+		}
+		s.infof("info\n")
+		s.warnf("warn\n")
+
+		var entry struct {
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("expected one JSON log entry, got err %v for %q", err, buf.String())
+		}
+		if entry.Level != "WARN" || entry.Msg != "warn" {
+			t.Errorf("expected WARN entry %q, got %s entry %q", "warn", entry.Level, entry.Msg)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if err := WithLogLevel("loud")(&Server{}); !errors.Is(err, ErrInvalidLogLevel) {
+			t.Errorf("expected WithLogLevel to return %v, got %v", ErrInvalidLogLevel, err)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package woodwatch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maintenanceTimeLayout is the layout of MaintenanceWindow Start and End times
+// of day.
+const maintenanceTimeLayout = "15:04"
+
+var (
+	// ErrInvalidMaintenanceTime is returned (wrapped with the time) from
+	// MaintenanceWindow.Valid() when the Start or End isn't a 24-hour "HH:MM"
+	// time of day.
+	ErrInvalidMaintenanceTime = errors.New(
+		`MaintenanceWindow Start and End must be 24-hour "HH:MM" times of day`)
+	// ErrInvalidMaintenanceDay is returned (wrapped with the day) from
+	// MaintenanceWindow.Valid() when one of the Days isn't a weekday name.
+	ErrInvalidMaintenanceDay = errors.New(
+		`MaintenanceWindow Days must be weekday names, e.g. "Monday" or "Mon"`)
+	// ErrEmptyMaintenanceWindow is returned (wrapped with the time) from
+	// MaintenanceWindow.Valid() when the Start and End are the same.
+	ErrEmptyMaintenanceWindow = errors.New(
+		"MaintenanceWindow Start and End must be different")
+)
+
+// MaintenanceWindow describes a recurring period of scheduled downtime for
+// a peer. The peer's state is still tracked during a MaintenanceWindow but its
+// events aren't dispatched.
+type MaintenanceWindow struct {
+	// Start is the local time of day the window starts as 24-hour "HH:MM",
+	// e.g. "02:00".
+	Start string `toml:"start"`
+	// End is the local time of day the window ends as 24-hour "HH:MM", e.g.
+	// "04:30". If it is before the Start the window ends on the following day,
+	// e.g. a Start of "23:00" and End of "01:00".
+	End string `toml:"end"`
+	// Days is an optional list of the weekday names, e.g. "Saturday" or "Sat",
+	// the window starts on. If empty the window starts every day.
+	Days []string `toml:"days"`
+}
+
+// Valid checks that a MaintenanceWindow is valid. It returns every problem with
+// the MaintenanceWindow joined with errors.Join or nil if the
+// MaintenanceWindow is valid. If the Start or End can't be parsed
+// ErrInvalidMaintenanceTime is included wrapped with the time. If they are the
+// same ErrEmptyMaintenanceWindow is included wrapped with the time. If one of
+// the Days isn't a weekday name ErrInvalidMaintenanceDay is included wrapped
+// with the day.
+func (mw MaintenanceWindow) Valid() error {
+	_, err := mw.parse()
+
+	return err
+}
+
+// parse returns the maintenanceWindow for the MaintenanceWindow or the errors
+// described by Valid().
+func (mw MaintenanceWindow) parse() (maintenanceWindow, error) {
+	var errs []error
+	start, startErr := parseTimeOfDay(mw.Start)
+	if startErr != nil {
+		errs = append(errs, startErr)
+	}
+	end, endErr := parseTimeOfDay(mw.End)
+	if endErr != nil {
+		errs = append(errs, endErr)
+	}
+	if startErr == nil && endErr == nil && start == end {
+		errs = append(errs, fmt.Errorf("%w: %q", ErrEmptyMaintenanceWindow, mw.Start))
+	}
+
+	var days map[time.Weekday]bool
+	if len(mw.Days) > 0 {
+		days = make(map[time.Weekday]bool, len(mw.Days))
+	}
+	for _, day := range mw.Days {
+		weekday, ok := parseWeekday(day)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: %q", ErrInvalidMaintenanceDay, day))
+
+			continue
+		}
+		days[weekday] = true
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return maintenanceWindow{}, err
+	}
+
+	return maintenanceWindow{start: start, end: end, days: days}, nil
+}
+
+// parseTimeOfDay returns the duration since midnight of the given 24-hour
+// "HH:MM" time of day.
+func parseTimeOfDay(timeOfDay string) (time.Duration, error) {
+	t, err := time.Parse(maintenanceTimeLayout, timeOfDay)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMaintenanceTime, timeOfDay)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseWeekday returns the time.Weekday for the given full or three letter
+// weekday name, ignoring case. It returns false if the name isn't a weekday.
+func parseWeekday(name string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(name, day.String()) || strings.EqualFold(name, day.String()[:3]) {
+			return day, true
+		}
+	}
+
+	return 0, false
+}
+
+// maintenanceWindow is a parsed MaintenanceWindow.
+type maintenanceWindow struct {
+	// start is the duration since midnight the window starts at.
+	start time.Duration
+	// end is the duration since midnight the window ends at. If it is before
+	// start the window ends on the following day.
+	end time.Duration
+	// days are the weekdays the window starts on. If nil the window starts
+	// every day.
+	days map[time.Weekday]bool
+}
+
+// startsOn returns true if the window starts on the given weekday.
+func (mw maintenanceWindow) startsOn(day time.Weekday) bool {
+	return mw.days == nil || mw.days[day]
+}
+
+// contains returns true if the given time is within the window, using the
+// time's location for the time of day and weekday.
+func (mw maintenanceWindow) contains(t time.Time) bool {
+	// Use the wall clock rather than the elapsed time since midnight so that
+	// windows start and end at the same time of day on daylight saving days.
+	hour, minute, second := t.Clock()
+	sinceMidnight := time.Duration(hour)*time.Hour +
+		time.Duration(minute)*time.Minute +
+		time.Duration(second)*time.Second
+
+	if mw.start < mw.end {
+		return mw.startsOn(t.Weekday()) && sinceMidnight >= mw.start && sinceMidnight < mw.end
+	}
+
+	// The window ends on the day after it starts. The time is either in the part
+	// of the window on the day it starts or the part on the following day.
+	yesterday := (t.Weekday() + 6) % 7
+
+	return (mw.startsOn(t.Weekday()) && sinceMidnight >= mw.start) ||
+		(mw.startsOn(yesterday) && sinceMidnight < mw.end)
+}
+
+// inMaintenance returns true if the given time is within any of the peer's
+// maintenance windows.
+func (p *peer) inMaintenance(now time.Time) bool {
+	for _, mw := range p.maintenanceWindows {
+		if mw.contains(now) {
+			return true
+		}
+	}
+
+	return false
+}
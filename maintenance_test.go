@@ -0,0 +1,192 @@
+package woodwatch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// TestMaintenanceWindowValid tests that MaintenanceWindow.Valid() returns the
+// expected errors.
+func TestMaintenanceWindowValid(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		Window         MaintenanceWindow
+		ExpectedErrors []error
+	}{
+		{
+			Name:   "Valid",
+			Window: MaintenanceWindow{Start: "02:00", End: "04:30", Days: []string{"Sunday", "sat"}},
+		},
+		{
+			Name:   "Valid across midnight",
+			Window: MaintenanceWindow{Start: "23:00", End: "01:00"},
+		},
+		{
+			Name:           "Invalid times",
+			Window:         MaintenanceWindow{Start: "2am", End: "25:00"},
+			ExpectedErrors: []error{ErrInvalidMaintenanceTime},
+		},
+		{
+			Name:           "Empty window",
+			Window:         MaintenanceWindow{Start: "02:00", End: "02:00"},
+			ExpectedErrors: []error{ErrEmptyMaintenanceWindow},
+		},
+		{
+			Name:           "Invalid day",
+			Window:         MaintenanceWindow{Start: "02:00", End: "03:00", Days: []string{"Caturday"}},
+			ExpectedErrors: []error{ErrInvalidMaintenanceDay},
+		},
+		{
+			Name:           "Multiple errors",
+			Window:         MaintenanceWindow{End: "03:00", Days: []string{"Someday"}},
+			ExpectedErrors: []error{ErrInvalidMaintenanceTime, ErrInvalidMaintenanceDay},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Window.Valid()
+			if len(tc.ExpectedErrors) == 0 && err != nil {
+				t.Fatalf("expected Valid() to return nil err, got %v", err)
+			}
+			for _, expected := range tc.ExpectedErrors {
+				if !errors.Is(err, expected) {
+					t.Errorf("expected Valid() to return err %v, got %v", expected, err)
+				}
+			}
+		})
+	}
+}
+
+// TestMaintenanceWindowContains tests that maintenanceWindow.contains returns
+// true only for times within the window.
+func TestMaintenanceWindowContains(t *testing.T) {
+	// 2024-01-06 is a Saturday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	testCases := []struct {
+		Name     string
+		Window   MaintenanceWindow
+		Time     time.Time
+		Expected bool
+	}{
+		{
+			Name:     "Every day, within",
+			Window:   MaintenanceWindow{Start: "02:00", End: "04:00"},
+			Time:     at(3, 2, 0),
+			Expected: true,
+		},
+		{
+			Name:   "Every day, at end",
+			Window: MaintenanceWindow{Start: "02:00", End: "04:00"},
+			Time:   at(3, 4, 0),
+		},
+		{
+			Name:   "Every day, before start",
+			Window: MaintenanceWindow{Start: "02:00", End: "04:00"},
+			Time:   at(3, 1, 59),
+		},
+		{
+			Name:     "Matching day",
+			Window:   MaintenanceWindow{Start: "02:00", End: "04:00", Days: []string{"Saturday"}},
+			Time:     at(6, 3, 0),
+			Expected: true,
+		},
+		{
+			Name:   "Other day",
+			Window: MaintenanceWindow{Start: "02:00", End: "04:00", Days: []string{"Saturday"}},
+			Time:   at(7, 3, 0),
+		},
+		{
+			Name:     "Across midnight, before midnight",
+			Window:   MaintenanceWindow{Start: "23:00", End: "01:00", Days: []string{"Sat"}},
+			Time:     at(6, 23, 30),
+			Expected: true,
+		},
+		{
+			Name:     "Across midnight, after midnight",
+			Window:   MaintenanceWindow{Start: "23:00", End: "01:00", Days: []string{"Sat"}},
+			Time:     at(7, 0, 30),
+			Expected: true,
+		},
+		{
+			Name:   "Across midnight, after midnight on other day",
+			Window: MaintenanceWindow{Start: "23:00", End: "01:00", Days: []string{"Sat"}},
+			Time:   at(6, 0, 30),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			window, err := tc.Window.parse()
+			if err != nil {
+				t.Fatalf("expected parse() to return nil err, got %v", err)
+			}
+			if contains := window.contains(tc.Time); contains != tc.Expected {
+				t.Errorf("expected contains(%v) to be %v, got %v", tc.Time, tc.Expected, contains)
+			}
+		})
+	}
+}
+
+// TestCheckPeerMaintenanceWindow tests that checkPeer tracks the state of
+// a peer in a maintenance window without dispatching its events.
+func TestCheckPeerMaintenanceWindow(t *testing.T) {
+	c := Config{
+		UpThreshold:   1,
+		DownThreshold: 1,
+		MonitorCycle:  "1s",
+		PeerTimeout:   "2s",
+		Peers: []PeerConfig{
+			{
+				Name:    "LAN",
+				Network: "192.168.1.0/24",
+				MaintenanceWindows: []MaintenanceWindow{
+					{Start: "02:00", End: "04:00"},
+				},
+			},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	now := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		return now
+	}
+	events := s.Subscribe()
+
+	// Down -> Maybe Up -> Up during the maintenance window isn't dispatched.
+	p := s.peers[0]
+	p.lastSeen = now
+	s.checkPeer(context.Background(), p)
+	s.checkPeer(context.Background(), p)
+	if state := p.state.String(); state != "Up" {
+		t.Fatalf("expected peer to be Up, got %q", state)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("expected event in maintenance window to be suppressed, got %#v", event)
+	default:
+	}
+
+	// Up -> Maybe Down -> Down after the maintenance window is.
+	now = now.Add(2 * time.Hour)
+	s.checkPeer(context.Background(), p)
+	s.checkPeer(context.Background(), p)
+	select {
+	case event := <-events:
+		if event.NewState != "Down" {
+			t.Errorf("expected Down event, got %#v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Down event after maintenance window, got none")
+	}
+}
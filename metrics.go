@@ -0,0 +1,213 @@
+package woodwatch
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsShutdownTimeout is how long Server.Close waits for in-flight metrics
+// requests before closing the metrics HTTP server.
+var metricsShutdownTimeout = 5 * time.Second
+
+// peerDescs are the descriptions of the metrics collected for each peer. They
+// depend on the label names of the peers.
+type peerDescs struct {
+	// state describes the woodwatch_peer_state gauge.
+	state *prometheus.Desc
+	// lastSeen describes the woodwatch_peer_last_seen_seconds gauge.
+	lastSeen *prometheus.Desc
+	// flaps describes the woodwatch_peer_flap_count_total counter.
+	flaps *prometheus.Desc
+}
+
+// newPeerDescs returns the descriptions of the peer metrics with a "peer"
+// label and a label for each of the given label names.
+func newPeerDescs(labelNames []string) peerDescs {
+	labels := append([]string{peerLabelName}, labelNames...)
+
+	return peerDescs{
+		state: prometheus.NewDesc(
+			"woodwatch_peer_state",
+			"Whether the peer is Up (1) or not (0).",
+			labels, nil),
+		lastSeen: prometheus.NewDesc(
+			"woodwatch_peer_last_seen_seconds",
+			"Unix timestamp of when the peer was last seen, or 0 if it hasn't been seen.",
+			labels, nil),
+		flaps: prometheus.NewDesc(
+			"woodwatch_peer_flap_count_total",
+			"Number of noteworthy state changes the peer has made.",
+			labels, nil),
+	}
+}
+
+// metrics holds the Prometheus metrics for a Server.
+type metrics struct {
+	// registry is the registry the Server's metrics are registered with and
+	// served from.
+	registry *prometheus.Registry
+	// transitions counts the state changes of each peer.
+	transitions *prometheus.CounterVec
+	// packets counts the ICMP packets received by the Server.
+	packets prometheus.Counter
+}
+
+// newMetrics constructs the metrics for the given Server. The state, last seen
+// time and flap count of the Server's peers are collected from the peers each
+// time the metrics are gathered.
+func newMetrics(s *Server) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "woodwatch_peer_state_transitions_total",
+			Help: "Number of state changes of the peer.",
+		}, []string{"peer", "from", "to"}),
+		packets: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "woodwatch_packets_received_total",
+			Help: "Number of ICMP packets received.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.transitions,
+		m.packets,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "woodwatch_monitor_panics_total",
+			Help: "Number of panics recovered from while checking peers or dispatching events.",
+		}, func() float64 {
+			return float64(s.panics.Load())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "woodwatch_webhook_dispatches_dropped_total",
+			Help: "Number of webhook dispatches dropped because the webhook queue was full.",
+		}, func() float64 {
+			return float64(s.dispatchesDropped.Load())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "woodwatch_peer_lock_timeout_total",
+			Help: "Number of times a peer's lock couldn't be acquired within the PeerLockTimeout.",
+		}, func() float64 {
+			return float64(s.peerLockTimeouts.Load())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "woodwatch_read_errors_total",
+			Help: "Number of errors reading ICMP packets.",
+		}, func() float64 {
+			return float64(s.readErrors.Load())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "woodwatch_retryable_read_errors_total",
+			Help: "Number of transient errors reading ICMP packets that were retried.",
+		}, func() float64 {
+			return float64(s.retryableReadErrors.Load())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "woodwatch_reconnects_total",
+			Help: "Number of ICMP PacketConns reopened after failing to read.",
+		}, func() float64 {
+			return float64(s.reconnects.Load())
+		}),
+		peerCollector{s})
+
+	return m
+}
+
+// peerCollector is a prometheus.Collector for the current state of a Server's
+// peers.
+type peerCollector struct {
+	s *Server
+}
+
+// Describe sends nothing to the given channel, making the peerCollector an
+// unchecked collector. The labels of the peer metrics depend on the Labels of
+// the peers, which can change when the Server's config is reloaded.
+func (c peerCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect sends the state, last seen time and flap count of each of the
+// Server's peers to the given channel. Each metric is labelled with the peer
+// name and the peer's Labels. Every peer has a label for each label name used
+// by any peer, with an empty value if it doesn't have that label.
+func (c peerCollector) Collect(ch chan<- prometheus.Metric) {
+	c.s.peersMu.RLock()
+	peers := c.s.peers
+	c.s.peersMu.RUnlock()
+
+	allLabels := make(map[string]string)
+	for _, p := range peers {
+		for name := range p.Labels {
+			allLabels[name] = ""
+		}
+	}
+	names := labelNames(allLabels)
+	descs := newPeerDescs(names)
+
+	for _, p := range peers {
+		labelValues := []string{p.Name}
+		for _, name := range names {
+			labelValues = append(labelValues, p.Labels[name])
+		}
+
+		if !c.s.rLockPeer(p) {
+			continue
+		}
+		var up, lastSeen float64
+		if p.state.String() == "Up" {
+			up = 1
+		}
+		if !p.lastSeen.IsZero() {
+			lastSeen = float64(p.lastSeen.UnixNano()) / float64(time.Second)
+		}
+		p.lastSeenMu.RUnlock()
+
+		ch <- prometheus.MustNewConstMetric(
+			descs.state, prometheus.GaugeValue, up, labelValues...)
+		ch <- prometheus.MustNewConstMetric(
+			descs.lastSeen, prometheus.GaugeValue, lastSeen, labelValues...)
+		ch <- prometheus.MustNewConstMetric(
+			descs.flaps, prometheus.CounterValue, float64(p.flapCount.Load()), labelValues...)
+	}
+}
+
+// listenMetrics starts an HTTP server serving the Server's metrics at /metrics
+// on the Server's metrics address. The metrics address is updated with the
+// address that was listened on, e.g. to include the port when it was zero.
+func (s *Server) listenMetrics() error {
+	l, err := net.Listen("tcp", s.metricsAddr)
+	if err != nil {
+		return err
+	}
+	s.metricsAddr = l.Addr().String()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}))
+	s.metricsServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.infof("serving metrics on http://%s/metrics\n", s.metricsAddr)
+
+	go func() {
+		if err := s.metricsServer.Serve(l); err != http.ErrServerClosed {
+			s.errorf("error serving metrics: %v\n", err)
+			s.reportError(err)
+		}
+	}()
+
+	return nil
+}
+
+// closeMetrics shuts down the Server's metrics HTTP server if there is one,
+// waiting for in-flight requests to finish.
+func (s *Server) closeMetrics() {
+	if s.metricsServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+	defer cancel()
+	if err := s.metricsServer.Shutdown(ctx); err != nil {
+		s.errorf("error shutting down metrics server: %v\n", err)
+	}
+}
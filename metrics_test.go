@@ -0,0 +1,132 @@
+package woodwatch
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMetrics tests that a Server serves the expected metrics for its peers
+// over HTTP and stops serving them when closed.
+func TestMetrics(t *testing.T) {
+	c := Config{
+		UpThreshold:  1,
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServer(
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithConfig(c),
+		WithMetricsAddr("127.0.0.1:0"))
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	// Bring the peer Up.
+	p := s.peers[0]
+	for i := 0; i < 2; i++ {
+		p.lastSeen = time.Unix(1700000000, 0)
+		s.peerTimeout = time.Since(p.lastSeen) + time.Minute
+		s.checkPeer(context.Background(), p)
+	}
+	s.metrics.packets.Inc()
+
+	if err := s.listenMetrics(); err != nil {
+		t.Fatalf("expected listenMetrics to return nil err, got %v", err)
+	}
+
+	resp, err := http.Get("http://" + s.metricsAddr + "/metrics")
+	if err != nil {
+		t.Fatalf("expected GET /metrics to return nil err, got %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("expected to read /metrics body, got err %v", err)
+	}
+
+	expectedLines := []string{
+		`woodwatch_peer_state{peer="LAN"} 1`,
+		`woodwatch_peer_last_seen_seconds{peer="LAN"} 1.7e+09`,
+		`woodwatch_peer_flap_count_total{peer="LAN"} 1`,
+		`woodwatch_peer_state_transitions_total{from="Down",peer="LAN",to="Maybe Up (1 of 1)"} 1`,
+		`woodwatch_peer_state_transitions_total{from="Maybe Up (1 of 1)",peer="LAN",to="Up"} 1`,
+		`woodwatch_packets_received_total 1`,
+		`woodwatch_monitor_panics_total 0`,
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+
+	s.closeMetrics()
+	if _, err := http.Get("http://" + s.metricsAddr + "/metrics"); err == nil {
+		t.Errorf("expected GET /metrics after close to return err, got nil")
+	}
+}
+
+// TestMetricsLabels tests that the peer metrics are labelled with the Labels of
+// each peer and an empty value for the Labels of other peers.
+func TestMetricsLabels(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{
+				Name:    "LAN",
+				Network: "192.168.1.0/24",
+				Labels:  map[string]string{"region": "eu-west", "tier": "gold"},
+			},
+			{
+				Name:    "VPN",
+				Network: "10.0.0.0/8",
+				Labels:  map[string]string{"region": "us-east"},
+			},
+			{Name: "Lab", Network: "172.16.0.0/12"},
+		},
+	}
+	s, err := NewServer(
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithConfig(c),
+		WithMetricsAddr("127.0.0.1:0"))
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	if err := s.listenMetrics(); err != nil {
+		t.Fatalf("expected listenMetrics to return nil err, got %v", err)
+	}
+	defer s.closeMetrics()
+
+	resp, err := http.Get("http://" + s.metricsAddr + "/metrics")
+	if err != nil {
+		t.Fatalf("expected GET /metrics to return nil err, got %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected GET /metrics to return status %d, got %d",
+			http.StatusOK, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("expected to read /metrics body, got err %v", err)
+	}
+
+	expectedLines := []string{
+		`woodwatch_peer_state{peer="LAN",region="eu-west",tier="gold"} 0`,
+		`woodwatch_peer_state{peer="VPN",region="us-east",tier=""} 0`,
+		`woodwatch_peer_state{peer="Lab",region="",tier=""} 0`,
+		`woodwatch_peer_flap_count_total{peer="VPN",region="us-east",tier=""} 0`,
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
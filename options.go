@@ -0,0 +1,289 @@
+package woodwatch
+
+import (
+	"errors"
+	"log"
+	"log/slog"
+	"time"
+)
+
+var (
+	// ErrInvalidMonitorCycle is returned from WithMonitorCycle when the monitor
+	// cycle is not positive.
+	ErrInvalidMonitorCycle = errors.New("Monitor cycle must be positive")
+	// ErrInvalidPeerTimeout is returned from WithPeerTimeout, or wrapped by
+	// PeerConfig.Valid, when the peer timeout is not positive.
+	ErrInvalidPeerTimeout = errors.New("Peer timeout must be positive")
+	// ErrEmptyMetricsAddress is returned from WithMetricsAddr when given an
+	// empty address.
+	ErrEmptyMetricsAddress = errors.New("Metrics address must not be empty")
+	// ErrEmptyHealthAddress is returned from WithHealthAddr when given an empty
+	// address.
+	ErrEmptyHealthAddress = errors.New("Health address must not be empty")
+	// ErrEmptyAPIKey is returned from WithAPIKey when given an empty key.
+	ErrEmptyAPIKey = errors.New("API key must not be empty")
+	// ErrInvalidCloseChanSize is returned from WithCloseChanSize when the size is
+	// negative.
+	ErrInvalidCloseChanSize = errors.New("Close channel size must not be negative")
+)
+
+// ServerOption is a function that configures a Server constructed by
+// NewServer. A ServerOption returns an error if the Server can't be configured
+// as requested.
+type ServerOption func(*Server) error
+
+// WithLogger configures the Server to log to the given log.Logger. If the
+// log.Logger is nil the standard logger is used. WithLogger replaces the
+// slog.Logger configured by WithSlogger.
+func WithLogger(logger *log.Logger) ServerOption {
+	return func(s *Server) error {
+		if logger == nil {
+			logger = log.Default()
+		}
+		s.log = logger
+		s.slog = nil
+
+		return nil
+	}
+}
+
+// WithSlogger configures the Server to log to the given slog.Logger. Events
+// are logged with the structured fields "peer", "state", "prevState" and
+// "lastSeen" and other messages are logged at their level. If the
+// slog.Logger is nil the default slog.Logger is used. WithSlogger replaces the
+// log.Logger configured by WithLogger.
+func WithSlogger(logger *slog.Logger) ServerOption {
+	return func(s *Server) error {
+		if logger == nil {
+			logger = slog.Default()
+		}
+		s.slog = logger
+		s.log = slog.NewLogLogger(logger.Handler(), slog.LevelInfo)
+
+		return nil
+	}
+}
+
+// WithLogLevel configures the least severe level of the messages the Server
+// logs: LogLevelDebug, LogLevelInfo, LogLevelWarn or LogLevelError. An empty
+// level is LogLevelInfo. If the level isn't supported ErrInvalidLogLevel is
+// returned wrapped with the level.
+func WithLogLevel(level string) ServerOption {
+	return func(s *Server) error {
+		logLevel, err := parseLogLevel(level)
+		if err != nil {
+			return err
+		}
+		s.logLevel = logLevel
+
+		return nil
+	}
+}
+
+// WithVerbose configures whether the Server logs and dispatches all state
+// change events or just notable ones. A verbose Server also logs debug
+// messages, as with LogLevelDebug.
+func WithVerbose(verbose bool) ServerOption {
+	return func(s *Server) error {
+		s.verbose = verbose
+
+		return nil
+	}
+}
+
+// WithListenAddress configures the address the Server listens for ICMP
+// messages on. If the address is empty ErrEmptyListenAddress is returned.
+func WithListenAddress(addr string) ServerOption {
+	return func(s *Server) error {
+		if addr == "" {
+			return ErrEmptyListenAddress
+		}
+		s.listenAddress = addr
+
+		return nil
+	}
+}
+
+// WithMonitorCycle configures the duration between the Server checking if peers
+// have timed out, overriding the Config's MonitorCycle if it is given after
+// WithConfig. If the duration is not positive ErrInvalidMonitorCycle is
+// returned.
+func WithMonitorCycle(d time.Duration) ServerOption {
+	return func(s *Server) error {
+		if d <= 0 {
+			return ErrInvalidMonitorCycle
+		}
+		s.monitorCycle = d
+
+		return nil
+	}
+}
+
+// WithPeerTimeout configures the duration within which a peer must have been
+// seen to be considered seen during a monitor cycle, overriding the Config's
+// PeerTimeout if it is given after WithConfig. Peers with their own
+// PeerTimeout keep using it. If the duration is not positive
+// ErrInvalidPeerTimeout is returned.
+func WithPeerTimeout(d time.Duration) ServerOption {
+	return func(s *Server) error {
+		if d <= 0 {
+			return ErrInvalidPeerTimeout
+		}
+		s.peerTimeout = d
+
+		return nil
+	}
+}
+
+// WithCloseChanSize configured the buffer size of the channel used to signal
+// the Server's monitoring goroutine to close. If the size is negative
+// ErrInvalidCloseChanSize is returned.
+//
+// Deprecated: The monitoring goroutine is stopped by cancelling the context
+// given to Server.Listen and WithCloseChanSize has no other effect.
+func WithCloseChanSize(size int) ServerOption {
+	return func(s *Server) error {
+		if size < 0 {
+			return ErrInvalidCloseChanSize
+		}
+
+		return nil
+	}
+}
+
+// WithMetricsAddr configures the Server to serve Prometheus metrics at
+// /metrics over HTTP on the given address, e.g. ":9090", once it is listening.
+// If the address is empty ErrEmptyMetricsAddress is returned.
+func WithMetricsAddr(addr string) ServerOption {
+	return func(s *Server) error {
+		if addr == "" {
+			return ErrEmptyMetricsAddress
+		}
+		s.metricsAddr = addr
+
+		return nil
+	}
+}
+
+// WithHealthAddr configures the Server to serve health checks over HTTP on
+// the given address, e.g. ":8080", once it is listening. GET /healthz responds
+// with a 200 OK while the Server is listening and GET /readyz responds with
+// a 200 OK once the Server has completed its first monitor cycle and a 503
+// Service Unavailable before then. Both respond with a JSON body like
+// {"status":"ok","peers":2}. If the address is empty ErrEmptyHealthAddress is
+// returned.
+func WithHealthAddr(addr string) ServerOption {
+	return func(s *Server) error {
+		if addr == "" {
+			return ErrEmptyHealthAddress
+		}
+		s.healthAddr = addr
+
+		return nil
+	}
+}
+
+// WithAPIKey configures the key that requests to the health check HTTP server
+// that change the Server's peers, e.g. POST /peers/{name}/ack, must send in an
+// "Authorization: Bearer <key>" header. Without an API key those requests get
+// a 403 Forbidden. If the key is empty ErrEmptyAPIKey is returned.
+func WithAPIKey(key string) ServerOption {
+	return func(s *Server) error {
+		if key == "" {
+			return ErrEmptyAPIKey
+		}
+		s.apiKey = key
+
+		return nil
+	}
+}
+
+// WithConfig configures the Server's peers, durations, listen network, message
+// brokers and other settings from the given Config. If the Config is not valid
+// the error from Config.Valid() is returned.
+func WithConfig(c Config) ServerOption {
+	return func(s *Server) error {
+		if err := c.Valid(); err != nil {
+			return err
+		}
+
+		// Build peers from the PeerConfigs
+		peers, err := loadPeers(c)
+		if err != nil {
+			return err
+		}
+
+		// Build the publishers for any configured message brokers
+		publishers, err := loadPublishers(c)
+		if err != nil {
+			return err
+		}
+		// Close any publishers from an earlier WithConfig
+		s.closePublishers()
+		s.publishers = publishers
+		s.setPeers(peers)
+		s.config = c
+
+		// Parse the monitor cycle and timeout durations.
+		// NOTE(@cpu): It's safe to throw away potential error returns from
+		// `time.ParseDuration` here because we checked c.Valid() and it verifies
+		// the duration validities.
+		s.monitorCycle, _ = time.ParseDuration(c.MonitorCycle)
+		s.peerTimeout, _ = time.ParseDuration(c.PeerTimeout)
+		// The MonitorCycleJitter is optional. If it is empty the jitter is zero.
+		s.monitorCycleJitter, _ = time.ParseDuration(c.MonitorCycleJitter)
+		// The MonitorJitter is optional. If it is empty peers aren't staggered.
+		s.monitorJitter, _ = time.ParseDuration(c.MonitorJitter)
+		// The StartupGracePeriod is optional. If it is empty there is no grace
+		// period.
+		s.startupGracePeriod, _ = time.ParseDuration(c.StartupGracePeriod)
+
+		// If there is no ListenNetwork listen for ICMPv4
+		s.listenNetwork = c.ListenNetwork
+		if s.listenNetwork == "" {
+			s.listenNetwork = ListenNetworkIPv4
+		}
+
+		// Build a webhook pointer out of the all down webhook URL if set
+		s.allDownWebhook = nil
+		if c.AllDownWebhook != "" {
+			s.allDownWebhook = c.newWebhook(c.AllDownWebhook)
+		}
+		// If there is no AllDownThreshold alert after the first all down cycle
+		s.allDownThreshold = c.AllDownThreshold
+		if s.allDownThreshold == 0 {
+			s.allDownThreshold = 1
+		}
+
+		s.recoverPanics = c.RecoverFromPanics == nil || *c.RecoverFromPanics
+
+		// The DedupWindow is optional. If it is empty identical events are
+		// always suppressed when EventDedup is enabled.
+		s.eventDedup = c.EventDedup
+		s.dedupWindow, _ = time.ParseDuration(c.DedupWindow)
+
+		// Zero values use the default queue depth and number of workers.
+		s.webhookQueueDepth = c.WebhookQueueDepth
+		s.webhookWorkers = c.WebhookWorkers
+
+		// Zero uses the default event log size.
+		s.eventLogSize = c.EventLogSize
+		// Zero uses the default packet buffer size.
+		s.packetBufSize = c.PacketBufSize
+		// Without FilterICMPTypes only echo requests update peers.
+		s.filterICMPTypes = c.FilterICMPTypes
+		// Zero uses the default peer lock timeout.
+		s.peerLockTimeout, _ = time.ParseDuration(c.PeerLockTimeout)
+		// Zero doesn't reopen PacketConns that fail to read and zero backoff
+		// uses the default reconnect backoff.
+		s.maxReconnectAttempts = c.MaxReconnectAttempts
+		s.reconnectBackoff, _ = time.ParseDuration(c.ReconnectBackoff)
+		// Zero uses the default API rate and burst limits.
+		s.apiRateLimit = c.APIRateLimit
+		s.apiBurstLimit = c.APIBurstLimit
+		// An empty LogLevel logs info messages, warnings and errors.
+		s.logLevel, _ = parseLogLevel(c.LogLevel)
+
+		return nil
+	}
+}
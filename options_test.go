@@ -0,0 +1,113 @@
+package woodwatch
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// TestNewServerOptionErrors tests that an error from a ServerOption is returned
+// from NewServer immediately.
+func TestNewServerOptionErrors(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Options       []ServerOption
+		ExpectedError error
+	}{
+		{
+			Name:          "No peers",
+			ExpectedError: ErrTooFewPeers,
+		},
+		{
+			Name:          "Empty listen address",
+			Options:       []ServerOption{WithListenAddress("")},
+			ExpectedError: ErrEmptyListenAddress,
+		},
+		{
+			Name:          "Invalid monitor cycle",
+			Options:       []ServerOption{WithMonitorCycle(0)},
+			ExpectedError: ErrInvalidMonitorCycle,
+		},
+		{
+			Name:          "Invalid peer timeout",
+			Options:       []ServerOption{WithPeerTimeout(-time.Second)},
+			ExpectedError: ErrInvalidPeerTimeout,
+		},
+		{
+			Name:          "Invalid close chan size",
+			Options:       []ServerOption{WithCloseChanSize(-1)},
+			ExpectedError: ErrInvalidCloseChanSize,
+		},
+		{
+			Name:          "Empty health address",
+			Options:       []ServerOption{WithHealthAddr("")},
+			ExpectedError: ErrEmptyHealthAddress,
+		},
+		{
+			Name:          "Empty API key",
+			Options:       []ServerOption{WithAPIKey("")},
+			ExpectedError: ErrEmptyAPIKey,
+		},
+		{
+			Name: "Error before invalid config",
+			Options: []ServerOption{
+				WithMonitorCycle(0),
+				WithConfig(Config{}),
+			},
+			ExpectedError: ErrInvalidMonitorCycle,
+		},
+		{
+			Name:          "Invalid config",
+			Options:       []ServerOption{WithConfig(Config{})},
+			ExpectedError: ErrTooFewPeers,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if _, err := NewServer(tc.Options...); !errors.Is(err, tc.ExpectedError) {
+				t.Errorf("expected err to be %v, was %v", tc.ExpectedError, err)
+			}
+		})
+	}
+}
+
+// TestNewServerOptions tests that ServerOptions given after WithConfig override
+// the Config.
+func TestNewServerOptions(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServer(
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithVerbose(true),
+		WithListenAddress("127.0.0.1"),
+		WithConfig(c),
+		WithMonitorCycle(3*time.Second),
+		WithPeerTimeout(4*time.Second))
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+
+	if !s.verbose {
+		t.Errorf("expected verbose Server")
+	}
+	if s.listenAddress != "127.0.0.1" {
+		t.Errorf("expected listen address %q, got %q", "127.0.0.1", s.listenAddress)
+	}
+	if s.monitorCycle != 3*time.Second {
+		t.Errorf("expected monitor cycle %v, got %v", 3*time.Second, s.monitorCycle)
+	}
+	if s.peerTimeout != 4*time.Second {
+		t.Errorf("expected peer timeout %v, got %v", 4*time.Second, s.peerTimeout)
+	}
+	if len(s.peers) != 1 {
+		t.Errorf("expected %d peers, got %d", 1, len(s.peers))
+	}
+}
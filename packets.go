@@ -0,0 +1,52 @@
+package woodwatch
+
+import (
+	"time"
+)
+
+const (
+	// packetWindowSeconds is how many seconds a packetWindow counts packets
+	// over.
+	packetWindowSeconds = 60
+)
+
+// packetWindow is a ring buffer counting the packets received from a peer in
+// each of the last packetWindowSeconds seconds. The zero value is an empty
+// packetWindow.
+type packetWindow struct {
+	// seconds holds the Unix time of the second each bucket of counts is for.
+	seconds [packetWindowSeconds]int64
+	// counts holds how many packets were received in each second.
+	counts [packetWindowSeconds]uint64
+}
+
+// bucket returns the index of the bucket for the given Unix second.
+func (w *packetWindow) bucket(second int64) int64 {
+	return ((second % packetWindowSeconds) + packetWindowSeconds) % packetWindowSeconds
+}
+
+// add counts a packet received at the given time, replacing the count of the
+// second packetWindowSeconds earlier if it is still in the ring buffer.
+func (w *packetWindow) add(now time.Time) {
+	second := now.Unix()
+	i := w.bucket(second)
+	if w.seconds[i] != second {
+		w.seconds[i] = second
+		w.counts[i] = 0
+	}
+	w.counts[i]++
+}
+
+// count returns how many packets were received in the packetWindowSeconds
+// seconds up to and including the given time.
+func (w *packetWindow) count(now time.Time) uint64 {
+	second := now.Unix()
+	var total uint64
+	for i, s := range w.seconds {
+		if s <= second && second-s < packetWindowSeconds {
+			total += w.counts[i]
+		}
+	}
+
+	return total
+}
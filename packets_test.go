@@ -0,0 +1,47 @@
+package woodwatch
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPacketWindow tests that a packetWindow only counts the packets received
+// in the last 60 seconds.
+func TestPacketWindow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var w packetWindow
+	if count := w.count(start); count != 0 {
+		t.Errorf("expected empty window to count 0 packets, got %d", count)
+	}
+
+	// 3 packets in the first second and 1 packet 30 seconds later.
+	w.add(start)
+	w.add(start.Add(500 * time.Millisecond))
+	w.add(start.Add(999 * time.Millisecond))
+	w.add(start.Add(30 * time.Second))
+
+	testCases := []struct {
+		Name     string
+		At       time.Duration
+		Expected uint64
+	}{
+		{Name: "Same second", At: 0, Expected: 3},
+		{Name: "Within the minute", At: 59 * time.Second, Expected: 4},
+		{Name: "First second expired", At: 60 * time.Second, Expected: 1},
+		{Name: "All expired", At: 90 * time.Second, Expected: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if count := w.count(start.Add(tc.At)); count != tc.Expected {
+				t.Errorf("expected %d packets, got %d", tc.Expected, count)
+			}
+		})
+	}
+
+	// A packet 60 seconds after the first replaces its bucket.
+	w.add(start.Add(60 * time.Second))
+	if count := w.count(start.Add(60 * time.Second)); count != 2 {
+		t.Errorf("expected 2 packets after replacing a bucket, got %d", count)
+	}
+}
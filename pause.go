@@ -0,0 +1,51 @@
+package woodwatch
+
+import (
+	"errors"
+)
+
+var (
+	// ErrAlreadyPaused is returned from Server.Pause when the Server's
+	// monitoring is already paused.
+	ErrAlreadyPaused = errors.New("Pause() can't be called while paused")
+	// ErrNotPaused is returned from Server.Resume when the Server's monitoring
+	// isn't paused.
+	ErrNotPaused = errors.New("Resume() must be called after Pause()")
+)
+
+// Pause temporarily halts monitoring the Server's peers, e.g. during a rolling
+// restart or a known network event. While paused no monitor cycles run so
+// peer states don't change and no events are dispatched. ICMP echo requests
+// are still received, and TCP ports dialed, and update when peers were last
+// seen. Pause returns ErrAlreadyPaused if the Server is already paused.
+func (s *Server) Pause() error {
+	if !s.paused.CompareAndSwap(false, true) {
+		return ErrAlreadyPaused
+	}
+	s.infof("pausing monitoring\n")
+
+	return nil
+}
+
+// Resume restarts monitoring the Server's peers after Pause. The next monitor
+// cycle runs a full MonitorCycle after Resume is called. Resume returns
+// ErrNotPaused if the Server isn't paused.
+func (s *Server) Resume() error {
+	if !s.paused.CompareAndSwap(true, false) {
+		return ErrNotPaused
+	}
+	s.infof("resuming monitoring\n")
+	// Restart the monitoring goroutine's ticker, if it isn't already going to be
+	// restarted.
+	select {
+	case s.resumed <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Paused returns true if the Server's monitoring is paused.
+func (s *Server) Paused() bool {
+	return s.paused.Load()
+}
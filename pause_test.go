@@ -0,0 +1,98 @@
+package woodwatch
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// TestPauseResumeErrors tests that Pause and Resume return errors when called
+// in the wrong state.
+func TestPauseResumeErrors(t *testing.T) {
+	s := &Server{log: log.New(io.Discard, "", 0)}
+	if err := s.Resume(); err != ErrNotPaused {
+		t.Errorf("expected Resume() before Pause() to return %v, got %v", ErrNotPaused, err)
+	}
+	if err := s.Pause(); err != nil {
+		t.Fatalf("expected Pause() to return nil err, got %v", err)
+	}
+	if !s.Paused() {
+		t.Errorf("expected Paused() to be true after Pause()")
+	}
+	if err := s.Pause(); err != ErrAlreadyPaused {
+		t.Errorf("expected second Pause() to return %v, got %v", ErrAlreadyPaused, err)
+	}
+	if err := s.Resume(); err != nil {
+		t.Fatalf("expected Resume() to return nil err, got %v", err)
+	}
+	if s.Paused() {
+		t.Errorf("expected Paused() to be false after Resume()")
+	}
+}
+
+// TestPauseMonitoring tests that peers aren't checked and no events are
+// dispatched while the Server is paused and that monitoring continues after
+// it is resumed.
+func TestPauseMonitoring(t *testing.T) {
+	allowShortDurations(t)
+	c := Config{
+		UpThreshold:   1,
+		DownThreshold: 1,
+		MonitorCycle:  "10ms",
+		PeerTimeout:   "10ms",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24", InitialState: InitialStateUp},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	events := s.Subscribe()
+	if err := s.Pause(); err != nil {
+		t.Fatalf("expected Pause() to return nil err, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.checkPeersTicker(ctx)
+		close(stopped)
+	}()
+
+	// The peer hasn't been seen but while paused it stays Up.
+	select {
+	case event := <-events:
+		t.Fatalf("expected no events while paused, got %#v", event)
+	case <-time.After(10 * s.monitorCycle):
+	}
+	if state := s.Peers()[0].State; state != "Up" {
+		t.Errorf("expected peer to be Up while paused, got %q", state)
+	}
+
+	// After resuming the peer goes Down.
+	if err := s.Resume(); err != nil {
+		t.Fatalf("expected Resume() to return nil err, got %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.NewState != "Down" {
+			t.Errorf("expected Down event, got %#v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Down event after resuming, got none")
+	}
+
+	// Monitoring stops when paused again.
+	if err := s.Pause(); err != nil {
+		t.Fatalf("expected Pause() to return nil err, got %v", err)
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("expected monitoring to stop while paused")
+	}
+}
@@ -1,68 +1,327 @@
 package woodwatch
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cpu/woodwatch/internal/states"
 	"github.com/cpu/woodwatch/internal/webhook"
 )
 
+const (
+	// protocolICMP is the protocol for monitoring peers by the ICMP echo
+	// requests they send.
+	protocolICMP = "icmp"
+	// protocolTCPPrefix is the prefix of protocols for monitoring peers by
+	// dialing a TCP port of the peer, e.g. "tcp:9999".
+	protocolTCPPrefix = "tcp:"
+	// observationBufferSize is how many observations a peer's observationChan
+	// holds before they are aggregated.
+	observationBufferSize = 64
+)
+
+var (
+	// ErrOverlappingPeerNetworks is returned (wrapped with both peer names) from
+	// loadPeers when one of a peer's Networks contains some or all of one of
+	// the Networks of another peer monitored by the same protocol. Only the first matching peer
+	// would ever be seen by that protocol.
+	ErrOverlappingPeerNetworks = errors.New("Peer Networks must not overlap")
+	// ErrDuplicatePeerName is returned (wrapped with the name and the indices
+	// of both PeerConfigs) from loadPeers when two PeerConfigs have the same
+	// Name. Their events, logs and statuses couldn't be told apart.
+	ErrDuplicatePeerName = errors.New("Peer Names must be unique")
+
+	// defaultWebhookTimeout is how long each webhook POST may take when the
+	// Config has no WebhookTimeout.
+	defaultWebhookTimeout = 10 * time.Second
+)
+
 // peer is a struct describing a peer to be monitored.
 type peer struct {
 	// Name is the friendly display name for the peer . E.g. "Comcast", "Cocego :fire:".
 	Name string
-	// Network is the IP network that the peer is expected to send ICMP echo
-	// request messages from.
-	Network *net.IPNet
-	// Webhook is an optional webhook to dispatch events to.
-	Webhook *webhook.Hook
+	// Networks are the IP networks that the peer is expected to send ICMP echo
+	// request messages from. There is always at least one.
+	Networks []*net.IPNet
+	// Webhooks are optional webhooks to dispatch events to.
+	Webhooks []*webhook.Hook
+	// PagerDuty is an optional PagerDuty integration to trigger and resolve
+	// incidents for the peer with.
+	PagerDuty *webhook.PagerDutyHook
+	// Tags is an optional list of labels for the peer that are included in
+	// events.
+	Tags []string
+	// Labels is an optional map of label names to values for the peer that are
+	// included in events and added to its metrics.
+	Labels map[string]string
+	// Description is an optional description of the peer that is included in
+	// events.
+	Description string
+	// Owner is an optional owner of the peer that is included in events.
+	Owner string
+	// ExternalURL is an optional URL with more information about the peer
+	// that is included in events.
+	ExternalURL string
+	// protocols are the protocols the peer is monitored by, e.g. "icmp",
+	// "tcp:9999".
+	protocols []string
+	// requireAllProtocols indicates whether the peer must be seen by all of its
+	// protocols to be considered seen, rather than any one of them.
+	requireAllProtocols bool
+	// icmpIdentifier is the identifier the peer's ICMP echo requests must have
+	// to be seen. If zero ICMP messages with any identifier are seen.
+	icmpIdentifier uint16
+	// probeMode indicates whether the Server sends ICMP echo requests to the
+	// peer and sees it when it replies.
+	probeMode bool
+	// probeInterval is the duration between probes of a peer in probe mode. If
+	// zero the Server's monitorCycle is used.
+	probeInterval time.Duration
+	// probeSentAt is when the most recent probe was sent to the peer. Reading
+	// or writing this field must be done only after acquiring the lastSeenMu.
+	probeSentAt time.Time
+	// probeSeq is the sequence number of the most recent probe sent to the
+	// peer. Reading or writing this field must be done only after acquiring the
+	// lastSeenMu.
+	probeSeq uint16
+	// peerTimeout is the duration within which the peer must have been seen to
+	// be considered seen during a monitor cycle. If zero the Server's
+	// peerTimeout is used.
+	peerTimeout time.Duration
 	// UpThreshold is how many cycles the peer needs to be sending ICMP echo
 	// requests without timeout before it is considered up.
 	upThreshold uint
 	// DownThreshold is how many cycles the peer needs to miss sending ICMP echo
 	// requests before it is considered down.
 	downThreshold uint
+	// flappingThreshold is how many up/down transitions the peer needs to make
+	// within 2*flappingThreshold cycles before it is considered flapping. If zero
+	// flapping isn't detected.
+	flappingThreshold uint
 	// lastSeenMu is a r/w mutex for controlling access to the lastSeen timestamp
-	// for multiple goroutines.
+	// and state for multiple goroutines. The Server acquires it with lockPeer
+	// and rLockPeer so that a lock that is never released can't hang it.
 	lastSeenMu *sync.RWMutex
-	// lastSeen is the time the server last received an ICMP echo request from the
-	// peer. Reading or writing this field must be done only after acquiring the
+	// lastSeen is the time the peer was last seen by any of its protocols.
+	// Reading or writing this field must be done only after acquiring the
 	// lastSeenMu.
 	lastSeen time.Time
-	// state is the peer's current PeerState
+	// observationChan receives an observation each time the peer is seen by one
+	// of its protocols. The observations are aggregated into the
+	// protocolLastSeen when the peer is checked. Sending to or receiving from
+	// this channel must be done only after acquiring the lastSeenMu.
+	observationChan chan observation
+	// protocolLastSeen is the time the peer was last seen by each of its
+	// protocols, as of the last aggregated observation. Reading or writing this
+	// field must be done only after acquiring the lastSeenMu.
+	protocolLastSeen map[string]time.Time
+	// state is the peer's current PeerState. Reading or writing this field must
+	// be done only after acquiring the lastSeenMu.
 	state states.PeerState
+	// stateEnteredAt is when the peer's state last changed. Reading or writing
+	// this field must be done only after acquiring the lastSeenMu.
+	stateEnteredAt time.Time
+	// stableStateEnteredAt is when the peer last entered an Up, Down or
+	// Flapping state, i.e. when it last made a noteworthy transition. Moving
+	// through the intermediate Maybe states doesn't change it. Reading or
+	// writing this field must be done only after acquiring the lastSeenMu.
+	stableStateEnteredAt time.Time
+	// totalUptime is how long the peer was Up before it entered its current
+	// state. Reading or writing this field must be done only after acquiring
+	// the lastSeenMu.
+	totalUptime time.Duration
+	// totalDowntime is how long the peer was Down before it entered its
+	// current state. Reading or writing this field must be done only after
+	// acquiring the lastSeenMu.
+	totalDowntime time.Duration
+	// ackUntil is when the peer's acknowledgement expires. Events aren't
+	// dispatched for an acknowledged peer. Reading or writing this field must be
+	// done only after acquiring the lastSeenMu.
+	ackUntil time.Time
+	// ackMessage is the message given when the peer was acknowledged. Reading or
+	// writing this field must be done only after acquiring the lastSeenMu.
+	ackMessage string
+	// packetsReceived is how many packets, or successful TCP dials, have been
+	// received from the peer. Reading or writing this field must be done only
+	// after acquiring the lastSeenMu.
+	packetsReceived uint64
+	// packets counts the packets received from the peer in the last minute.
+	// Reading or writing this field must be done only after acquiring the
+	// lastSeenMu.
+	packets packetWindow
+	// maintenanceWindows are the peer's recurring periods of scheduled downtime
+	// during which its events aren't dispatched.
+	maintenanceWindows []maintenanceWindow
+	// history holds the peer's most recent state changes. Reading or writing
+	// this field must be done only after acquiring the lastSeenMu.
+	history *stateHistory
+	// echo tracks the sequence numbers of the ICMP echo requests received from
+	// the peer. Reading or writing this field must be done only after acquiring
+	// the lastSeenMu.
+	echo echoTracker
+	// flapCount is how many noteworthy state changes (e.g. Up to Down, Down to
+	// Up) the peer has made since the server started.
+	flapCount atomic.Uint64
 }
 
 // String returns a string representation of the peer.
-func (p peer) String() string {
+func (p *peer) String() string {
 	return fmt.Sprintf("Peer %s - Network %s - State %s",
-		p.Name, p.Network, p.state)
+		p.Name, p.networks(), p.state)
+}
+
+// networks returns the peer's Networks as a comma separated string, e.g.
+// "192.168.1.0/24,10.0.0.0/8".
+func (p *peer) networks() string {
+	networks := make([]string, 0, len(p.Networks))
+	for _, network := range p.Networks {
+		networks = append(networks, network.String())
+	}
+
+	return strings.Join(networks, ",")
+}
+
+// contains returns true if any of the peer's Networks contains the given IP.
+func (p *peer) contains(ip net.IP) bool {
+	for _, network := range p.Networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// monitoredBy returns true if the peer is monitored by the given protocol.
+func (p *peer) monitoredBy(protocol string) bool {
+	for _, pr := range p.protocols {
+		if pr == protocol {
+			return true
+		}
+	}
+
+	return false
+}
+
+// observation is a peer being seen by one of its protocols.
+type observation struct {
+	// protocol is the protocol the peer was seen by, e.g. "icmp".
+	protocol string
+	// at is when the peer was seen.
+	at time.Time
+}
+
+// observe reports that the peer was seen by the given protocol at the given
+// time to its observationChan. If the observationChan is full the observations
+// in it are aggregated first. The caller must hold the lastSeenMu.
+func (p *peer) observe(protocol string, at time.Time) {
+	o := observation{protocol: protocol, at: at}
+	select {
+	case p.observationChan <- o:
+	default:
+		p.aggregateObservations()
+		p.observationChan <- o
+	}
+}
+
+// aggregateObservations receives the observations in the peer's
+// observationChan, updating when the peer was last seen by each protocol. The
+// caller must hold the lastSeenMu.
+func (p *peer) aggregateObservations() {
+	for {
+		select {
+		case o := <-p.observationChan:
+			if o.at.After(p.protocolLastSeen[o.protocol]) {
+				p.protocolLastSeen[o.protocol] = o.at
+			}
+		default:
+			return
+		}
+	}
+}
+
+// seen aggregates the peer's observations and returns true if the peer was
+// seen within the timeout before now. If the peer requires all of its
+// protocols it must have been seen by each of them, otherwise being seen by
+// any one of them is enough. The caller must hold the lastSeenMu.
+func (p *peer) seen(now time.Time, timeout time.Duration) bool {
+	p.aggregateObservations()
+	if !p.requireAllProtocols {
+		return now.Sub(p.lastSeen) < timeout
+	}
+	for _, protocol := range p.protocols {
+		if now.Sub(p.protocolLastSeen[protocol]) >= timeout {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sharesProtocol returns true if the peer and the other peer are monitored by
+// at least one of the same protocols.
+func (p *peer) sharesProtocol(other *peer) bool {
+	for _, protocol := range p.protocols {
+		if other.monitoredBy(protocol) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// acknowledged returns true if the peer has an acknowledgement that hasn't
+// expired at the given time. The caller must hold the lastSeenMu.
+func (p *peer) acknowledged(now time.Time) bool {
+	return now.Before(p.ackUntil)
 }
 
 // NewPeer constructs a peer for the given arguments or returns an error.
 func newPeer(
 	name string,
-	network string,
-	upThreshold, downThreshold uint,
-	hook *webhook.Hook) (*peer, error) {
-	// parse the string representation of the CIDR network to ensure it is
-	// valid.
-	_, parsedNetwork, err := net.ParseCIDR(network)
-	if err != nil {
-		return nil, err
+	networks []string,
+	upThreshold, downThreshold, flappingThreshold uint,
+	hooks []*webhook.Hook,
+	tags []string) (*peer, error) {
+	// parse the string representations of the CIDR networks to ensure they
+	// are valid.
+	if len(networks) == 0 {
+		return nil, ErrNoPeerNetwork
+	}
+	parsedNetworks := make([]*net.IPNet, 0, len(networks))
+	for _, network := range networks {
+		_, parsedNetwork, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, err
+		}
+		parsedNetworks = append(parsedNetworks, parsedNetwork)
 	}
 
+	now := time.Now()
+
 	return &peer{
-		Name:          name,
-		Network:       parsedNetwork,
-		Webhook:       hook,
-		upThreshold:   upThreshold,
-		downThreshold: downThreshold,
+		Name:              name,
+		Networks:          parsedNetworks,
+		Webhooks:          hooks,
+		Tags:              tags,
+		protocols:         []string{protocolICMP},
+		upThreshold:       upThreshold,
+		downThreshold:     downThreshold,
+		flappingThreshold: flappingThreshold,
 		// Build a state representation for the peer given the peer's thresholds
-		state: states.NewPeer(upThreshold, downThreshold),
+		state:                states.NewPeer(upThreshold, downThreshold, flappingThreshold),
+		stateEnteredAt:       now,
+		stableStateEnteredAt: now,
+		history:              newStateHistory(defaultMaxHistory),
+		// Peers are monitored by ICMP unless loadPeers configures other protocols
+		protocolLastSeen: make(map[string]time.Time),
+		observationChan:  make(chan observation, observationBufferSize),
 		// Construct a RW Mutex for this peer
 		lastSeenMu: new(sync.RWMutex),
 	}, nil
@@ -73,12 +332,21 @@ func newPeer(
 // own override config values from each PeerConfig or the global values from the
 // Config if no override was specified. Before constructing Peers Config.Valid()
 // is called and any errors are returned, ensuring the config is sensible before
-// trying to construct Peers.
+// trying to construct Peers. If two PeerConfigs have the same Name
+// ErrDuplicatePeerName is returned.
 func loadPeers(c Config) ([]*peer, error) {
 	// Check the config is valid
 	if err := c.Valid(); err != nil {
 		return nil, err
 	}
+	// Check no two peers have the same name
+	seen := make(map[string]int, len(c.Peers))
+	for i, pc := range c.Peers {
+		if first, found := seen[pc.Name]; found {
+			return nil, fmt.Errorf("%w: %q (Peers %d and %d)", ErrDuplicatePeerName, pc.Name, first, i)
+		}
+		seen[pc.Name] = i
+	}
 
 	// Build the Peers with the PeerConfigs
 	var peers []*peer
@@ -95,25 +363,145 @@ func loadPeers(c Config) ([]*peer, error) {
 			downThreshold = c.DownThreshold
 		}
 
-		// If there is an override WebHook use it, otherwise use the global
-		hookURL := pc.Webhook
-		if hookURL == "" {
-			hookURL = c.Webhook
+		// If there is an override FlappingThreshold use it, otherwise use the
+		// global
+		flappingThreshold := pc.FlappingThreshold
+		if flappingThreshold == 0 {
+			flappingThreshold = c.FlappingThreshold
+		}
+
+		// If there are override Webhooks use them, otherwise use the global
+		hookURLs := webhookURLs(pc.Webhook, pc.Webhooks)
+		if len(hookURLs) == 0 {
+			hookURLs = webhookURLs(c.Webhook, c.Webhooks)
 		}
-		// Build a webhook pointer out of the URL if set
-		var hook *webhook.Hook
-		if hookURL != "" {
-			h := webhook.Hook(hookURL)
-			hook = &h
+		// Build webhooks out of the URLs
+		var hooks []*webhook.Hook
+		for _, hookURL := range hookURLs {
+			hook := c.newWebhook(hookURL)
+			hook.Secret = pc.WebhookSecret
+			hooks = append(hooks, hook)
 		}
 
 		// Construct the peer and append it to the peers list
-		peer, err := newPeer(pc.Name, pc.Network, upThreshold, downThreshold, hook)
+		peer, err := newPeer(
+			pc.Name, pc.networks(), upThreshold, downThreshold, flappingThreshold, hooks, pc.Tags)
 		if err != nil {
 			return nil, err
 		}
+		// If there are Protocols use them instead of the default of ICMP
+		if len(pc.Protocols) > 0 {
+			peer.protocols = pc.Protocols
+		}
+		// If the peer is monitored by dialing its TCPPort add that protocol,
+		// replacing the default of ICMP
+		if pc.MonitorType == MonitorTypeTCP {
+			dial := fmt.Sprintf("%s%d", protocolTCPPrefix, pc.TCPPort)
+			switch {
+			case len(pc.Protocols) == 0:
+				peer.protocols = []string{dial}
+			case !slices.Contains(pc.Protocols, dial):
+				peer.protocols = append(slices.Clone(pc.Protocols), dial)
+			}
+		}
+		peer.requireAllProtocols = pc.RequireAllProtocols
+		peer.icmpIdentifier = pc.ICMPIdentifier
+		// If the peer is probed use its ProbeInterval, or the Server's
+		// monitorCycle if it doesn't have one. The ProbeInterval was checked by
+		// c.Valid().
+		peer.probeMode = pc.ProbeMode
+		if pc.ProbeInterval != "" {
+			peer.probeInterval, _ = time.ParseDuration(pc.ProbeInterval)
+		}
+		peer.Labels = pc.Labels
+		peer.Description = pc.Description
+		peer.Owner = pc.Owner
+		peer.ExternalURL = pc.ExternalURL
+		// If there is a PagerDutyRoutingKey open incidents for the peer with it
+		if pc.PagerDutyRoutingKey != "" {
+			peer.PagerDuty = webhook.NewPagerDutyHook(pc.PagerDutyRoutingKey)
+		}
+		// If there is a MaxHistory use it instead of the default
+		if c.MaxHistory != 0 {
+			peer.history = newStateHistory(c.MaxHistory)
+		}
+		// If the peer is known to be up at startup start it in the up state
+		// instead of the default down state
+		if pc.InitialState == InitialStateUp {
+			peer.state = states.NewPeerUp(upThreshold, downThreshold, flappingThreshold)
+		}
+		// Parse the MaintenanceWindows. They were checked by c.Valid().
+		for _, mw := range pc.MaintenanceWindows {
+			window, _ := mw.parse()
+			peer.maintenanceWindows = append(peer.maintenanceWindows, window)
+		}
+		// If there is an override PeerTimeout use it, otherwise the peer uses the
+		// Server's global peerTimeout. The PeerTimeout was checked by c.Valid().
+		if pc.PeerTimeout != "" {
+			peer.peerTimeout, _ = time.ParseDuration(pc.PeerTimeout)
+		}
 		peers = append(peers, peer)
 	}
 
+	if err := checkOverlappingNetworks(peers); err != nil {
+		return nil, err
+	}
+
 	return peers, nil
 }
+
+// checkOverlappingNetworks compares the Networks of every pair of the given
+// peers that are monitored by a common protocol and returns
+// ErrOverlappingPeerNetworks wrapped with the names and networks of the first
+// pair that overlap. Two CIDR networks overlap exactly when one contains the
+// other's network address.
+func checkOverlappingNetworks(peers []*peer) error {
+	for i, a := range peers {
+		for _, b := range peers[i+1:] {
+			if !a.sharesProtocol(b) {
+				continue
+			}
+			for _, aNetwork := range a.Networks {
+				for _, bNetwork := range b.Networks {
+					if aNetwork.Contains(bNetwork.IP) || bNetwork.Contains(aNetwork.IP) {
+						return fmt.Errorf("%w: %q (%s) and %q (%s)",
+							ErrOverlappingPeerNetworks, a.Name, aNetwork, b.Name, bNetwork)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// newWebhook returns a webhook.Hook for the given URL with the Config's
+// WebhookFormat, WebhookTimeout, WebhookProxyURL, WebhookInsecureSkipVerify
+// and webhook TLS files.
+// The WebhookTimeout must have been checked by c.Valid().
+func (c Config) newWebhook(hookURL string) *webhook.Hook {
+	hook := webhook.NewHook(hookURL)
+	hook.HookFormat = c.WebhookFormat
+	hook.Timeout = defaultWebhookTimeout
+	if c.WebhookTimeout != "" {
+		hook.Timeout, _ = time.ParseDuration(c.WebhookTimeout)
+	}
+	hook.ProxyURL = c.WebhookProxyURL
+	hook.InsecureSkipVerify = c.WebhookInsecureSkipVerify
+	hook.TLSCertFile = c.WebhookTLSCertFile
+	hook.TLSKeyFile = c.WebhookTLSKeyFile
+	hook.TLSCACertFile = c.WebhookTLSCACertFile
+
+	return hook
+}
+
+// webhookURLs returns the given deprecated single webhook URL, if it is set,
+// followed by the given list of webhook URLs.
+func webhookURLs(hookURL string, hookURLs []string) []string {
+	var urls []string
+	if hookURL != "" {
+		urls = append(urls, hookURL)
+	}
+
+	return append(urls, hookURLs...)
+}
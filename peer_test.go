@@ -1,15 +1,21 @@
 package woodwatch
 
 import (
+	"errors"
+	"reflect"
 	"testing"
+	"time"
 )
 
 // TestNewPeerError tests that calling newPeer with a bad CIDR network
 // string will produce an error.
 func TestNewPeerError(t *testing.T) {
-	if _, err := newPeer("bad CIDR", "", 0, 0, nil); err == nil {
+	if _, err := newPeer("bad CIDR", []string{""}, 0, 0, 0, nil, nil); err == nil {
 		t.Fatalf("expected err from newPeer with bad CIDR, got nil\n")
 	}
+	if _, err := newPeer("no CIDR", nil, 0, 0, 0, nil, nil); !errors.Is(err, ErrNoPeerNetwork) {
+		t.Fatalf("expected ErrNoPeerNetwork from newPeer with no CIDR, got %v\n", err)
+	}
 }
 
 // TestPeerString tests that calling peer.String() returns the expected string
@@ -17,12 +23,12 @@ func TestNewPeerError(t *testing.T) {
 func TestPeerString(t *testing.T) {
 	p, err := newPeer(
 		"TestPeer",
-		"192.168.1.0/24",
-		0, 0, nil)
+		[]string{"192.168.1.0/24", "10.0.0.0/8"},
+		0, 0, 0, nil, nil)
 	if err != nil {
 		t.Fatalf("newPeer returned %v expected nil", err)
 	}
-	expected := "Peer TestPeer - Network 192.168.1.0/24 - State Down"
+	expected := "Peer TestPeer - Network 192.168.1.0/24,10.0.0.0/8 - State Down"
 	if p.String() != expected {
 		t.Errorf("Expected p.String() to be %q was %q", expected, p.String())
 	}
@@ -31,12 +37,27 @@ func TestPeerString(t *testing.T) {
 func TestLoadPeers(t *testing.T) {
 	exampleHookA := "example.org"
 	exampleHookB := "example.com"
+	exampleHookC := "example.net"
 
 	type expectedPeer struct {
 		Name          string
 		UpThreshold   uint
 		DownThreshold uint
-		Webhook       string
+		Webhooks      []string
+		PeerTimeout   time.Duration
+		// State is the expected state of the peer. If empty "Down" is expected.
+		State string
+		// WebhookFormat is the expected HookFormat of each of the peer's
+		// Webhooks.
+		WebhookFormat string
+		// WebhookSecret is the expected Secret of each of the peer's Webhooks.
+		WebhookSecret string
+		// Protocols are the expected protocols of the peer. If nil they aren't
+		// checked.
+		Protocols []string
+		// PagerDutyRoutingKey is the expected routing key of the peer's
+		// PagerDutyHook. If empty the peer is expected to have no PagerDutyHook.
+		PagerDutyRoutingKey string
 	}
 	testCases := []struct {
 		Name          string
@@ -64,7 +85,7 @@ func TestLoadPeers(t *testing.T) {
 					},
 					{
 						Name:    "Second",
-						Network: "192.168.1.0/24",
+						Network: "192.168.2.0/24",
 					},
 				},
 			},
@@ -73,13 +94,13 @@ func TestLoadPeers(t *testing.T) {
 					Name:          "First",
 					UpThreshold:   10,
 					DownThreshold: 11,
-					Webhook:       exampleHookA,
+					Webhooks:      []string{exampleHookA},
 				},
 				{
 					Name:          "Second",
 					UpThreshold:   10,
 					DownThreshold: 11,
-					Webhook:       exampleHookA,
+					Webhooks:      []string{exampleHookA},
 				},
 			},
 		},
@@ -97,10 +118,11 @@ func TestLoadPeers(t *testing.T) {
 						Network:       "192.168.1.0/24",
 						DownThreshold: 99,
 						Webhook:       exampleHookB,
+						PeerTimeout:   "60s",
 					},
 					{
 						Name:        "Second",
-						Network:     "192.168.1.0/24",
+						Network:     "192.168.2.0/24",
 						UpThreshold: 128,
 					},
 				},
@@ -110,22 +132,207 @@ func TestLoadPeers(t *testing.T) {
 					Name:          "First",
 					UpThreshold:   10,
 					DownThreshold: 99,
-					Webhook:       exampleHookB,
+					Webhooks:      []string{exampleHookB},
+					PeerTimeout:   time.Minute,
 				},
 				{
 					Name:          "Second",
 					UpThreshold:   128,
 					DownThreshold: 11,
-					Webhook:       exampleHookA,
+					Webhooks:      []string{exampleHookA},
+				},
+			},
+		},
+		{
+			Name: "Multiple webhooks config",
+			Conf: Config{
+				MonitorCycle: "2s",
+				PeerTimeout:  "2s",
+				Webhook:      exampleHookA,
+				Webhooks:     []string{exampleHookB},
+				Peers: []PeerConfig{
+					{
+						Name:     "First",
+						Network:  "192.168.1.0/24",
+						Webhook:  exampleHookB,
+						Webhooks: []string{exampleHookA, exampleHookC},
+					},
+					{
+						Name:    "Second",
+						Network: "192.168.2.0/24",
+					},
+					{
+						Name:     "Third",
+						Network:  "192.168.3.0/24",
+						Webhooks: []string{exampleHookC},
+					},
+				},
+			},
+			ExpectedPeers: []expectedPeer{
+				{
+					Name:     "First",
+					Webhooks: []string{exampleHookB, exampleHookA, exampleHookC},
+				},
+				{
+					Name:     "Second",
+					Webhooks: []string{exampleHookA, exampleHookB},
+				},
+				{
+					Name:     "Third",
+					Webhooks: []string{exampleHookC},
+				},
+			},
+		},
+		{
+			Name: "Webhook format and secret config",
+			Conf: Config{
+				MonitorCycle:  "2s",
+				PeerTimeout:   "2s",
+				Webhook:       exampleHookA,
+				WebhookFormat: "slack",
+				Peers: []PeerConfig{
+					{Name: "First", Network: "192.168.1.0/24"},
+					{
+						Name:          "Second",
+						Network:       "192.168.2.0/24",
+						Webhooks:      []string{exampleHookB},
+						WebhookSecret: "secret",
+					},
+				},
+			},
+			ExpectedPeers: []expectedPeer{
+				{Name: "First", Webhooks: []string{exampleHookA}, WebhookFormat: "slack"},
+				{
+					Name:          "Second",
+					Webhooks:      []string{exampleHookB},
+					WebhookFormat: "slack",
+					WebhookSecret: "secret",
+				},
+			},
+		},
+		{
+			Name: "PagerDuty config",
+			Conf: Config{
+				MonitorCycle: "2s",
+				PeerTimeout:  "2s",
+				Peers: []PeerConfig{
+					{Name: "First", Network: "192.168.1.0/24", PagerDutyRoutingKey: "key"},
+					{Name: "Second", Network: "192.168.2.0/24"},
+				},
+			},
+			ExpectedPeers: []expectedPeer{
+				{Name: "First", PagerDutyRoutingKey: "key"},
+				{Name: "Second"},
+			},
+		},
+		{
+			Name: "TCP monitor type config",
+			Conf: Config{
+				MonitorCycle: "2s",
+				PeerTimeout:  "2s",
+				Peers: []PeerConfig{
+					{
+						Name:        "First",
+						Network:     "192.168.1.1/32",
+						MonitorType: MonitorTypeTCP,
+						TCPPort:     22,
+					},
+					{
+						Name:        "Second",
+						Network:     "192.168.1.2/32",
+						MonitorType: MonitorTypeTCP,
+						TCPPort:     443,
+						Protocols:   []string{"icmp"},
+					},
+					{
+						Name:        "Third",
+						Network:     "192.168.2.0/24",
+						MonitorType: MonitorTypeICMP,
+					},
+				},
+			},
+			ExpectedPeers: []expectedPeer{
+				{Name: "First", Protocols: []string{"tcp:22"}},
+				{Name: "Second", Protocols: []string{"icmp", "tcp:443"}},
+				{Name: "Third", Protocols: []string{"icmp"}},
+			},
+		},
+		{
+			Name: "Overlapping networks",
+			Conf: Config{
+				MonitorCycle: "2s",
+				PeerTimeout:  "2s",
+				Peers: []PeerConfig{
+					{Name: "First", Network: "10.0.0.0/8"},
+					{Name: "Second", Network: "192.168.1.0/24"},
+					{Name: "Third", Network: "10.1.0.0/16"},
+				},
+			},
+			ExpectedError: ErrOverlappingPeerNetworks,
+		},
+		{
+			Name: "Duplicate names",
+			Conf: Config{
+				MonitorCycle: "2s",
+				PeerTimeout:  "2s",
+				Peers: []PeerConfig{
+					{Name: "ISP A", Network: "192.168.1.0/24"},
+					{Name: "ISP B", Network: "192.168.2.0/24"},
+					{Name: "ISP A", Network: "192.168.3.0/24"},
+				},
+			},
+			ExpectedError: ErrDuplicatePeerName,
+		},
+		{
+			Name: "Overlapping additional networks",
+			Conf: Config{
+				MonitorCycle: "2s",
+				PeerTimeout:  "2s",
+				Peers: []PeerConfig{
+					{Name: "First", Network: "192.168.1.0/24", Networks: []string{"10.0.0.0/8"}},
+					{Name: "Second", Networks: []string{"192.168.2.0/24", "10.1.0.0/16"}},
+				},
+			},
+			ExpectedError: ErrOverlappingPeerNetworks,
+		},
+		{
+			Name: "Overlapping networks with different protocols",
+			Conf: Config{
+				MonitorCycle: "2s",
+				PeerTimeout:  "2s",
+				Peers: []PeerConfig{
+					{Name: "First", Network: "10.0.0.0/8"},
+					{Name: "Second", Network: "10.1.0.1/32", Protocols: []string{"tcp:9999"}},
+				},
+			},
+			ExpectedPeers: []expectedPeer{
+				{Name: "First"},
+				{Name: "Second"},
+			},
+		},
+		{
+			Name: "Initial state config",
+			Conf: Config{
+				MonitorCycle: "2s",
+				PeerTimeout:  "2s",
+				Peers: []PeerConfig{
+					{Name: "First", Network: "192.168.1.0/24", InitialState: InitialStateUp},
+					{Name: "Second", Network: "192.168.2.0/24", InitialState: InitialStateDown},
+					{Name: "Third", Network: "192.168.3.0/24"},
 				},
 			},
+			ExpectedPeers: []expectedPeer{
+				{Name: "First", State: "Up"},
+				{Name: "Second"},
+				{Name: "Third"},
+			},
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
 			peers, err := loadPeers(tc.Conf)
-			if err != tc.ExpectedError {
+			if !errors.Is(err, tc.ExpectedError) {
 				t.Fatalf("expected loadPeers() to return err %v got %v",
 					tc.ExpectedError, err)
 			}
@@ -147,11 +354,183 @@ func TestLoadPeers(t *testing.T) {
 					t.Errorf("expected %dth peer to have downThreshold %d had %d",
 						i, expected.DownThreshold, p.downThreshold)
 				}
-				if string(*p.Webhook) != expected.Webhook {
-					t.Errorf("expected %dth peer to have Webhook %s had %s",
-						i, expected.Webhook, string(*p.Webhook))
+				if p.peerTimeout != expected.PeerTimeout {
+					t.Errorf("expected %dth peer to have peerTimeout %v had %v",
+						i, expected.PeerTimeout, p.peerTimeout)
+				}
+				expectedState := expected.State
+				if expectedState == "" {
+					expectedState = "Down"
+				}
+				if state := p.state.String(); state != expectedState {
+					t.Errorf("expected %dth peer to have state %q had %q",
+						i, expectedState, state)
+				}
+				if expected.Protocols != nil && !reflect.DeepEqual(p.protocols, expected.Protocols) {
+					t.Errorf("expected %dth peer to have protocols %v had %v",
+						i, expected.Protocols, p.protocols)
+				}
+				var routingKey string
+				if p.PagerDuty != nil {
+					routingKey = p.PagerDuty.RoutingKey
+				}
+				if routingKey != expected.PagerDutyRoutingKey {
+					t.Errorf("expected %dth peer to have PagerDuty routing key %q had %q",
+						i, expected.PagerDutyRoutingKey, routingKey)
+				}
+				var hookURLs []string
+				for _, hook := range p.Webhooks {
+					hookURLs = append(hookURLs, hook.URL)
+					if hook.HookFormat != expected.WebhookFormat {
+						t.Errorf("expected %dth peer to have Webhook format %q had %q",
+							i, expected.WebhookFormat, hook.HookFormat)
+					}
+					if hook.Secret != expected.WebhookSecret {
+						t.Errorf("expected %dth peer to have Webhook secret %q had %q",
+							i, expected.WebhookSecret, hook.Secret)
+					}
+				}
+				if !reflect.DeepEqual(hookURLs, expected.Webhooks) {
+					t.Errorf("expected %dth peer to have Webhooks %v had %v",
+						i, expected.Webhooks, hookURLs)
 				}
 			}
 		})
 	}
 }
+
+// TestPeerSeen tests that peer.seen requires the peer to be seen by any or all
+// of its protocols.
+func TestPeerSeen(t *testing.T) {
+	now := time.Now()
+	timeout := time.Minute
+	testCases := []struct {
+		Name                string
+		RequireAllProtocols bool
+		ProtocolLastSeen    map[string]time.Time
+		ExpectedSeen        bool
+	}{
+		{
+			Name:             "Any protocol, none seen",
+			ProtocolLastSeen: map[string]time.Time{},
+			ExpectedSeen:     false,
+		},
+		{
+			Name:             "Any protocol, one seen",
+			ProtocolLastSeen: map[string]time.Time{"tcp:9999": now},
+			ExpectedSeen:     true,
+		},
+		{
+			Name:                "All protocols, one seen",
+			RequireAllProtocols: true,
+			ProtocolLastSeen:    map[string]time.Time{"tcp:9999": now},
+			ExpectedSeen:        false,
+		},
+		{
+			Name:                "All protocols, one timed out",
+			RequireAllProtocols: true,
+			ProtocolLastSeen: map[string]time.Time{
+				"icmp":     now.Add(-2 * timeout),
+				"tcp:9999": now,
+			},
+			ExpectedSeen: false,
+		},
+		{
+			Name:                "All protocols, all seen",
+			RequireAllProtocols: true,
+			ProtocolLastSeen: map[string]time.Time{
+				"icmp":     now,
+				"tcp:9999": now,
+			},
+			ExpectedSeen: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p, err := newPeer("TestPeer", []string{"192.168.1.0/24"}, 0, 0, 0, nil, nil)
+			if err != nil {
+				t.Fatalf("newPeer returned %v expected nil", err)
+			}
+			p.protocols = []string{"icmp", "tcp:9999"}
+			p.requireAllProtocols = tc.RequireAllProtocols
+			p.protocolLastSeen = tc.ProtocolLastSeen
+			for _, lastSeen := range tc.ProtocolLastSeen {
+				if lastSeen.After(p.lastSeen) {
+					p.lastSeen = lastSeen
+				}
+			}
+			if seen := p.seen(now, timeout); seen != tc.ExpectedSeen {
+				t.Errorf("expected seen %v, got %v", tc.ExpectedSeen, seen)
+			}
+		})
+	}
+}
+
+// TestPeerObservations tests that the observations reported to a peer's
+// observationChan are aggregated when the peer is checked, including when more
+// are reported than the observationChan holds.
+func TestPeerObservations(t *testing.T) {
+	now := time.Now()
+	timeout := time.Minute
+	p, err := newPeer("TestPeer", []string{"192.168.1.1/32"}, 0, 0, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("newPeer returned %v expected nil", err)
+	}
+	p.protocols = []string{"icmp", "tcp:9999"}
+	p.requireAllProtocols = true
+
+	p.observe("tcp:9999", now)
+	for i := observationBufferSize * 2; i > 0; i-- {
+		p.observe("icmp", now.Add(-time.Duration(i)*time.Second))
+	}
+	if !p.seen(now, timeout) {
+		t.Errorf("expected peer to be seen by all protocols")
+	}
+	if len(p.observationChan) != 0 {
+		t.Errorf("expected observations to be aggregated, %d pending", len(p.observationChan))
+	}
+	expected := map[string]time.Time{
+		"icmp":     now.Add(-time.Second),
+		"tcp:9999": now,
+	}
+	for protocol, at := range expected {
+		if !p.protocolLastSeen[protocol].Equal(at) {
+			t.Errorf("expected peer to be last seen by %q at %v, got %v",
+				protocol, at, p.protocolLastSeen[protocol])
+		}
+	}
+
+	// An older observation doesn't replace a newer one.
+	p.observe("tcp:9999", now.Add(-2*timeout))
+	if !p.seen(now, timeout) {
+		t.Errorf("expected peer to still be seen by all protocols")
+	}
+}
+
+// TestNewWebhook tests that Config.newWebhook applies the Config's webhook
+// settings and the default WebhookTimeout.
+func TestNewWebhook(t *testing.T) {
+	c := Config{WebhookFormat: "slack"}
+	hook := c.newWebhook("https://hooks.example.com")
+	if hook.Timeout != defaultWebhookTimeout {
+		t.Errorf("expected default timeout %v, got %v", defaultWebhookTimeout, hook.Timeout)
+	}
+	if hook.HookFormat != "slack" {
+		t.Errorf("expected HookFormat %q, got %q", "slack", hook.HookFormat)
+	}
+
+	c = Config{
+		WebhookTimeout:            "5s",
+		WebhookProxyURL:           "http://proxy.example.com:3128",
+		WebhookInsecureSkipVerify: true,
+	}
+	hook = c.newWebhook("https://hooks.example.com")
+	if hook.Timeout != 5*time.Second {
+		t.Errorf("expected timeout %v, got %v", 5*time.Second, hook.Timeout)
+	}
+	if hook.ProxyURL != c.WebhookProxyURL || !hook.InsecureSkipVerify {
+		t.Errorf("expected proxy URL %q and InsecureSkipVerify, got %q and %v",
+			c.WebhookProxyURL, hook.ProxyURL, hook.InsecureSkipVerify)
+	}
+}
@@ -0,0 +1,208 @@
+package woodwatch
+
+import (
+	"context"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// probeTickInterval is how often a probeChecker checks if a probe is due for
+// each of the Server's peers in probe mode.
+const probeTickInterval = time.Second
+
+// probeIdentifier is the ICMP echo identifier of probes sent to peers without
+// an icmpIdentifier.
+var probeIdentifier = uint16(os.Getpid() & 0xffff)
+
+// probeData is the data of the ICMP echo requests sent as probes.
+var probeData = []byte("woodwatch")
+
+// probeChecker is a checker for peers in probe mode. It sends ICMP echo
+// requests to each peer's host address every probe interval and the peer is
+// seen when it replies within its timeout, see Server.handleProbeReply.
+type probeChecker struct {
+	// s is the Server whose peers are probed.
+	s *Server
+	// tick is the duration between checking if probes are due.
+	tick time.Duration
+}
+
+// run sends the probes that are due once per tick until the context is done.
+// Peers are read from the Server for each tick so that peers added by
+// reloading the config are probed too.
+func (c probeChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(c.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.check()
+		}
+	}
+}
+
+// check sends a probe to every peer in probe mode that hasn't been probed
+// within its probeInterval, or the Server's monitorCycle if it doesn't have
+// one.
+func (c probeChecker) check() {
+	c.s.peersMu.RLock()
+	peers := c.s.peers
+	c.s.peersMu.RUnlock()
+
+	now := c.s.currentTime()
+	for _, p := range peers {
+		if !p.probeMode {
+			continue
+		}
+		interval := p.probeInterval
+		if interval == 0 {
+			interval = c.s.monitorCycle
+		}
+		if !c.s.lockPeer(p) {
+			continue
+		}
+		due := p.probeSentAt.IsZero() || now.Sub(p.probeSentAt) >= interval
+		if due {
+			p.probeSentAt = now
+			p.probeSeq++
+		}
+		seq := p.probeSeq
+		p.lastSeenMu.Unlock()
+		if due {
+			c.s.sendProbe(p, seq)
+		}
+	}
+}
+
+// sendProbe sends an ICMP echo request with the given sequence number to the
+// host address of the given peer's first network. Errors, e.g. because there is
+// no route to the peer or the Server doesn't listen on an ICMP network for the
+// peer's address family, are logged as debug messages.
+func (s *Server) sendProbe(p *peer, seq uint16) {
+	ip := probeAddress(p.Networks[0])
+	conn := s.icmpConnFor(ip)
+	if conn == nil {
+		s.debugf("not probing peer %s: not listening for %s\n", p.Name, ip)
+
+		return
+	}
+
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if icmpProtocolNumber(conn) == protocolNumberICMPv6 {
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+	b, err := (&icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{ID: int(p.probeID()), Seq: int(seq), Data: probeData},
+	}).Marshal(nil)
+	if err != nil {
+		s.errorf("error marshalling probe for peer %s: %v\n", p.Name, err)
+
+		return
+	}
+
+	if _, err := conn.WriteTo(b, &net.IPAddr{IP: ip}); err != nil {
+		s.debugf("error probing peer %s at %s: %v\n", p.Name, ip, err)
+	}
+}
+
+// icmpConnFor returns the Server's PacketConn for sending ICMP messages to the
+// given IP, or nil if the Server doesn't listen on an ICMP network for its
+// address family.
+func (s *Server) icmpConnFor(ip net.IP) *icmp.PacketConn {
+	wantProto := protocolNumberICMPv6
+	if ip.To4() != nil {
+		wantProto = protocolNumberICMP
+	}
+	s.listenMu.Lock()
+	conns := []*icmp.PacketConn{s.conn, s.conn6}
+	s.listenMu.Unlock()
+	for _, conn := range conns {
+		if conn != nil && icmpProtocolNumber(conn) == wantProto {
+			return conn
+		}
+	}
+
+	return nil
+}
+
+// handleProbeReply updates the peer in probe mode that sent the given ICMP
+// message if it is an echo reply to the peer's probes that arrived within the
+// peer's timeout of the most recent probe. It returns false if the message
+// isn't an echo reply from a peer in probe mode, so that it is handled like
+// any other ICMP message.
+func (s *Server) handleProbeReply(proto int, msg *icmp.Message, src net.Addr) bool {
+	replyType := int(ipv4.ICMPTypeEchoReply)
+	if proto == protocolNumberICMPv6 {
+		replyType = int(ipv6.ICMPTypeEchoReply)
+	}
+	if icmpTypeNumber(msg.Type) != replyType {
+		return false
+	}
+	s.peersMu.RLock()
+	p := s.peerTrie.lookup(net.ParseIP(src.String()), protocolICMP)
+	s.peersMu.RUnlock()
+	if p == nil || !p.probeMode {
+		return false
+	}
+
+	echo, ok := msg.Body.(*icmp.Echo)
+	if !ok || echo.ID != int(p.probeID()) {
+		s.debugf("ignoring ICMP echo reply with unexpected identifier from %q\n", src)
+
+		return true
+	}
+	timeout := p.peerTimeout
+	if timeout == 0 {
+		timeout = s.peerTimeout
+	}
+	if !s.rLockPeer(p) {
+		return true
+	}
+	sentAt := p.probeSentAt
+	p.lastSeenMu.RUnlock()
+	if sentAt.IsZero() || s.currentTime().Sub(sentAt) >= timeout {
+		s.debugf("ignoring late ICMP echo reply from %q\n", src)
+
+		return true
+	}
+	s.updatePeer(src, protocolICMP)
+
+	return true
+}
+
+// probeID returns the ICMP echo identifier of the probes sent to the peer: its
+// icmpIdentifier if it has one, otherwise the probeIdentifier.
+func (p *peer) probeID() uint16 {
+	if p.icmpIdentifier != 0 {
+		return p.icmpIdentifier
+	}
+
+	return probeIdentifier
+}
+
+// probeAddress returns the host address probes are sent to for the given
+// network. This is the first usable address, e.g. 192.168.1.1 for
+// 192.168.1.0/24, or the network address of single host and point-to-point
+// networks, e.g. 192.168.1.10/32 and 10.0.0.0/31.
+func probeAddress(network *net.IPNet) net.IP {
+	ip := append(net.IP(nil), network.IP...)
+	if ones, bits := network.Mask.Size(); bits-ones < 2 {
+		return ip
+	}
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			break
+		}
+	}
+
+	return ip
+}
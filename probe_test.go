@@ -0,0 +1,231 @@
+package woodwatch
+
+import (
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// TestProbeAddress tests that probes are sent to the first usable address of
+// a network, or the network address of single host and point-to-point
+// networks.
+func TestProbeAddress(t *testing.T) {
+	testCases := []struct {
+		Network  string
+		Expected string
+	}{
+		{
+			Network:  "192.168.1.0/24",
+			Expected: "192.168.1.1",
+		},
+		{
+			Network:  "10.0.255.255/15",
+			Expected: "10.0.0.1",
+		},
+		{
+			Network:  "192.168.1.10/32",
+			Expected: "192.168.1.10",
+		},
+		{
+			Network:  "10.0.0.0/31",
+			Expected: "10.0.0.0",
+		},
+		{
+			Network:  "2001:db8::/64",
+			Expected: "2001:db8::1",
+		},
+		{
+			Network:  "2001:db8::1/128",
+			Expected: "2001:db8::1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Network, func(t *testing.T) {
+			_, network, err := net.ParseCIDR(tc.Network)
+			if err != nil {
+				t.Fatalf("expected ParseCIDR to return nil err, got %v", err)
+			}
+			if ip := probeAddress(network); !ip.Equal(net.ParseIP(tc.Expected)) {
+				t.Errorf("expected probeAddress(%q) to be %s, got %s", tc.Network, tc.Expected, ip)
+			}
+		})
+	}
+}
+
+// TestProbeCheckerCheck tests that probes are only due once per probe
+// interval.
+func TestProbeCheckerCheck(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "Router", Network: "192.168.1.1/32", ProbeMode: true, ProbeInterval: "5s"},
+			{Name: "LAN", Network: "10.0.0.0/8"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		return now
+	}
+	checker := probeChecker{s: s, tick: probeTickInterval}
+	router, lan := s.peers[0], s.peers[1]
+
+	expectProbe := func(p *peer, sentAt time.Time, seq uint16) {
+		t.Helper()
+		p.lastSeenMu.RLock()
+		defer p.lastSeenMu.RUnlock()
+		if !p.probeSentAt.Equal(sentAt) || p.probeSeq != seq {
+			t.Errorf("expected peer %s probe %d sent at %v, got %d sent at %v",
+				p.Name, seq, sentAt, p.probeSeq, p.probeSentAt)
+		}
+	}
+
+	start := now
+	checker.check()
+	expectProbe(router, start, 1)
+	expectProbe(lan, time.Time{}, 0)
+
+	now = start.Add(4 * time.Second)
+	checker.check()
+	expectProbe(router, start, 1)
+
+	now = start.Add(5 * time.Second)
+	checker.check()
+	expectProbe(router, now, 2)
+}
+
+// TestHandleProbeReply tests that peers in probe mode are seen by echo replies
+// to their probes that arrive within their timeout.
+func TestHandleProbeReply(t *testing.T) {
+	reply := func(t *testing.T, typ icmp.Type, id int) []byte {
+		t.Helper()
+		b, err := (&icmp.Message{
+			Type: typ,
+			Body: &icmp.Echo{ID: id, Seq: 1, Data: probeData},
+		}).Marshal(nil)
+		if err != nil {
+			t.Fatalf("expected Marshal to return nil err, got %v", err)
+		}
+
+		return b
+	}
+
+	testCases := []struct {
+		Name         string
+		ProbeMode    bool
+		Proto        int
+		Type         icmp.Type
+		ID           int
+		SentAgo      time.Duration
+		NotSent      bool
+		ExpectedSeen bool
+	}{
+		{
+			Name:         "Reply",
+			ProbeMode:    true,
+			Proto:        protocolNumberICMP,
+			Type:         ipv4.ICMPTypeEchoReply,
+			ID:           int(probeIdentifier),
+			SentAgo:      time.Second,
+			ExpectedSeen: true,
+		},
+		{
+			Name:         "ICMPv6 reply",
+			ProbeMode:    true,
+			Proto:        protocolNumberICMPv6,
+			Type:         ipv6.ICMPTypeEchoReply,
+			ID:           int(probeIdentifier),
+			SentAgo:      time.Second,
+			ExpectedSeen: true,
+		},
+		{
+			Name:      "Late reply",
+			ProbeMode: true,
+			Proto:     protocolNumberICMP,
+			Type:      ipv4.ICMPTypeEchoReply,
+			ID:        int(probeIdentifier),
+			SentAgo:   2 * time.Second,
+		},
+		{
+			Name:      "Reply without probe",
+			ProbeMode: true,
+			Proto:     protocolNumberICMP,
+			Type:      ipv4.ICMPTypeEchoReply,
+			ID:        int(probeIdentifier),
+			NotSent:   true,
+		},
+		{
+			Name:      "Reply with other identifier",
+			ProbeMode: true,
+			Proto:     protocolNumberICMP,
+			Type:      ipv4.ICMPTypeEchoReply,
+			ID:        int(probeIdentifier + 1),
+			SentAgo:   time.Second,
+		},
+		{
+			Name:    "Reply without probe mode",
+			Proto:   protocolNumberICMP,
+			Type:    ipv4.ICMPTypeEchoReply,
+			ID:      int(probeIdentifier),
+			SentAgo: time.Second,
+		},
+		{
+			Name:         "Echo request with probe mode",
+			ProbeMode:    true,
+			Proto:        protocolNumberICMP,
+			Type:         ipv4.ICMPTypeEcho,
+			ID:           1,
+			NotSent:      true,
+			ExpectedSeen: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			c := Config{
+				ListenNetwork: ListenNetworkBoth,
+				MonitorCycle:  "1s",
+				PeerTimeout:   "2s",
+				Peers: []PeerConfig{
+					{Name: "Router", Network: "192.168.1.1/32", ProbeMode: tc.ProbeMode},
+					{Name: "Router6", Network: "2001:db8::1/128", ProbeMode: tc.ProbeMode},
+				},
+			}
+			s, err := NewServerFromConfig(log.New(io.Discard, "", 0), true, "whatever", c)
+			if err != nil {
+				t.Fatalf("expected NewServer to return nil err, got %v", err)
+			}
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			s.now = func() time.Time {
+				return now
+			}
+
+			src, p := &net.IPAddr{IP: net.ParseIP("192.168.1.1")}, s.peers[0]
+			if tc.Proto == protocolNumberICMPv6 {
+				src, p = &net.IPAddr{IP: net.ParseIP("2001:db8::1")}, s.peers[1]
+			}
+			if !tc.NotSent {
+				p.probeSentAt = now.Add(-tc.SentAgo)
+			}
+			s.handlePacket(tc.Proto, reply(t, tc.Type, tc.ID), src)
+
+			p.lastSeenMu.RLock()
+			seen := p.lastSeen.Equal(now)
+			p.lastSeenMu.RUnlock()
+			if seen != tc.ExpectedSeen {
+				t.Errorf("expected peer seen to be %v, got %v", tc.ExpectedSeen, seen)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package woodwatch
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultAPIRateLimit is how many requests per second each client IP can
+	// make to the peer API when the Server's apiRateLimit is zero.
+	defaultAPIRateLimit = 10
+	// defaultAPIBurstLimit is how many requests each client IP can make to the
+	// peer API at once when the Server's apiBurstLimit is zero.
+	defaultAPIBurstLimit = 20
+	// apiLimiterTTL is how long the rate limiter of a client IP is kept after
+	// its last request.
+	apiLimiterTTL = 10 * time.Minute
+)
+
+// clientLimiter is the rate limiter of a client IP.
+type clientLimiter struct {
+	// limiter limits the client IP's requests.
+	limiter *rate.Limiter
+	// lastSeen is when the client IP last made a request.
+	lastSeen time.Time
+}
+
+// clientLimiters rate limits requests by client IP, forgetting the limiters of
+// client IPs that haven't made a request within the ttl.
+type clientLimiters struct {
+	// rps is how many requests per second each client IP can make.
+	rps rate.Limit
+	// burst is how many requests each client IP can make at once.
+	burst int
+	// ttl is how long a client IP's limiter is kept after its last request.
+	ttl time.Duration
+	// now returns the current time. Tests replace it.
+	now func() time.Time
+
+	// mu guards the clients and lastSweep.
+	mu sync.Mutex
+	// clients are the limiters by client IP.
+	clients map[string]*clientLimiter
+	// lastSweep is when expired limiters were last removed.
+	lastSweep time.Time
+}
+
+// newClientLimiters returns clientLimiters allowing each client IP the given
+// requests per second and burst.
+func newClientLimiters(rps float64, burst int) *clientLimiters {
+	return &clientLimiters{
+		rps:     rate.Limit(rps),
+		burst:   burst,
+		ttl:     apiLimiterTTL,
+		now:     time.Now,
+		clients: make(map[string]*clientLimiter),
+	}
+}
+
+// reserve takes a request from the limiter of the given client IP. It returns
+// zero if the request is allowed, or how long the client IP has to wait before
+// its next request will be.
+func (c *clientLimiters) reserve(ip string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.Sub(c.lastSweep) >= c.ttl {
+		for client, l := range c.clients {
+			if now.Sub(l.lastSeen) >= c.ttl {
+				delete(c.clients, client)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	l, found := c.clients[ip]
+	if !found {
+		l = &clientLimiter{limiter: rate.NewLimiter(c.rps, c.burst)}
+		c.clients[ip] = l
+	}
+	l.lastSeen = now
+
+	r := l.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return c.ttl
+	}
+	delay := r.DelayFrom(now)
+	if delay > 0 {
+		// The request isn't made so don't count it against the limit.
+		r.CancelAt(now)
+	}
+
+	return delay
+}
+
+// rateLimitMiddleware returns middleware limiting each client IP to the given
+// requests per second, with bursts of up to the given number of requests.
+// Requests over the limit get a 429 Too Many Requests with a Retry-After
+// header of how many seconds to wait before the next request will be allowed.
+// The handlers wrapped by the returned middleware share its limits.
+func rateLimitMiddleware(rps float64, burst int) func(http.Handler) http.Handler {
+	limiters := newClientLimiters(rps, burst)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				ip = r.RemoteAddr
+			}
+			if delay := limiters.reserve(ip); delay > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
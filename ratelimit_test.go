@@ -0,0 +1,104 @@
+package woodwatch
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRateLimitMiddleware tests that each client IP can make a burst of
+// requests before getting a 429 Too Many Requests with a Retry-After header.
+func TestRateLimitMiddleware(t *testing.T) {
+	handler := rateLimitMiddleware(1, 3)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/peers", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	// The burst is allowed from any port of the client IP.
+	for i, remoteAddr := range []string{"192.0.2.1:1000", "192.0.2.1:1001", "192.0.2.1:1002"} {
+		if rec := request(remoteAddr); rec.Code != http.StatusNoContent {
+			t.Fatalf("expected request %d to return %d, got %d", i, http.StatusNoContent, rec.Code)
+		}
+	}
+
+	rec := request("192.0.2.1:1003")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected request over the burst to return %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("expected Retry-After %q, got %q", "1", retryAfter)
+	}
+
+	// Other client IPs have their own limits.
+	if rec := request("192.0.2.2:1000"); rec.Code != http.StatusNoContent {
+		t.Errorf("expected another client IP to return %d, got %d", http.StatusNoContent, rec.Code)
+	}
+}
+
+// TestClientLimitersExpiry tests that rejected requests don't count against
+// the limit and that the limiters of idle client IPs are forgotten.
+func TestClientLimitersExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiters := newClientLimiters(1, 1)
+	limiters.now = func() time.Time { return now }
+
+	if delay := limiters.reserve("192.0.2.1"); delay != 0 {
+		t.Fatalf("expected first request to be allowed, got delay %v", delay)
+	}
+	for i := 0; i < 3; i++ {
+		if delay := limiters.reserve("192.0.2.1"); delay != time.Second {
+			t.Fatalf("expected request %d to be delayed %v, got %v", i, time.Second, delay)
+		}
+	}
+	now = now.Add(time.Second)
+	if delay := limiters.reserve("192.0.2.1"); delay != 0 {
+		t.Fatalf("expected request after a second to be allowed, got delay %v", delay)
+	}
+
+	now = now.Add(apiLimiterTTL)
+	limiters.reserve("192.0.2.2")
+	if _, found := limiters.clients["192.0.2.1"]; found {
+		t.Errorf("expected limiter of idle client IP to be removed")
+	}
+	if _, found := limiters.clients["192.0.2.2"]; !found {
+		t.Errorf("expected limiter of active client IP to be kept")
+	}
+}
+
+// TestAPIRateLimit tests that the Server's API is rate limited with the
+// configured APIRateLimit and APIBurstLimit.
+func TestAPIRateLimit(t *testing.T) {
+	c := Config{
+		MonitorCycle:  "1s",
+		PeerTimeout:   "2s",
+		APIRateLimit:  0.001,
+		APIBurstLimit: 2,
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s := newAPITestServer(t, WithLogger(log.New(io.Discard, "", 0)), WithConfig(c))
+
+	for _, path := range []string{"/peers", "/peers/LAN"} {
+		if code, _ := apiRequest(t, s, http.MethodGet, path, "", ""); code != http.StatusOK {
+			t.Fatalf("expected GET %s to return %d, got %d", path, http.StatusOK, code)
+		}
+	}
+	if code, _ := apiRequest(t, s, http.MethodGet, "/peers/LAN/ack", "", ""); code != http.StatusTooManyRequests {
+		t.Errorf("expected GET /peers/LAN/ack over the burst to return %d, got %d", http.StatusTooManyRequests, code)
+	}
+	if code, _ := apiRequest(t, s, http.MethodGet, "/healthz", "", ""); code == http.StatusTooManyRequests {
+		t.Errorf("expected GET /healthz to not be rate limited")
+	}
+}
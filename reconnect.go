@@ -0,0 +1,102 @@
+package woodwatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+// defaultReconnectBackoff is how long to wait before each attempt to reopen
+// a PacketConn when the Server has no reconnectBackoff.
+const defaultReconnectBackoff = time.Second
+
+// ErrReconnectFailed is returned (wrapped with the number of attempts and the
+// last error) from Server.Listen when reading packets failed and the
+// PacketConn couldn't be reopened within the Server's maxReconnectAttempts.
+var ErrReconnectFailed = errors.New("Could not reopen the ICMP PacketConn")
+
+// readReconnecting reads packets from the Server's conn, or its conn6 if v6 is
+// true, with readPacket. If reading fails with an error other than
+// ErrServerClosed and the Server has a maxReconnectAttempts the PacketConn is
+// reopened on the given network and address with reconnectICMP, replacing the
+// failed one, and reading continues. Monitoring isn't interrupted so the
+// peers' states and when they were last seen are kept. If the Server has no
+// maxReconnectAttempts the read error is returned and if the PacketConn
+// can't be reopened the error from reconnectICMP is.
+func (s *Server) readReconnecting(ctx context.Context, network, address string, v6 bool) error {
+	for {
+		s.listenMu.Lock()
+		conn := s.conn
+		if v6 {
+			conn = s.conn6
+		}
+		s.listenMu.Unlock()
+
+		err := s.readPacket(conn)
+		if errors.Is(err, ErrServerClosed) || s.maxReconnectAttempts == 0 {
+			return err
+		}
+		s.errorf("error reading %s packets, reconnecting: %v\n", network, err)
+
+		newConn, err := s.reconnectICMP(ctx, network, address)
+		if err != nil {
+			return err
+		}
+		// Swap the PacketConns while holding the listenMu so that closeWhenDone
+		// either closes the new PacketConn or the Server is already closing and
+		// it is closed here.
+		s.listenMu.Lock()
+		if s.closing.Load() {
+			s.listenMu.Unlock()
+			_ = newConn.Close()
+
+			return ErrServerClosed
+		}
+		if v6 {
+			s.conn6 = newConn
+		} else {
+			s.conn = newConn
+		}
+		s.listenMu.Unlock()
+		_ = conn.Close()
+		s.reconnects.Add(1)
+		s.infof("reconnected to %s:%s\n", network, address)
+	}
+}
+
+// reconnectICMP opens a PacketConn listening for ICMP packets on the given
+// network and address, waiting the Server's reconnectBackoff, or
+// defaultReconnectBackoff if it has none, before each attempt. If every one of
+// the Server's maxReconnectAttempts fails ErrReconnectFailed is returned
+// wrapped with the number of attempts and the last error. If the given context
+// is done while waiting ErrServerClosed is returned.
+func (s *Server) reconnectICMP(ctx context.Context, network, address string) (*icmp.PacketConn, error) {
+	backoff := s.reconnectBackoff
+	if backoff == 0 {
+		backoff = defaultReconnectBackoff
+	}
+
+	var err error
+	for attempt := 1; attempt <= s.maxReconnectAttempts; attempt++ {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return nil, ErrServerClosed
+		case <-timer.C:
+		}
+
+		var conn *icmp.PacketConn
+		if conn, err = s.listenICMP(network, address); err == nil {
+			return conn, nil
+		}
+		s.warnf("reconnect attempt %d of %d to %s:%s failed: %v\n",
+			attempt, s.maxReconnectAttempts, network, address, err)
+	}
+
+	return nil, fmt.Errorf("%w after %d attempts: %w", ErrReconnectFailed, s.maxReconnectAttempts, err)
+}
@@ -0,0 +1,120 @@
+package woodwatch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+// TestReconnectICMP tests that reconnectICMP gives up after the Server's
+// maxReconnectAttempts and stops waiting when the context is done.
+func TestReconnectICMP(t *testing.T) {
+	s := &Server{
+		log:                  log.New(io.Discard, "", 0),
+		maxReconnectAttempts: 3,
+		reconnectBackoff:     time.Millisecond,
+	}
+
+	t.Run("Give up", func(t *testing.T) {
+		conn, err := s.reconnectICMP(context.Background(), "bogus", "127.0.0.1")
+		if conn != nil {
+			t.Errorf("expected reconnectICMP to return a nil conn, got %v", conn)
+		}
+		if !errors.Is(err, ErrReconnectFailed) {
+			t.Errorf("expected reconnectICMP to return %v, got %v", ErrReconnectFailed, err)
+		}
+	})
+
+	t.Run("Cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := s.reconnectICMP(ctx, "bogus", "127.0.0.1"); !errors.Is(err, ErrServerClosed) {
+			t.Errorf("expected reconnectICMP to return %v, got %v", ErrServerClosed, err)
+		}
+	})
+}
+
+// TestListenReconnect tests that a Server with a maxReconnectAttempts reopens
+// its PacketConn when reading from it fails and keeps its peers' states.
+func TestListenReconnect(t *testing.T) {
+	// Use an unprivileged ICMP socket so the test doesn't need to run as root.
+	c := Config{
+		MonitorCycle:         "1m",
+		PeerTimeout:          "1m",
+		MaxReconnectAttempts: 3,
+		ReconnectBackoff:     "1ms",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24", InitialState: InitialStateUp},
+		},
+	}
+	s, err := NewServer(
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithListenAddress("127.0.0.1"),
+		WithConfig(c))
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	s.listenNetwork = "udp4"
+	conn, err := icmp.ListenPacket(s.listenNetwork, s.listenAddress)
+	if err != nil {
+		t.Skipf("unprivileged ICMP sockets aren't available: %v", err)
+	}
+	_ = conn.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.Listen(context.Background())
+	}()
+	listeningConn := func() *icmp.PacketConn {
+		s.listenMu.Lock()
+		defer s.listenMu.Unlock()
+
+		return s.conn
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for listeningConn() == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	oldConn := listeningConn()
+	if oldConn == nil {
+		t.Fatalf("expected Server to be listening")
+	}
+
+	// A read deadline in the past makes reading from the PacketConn fail.
+	if err := oldConn.SetReadDeadline(time.Now()); err != nil {
+		t.Fatalf("expected SetReadDeadline to return nil err, got %v", err)
+	}
+	for s.reconnects.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if reconnects := s.reconnects.Load(); reconnects != 1 {
+		t.Fatalf("expected 1 reconnect, got %d", reconnects)
+	}
+	if listeningConn() == oldConn {
+		t.Errorf("expected the PacketConn to be replaced")
+	}
+	p := s.peers[0]
+	p.lastSeenMu.RLock()
+	state := p.state.String()
+	p.lastSeenMu.RUnlock()
+	if state != "Up" {
+		t.Errorf("expected peer to still be Up, got %q", state)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Errorf("expected Close to return nil err, got %v", err)
+	}
+	select {
+	case err := <-errChan:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Errorf("expected Listen to return %v, got %v", ErrServerClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected Listen to return after Close")
+	}
+}
@@ -3,12 +3,23 @@
 package woodwatch
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"math/big"
 	"net"
+	"net/http"
+	"runtime/debug"
+	"runtime/trace"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/coreos/go-systemd/v22/daemon"
 	"github.com/cpu/woodwatch/internal/webhook"
 	"golang.org/x/net/icmp"
 )
@@ -20,12 +31,48 @@ var (
 	// ErrServerNotListening is returned from Server.Close when the Server is not
 	// listening.
 	ErrServerNotListening = errors.New("Close() must be called after Listen()")
+	// ErrServerClosed is returned from Server.Listen, wrapped with the
+	// context's error, when the Server stopped listening because it was closed
+	// rather than because reading packets failed.
+	ErrServerClosed = errors.New("Server closed")
 	// ErrEmptyListenAddress is returned from Server.Listen when the Server's
-	// listen address is empty.
+	// listen address is empty and from WithListenAddress when given an empty
+	// listen address.
 	ErrEmptyListenAddress = errors.New("Listen address must not be empty")
 	// ErrTooFewPeers is returned from NewServer when there aren't enough
 	// peers provided.
 	ErrTooFewPeers = errors.New("One or more Peers must be configured")
+	// ErrPeerNotFound is returned when no peer with the requested name is
+	// configured.
+	ErrPeerNotFound = errors.New("No Peer with that name is configured")
+	// ErrPeerAlreadyExists is returned (wrapped with the peer name) from
+	// Server.AddPeer when a peer with the same name is already configured.
+	ErrPeerAlreadyExists = errors.New("A Peer with that name is already configured")
+	// ErrInvalidAckDuration is returned from Server.AcknowledgePeer when the
+	// acknowledgement duration is not positive.
+	ErrInvalidAckDuration = errors.New("Acknowledgement duration must be positive")
+	// ErrInvalidSLAWindow is returned from Server.PeerSLA when the window is
+	// not positive.
+	ErrInvalidSLAWindow = errors.New("SLA window must be positive")
+
+	// allDownState is the NewState of the event dispatched when every peer is
+	// Down.
+	allDownState = "All Down"
+	// notAllDownState is the NewState of the event dispatched when a peer comes
+	// back Up after every peer was Down.
+	notAllDownState = "Not All Down"
+
+	// defaultListenAddress is the listen address used when a Server is
+	// constructed without WithListenAddress.
+	defaultListenAddress = "0.0.0.0"
+
+	// defaultPacketBufSize is the size of the buffer ICMP packets are read into
+	// when the Config's PacketBufSize is zero. It is the Ethernet MTU.
+	defaultPacketBufSize = 1500
+
+	// waitForPeerInterval is how often WaitForPeerState checks the state of the
+	// peer without an event.
+	waitForPeerInterval = 100 * time.Millisecond
 )
 
 // Server is a struct for monitoring peers for keepalives received on
@@ -33,133 +80,1099 @@ var (
 type Server struct {
 	// log is the Server's log.Logger instance.
 	log *log.Logger
+	// slog is the Server's optional slog.Logger instance. When it is set events
+	// are logged with structured fields and log is a log.Logger that writes to
+	// it.
+	slog *slog.Logger
 	// Verbose indicates whether all state change events should be logged and
-	// dispatched or just notable ones.
+	// dispatched or just notable ones. Debug messages are logged when verbose
+	// regardless of the logLevel.
 	verbose bool
+	// logLevel is the least severe level of the messages logged. The zero value
+	// is slog.LevelInfo.
+	logLevel slog.Level
 	// listenAddress is the address used with icmp.ListenPacket in Listen to
 	// create conn.
 	listenAddress string
+	// listenNetwork is the network used with icmp.ListenPacket in Listen. It is
+	// one of ListenNetworkIPv4, ListenNetworkIPv6 or ListenNetworkBoth.
+	listenNetwork string
+	// listenMu guards conn, conn6, cancel and closed, which are set by Listen
+	// and read by Close. conn and conn6 are replaced when they are reopened by
+	// readReconnecting.
+	listenMu sync.Mutex
 	// conn is created in Listen with icmp.ListenPacket. ICMP messages are read
 	// from conn.
 	conn *icmp.PacketConn
-	// peers is a list of configured peers.
+	// conn6 is created in Listen with icmp.ListenPacket when the listenNetwork
+	// is ListenNetworkBoth. ICMPv6 messages are read from conn6 while ICMPv4
+	// messages are read from conn.
+	conn6 *icmp.PacketConn
+	// peersMu is a r/w mutex for controlling access to the peers list for
+	// multiple goroutines.
+	peersMu sync.RWMutex
+	// peers is a list of configured peers. Reading or writing this field must be
+	// done only after acquiring the peersMu. The list is copied on write: it is
+	// only ever replaced, never modified in place, so readers can take
+	// a snapshot of it while holding the read lock and iterate the snapshot
+	// after releasing it.
 	peers []*peer
-	// closeChan is used to signal a close to the monitoring goroutine.
-	closeChan chan bool
+	// peerTrie holds the Networks of the peers for updatePeer to find the peer
+	// a heartbeat is from without checking every peer. It is rebuilt by
+	// setPeers whenever the peers are replaced. Reading or writing this field
+	// must be done only after acquiring the peersMu.
+	peerTrie *peerTrie
+	// config is the Config the peers were last loaded from. AddPeer uses its
+	// global settings for added peers. Reading or writing this field must be
+	// done only after acquiring the peersMu.
+	config Config
+	// configWatcher is an optional ConfigWatcher. Configs received from it are
+	// used to update the Server's peers.
+	configWatcher *ConfigWatcher
+	// publishers is a list of message brokers that events are published to in
+	// addition to the peer webhooks.
+	publishers []webhook.Publisher
+	// allDownWebhook is an optional webhook to dispatch events to when every peer
+	// is Down and when a peer comes back Up afterwards.
+	allDownWebhook *webhook.Hook
+	// allDownThreshold is how many consecutive monitor cycles every peer needs
+	// to be Down before the all down event is dispatched.
+	allDownThreshold uint
+	// allDownCycles is how many consecutive monitor cycles every peer has been
+	// Down. It is only accessed by the monitoring goroutine.
+	allDownCycles uint
+	// allDown indicates whether the all down event was dispatched and hasn't
+	// been resolved by a peer coming back Up. It is only accessed by the
+	// monitoring goroutine.
+	allDown bool
+	// cancel cancels the context the Server is listening with. It is set by
+	// Listen.
+	cancel context.CancelFunc
+	// closed is closed once the Server has stopped listening and closed its
+	// connections. It is set by Listen.
+	closed chan struct{}
+	// closeErr is the error from closing the Server's PacketConn. It must only
+	// be read after closed is closed.
+	closeErr error
+	// closing is set once the Server starts closing its connections so that
+	// the errors from reading its closed PacketConns aren't mistaken for read
+	// failures.
+	closing atomic.Bool
 	// monitorCycle is the duration of time between checking if peers have timed out.
 	monitorCycle time.Duration
+	// monitorCycleJitter is the maximum duration of the random delay before the
+	// first monitor cycle.
+	monitorCycleJitter time.Duration
+	// monitorJitter is the maximum duration each peer's check is delayed by
+	// from the start of a monitor cycle. If zero every peer is checked at once.
+	monitorJitter time.Duration
 	// peerTimeout is the duration of time the peer must have sent an ICMP echo
 	// request within to be considered seen recently enough during a monitor
 	// cycle.
 	peerTimeout time.Duration
+	// startupGracePeriod is the duration after the Server starts listening
+	// during which every peer is considered seen and no events are
+	// dispatched.
+	startupGracePeriod time.Duration
+	// startedAt is when the Server started listening. It is written in Listen
+	// before the health check server and monitoring goroutine are started.
+	startedAt time.Time
+	// peerLockTimeout is how long to wait for the lastSeenMu of a peer before
+	// giving up. If zero defaultPeerLockTimeout is used.
+	peerLockTimeout time.Duration
+	// peerLockTimeouts counts the times a peer's lastSeenMu couldn't be
+	// acquired within the peerLockTimeout.
+	peerLockTimeouts atomic.Uint64
+	// systemdNotify indicates whether the Server notifies systemd of its status
+	// with sd_notify.
+	systemdNotify bool
+	// metrics are the Server's Prometheus metrics.
+	metrics *metrics
+	// metricsAddr is an optional address to serve the Server's metrics on over
+	// HTTP.
+	metricsAddr string
+	// metricsServer is created in Listen when there is a metricsAddr. It serves
+	// the Server's metrics.
+	metricsServer *http.Server
+	// healthAddr is an optional address to serve the Server's health checks on
+	// over HTTP.
+	healthAddr string
+	// healthServer is created in Listen when there is a healthAddr. It serves
+	// the Server's health checks.
+	healthServer *http.Server
+	// apiRateLimit is how many requests per second each client IP can make to
+	// the peer API. If zero defaultAPIRateLimit is used.
+	apiRateLimit float64
+	// apiBurstLimit is how many requests each client IP can make to the peer
+	// API at once. If zero defaultAPIBurstLimit is used.
+	apiBurstLimit int
+	// apiKey is the key requests that change the Server's peers through the
+	// health check HTTP server must authenticate with. If it is empty those
+	// requests are forbidden.
+	apiKey string
+	// monitorCycleCompleted is set once the Server has checked all of its peers
+	// for the first time.
+	monitorCycleCompleted atomic.Bool
+	// paused is set while the Server's monitoring is paused by Pause.
+	paused atomic.Bool
+	// resumed is sent to by Resume to restart the monitoring goroutine's
+	// ticker.
+	resumed chan struct{}
+	// recoverPanics indicates whether panics while checking peers or dispatching
+	// events are recovered from.
+	recoverPanics bool
+	// panics is how many panics the Server has recovered from.
+	panics atomic.Uint64
+	// eventDedup indicates whether events with the same peer and NewState as
+	// the most recent event dispatched for the peer are suppressed.
+	eventDedup bool
+	// dedupWindow is how long after an event is dispatched an identical event
+	// is suppressed when eventDedup is enabled. If zero identical events are
+	// always suppressed.
+	dedupWindow time.Duration
+	// dedupMu guards lastDispatched.
+	dedupMu sync.Mutex
+	// lastDispatched is the most recent event dispatched for each peer, keyed
+	// by peer name, when eventDedup is enabled.
+	lastDispatched map[string]dispatchedEvent
+	// eventLogSize is how many dispatched events are kept in the eventLog. If
+	// zero defaultEventLogSize is used.
+	eventLogSize uint
+	// eventLogMu guards eventLog.
+	eventLogMu sync.Mutex
+	// eventLog holds the most recent events the Server dispatched for
+	// ReplayEvents. It is created when the first event is recorded.
+	eventLog *eventLog
+	// webhookQueueDepth is how many webhook dispatches can be queued. If zero
+	// defaultWebhookQueueDepth is used.
+	webhookQueueDepth int
+	// webhookWorkers is how many webhook dispatches are made at once. If zero
+	// defaultWebhookWorkers is used.
+	webhookWorkers int
+	// dispatchMu guards dispatchQueue, dispatchCtx, dispatchCancel,
+	// dispatchStopped and dispatchDraining.
+	dispatchMu sync.Mutex
+	// dispatchQueue is the queue of webhook dispatches drained by the dispatch
+	// workers. It is created when the first dispatch is queued.
+	dispatchQueue chan dispatchJob
+	// dispatchCtx is the context dispatches are made with. It is cancelled by
+	// dispatchCancel when the dispatch workers are stopped.
+	dispatchCtx    context.Context
+	dispatchCancel context.CancelFunc
+	// dispatchWG is used to wait for the dispatch workers to return.
+	dispatchWG sync.WaitGroup
+	// dispatchStopped is set once the dispatch workers have been stopped.
+	dispatchStopped bool
+	// dispatchDraining is set by DrainAndClose to stop new dispatches being
+	// queued while the queued and in progress dispatches finish.
+	dispatchDraining bool
+	// dispatchPending counts the dispatches that are queued or in progress.
+	dispatchPending sync.WaitGroup
+	// dispatchesDropped is how many webhook dispatches were dropped because
+	// the dispatch queue was full.
+	dispatchesDropped atomic.Uint64
+	// now returns the current time. If nil time.Now is used. Tests replace it
+	// with a fake clock.
+	now func() time.Time
+	// subscribersMu guards subscribers.
+	subscribersMu sync.Mutex
+	// subscribers are the channels returned by Subscribe that events are sent
+	// to, keyed by their receive-only form.
+	subscribers map[<-chan webhook.Event]chan webhook.Event
+	// packetBufSize is the size of the buffer ICMP packets are read into. If
+	// zero defaultPacketBufSize is used.
+	packetBufSize int
+	// readErrors counts the errors reading ICMP packets, other than those
+	// caused by the Server closing.
+	readErrors atomic.Uint64
+	// retryableReadErrors counts the readErrors that were retried.
+	retryableReadErrors atomic.Uint64
+	// maxReconnectAttempts is how many times in a row reopening a PacketConn
+	// that failed to read is attempted before Listen gives up. If zero
+	// PacketConns aren't reopened.
+	maxReconnectAttempts int
+	// reconnectBackoff is how long to wait before each attempt to reopen
+	// a PacketConn. If zero defaultReconnectBackoff is used.
+	reconnectBackoff time.Duration
+	// reconnects counts the PacketConns that were reopened after failing to
+	// read.
+	reconnects atomic.Uint64
+	// filterICMPTypes are the ICMP message types a peer is seen by. If empty
+	// only echo requests are.
+	filterICMPTypes []int
+	// errs is the channel returned by Errors that the Server's errors are sent
+	// to.
+	errs chan error
+}
+
+// NewServer constructs a woodwatch.Server configured by the given
+// ServerOptions or returns an error. Options are applied in order and the
+// first error returned by an option is returned immediately. A Server must be
+// given peers to monitor, usually with WithConfig, or ErrTooFewPeers is
+// returned. The Server will not be running and listening for ICMP messages
+// until it is explicitly started by calling Server.Listen(ctx).
+func NewServer(opts ...ServerOption) (*Server, error) {
+	s := &Server{
+		log:              log.Default(),
+		listenAddress:    defaultListenAddress,
+		listenNetwork:    ListenNetworkIPv4,
+		allDownThreshold: 1,
+		recoverPanics:    true,
+		resumed:          make(chan struct{}, 1),
+		errs:             make(chan error, errorsBufferSize),
+	}
+	s.metrics = newMetrics(s)
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			s.closePublishers()
+
+			return nil, err
+		}
+	}
+	if len(s.peers) == 0 {
+		s.closePublishers()
+
+		return nil, ErrTooFewPeers
+	}
+
+	// Log each of the peers and the initial state
+	for _, p := range s.peers {
+		s.infof("%s\n", p)
+	}
+
+	return s, nil
 }
 
-// NewServer constructs a woodwatch.Server for the given arguments and config or
-// returns an error. The Server will not be running and listening for ICMP
-// messages until it is explicitly started by calling Server.Listen().
-func NewServer(
+// NewServerFromConfig constructs a woodwatch.Server for the given arguments and
+// config or returns an error. It is equivalent to calling NewServer with
+// WithLogger, WithVerbose, WithListenAddress and WithConfig. The Server will
+// not be running and listening for ICMP messages until it is explicitly
+// started by calling Server.Listen().
+func NewServerFromConfig(
 	log *log.Logger,
 	verbose bool,
 	addr string,
 	c Config) (*Server, error) {
-	if addr == "" {
-		return nil, ErrEmptyListenAddress
+	return NewServer(
+		WithLogger(log),
+		WithVerbose(verbose),
+		WithListenAddress(addr),
+		WithConfig(c))
+}
+
+// closePublishers closes each of the Server's publishers, logging any errors.
+func (s *Server) closePublishers() {
+	for _, pub := range s.publishers {
+		if err := pub.Close(); err != nil {
+			s.errorf("error closing publisher: %v\n", err)
+		}
 	}
-	if err := c.Valid(); err != nil {
-		return nil, err
+}
+
+// loadPublishers constructs a webhook.Publisher for each of the message
+// brokers configured in the Config. If a Publisher can't be constructed any
+// that were already constructed are closed and the error is returned.
+func loadPublishers(c Config) ([]webhook.Publisher, error) {
+	var publishers []webhook.Publisher
+	if c.AMQPAddress != "" {
+		publishers = append(publishers, webhook.NewAMQPPublisher(
+			c.AMQPAddress, c.AMQPExchange, c.AMQPRoutingKey))
+	}
+	if c.NATSServer != "" {
+		pub, err := webhook.NewNATSPublisher(
+			c.NATSServer, c.NATSSubject, c.NATSCredentials, c.NATSUseJetStream)
+		if err != nil {
+			for _, p := range publishers {
+				_ = p.Close()
+			}
+
+			return nil, err
+		}
+		publishers = append(publishers, pub)
 	}
 
-	// Parse the monitor cycle and timeout durations.
-	// NOTE(@cpu): It's safe to throw away potential error returns from
-	// `time.ParseDuration` here because we checked c.Valid() and it verifies the
-	// duration validities.
-	monitorCycleDuration, _ := time.ParseDuration(c.MonitorCycle)
-	peerTimeoutDuration, _ := time.ParseDuration(c.PeerTimeout)
+	return publishers, nil
+}
 
-	// Build peers from the PeerConfigs
-	peers, err := loadPeers(c)
+// Listen opens a PacketConn for the Server's listen network and address that
+// will listen for ICMP packets. When the listen network is ListenNetworkBoth
+// ICMPv4 packets are listened for on the listen address and ICMPv6 packets are
+// listened for on all interfaces ("::"). Listen blocks until the given context
+// is cancelled or the Server's Close function is called. Then the Server stops
+// monitoring its peers, closes its connections and Listen returns
+// ErrServerClosed wrapped with the context's error, so that errors.Is matches
+// both. If reading packets fails for any other reason that error is returned
+// instead. If Listen is called on a Server with an empty listen
+// address it will return ErrEmptyListeningAddress. If Listen is called more
+// than once it will return ErrServerAlreadyListening for all calls after the
+// first. Errors returned from Listen other than ErrServerClosed and
+// ErrServerAlreadyListening are also sent to the Server's Errors channel as
+// a *FatalError.
+func (s *Server) Listen(ctx context.Context) error {
+	s.listenMu.Lock()
+	ctx, cancel, err := s.listen(ctx)
+	s.listenMu.Unlock()
+	// A Server that is already listening keeps running, so the error isn't
+	// fatal.
+	if errors.Is(err, ErrServerAlreadyListening) {
+		return err
+	}
 	if err != nil {
-		return nil, err
+		s.reportError(&FatalError{Err: err})
+
+		return err
 	}
+	s.sdNotify(daemon.SdNotifyReady)
 
-	// Log each of the peers and the initial state
-	for _, p := range peers {
-		log.Print(p)
+	// Read the ICMPv6 packets of the dual-stack mode in another goroutine.
+	if s.conn6 != nil {
+		go func() {
+			err := s.readReconnecting(ctx, ListenNetworkIPv6, "::", true)
+			if errors.Is(err, ErrServerClosed) {
+				s.infof("stopped reading %s packets: %v\n", ListenNetworkIPv6, err)
+
+				return
+			}
+			s.errorf("stopped reading %s packets: %v\n", ListenNetworkIPv6, err)
+			s.reportError(fmt.Errorf("stopped reading %s packets: %w", ListenNetworkIPv6, err))
+		}()
+	}
+
+	// Reading stops when closeWhenDone closes the PacketConn after the context
+	// is cancelled.
+	network := s.listenNetwork
+	if network == ListenNetworkBoth {
+		network = ListenNetworkIPv4
+	}
+	err = s.readReconnecting(ctx, network, s.listenAddress, false)
+	if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ErrServerClosed) {
+		<-s.closed
+
+		return fmt.Errorf("%w: %w", ErrServerClosed, ctxErr)
 	}
+	// Reading stopped for another reason so cancel the context to close
+	// everything.
+	cancel()
+	<-s.closed
+	s.reportError(&FatalError{Err: err})
 
-	return &Server{
-		log:           log,
-		verbose:       verbose,
-		listenAddress: addr,
-		peers:         peers,
-		closeChan:     make(chan bool, 1),
-		monitorCycle:  monitorCycleDuration,
-		peerTimeout:   peerTimeoutDuration,
-	}, nil
+	return err
 }
 
-// Listen opens a PacketConn for the Server's listen address that will listen
-// for ICMP packets. If Listen is called on a Server with an empty listen
-// address it will return ErrEmptyListeningAddress. If Listen is called more
-// than once it will return ErrServerAlreadyListening for all calls after the
-// first.
-func (s *Server) Listen() error {
+// listen opens the Server's PacketConns and other listeners and starts the
+// Server's goroutines for Listen. It returns a context derived from the given
+// context that is cancelled by Close and its cancel function. The caller must
+// hold the listenMu.
+func (s *Server) listen(ctx context.Context) (context.Context, context.CancelFunc, error) {
 	// Don't listen if there is no listen address
 	if s.listenAddress == "" {
-		return ErrEmptyListenAddress
+		return nil, nil, ErrEmptyListenAddress
 	}
 	// Don't listen again if the server is already listening.
 	if s.conn != nil {
-		return ErrServerAlreadyListening
+		return nil, nil, ErrServerAlreadyListening
 	}
 
-	// Start monitoring the last seen date of the peers.
-	go s.checkPeersTicker()
+	s.startedAt = s.currentTime()
 
-	// Listen for packets on the server listenAddress
+	// Serve metrics if there is a metrics address.
+	if s.metricsAddr != "" {
+		if err := s.listenMetrics(); err != nil {
+			return nil, nil, err
+		}
+	}
+	// Serve health checks if there is a health address.
+	if s.healthAddr != "" {
+		if err := s.listenHealth(); err != nil {
+			s.closeMetrics()
+
+			return nil, nil, err
+		}
+	}
+
+	// Listen for packets on the server listenAddress. In dual-stack mode listen
+	// for ICMPv4 and ICMPv6 packets on separate PacketConns.
+	var conn, conn6 *icmp.PacketConn
 	var err error
-	s.conn, err = icmp.ListenPacket("ip4:icmp", s.listenAddress)
+	if s.listenNetwork != ListenNetworkBoth {
+		conn, err = s.listenICMP(s.listenNetwork, s.listenAddress)
+	} else {
+		conn, err = s.listenICMP(ListenNetworkIPv4, s.listenAddress)
+		if err == nil {
+			conn6, err = s.listenICMP(ListenNetworkIPv6, "::")
+			if err != nil {
+				_ = conn.Close()
+			}
+		}
+	}
+	if err != nil {
+		s.closeMetrics()
+		s.closeHealth()
+
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	s.closed = make(chan struct{})
+	s.conn, s.conn6 = conn, conn6
+
+	// Start monitoring the last seen date of the peers.
+	go s.checkPeersTicker(ctx)
+	// Start actively checking the peers that aren't monitored by listening.
+	for _, c := range s.checkers() {
+		go c.run(ctx)
+	}
+	// Start applying config changes if there is a ConfigWatcher.
+	if s.configWatcher != nil {
+		go s.watchConfig()
+	}
+	// Close everything once the context is cancelled.
+	go s.closeWhenDone(ctx)
+
+	return ctx, cancel, nil
+}
+
+// listenICMP opens a PacketConn listening for ICMP packets on the given network
+// and address.
+func (s *Server) listenICMP(network, address string) (*icmp.PacketConn, error) {
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	s.infof("server listening on %s:%s\n", network, address)
+
+	return conn, nil
+}
+
+// SetConfigWatcher wires the Server to a ConfigWatcher. Once the Server is
+// listening each Config received from the ConfigWatcher replaces the Server's
+// peers. Peers with the same name and network as an existing peer keep their
+// current state. The Server closes the ConfigWatcher when the Server is
+// closed. SetConfigWatcher must be called before Listen.
+func (s *Server) SetConfigWatcher(w *ConfigWatcher) {
+	s.configWatcher = w
+}
+
+// watchConfig reloads the Server's peers for each Config received from the
+// Server's ConfigWatcher until the ConfigWatcher is closed.
+func (s *Server) watchConfig() {
+	for c := range s.configWatcher.Changes() {
+		if err := s.Reload(c); err != nil {
+			s.errorf("error reloading config: %v\n", err)
+			s.reportError(err)
+
+			continue
+		}
+		s.infof("reloaded config\n")
+	}
+}
+
+// Reload builds new peers from the given Config and atomically swaps them in
+// place of the Server's current peers. New peers that have the same name and
+// network as a current peer take over that peer's last seen time, state,
+// uptime and downtime, acknowledgement, state history, packet counts and echo
+// sequence tracking, while their flap count starts over at zero. Other new
+// peers start Down. Current peers that aren't in the Config, including those
+// added with AddPeer, stop being monitored without an event being dispatched
+// and their expvars are deleted unless a new peer has the same name. The peers
+// added, removed and modified since the current Config, as described
+// by DiffConfigs, are logged. If the Config is not valid the error is returned
+// and the current peers are kept.
+func (s *Server) Reload(c Config) error {
+	peers, err := loadPeers(c)
+	if err != nil {
+		return err
+	}
+
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	current := make(map[string]*peer, len(s.peers))
+	for _, p := range s.peers {
+		current[p.Name+" "+p.networks()] = p
+	}
+	for _, p := range peers {
+		key := p.Name + " " + p.networks()
+		old, found := current[key]
+		if !found {
+			continue
+		}
+		delete(current, key)
+		// A peer whose lock can't be acquired starts over like a new peer. The
+		// lock is written to aggregate the old peer's observations.
+		if !s.lockPeer(old) {
+			continue
+		}
+		old.aggregateObservations()
+		p.lastSeen = old.lastSeen
+		for protocol, lastSeen := range old.protocolLastSeen {
+			p.protocolLastSeen[protocol] = lastSeen
+		}
+		p.state = old.state
+		p.stateEnteredAt = old.stateEnteredAt
+		p.stableStateEnteredAt = old.stableStateEnteredAt
+		p.totalUptime = old.totalUptime
+		p.totalDowntime = old.totalDowntime
+		p.ackUntil = old.ackUntil
+		p.ackMessage = old.ackMessage
+		p.packetsReceived = old.packetsReceived
+		p.packets = old.packets
+		p.echo = old.echo
+		for _, entry := range old.history.list() {
+			p.history.add(entry)
+		}
+		old.lastSeenMu.Unlock()
+	}
+	// Forget the expvars of the current peers whose name isn't reused.
+	names := make(map[string]bool, len(peers))
+	for _, p := range peers {
+		names[p.Name] = true
+	}
+	for _, old := range current {
+		if !names[old.Name] {
+			expvarRemovePeer(old.Name)
+		}
+	}
+	for _, line := range DiffConfigs(s.config, c).Lines() {
+		s.infof("%s\n", line)
+	}
+	s.setPeers(peers)
+	s.config = c
+
+	return nil
+}
+
+// AddPeer builds a peer from the given PeerConfig and starts monitoring it
+// without restarting the Server. Zero values in the PeerConfig use the global
+// settings of the Server's Config, as they would in the Config's Peers. If the
+// PeerConfig is not valid the error is returned. If a peer with the same name
+// is already configured ErrPeerAlreadyExists is returned wrapped with the
+// name. If the peer's Network overlaps the Network of a current peer monitored
+// by a common protocol ErrOverlappingPeerNetworks is returned. The new peer
+// starts in its InitialState. Peers monitored by a "tcp:<port>" protocol for
+// a port no current peer is monitored by aren't seen until the Server is
+// restarted.
+func (s *Server) AddPeer(pc PeerConfig) error {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	for _, p := range s.peers {
+		if p.Name == pc.Name {
+			return fmt.Errorf("%w: %q", ErrPeerAlreadyExists, pc.Name)
+		}
+	}
+
+	// Build the peer with the global settings of the current Config.
+	c := s.config
+	c.Peers = []PeerConfig{pc}
+	added, err := loadPeers(c)
 	if err != nil {
 		return err
 	}
-	s.log.Printf("server listening on ip4:icmp:%s\n", s.listenAddress)
+	// Copy the peers on write so that readers that took a snapshot of the peers
+	// list can keep iterating it without holding the peersMu.
+	peers := append(slices.Clone(s.peers), added...)
+	if err := checkOverlappingNetworks(peers); err != nil {
+		return err
+	}
+	s.setPeers(peers)
+	s.config.Peers = append(slices.Clone(s.config.Peers), pc)
+	s.infof("added Peer %s - Network %s\n", added[0].Name, added[0].networks())
+
+	return nil
+}
+
+// RemovePeer stops monitoring the peer with the given name without restarting
+// the Server. No event is dispatched for the removed peer and its expvars are
+// deleted. If no peer with the given name is configured ErrPeerNotFound is
+// returned.
+func (s *Server) RemovePeer(name string) error {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	i := slices.IndexFunc(s.peers, func(p *peer) bool {
+		return p.Name == name
+	})
+	if i < 0 {
+		return ErrPeerNotFound
+	}
+	removed := s.peers[i]
+	// Copy the peers on write so that readers that took a snapshot of the peers
+	// list can keep iterating it without holding the peersMu.
+	s.setPeers(slices.Delete(slices.Clone(s.peers), i, i+1))
+	s.config.Peers = slices.DeleteFunc(slices.Clone(s.config.Peers), func(pc PeerConfig) bool {
+		return pc.Name == name
+	})
+	expvarRemovePeer(removed.Name)
+	s.infof("removed Peer %s - Network %s\n", removed.Name, removed.networks())
+
+	return nil
+}
+
+// setPeers replaces the Server's peers with the given peers and rebuilds the
+// peerTrie for them. The caller must hold the peersMu for writing unless the
+// Server is being constructed.
+func (s *Server) setPeers(peers []*peer) {
+	s.peers = peers
+	s.peerTrie = newPeerTrie(peers)
+}
+
+// findPeer returns the Server's peer with the given name or nil if there is no
+// peer with that name.
+func (s *Server) findPeer(name string) *peer {
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	for _, p := range s.peers {
+		if p.Name == name {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// WaitForPeer blocks until the peer with the given name is in the target state
+// (e.g. "Up", "Down") or the timeout expires. It is WaitForPeerState with
+// a context that expires after the timeout, so if the timeout expires first
+// context.DeadlineExceeded is returned.
+func (s *Server) WaitForPeer(name string, targetState string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return s.WaitForPeerState(ctx, name, targetState)
+}
+
+// WaitForPeerState blocks until the peer with the given name is in the given
+// state (e.g. "Up", "Down") or the context is done. The peer's state is checked
+// whenever the Server sends an event to its subscribers and at least every
+// 100ms, so that states that don't produce an event, e.g. "Maybe Up (1 of 2)"
+// or a state entered during a maintenance window, are noticed too. If no peer
+// with the given name is configured ErrPeerNotFound is returned. If the context
+// is done first its error is returned.
+func (s *Server) WaitForPeerState(ctx context.Context, name string, state string) error {
+	events := s.Subscribe()
+	defer s.Unsubscribe(events)
+	ticker := time.NewTicker(waitForPeerInterval)
+	defer ticker.Stop()
+
+	for {
+		p := s.findPeer(name)
+		if p == nil {
+			return ErrPeerNotFound
+		}
+		// Keep waiting if the peer's lock can't be acquired.
+		if s.rLockPeer(p) {
+			current := p.state.String()
+			p.lastSeenMu.RUnlock()
+			if current == state {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-events:
+			// The subscription is closed when the Server stops listening. Keep
+			// checking the peer's state with the ticker.
+			if !ok {
+				events = nil
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// ManualHeartbeat updates the last seen time of the peer with the given name
+// to now, as if an ICMP echo request had been received from the peer. This
+// allows peers to be kept up by something other than ICMP, e.g. an HTTP
+// keepalive. If no peer with the given name is configured ErrPeerNotFound is
+// returned and if the peer's lock can't be acquired ErrPeerLockTimeout is
+// returned.
+func (s *Server) ManualHeartbeat(peerName string) error {
+	p := s.findPeer(peerName)
+	if p == nil {
+		return ErrPeerNotFound
+	}
+
+	s.debugf("manual heartbeat updated lastseen for %s\n", p.Name)
+	if !s.lockPeer(p) {
+		return peerLockTimeoutError(p)
+	}
+	defer p.lastSeenMu.Unlock()
+	now := s.currentTime()
+	p.lastSeen = now
+	p.observe(protocolICMP, now)
+
+	return nil
+}
+
+// ResetPeerStats zeroes the statistics kept for the peer with the given name:
+// its flap count and its total uptime and downtime, with the time spent in its
+// current state counted from now. If no peer with the given name is configured
+// ErrPeerNotFound is returned and if the peer's lock can't be acquired
+// ErrPeerLockTimeout is returned.
+func (s *Server) ResetPeerStats(name string) error {
+	return s.resetPeerStatsBy(name, "")
+}
+
+// resetPeerStatsBy is ResetPeerStats logging the reset as requested by the
+// given requester if it isn't empty.
+func (s *Server) resetPeerStatsBy(name, requester string) error {
+	p := s.findPeer(name)
+	if p == nil {
+		return ErrPeerNotFound
+	}
+	now := s.currentTime()
+	if !s.resetPeerStats(p, now) {
+		return peerLockTimeoutError(p)
+	}
+	s.infof("reset stats for peer %s at %s%s\n",
+		p.Name, now.Format(time.RFC3339), requestedBy(requester))
+
+	return nil
+}
+
+// ResetStats zeroes the statistics kept for all of the Server's peers as with
+// ResetPeerStats. Peers whose lock can't be acquired are skipped.
+func (s *Server) ResetStats() {
+	s.resetStatsBy("")
+}
+
+// resetStatsBy is ResetStats logging the reset as requested by the given
+// requester if it isn't empty.
+func (s *Server) resetStatsBy(requester string) {
+	s.peersMu.RLock()
+	defer s.peersMu.RUnlock()
+	now := s.currentTime()
+	for _, p := range s.peers {
+		s.resetPeerStats(p, now)
+	}
+	s.infof("reset stats for all peers at %s%s\n",
+		now.Format(time.RFC3339), requestedBy(requester))
+}
+
+// requestedBy returns " by " and the given requester for a log line, or an
+// empty string if the requester is empty.
+func requestedBy(requester string) string {
+	if requester == "" {
+		return ""
+	}
+
+	return " by " + requester
+}
+
+// resetPeerStats zeroes the given peer's flap count, total uptime and total
+// downtime and restarts the time spent in its current state at the given
+// time. It returns false if the peer's lock can't be acquired.
+func (s *Server) resetPeerStats(p *peer, now time.Time) bool {
+	if !s.lockPeer(p) {
+		return false
+	}
+	defer p.lastSeenMu.Unlock()
+	p.flapCount.Store(0)
+	p.totalUptime = 0
+	p.totalDowntime = 0
+	p.stateEnteredAt = now
 
-	return s.readPacket()
+	return true
+}
+
+// AcknowledgePeer acknowledges the peer with the given name for the given
+// duration. An acknowledged peer is still monitored but no events are
+// dispatched for it until the acknowledgement expires or the peer comes back
+// Up. The message is logged and included in the log line of each event that
+// is not dispatched. If no peer with the given name is configured
+// ErrPeerNotFound is returned. If the duration is not positive
+// ErrInvalidAckDuration is returned. If the peer's lock can't be acquired
+// ErrPeerLockTimeout is returned.
+func (s *Server) AcknowledgePeer(name string, duration time.Duration, message string) error {
+	if duration <= 0 {
+		return ErrInvalidAckDuration
+	}
+	p := s.findPeer(name)
+	if p == nil {
+		return ErrPeerNotFound
+	}
+
+	if !s.lockPeer(p) {
+		return peerLockTimeoutError(p)
+	}
+	defer p.lastSeenMu.Unlock()
+	p.ackUntil = s.currentTime().Add(duration)
+	p.ackMessage = message
+	s.infof("peer %s acknowledged until %s: %s\n",
+		p.Name, p.ackUntil.Format(time.RFC3339), message)
+
+	return nil
+}
+
+// PeerAcknowledgement returns when the acknowledgement of the peer with the
+// given name expires and its message. If the peer isn't acknowledged a zero
+// time and an empty message are returned. If no peer with the given name is
+// configured ErrPeerNotFound is returned and if the peer's lock can't be
+// acquired ErrPeerLockTimeout is returned.
+func (s *Server) PeerAcknowledgement(name string) (time.Time, string, error) {
+	p := s.findPeer(name)
+	if p == nil {
+		return time.Time{}, "", ErrPeerNotFound
+	}
+
+	if !s.rLockPeer(p) {
+		return time.Time{}, "", peerLockTimeoutError(p)
+	}
+	defer p.lastSeenMu.RUnlock()
+	if !p.acknowledged(s.currentTime()) {
+		return time.Time{}, "", nil
+	}
+
+	return p.ackUntil, p.ackMessage, nil
+}
+
+// PeerStatus describes the current status of a monitored peer. It is the JSON
+// body of a GET /peers/{name} response and of each peer of a GET /peers
+// response.
+type PeerStatus struct {
+	// Name is the peer's name.
+	Name string `json:"name"`
+	// Network is the CIDR network the peer is expected to be seen from. If the
+	// peer has more than one network they are comma separated, e.g.
+	// "192.168.1.0/24,10.0.0.0/8".
+	Network string `json:"network"`
+	// Tags are the peer's tags, e.g. "production".
+	Tags []string `json:"tags,omitempty"`
+	// State is the peer's current state, e.g. "Up", "Down" or "Maybe Up (1 of 2)".
+	State string `json:"state"`
+	// LastSeen is when the peer was last seen, or the zero time if it hasn't
+	// been seen.
+	LastSeen time.Time `json:"lastSeen"`
+	// UpThreshold is how many cycles the peer needs to be seen before it is
+	// considered up.
+	UpThreshold uint `json:"upThreshold"`
+	// DownThreshold is how many cycles the peer needs to not be seen before it
+	// is considered down.
+	DownThreshold uint `json:"downThreshold"`
+	// PacketsReceived is how many packets, or successful TCP dials, have been
+	// received from the peer.
+	PacketsReceived uint64 `json:"packetsReceived"`
+	// LastMinutePackets is how many packets, or successful TCP dials, have been
+	// received from the peer in the last 60 seconds.
+	LastMinutePackets uint64 `json:"lastMinutePackets"`
+	// PacketRate is the average number of packets, or successful TCP dials,
+	// received from the peer per second over the last 60 seconds.
+	PacketRate float64 `json:"packetRate"`
+	// UptimeSinceStart is how long the peer has been Up since the Server
+	// started.
+	UptimeSinceStart time.Duration `json:"uptimeSinceStart"`
+	// DowntimeSinceStart is how long the peer has been Down since the Server
+	// started.
+	DowntimeSinceStart time.Duration `json:"downtimeSinceStart"`
+	// LastSeq is the sequence number of the most recent in order ICMP echo
+	// request received from the peer.
+	LastSeq uint16 `json:"lastSeq"`
+	// DuplicatePackets is how many ICMP echo requests were received from the
+	// peer with the same sequence number as the one before.
+	DuplicatePackets uint64 `json:"duplicatePackets"`
+	// OutOfOrderPackets is how many ICMP echo requests were received from the
+	// peer with a sequence number before the LastSeq.
+	OutOfOrderPackets uint64 `json:"outOfOrderPackets"`
+	// StateDuration is how long the peer has been in its current Up, Down or
+	// Flapping state, including any Maybe states it has gone through since,
+	// e.g. 3h24m for a peer that went Down 3h24m ago.
+	StateDuration time.Duration `json:"stateDuration"`
+	// FlapCount is how many noteworthy state changes the peer has made since
+	// the Server started or its stats were last reset.
+	FlapCount uint64 `json:"flapCount"`
+	// AckUntil is when the peer's acknowledgement expires, or the zero time if
+	// it isn't acknowledged.
+	AckUntil time.Time `json:"ackUntil"`
+	// AckMessage is the message the peer was acknowledged with, if it is
+	// acknowledged.
+	AckMessage string `json:"ackMessage,omitempty"`
+}
+
+// Peers returns a snapshot of the current status of each of the Server's
+// peers, in the order they are configured. Each peer's status is consistent
+// but peers are checked in turn, so the statuses of different peers may be
+// from different monitor cycles. Peers whose lock can't be acquired are left
+// out.
+func (s *Server) Peers() []PeerStatus {
+	s.peersMu.RLock()
+	peers := s.peers
+	s.peersMu.RUnlock()
+
+	now := s.currentTime()
+	statuses := make([]PeerStatus, 0, len(peers))
+	for _, p := range peers {
+		if !s.rLockPeer(p) {
+			continue
+		}
+		statuses = append(statuses, p.status(now))
+		p.lastSeenMu.RUnlock()
+	}
+
+	return statuses
+}
+
+// Peer returns the current status of the peer with the given name. If no peer
+// with the given name is configured ErrPeerNotFound is returned and if the
+// peer's lock can't be acquired ErrPeerLockTimeout is returned.
+func (s *Server) Peer(name string) (PeerStatus, error) {
+	p := s.findPeer(name)
+	if p == nil {
+		return PeerStatus{}, ErrPeerNotFound
+	}
+
+	if !s.rLockPeer(p) {
+		return PeerStatus{}, peerLockTimeoutError(p)
+	}
+	defer p.lastSeenMu.RUnlock()
+
+	return p.status(s.currentTime()), nil
+}
+
+// status returns the PeerStatus of the peer at the given time. The caller must
+// hold the peer's lastSeenMu.
+func (p *peer) status(now time.Time) PeerStatus {
+	lastMinutePackets := p.packets.count(now)
+	uptime, downtime := p.uptime(now)
+	status := PeerStatus{
+		Name:               p.Name,
+		Network:            p.networks(),
+		Tags:               p.Tags,
+		State:              p.state.String(),
+		LastSeen:           p.lastSeen,
+		UpThreshold:        p.upThreshold,
+		DownThreshold:      p.downThreshold,
+		PacketsReceived:    p.packetsReceived,
+		LastMinutePackets:  lastMinutePackets,
+		PacketRate:         float64(lastMinutePackets) / packetWindowSeconds,
+		UptimeSinceStart:   uptime,
+		DowntimeSinceStart: downtime,
+		LastSeq:            p.echo.lastSeq,
+		DuplicatePackets:   p.echo.duplicates,
+		OutOfOrderPackets:  p.echo.outOfOrder,
+		StateDuration:      now.Sub(p.stableStateEnteredAt),
+		FlapCount:          p.flapCount.Load(),
+	}
+	if p.acknowledged(now) {
+		status.AckUntil = p.ackUntil
+		status.AckMessage = p.ackMessage
+	}
+
+	return status
 }
 
 // checkPeersTicker will call checkPeer for each of the Server's configured
-// peers once per monitorCycle until the Server's Close function is called.
-func (s *Server) checkPeersTicker() {
+// peers once per monitorCycle until the given context is done. If the Server
+// has a monitorCycleJitter the ticker is started after a random delay of up to
+// the jitter. Peers are checked with checkPeers, which staggers them when the
+// Server has a monitorJitter.
+func (s *Server) checkPeersTicker(ctx context.Context) {
+	if s.monitorCycleJitter > 0 {
+		select {
+		case <-ctx.Done():
+			s.infof("stopping monitoring\n")
+
+			return
+		case <-time.After(randomDuration(s.monitorCycleJitter)):
+		}
+	}
+
 	ticker := time.NewTicker(s.monitorCycle)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-s.closeChan:
-			s.log.Printf("stopping monitoring\n")
+		case <-ctx.Done():
+			s.infof("stopping monitoring\n")
 
 			return
+		case <-s.resumed:
+			// Start a full monitor cycle from when monitoring was resumed.
+			ticker.Reset(s.monitorCycle)
 		case <-ticker.C:
-			for _, src := range s.peers {
-				s.checkPeer(src)
+			// Tell the systemd watchdog monitoring is still running, even while
+			// it is paused.
+			s.sdNotify(daemon.SdNotifyWatchdog)
+			// Skip the monitor cycle while monitoring is paused.
+			if s.paused.Load() {
+				continue
 			}
+			s.peersMu.RLock()
+			peers := s.peers
+			s.peersMu.RUnlock()
+			// Group the checks of each monitor cycle in a trace task so they can be
+			// inspected with the Go execution tracer.
+			cycleCtx, task := trace.NewTask(ctx, "monitorCycle")
+			if !s.checkPeers(cycleCtx, peers) {
+				task.End()
+
+				continue
+			}
+			s.checkAllDown(peers)
+			task.End()
+			s.monitorCycleCompleted.Store(true)
 		}
 	}
 }
 
+// inStartupGracePeriod returns true if the Server started listening less than
+// the startupGracePeriod ago.
+func (s *Server) inStartupGracePeriod() bool {
+	return s.currentTime().Sub(s.startedAt) < s.startupGracePeriod
+}
+
+// currentTime returns the current time from the Server's clock.
+func (s *Server) currentTime() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+
+	return s.now()
+}
+
+// randomDuration returns a random duration in [0, max) read from crypto/rand so
+// that woodwatch instances started at the same time don't pick the same
+// duration. If crypto/rand fails zero is returned.
+func randomDuration(max time.Duration) time.Duration {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0
+	}
+
+	return time.Duration(n.Int64())
+}
+
 // checkPeer checks if the given peer's last seen date is within an
-// acceptable time range.
-func (s *Server) checkPeer(p *peer) {
+// acceptable time range. The check is recorded as a "checkPeer" region of the
+// execution trace for the given context.
+func (s *Server) checkPeer(ctx context.Context, p *peer) {
 	// defensive check - shouldn't happen.
 	if p == nil {
 		return
 	}
-	p.lastSeenMu.RLock()
-	defer p.lastSeenMu.RUnlock()
+	defer s.recoverPanic("checking peer " + p.Name)
+	defer trace.StartRegion(ctx, "checkPeer").End()
+	trace.Log(ctx, "peer", p.Name)
+
+	// Skip checking a peer whose lock can't be acquired this monitor cycle.
+	if !s.lockPeer(p) {
+		return
+	}
+	defer p.lastSeenMu.Unlock()
 
-	// Check if the peer has been seen within the peerTimeout
-	var seen bool
-	if time.Since(p.lastSeen) < s.peerTimeout {
-		seen = true
+	// Check if the peer has been seen within its own peerTimeout, or the
+	// Server's if it doesn't have one. During the startup grace period every peer
+	// is considered seen so that peers that haven't had time to send an ICMP
+	// echo request yet aren't considered down.
+	timeout := p.peerTimeout
+	if timeout == 0 {
+		timeout = s.peerTimeout
 	}
+	now := s.currentTime()
+	inGracePeriod := s.inStartupGracePeriod()
+	seen := p.seen(now, timeout) || inGracePeriod
 
 	// Call the heartbeat function of the peer's current state with the
 	// observation to produce a new state.
@@ -167,27 +1180,110 @@ func (s *Server) checkPeer(p *peer) {
 	var noteworthy bool
 	p.state, noteworthy = p.state.Heartbeat(seen)
 	newState := p.state.String()
+	trace.Logf(ctx, "transition", "%s -> %s", oldState, newState)
+	if oldState != newState && s.metrics != nil {
+		s.metrics.transitions.WithLabelValues(p.Name, oldState, newState).Inc()
+	}
+	// Count state changes and record the peer's state in the woodwatch
+	// expvars.
+	if oldState != newState {
+		expvarTransitions.Add(p.Name, 1)
+	}
+	expvarPeerState(p.Name, newState)
+
+	// Track how long the peer was in its previous state, restarting the clock
+	// when the state changes, for its uptime and downtime.
+	if oldState != newState {
+		p.addStateDuration(oldState, now.Sub(p.stateEnteredAt))
+		p.stateEnteredAt = now
+		p.history.add(StateEntry{
+			Timestamp:  now,
+			OldState:   oldState,
+			NewState:   newState,
+			Noteworthy: noteworthy,
+		})
+	}
+
+	// The event describes how long the peer was in its last Up, Down or
+	// Flapping state, including the Maybe states it went through since, rather
+	// than how long it was in a single Maybe state. The clock restarts with
+	// each noteworthy transition.
+	stateDuration := now.Sub(p.stableStateEnteredAt)
+	if noteworthy {
+		p.stableStateEnteredAt = now
+	}
 
 	prettyLastSeen := p.lastSeen.Format("2006-01-02 03:04:05 PM -0700")
 	event := webhook.Event{
-		Timestamp: time.Now(),
-		LastSeen:  p.lastSeen,
-		Title:     fmt.Sprintf("Peer %s is %s", p.Name, newState),
+		Peer:            p.Name,
+		Tags:            p.Tags,
+		Labels:          p.Labels,
+		PeerDescription: p.Description,
+		PeerOwner:       p.Owner,
+		PeerURL:         p.ExternalURL,
+		Timestamp:       now,
+		LastSeen:        p.lastSeen,
+		Title:           fmt.Sprintf("Peer %s is %s", p.Name, newState),
 		Text: fmt.Sprintf("%s (last seen %s) was previously %s and is now %s",
 			p.Name, prettyLastSeen, oldState, newState),
-		NewState:  newState,
-		PrevState: oldState,
+		NewState:      newState,
+		PrevState:     oldState,
+		StateDuration: stateDuration,
 	}
 
+	// Clear any acknowledgement when the peer recovers.
+	if noteworthy && newState == "Up" {
+		p.ackUntil = time.Time{}
+		p.ackMessage = ""
+	}
+	acknowledged := p.acknowledged(now)
+	inMaintenance := p.inMaintenance(now)
+
 	dispatch := func() {
-		if p.Webhook != nil {
-			go p.Webhook.Dispatch(event)
+		// Don't dispatch events during the startup grace period to avoid an alert
+		// storm on cold start.
+		if inGracePeriod {
+			s.debugf("suppressing event %q during startup grace period\n", event.Title)
+
+			return
+		}
+		// Don't dispatch events during one of the peer's maintenance windows.
+		if inMaintenance {
+			s.debugf("suppressing event %q during maintenance window\n", event.Title)
+
+			return
+		}
+		// Don't dispatch an event identical to the last one dispatched for the
+		// peer.
+		if s.duplicateEvent(event) {
+			s.infof("suppressing duplicate event %q\n", event.Title)
+
+			return
+		}
+		// Don't dispatch events for an acknowledged peer, only log them.
+		if acknowledged {
+			s.logAcknowledgedEvent(event, p.ackUntil, p.ackMessage)
+
+			return
+		}
+		// Dispatch to each webhook and to PagerDuty.
+		for _, hook := range p.Webhooks {
+			s.dispatchTo(ctx, hook, hook.URL, event)
+		}
+		if p.PagerDuty != nil {
+			s.dispatchTo(ctx, p.PagerDuty, webhook.PagerDutyEventsURL, event)
 		}
-		s.log.Print(event.Title)
+		for _, pub := range s.publishers {
+			s.publishTo(ctx, pub, event)
+		}
+		s.notifySubscribers(event)
+		s.recordEvent(event)
+		s.logEvent(event)
 	}
 
 	if noteworthy {
-		// If the event was noteworthy dispatch it.
+		// If the event was noteworthy count it and dispatch it.
+		p.flapCount.Add(1)
 		dispatch()
 	} else if oldState != newState && s.verbose {
 		// If the event was a state change and we're being verbose then dispatch it
@@ -196,64 +1292,233 @@ func (s *Server) checkPeer(p *peer) {
 	}
 }
 
-// readPacket will read an ICMP packet from the server's PacketConn connection
-// and update the first source that matches the source IP of the sender.
-func (s *Server) readPacket() error {
-	// Process messages until an error from ReadFrom occurs. Notably this will
-	// happen when the Server's Close function is called and the underlying
-	// PacketConn is closed.
+// checkAllDown checks if every one of the given peers is Down after a monitor
+// cycle. Once every peer has been Down for allDownThreshold consecutive cycles
+// an all down event is dispatched to the Server's allDownWebhook. When a peer
+// comes back Up afterwards a second event is dispatched to resolve it.
+func (s *Server) checkAllDown(peers []*peer) {
+	allDown, anyUp := len(peers) > 0, false
+	for _, p := range peers {
+		// A peer whose lock can't be acquired isn't known to be Down.
+		if !s.rLockPeer(p) {
+			allDown = false
+
+			continue
+		}
+		state := p.state.String()
+		p.lastSeenMu.RUnlock()
+		if state != "Down" {
+			allDown = false
+		}
+		if state == "Up" {
+			anyUp = true
+		}
+	}
+
+	if !allDown {
+		s.allDownCycles = 0
+		if s.allDown && anyUp {
+			s.allDown = false
+			s.dispatchAllDown(webhook.Event{
+				Title:     "Peers are no longer all Down",
+				Text:      fmt.Sprintf("At least one of %d peers is Up again", len(peers)),
+				Timestamp: s.currentTime(),
+				NewState:  notAllDownState,
+				PrevState: allDownState,
+			})
+		}
+
+		return
+	}
+
+	s.allDownCycles++
+	if s.allDown || s.allDownCycles < s.allDownThreshold {
+		return
+	}
+	s.allDown = true
+	s.dispatchAllDown(webhook.Event{
+		Title: "All peers are Down",
+		Text: fmt.Sprintf("All %d peers have been Down for %d monitor cycles",
+			len(peers), s.allDownCycles),
+		Timestamp: s.currentTime(),
+		NewState:  allDownState,
+		PrevState: notAllDownState,
+	})
+}
+
+// dispatchAllDown logs the given all down event and dispatches it to the
+// Server's allDownWebhook if there is one.
+func (s *Server) dispatchAllDown(event webhook.Event) {
+	if s.allDownWebhook != nil {
+		s.dispatchTo(context.Background(), s.allDownWebhook, s.allDownWebhook.URL, event)
+	}
+	s.notifySubscribers(event)
+	s.recordEvent(event)
+	s.logEvent(event)
+}
+
+// recoverPanic recovers from a panic in the calling goroutine when the Server
+// is configured to recover from panics. The panic is logged with a stack trace
+// and counted. It must be called with defer.
+func (s *Server) recoverPanic(activity string) {
+	if !s.recoverPanics {
+		return
+	}
+	if r := recover(); r != nil {
+		s.panics.Add(1)
+		s.errorf("recovered from panic %s: %v\n%s", activity, r, debug.Stack())
+	}
+}
+
+// publish publishes the event with the given publisher, logging any error.
+func (s *Server) publish(pub webhook.Publisher, event webhook.Event) {
+	defer s.recoverPanic("publishing event for peer " + event.Peer)
+	if err := pub.Publish(event); err != nil {
+		s.errorf("error publishing event %q: %v\n", event.Title, err)
+	}
+}
+
+// readPacket will read ICMP packets from the given PacketConn connection and
+// update the first source that matches the source IP of the sender with
+// handlePacket. Each packet is read into a buffer of the Server's
+// packetBufSize. Only the ICMP message type is used to filter packets, the
+// rest of the payload is available for future filtering. When the PacketConn
+// is closed because the Server is closing ErrServerClosed is returned.
+func (s *Server) readPacket(conn *icmp.PacketConn) error {
+	return s.readPackets(conn, icmpProtocolNumber(conn))
+}
+
+// readPackets reads packets of the given IP protocol number from the given
+// packetReader as described by readPacket. Errors that isRetryableError
+// reports as transient are logged, counted and the read is retried. Any other
+// error is counted and returned.
+func (s *Server) readPackets(conn packetReader, proto int) error {
+	size := s.packetBufSize
+	if size == 0 {
+		size = defaultPacketBufSize
+	}
+	buf := make([]byte, size)
+	// Process messages until a fatal error from ReadFrom occurs. Notably this
+	// will happen when the Server's Close function is called and the
+	// underlying PacketConn is closed.
 	for {
-		var buf []byte
-		_, srcIP, err := s.conn.ReadFrom(buf)
+		n, srcIP, err := conn.ReadFrom(buf)
 		if err != nil {
+			if s.closing.Load() {
+				return ErrServerClosed
+			}
+			s.readErrors.Add(1)
+			if isRetryableError(err) {
+				s.retryableReadErrors.Add(1)
+				s.warnf("retrying reading ICMP packet: %v\n", err)
+
+				continue
+			}
+
 			return err
 		}
-		s.updatePeer(srcIP)
+		if s.metrics != nil {
+			s.metrics.packets.Inc()
+		}
+		s.handlePacket(proto, buf[:n], srcIP)
 	}
 }
 
-// updatePeer iterates the Server's configured peers checking if any of the
-// peer networks contain the given address. The first matching peer will
-// have its last seen field set to the current time.
-func (s *Server) updatePeer(addr fmt.Stringer) {
-	parsedIP := net.ParseIP(addr.String())
+// updatePeer looks up the Server's configured peers in the peerTrie to find
+// the peers monitored by the given protocol with any network that contains
+// the given address. The first configured matching peer will have its last
+// seen fields set to the current time and is returned. If no peer matches, or
+// the matching peer's lock can't be acquired, nil is returned.
+func (s *Server) updatePeer(addr fmt.Stringer, protocol string) *peer {
+	// Count the heartbeat by its protocol in the woodwatch expvars.
+	expvarPacketsReceived.Add(protocol, 1)
 
-	var matchedPeer *peer
-	for _, p := range s.peers {
-		if p.Network.Contains(parsedIP) {
-			matchedPeer = p
+	parsedIP := net.ParseIP(addr.String())
 
-			break
-		}
-	}
+	s.peersMu.RLock()
+	matchedPeer := s.peerTrie.lookup(parsedIP, protocol)
+	s.peersMu.RUnlock()
 
 	if matchedPeer == nil {
-		if s.verbose {
-			s.log.Printf("no configured peer matched %q", addr)
-		}
+		s.debugf("no configured %s peer matched %q", protocol, addr)
 
-		return
+		return nil
 	}
 
-	if s.verbose {
-		s.log.Printf("ip %q updated lastseen for %s\n", addr, matchedPeer.Name)
+	s.debugf("ip %q updated lastseen for %s by %s\n",
+		addr, matchedPeer.Name, protocol)
+	if !s.lockPeer(matchedPeer) {
+		return nil
 	}
-	matchedPeer.lastSeenMu.Lock()
 	defer matchedPeer.lastSeenMu.Unlock()
-	matchedPeer.lastSeen = time.Now()
+	now := s.currentTime()
+	matchedPeer.lastSeen = now
+	matchedPeer.observe(protocol, now)
+	matchedPeer.packetsReceived++
+	matchedPeer.packets.add(now)
+
+	return matchedPeer
 }
 
-// Close closes the Server's PacketConn and stops listening for ICMP messages on
-// the Server's listen address. If Close is called before Listen it will return
+// Close cancels the context the Server is listening with, stopping it
+// listening for ICMP messages on the Server's listen address, and waits for the
+// Server's connections to be closed. It returns the error from closing the
+// Server's PacketConn, if any. If Close is called before Listen it will return
 // ErrServerNotListening.
 func (s *Server) Close() error {
+	s.listenMu.Lock()
 	if s.conn == nil {
+		s.listenMu.Unlock()
+
 		return ErrServerNotListening
 	}
-	// Signal the monitoring go routine to close
-	s.closeChan <- true
+	cancel, closed := s.cancel, s.closed
+	s.listenMu.Unlock()
+
+	cancel()
+	<-closed
+
+	return s.closeErr
+}
+
+// closeWhenDone waits for the given context to be done and then closes all of
+// the Server's connections, stopping it from listening.
+func (s *Server) closeWhenDone(ctx context.Context) {
+	defer close(s.closed)
+	<-ctx.Done()
+	s.sdNotify(daemon.SdNotifyStopping)
+	// Reading from the PacketConns fails once they are closed below.
+	s.closing.Store(true)
+
+	// Stop watching for config changes
+	if s.configWatcher != nil {
+		if err := s.configWatcher.Close(); err != nil {
+			s.errorf("error closing config watcher: %v\n", err)
+		}
+	}
+	// Stop serving metrics
+	s.closeMetrics()
+	// Stop serving health checks
+	s.closeHealth()
+	// Stop dispatching webhooks
+	s.stopDispatchWorkers()
+	// Close the subscriber channels
+	s.closeSubscribers()
+	// Close the connections to any message brokers
+	s.closePublishers()
+	// Hold the listenMu while closing the PacketConns so that they aren't
+	// replaced by readReconnecting.
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+	// Close the dual-stack ICMPv6 PacketConn if there is one
+	if s.conn6 != nil {
+		if err := s.conn6.Close(); err != nil {
+			s.errorf("error closing %s conn: %v\n", ListenNetworkIPv6, err)
+		}
+	}
 	// Close the underlying PacketConn. This will cause the `ReadFrom` in the
-	// infinite for loop in `Serve` to immediately read a *net.OpError from using
-	// the closed connection. Its a good enough "clean" exit mechanism for me!
-	return s.conn.Close()
+	// infinite for loop in `readPacket` to immediately read a *net.OpError from
+	// using the closed connection. Its a good enough "clean" exit mechanism for
+	// me!
+	s.closeErr = s.conn.Close()
 }
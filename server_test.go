@@ -1,8 +1,20 @@
 package woodwatch
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/cpu/woodwatch/internal/states"
+	"github.com/cpu/woodwatch/internal/webhook"
+	webhooktesting "github.com/cpu/woodwatch/internal/webhook/testing"
 	"golang.org/x/net/icmp"
 )
 
@@ -33,7 +45,7 @@ func TestListenErrors(t *testing.T) {
 				listenAddress: tc.Addr,
 				conn:          tc.Conn,
 			}
-			if err := s.Listen(); err == nil {
+			if err := s.Listen(context.Background()); err == nil {
 				t.Fatalf("expected err from Listen(), got nil\n")
 			} else if err != tc.ExpectedErr {
 				t.Errorf("expected err to be %v, was %v\n", tc.ExpectedErr, err)
@@ -56,6 +68,74 @@ func TestCloseError(t *testing.T) {
 	}
 }
 
+// TestListenContext tests that cancelling the context given to Listen, or
+// calling Close, stops the Server listening and makes Listen return
+// ErrServerClosed wrapped with the context's error.
+func TestListenContext(t *testing.T) {
+	newListeningServer := func(t *testing.T, ctx context.Context) (*Server, chan error) {
+		t.Helper()
+		// Use an unprivileged ICMP socket so the test doesn't need to run as root.
+		s := &Server{
+			log:           log.New(io.Discard, "", 0),
+			listenAddress: "127.0.0.1",
+			listenNetwork: "udp4",
+			monitorCycle:  time.Second,
+		}
+		conn, err := icmp.ListenPacket(s.listenNetwork, s.listenAddress)
+		if err != nil {
+			t.Skipf("unprivileged ICMP sockets aren't available: %v", err)
+		}
+		_ = conn.Close()
+
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- s.Listen(ctx)
+		}()
+
+		return s, errChan
+	}
+
+	expectListenErr := func(t *testing.T, errChan chan error, expected error) {
+		t.Helper()
+		select {
+		case err := <-errChan:
+			if !errors.Is(err, expected) {
+				t.Errorf("expected Listen to return %v, got %v", expected, err)
+			}
+			if !errors.Is(err, ErrServerClosed) {
+				t.Errorf("expected Listen to return %v, got %v", ErrServerClosed, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected Listen to return after cancellation")
+		}
+	}
+
+	t.Run("Cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		_, errChan := newListeningServer(t, ctx)
+		cancel()
+		expectListenErr(t, errChan, context.Canceled)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		s, errChan := newListeningServer(t, context.Background())
+		// Wait for the Server to be listening before closing it.
+		for {
+			s.listenMu.Lock()
+			listening := s.conn != nil
+			s.listenMu.Unlock()
+			if listening {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if err := s.Close(); err != nil {
+			t.Errorf("expected Close to return nil err, got %v", err)
+		}
+		expectListenErr(t, errChan, context.Canceled)
+	})
+}
+
 // TestNewServerError tests that calling NewServer with invalid args fails with
 // the expected errors.
 func TestNewServerError(t *testing.T) {
@@ -77,11 +157,1110 @@ func TestNewServerError(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
-			if _, err := NewServer(nil, false, tc.ListenAddress, Config{}); err == nil {
+			if _, err := NewServerFromConfig(nil, false, tc.ListenAddress, Config{}); err == nil {
 				t.Fatalf("expected err from NewServer(), got nil\n")
-			} else if err != tc.ExpectedError {
+			} else if !errors.Is(err, tc.ExpectedError) {
 				t.Errorf("expected err to be %v, was %v\n", tc.ExpectedError, err)
 			}
 		})
 	}
 }
+
+// TestCheckPeerFlapCount tests that checkPeer counts each noteworthy state
+// change of a peer.
+func TestCheckPeerFlapCount(t *testing.T) {
+	p, err := newPeer("TestPeer", []string{"192.168.1.0/24"}, 1, 1, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("newPeer returned %v expected nil", err)
+	}
+	s := Server{
+		log:         log.New(io.Discard, "", 0),
+		peers:       []*peer{p},
+		peerTimeout: time.Minute,
+	}
+
+	testCases := []struct {
+		Seen              bool
+		ExpectedFlapCount uint64
+	}{
+		// Down -> Maybe Up
+		{Seen: true, ExpectedFlapCount: 0},
+		// Maybe Up -> Up
+		{Seen: true, ExpectedFlapCount: 1},
+		// Up -> Up
+		{Seen: true, ExpectedFlapCount: 1},
+		// Up -> Maybe Down
+		{Seen: false, ExpectedFlapCount: 1},
+		// Maybe Down -> Down
+		{Seen: false, ExpectedFlapCount: 2},
+	}
+
+	for i, tc := range testCases {
+		if tc.Seen {
+			p.lastSeen = time.Now()
+		} else {
+			p.lastSeen = time.Time{}
+		}
+		s.checkPeer(context.Background(), p)
+		if count := p.flapCount.Load(); count != tc.ExpectedFlapCount {
+			t.Errorf("after check %d expected flap count %d, got %d",
+				i, tc.ExpectedFlapCount, count)
+		}
+	}
+}
+
+// TestReloadPreservesState tests that reloading a config keeps the state of
+// peers that have the same name and network and starts new peers fresh.
+func TestReloadPreservesState(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "Kept", Network: "192.168.1.0/24"},
+			{Name: "Moved", Network: "192.168.2.0/24"},
+			{Name: "Removed", Network: "192.168.3.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	lastSeen := time.Now()
+	for _, p := range s.peers {
+		p.lastSeen = lastSeen
+		p.state, _ = p.state.Heartbeat(true)
+		p.flapCount.Store(1)
+	}
+
+	if err := s.Reload(Config{}); !errors.Is(err, ErrTooFewPeers) {
+		t.Fatalf("expected Reload of invalid config to return %v, got %v",
+			ErrTooFewPeers, err)
+	}
+	if len(s.peers) != len(c.Peers) {
+		t.Fatalf("expected %d peers after invalid reload, got %d",
+			len(c.Peers), len(s.peers))
+	}
+
+	c.Peers = []PeerConfig{
+		{Name: "Kept", Network: "192.168.1.0/24"},
+		{Name: "Moved", Network: "192.168.20.0/24"},
+		{Name: "Added", Network: "192.168.4.0/24"},
+	}
+	if err := s.Reload(c); err != nil {
+		t.Fatalf("expected Reload to return nil err, got %v", err)
+	}
+
+	if len(s.peers) != len(c.Peers) {
+		t.Fatalf("expected %d peers after reload, got %d", len(c.Peers), len(s.peers))
+	}
+	for _, p := range s.peers {
+		kept := p.Name == "Kept"
+		if p.lastSeen.Equal(lastSeen) != kept {
+			t.Errorf("expected peer %q to keep last seen: %v, got %v",
+				p.Name, kept, p.lastSeen)
+		}
+		if (p.state.String() != "Down") != kept {
+			t.Errorf("expected peer %q to keep state: %v, got %q",
+				p.Name, kept, p.state)
+		}
+		if count := p.flapCount.Load(); count != 0 {
+			t.Errorf("expected peer %q's flap count to start over at 0, got %d",
+				p.Name, count)
+		}
+	}
+}
+
+// TestWaitForPeer tests that WaitForPeer returns once a peer reaches the target
+// state and returns the expected errors otherwise.
+func TestWaitForPeer(t *testing.T) {
+	c := Config{
+		UpThreshold:  1,
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+
+	if err := s.WaitForPeer("Unknown", "Up", time.Second); err != ErrPeerNotFound {
+		t.Errorf("expected err %v for unknown peer, got %v", ErrPeerNotFound, err)
+	}
+	if err := s.WaitForPeer("LAN", "Down", time.Second); err != nil {
+		t.Errorf("expected nil err for peer already in state, got %v", err)
+	}
+	if err := s.WaitForPeer("LAN", "Up", 10*time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("expected err %v waiting for state that isn't reached, got %v",
+			context.DeadlineExceeded, err)
+	}
+
+	// Make the peer go Up while waiting for it.
+	p := s.peers[0]
+	go func() {
+		for i := 0; i < 2; i++ {
+			p.lastSeenMu.Lock()
+			p.lastSeen = time.Now()
+			p.lastSeenMu.Unlock()
+			s.checkPeer(context.Background(), p)
+		}
+	}()
+	if err := s.WaitForPeer("LAN", "Up", time.Second); err != nil {
+		t.Errorf("expected nil err waiting for peer to go Up, got %v", err)
+	}
+}
+
+// TestWaitForPeerState tests that WaitForPeerState returns as soon as an event
+// puts the peer in the state and returns the context's error otherwise.
+func TestWaitForPeerState(t *testing.T) {
+	c := Config{
+		UpThreshold:  1,
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	// Only an event can wake the waiter before the test times out.
+	defer func(interval time.Duration) {
+		waitForPeerInterval = interval
+	}(waitForPeerInterval)
+	waitForPeerInterval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.WaitForPeerState(ctx, "Unknown", "Up"); err != ErrPeerNotFound {
+		t.Errorf("expected err %v for unknown peer, got %v", ErrPeerNotFound, err)
+	}
+	if err := s.WaitForPeerState(ctx, "LAN", "Up"); err != context.Canceled {
+		t.Errorf("expected err %v with a cancelled context, got %v", context.Canceled, err)
+	}
+
+	// Make the peer go Up, dispatching an event, once the waiter is subscribed.
+	p := s.peers[0]
+	go func() {
+		for {
+			s.subscribersMu.Lock()
+			subscribed := len(s.subscribers) > 0
+			s.subscribersMu.Unlock()
+			if subscribed {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		for i := 0; i < 2; i++ {
+			p.lastSeenMu.Lock()
+			p.lastSeen = time.Now()
+			p.lastSeenMu.Unlock()
+			s.checkPeer(context.Background(), p)
+		}
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.WaitForPeerState(ctx, "LAN", "Up"); err != nil {
+		t.Errorf("expected nil err waiting for peer to go Up, got %v", err)
+	}
+}
+
+// panicState is a states.PeerState that panics on every heartbeat.
+type panicState struct{}
+
+func (panicState) Heartbeat(bool) (states.PeerState, bool) { panic("heartbeat") }
+func (panicState) String() string                          { return "Panic" }
+
+// TestCheckPeerRecoverPanic tests that a panic in checkPeer is recovered from
+// and counted only when the Server is configured to recover from panics.
+func TestCheckPeerRecoverPanic(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		RecoverPanics bool
+	}{
+		{Name: "Recover from panics", RecoverPanics: true},
+		{Name: "Don't recover from panics", RecoverPanics: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p, err := newPeer("TestPeer", []string{"192.168.1.0/24"}, 1, 1, 0, nil, nil)
+			if err != nil {
+				t.Fatalf("newPeer returned %v expected nil", err)
+			}
+			p.state = panicState{}
+			s := Server{
+				log:           log.New(io.Discard, "", 0),
+				recoverPanics: tc.RecoverPanics,
+			}
+
+			panicked := func() (panicked bool) {
+				defer func() {
+					panicked = recover() != nil
+				}()
+				s.checkPeer(context.Background(), p)
+
+				return false
+			}()
+
+			if panicked == tc.RecoverPanics {
+				t.Errorf("expected checkPeer to panic: %v, got %v",
+					!tc.RecoverPanics, panicked)
+			}
+			expectedPanics := uint64(0)
+			if tc.RecoverPanics {
+				expectedPanics = 1
+			}
+			if count := s.panics.Load(); count != expectedPanics {
+				t.Errorf("expected %d recovered panics, got %d", expectedPanics, count)
+			}
+		})
+	}
+}
+
+// TestCheckPeerStateEnteredAt tests that checkPeer updates when the peer entered
+// its state only when the state changes.
+func TestCheckPeerStateEnteredAt(t *testing.T) {
+	p, err := newPeer("TestPeer", []string{"192.168.1.0/24"}, 1, 1, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("newPeer returned %v expected nil", err)
+	}
+	s := Server{
+		log:         log.New(io.Discard, "", 0),
+		peerTimeout: time.Minute,
+	}
+
+	// Down -> Down shouldn't change when the state was entered.
+	enteredAt := time.Now().Add(-time.Hour)
+	p.stateEnteredAt = enteredAt
+	s.checkPeer(context.Background(), p)
+	if !p.stateEnteredAt.Equal(enteredAt) {
+		t.Errorf("expected state entered at %v to be unchanged, got %v",
+			enteredAt, p.stateEnteredAt)
+	}
+
+	// Down -> Maybe Up should.
+	p.lastSeen = time.Now()
+	s.checkPeer(context.Background(), p)
+	if !p.stateEnteredAt.After(enteredAt) {
+		t.Errorf("expected state entered at to be updated after %v, got %v",
+			enteredAt, p.stateEnteredAt)
+	}
+}
+
+// TestCheckPeerStateDuration tests that the StateDuration of events is how long
+// the peer was in its last Up or Down state, including the Maybe states it went
+// through since, rather than how long it was in its last Maybe state.
+func TestCheckPeerStateDuration(t *testing.T) {
+	p, err := newPeer("TestPeer", []string{"192.168.1.0/24"}, 3, 3, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("newPeer returned %v expected nil", err)
+	}
+	now := time.Now()
+	s := Server{
+		log:         log.New(io.Discard, "", 0),
+		peerTimeout: time.Minute,
+		now:         func() time.Time { return now },
+	}
+	s.setPeers([]*peer{p})
+	events := s.Subscribe()
+	p.stateEnteredAt = now
+	p.stableStateEnteredAt = now
+
+	// The peer is Down for 3h before it is seen, then takes three monitor
+	// cycles of a minute to come Up.
+	now = now.Add(3 * time.Hour)
+	p.lastSeen = now
+	for i := 0; i < 4; i++ {
+		s.checkPeer(context.Background(), p)
+		now = now.Add(time.Minute)
+		p.lastSeen = now
+	}
+	var up webhook.Event
+	for len(events) > 0 {
+		if e := <-events; e.NewState == "Up" {
+			up = e
+		}
+	}
+	if up.NewState != "Up" {
+		t.Fatalf("expected an Up event")
+	}
+	if expected := 3*time.Hour + 3*time.Minute; up.StateDuration != expected {
+		t.Errorf("expected Up event StateDuration %v, got %v", expected, up.StateDuration)
+	}
+
+	// A minute later the peer has been Up for a minute.
+	if d := s.Peers()[0].StateDuration; d != time.Minute {
+		t.Errorf("expected PeerStatus StateDuration %v, got %v", time.Minute, d)
+	}
+}
+
+// TestCheckPeerTimeout tests that checkPeer uses a peer's own timeout when it
+// has one and the Server's otherwise.
+func TestCheckPeerTimeout(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		PeerTimeout   time.Duration
+		ExpectedState string
+	}{
+		{
+			Name:          "Server timeout",
+			ExpectedState: "Down",
+		},
+		{
+			Name:          "Peer timeout",
+			PeerTimeout:   time.Hour,
+			ExpectedState: "Up",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p, err := newPeer("TestPeer", []string{"192.168.1.0/24"}, 1, 1, 0, nil, nil)
+			if err != nil {
+				t.Fatalf("newPeer returned %v expected nil", err)
+			}
+			p.peerTimeout = tc.PeerTimeout
+			s := Server{
+				log:         log.New(io.Discard, "", 0),
+				peerTimeout: time.Minute,
+			}
+
+			// The peer was last seen within its own timeout but not the Server's.
+			p.lastSeen = time.Now().Add(-10 * time.Minute)
+			s.checkPeer(context.Background(), p)
+			s.checkPeer(context.Background(), p)
+			if state := p.state.String(); state != tc.ExpectedState {
+				t.Errorf("expected peer state %q, got %q", tc.ExpectedState, state)
+			}
+		})
+	}
+}
+
+// TestCheckPeerWebhooks tests that checkPeer dispatches an event to every
+// webhook of a peer, even when one of them fails.
+func TestCheckPeerWebhooks(t *testing.T) {
+	var servers []*webhooktesting.MockHookServer
+	var hooks []*webhook.Hook
+	for _, status := range []int{
+		http.StatusInternalServerError,
+		http.StatusOK,
+		http.StatusOK,
+	} {
+		srv := webhooktesting.NewMockHookServer()
+		defer srv.Close()
+		srv.RespondWith(status, 0)
+		servers = append(servers, srv)
+		hooks = append(hooks, &webhook.Hook{URL: srv.URL()})
+	}
+
+	p, err := newPeer("TestPeer", []string{"192.168.1.0/24"}, 1, 1, 0, hooks, nil)
+	if err != nil {
+		t.Fatalf("newPeer returned %v expected nil", err)
+	}
+	p.Description = "Office uplink"
+	p.Owner = "network-team"
+	p.ExternalURL = "https://wiki.example.com/office"
+	s := Server{
+		log:         log.New(io.Discard, "", 0),
+		peerTimeout: time.Minute,
+	}
+
+	// Down -> Maybe Up -> Up dispatches an event for the Up transition.
+	p.lastSeen = time.Now()
+	s.checkPeer(context.Background(), p)
+	s.checkPeer(context.Background(), p)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for i, srv := range servers {
+		for len(srv.Events()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		events := srv.Events()
+		if len(events) != 1 {
+			t.Fatalf("expected 1 event dispatched to webhook %d, got %d", i, len(events))
+		}
+		if events[0].NewState != "Up" {
+			t.Errorf("expected webhook %d event NewState %q, got %q", i, "Up", events[0].NewState)
+		}
+		if e := events[0]; e.PeerDescription != p.Description || e.PeerOwner != p.Owner || e.PeerURL != p.ExternalURL {
+			t.Errorf("expected webhook %d event to have the peer annotations, got %#v", i, e)
+		}
+	}
+}
+
+// TestCheckAllDown tests that checkAllDown raises the all down alert once
+// every peer has been Down for the threshold and resolves it when a peer comes
+// back Up.
+func TestCheckAllDown(t *testing.T) {
+	a, err := newPeer("A", []string{"192.168.1.0/24"}, 1, 1, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("newPeer returned %v expected nil", err)
+	}
+	b, err := newPeer("B", []string{"192.168.2.0/24"}, 1, 1, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("newPeer returned %v expected nil", err)
+	}
+	s := Server{
+		log:              log.New(io.Discard, "", 0),
+		allDownThreshold: 2,
+	}
+	down := states.NewPeer(1, 1, 0)
+	maybeUp, _ := down.Heartbeat(true)
+	up, _ := maybeUp.Heartbeat(true)
+
+	testCases := []struct {
+		Name            string
+		StateA, StateB  states.PeerState
+		ExpectedAllDown bool
+	}{
+		{Name: "One Up", StateA: up, StateB: down, ExpectedAllDown: false},
+		{Name: "All Down below threshold", StateA: down, StateB: down, ExpectedAllDown: false},
+		{Name: "All Down at threshold", StateA: down, StateB: down, ExpectedAllDown: true},
+		{Name: "One Maybe Up", StateA: maybeUp, StateB: down, ExpectedAllDown: true},
+		{Name: "One back Up", StateA: up, StateB: down, ExpectedAllDown: false},
+		{Name: "All Down again", StateA: down, StateB: down, ExpectedAllDown: false},
+	}
+
+	for _, tc := range testCases {
+		a.state, b.state = tc.StateA, tc.StateB
+		s.checkAllDown([]*peer{a, b})
+		if s.allDown != tc.ExpectedAllDown {
+			t.Errorf("%s: expected all down %v, got %v",
+				tc.Name, tc.ExpectedAllDown, s.allDown)
+		}
+	}
+}
+
+// TestAcknowledgePeer tests that a peer can be acknowledged and that the
+// acknowledgement is cleared when the peer comes back Up.
+func TestAcknowledgePeer(t *testing.T) {
+	c := Config{
+		UpThreshold:  1,
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+
+	if err := s.AcknowledgePeer("Unknown", time.Hour, "maintenance"); err != ErrPeerNotFound {
+		t.Errorf("expected err %v for unknown peer, got %v", ErrPeerNotFound, err)
+	}
+	if err := s.AcknowledgePeer("LAN", 0, "maintenance"); err != ErrInvalidAckDuration {
+		t.Errorf("expected err %v for zero duration, got %v", ErrInvalidAckDuration, err)
+	}
+	if _, _, err := s.PeerAcknowledgement("Unknown"); err != ErrPeerNotFound {
+		t.Errorf("expected err %v for unknown peer, got %v", ErrPeerNotFound, err)
+	}
+
+	if err := s.AcknowledgePeer("LAN", time.Hour, "maintenance"); err != nil {
+		t.Fatalf("expected AcknowledgePeer to return nil err, got %v", err)
+	}
+	until, message, err := s.PeerAcknowledgement("LAN")
+	if err != nil {
+		t.Fatalf("expected PeerAcknowledgement to return nil err, got %v", err)
+	}
+	if until.IsZero() || message != "maintenance" {
+		t.Errorf("expected acknowledgement with message %q, got %v %q",
+			"maintenance", until, message)
+	}
+
+	// Bring the peer Up, clearing the acknowledgement.
+	p := s.peers[0]
+	for i := 0; i < 2; i++ {
+		p.lastSeen = time.Now()
+		s.checkPeer(context.Background(), p)
+	}
+	if until, message, _ := s.PeerAcknowledgement("LAN"); !until.IsZero() || message != "" {
+		t.Errorf("expected acknowledgement to be cleared when peer is Up, got %v %q",
+			until, message)
+	}
+}
+
+// TestUpdatePeerProtocol tests that updatePeer only updates peers monitored by
+// the protocol the peer was seen by.
+func TestUpdatePeerProtocol(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "TCP", Network: "192.168.1.1/32", Protocols: []string{"tcp:9999"}},
+			{Name: "ICMP", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	tcpPeer, icmpPeer := s.peers[0], s.peers[1]
+
+	s.updatePeer(net.ParseIP("192.168.1.1"), "icmp")
+	if !tcpPeer.lastSeen.IsZero() || icmpPeer.lastSeen.IsZero() {
+		t.Errorf("expected only ICMP peer to be updated by icmp")
+	}
+
+	icmpPeer.lastSeen = time.Time{}
+	s.updatePeer(net.ParseIP("192.168.1.1"), "tcp:9999")
+	if tcpPeer.lastSeen.IsZero() || !icmpPeer.lastSeen.IsZero() {
+		t.Errorf("expected only TCP peer to be updated by tcp:9999")
+	}
+	tcpPeer.aggregateObservations()
+	if tcpPeer.protocolLastSeen["tcp:9999"].IsZero() {
+		t.Errorf("expected TCP peer's tcp:9999 last seen to be updated")
+	}
+}
+
+// TestUpdatePeerNetworks tests that updatePeer updates a peer with multiple
+// networks when it is seen from any of them.
+func TestUpdatePeerNetworks(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "Dual-homed", Network: "192.168.1.0/24", Networks: []string{"10.0.0.0/8"}},
+			{Name: "Other", Networks: []string{"172.16.0.0/12"}},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	dualHomed, other := s.peers[0], s.peers[1]
+
+	for _, ip := range []string{"192.168.1.1", "10.1.2.3"} {
+		dualHomed.lastSeen = time.Time{}
+		s.updatePeer(net.ParseIP(ip), protocolICMP)
+		if dualHomed.lastSeen.IsZero() {
+			t.Errorf("expected dual-homed peer to be updated by %s", ip)
+		}
+	}
+	if !other.lastSeen.IsZero() {
+		t.Errorf("expected other peer not to be updated")
+	}
+}
+
+// TestManualHeartbeat tests that ManualHeartbeat updates the last seen time of
+// the named peer.
+func TestManualHeartbeat(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+
+	if err := s.ManualHeartbeat("Unknown"); err != ErrPeerNotFound {
+		t.Errorf("expected err %v for unknown peer, got %v", ErrPeerNotFound, err)
+	}
+	before := time.Now()
+	if err := s.ManualHeartbeat("LAN"); err != nil {
+		t.Fatalf("expected ManualHeartbeat to return nil err, got %v", err)
+	}
+	if lastSeen := s.peers[0].lastSeen; lastSeen.Before(before) {
+		t.Errorf("expected last seen to be updated after %v, got %v", before, lastSeen)
+	}
+}
+
+// TestResetStats tests that ResetPeerStats and ResetStats zero the flap counts,
+// uptime and downtime of peers.
+func TestResetStats(t *testing.T) {
+	c := Config{
+		MonitorCycle: "1s",
+		PeerTimeout:  "2s",
+		Peers: []PeerConfig{
+			{Name: "A", Network: "192.168.1.0/24"},
+			{Name: "B", Network: "192.168.2.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	a, b := s.peers[0], s.peers[1]
+	a.flapCount.Store(3)
+	b.flapCount.Store(5)
+	start := time.Now().Add(-time.Hour)
+	for _, p := range s.peers {
+		p.stateEnteredAt = start
+		p.totalUptime = time.Hour
+		p.totalDowntime = time.Minute
+	}
+	now := start.Add(2 * time.Hour)
+	s.now = func() time.Time { return now }
+
+	if err := s.ResetPeerStats("Unknown"); err != ErrPeerNotFound {
+		t.Errorf("expected err %v for unknown peer, got %v", ErrPeerNotFound, err)
+	}
+	if err := s.ResetPeerStats("A"); err != nil {
+		t.Fatalf("expected ResetPeerStats to return nil err, got %v", err)
+	}
+	if a.flapCount.Load() != 0 || b.flapCount.Load() != 5 {
+		t.Errorf("expected only peer A's flap count to be reset, got %d and %d",
+			a.flapCount.Load(), b.flapCount.Load())
+	}
+	if up, down := a.uptime(now); up != 0 || down != 0 {
+		t.Errorf("expected peer A's uptime and downtime to be reset, got %v and %v",
+			up, down)
+	}
+	if up, down := b.uptime(now); up != time.Hour || down != time.Minute+2*time.Hour {
+		t.Errorf("expected peer B's uptime and downtime to be kept, got %v and %v",
+			up, down)
+	}
+
+	s.ResetStats()
+	if b.flapCount.Load() != 0 {
+		t.Errorf("expected peer B's flap count to be reset, got %d", b.flapCount.Load())
+	}
+	if up, down := b.uptime(now); up != 0 || down != 0 {
+		t.Errorf("expected peer B's uptime and downtime to be reset, got %v and %v",
+			up, down)
+	}
+}
+
+// TestPeers tests that Server.Peers returns the current status of each peer.
+func TestPeers(t *testing.T) {
+	c := Config{
+		UpThreshold:   1,
+		DownThreshold: 3,
+		MonitorCycle:  "1s",
+		PeerTimeout:   "2s",
+		Peers: []PeerConfig{
+			{Name: "A", Network: "192.168.1.0/24"},
+			{Name: "B", Network: "192.168.2.0/24", UpThreshold: 2},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		return now
+	}
+	for _, p := range s.peers {
+		p.stateEnteredAt = now
+		p.stableStateEnteredAt = now
+	}
+	// Receive 3 packets from A, 2 of them in the last minute.
+	a := s.peers[0]
+	from := &net.IPAddr{IP: net.ParseIP("192.168.1.1")}
+	s.updatePeer(from, protocolICMP)
+	now = now.Add(90 * time.Second)
+	s.updatePeer(from, protocolICMP)
+	s.updatePeer(from, protocolICMP)
+	lastSeen := now
+	s.checkPeer(context.Background(), a)
+	s.checkPeer(context.Background(), a)
+
+	expected := []PeerStatus{
+		{
+			Name:               "A",
+			Network:            "192.168.1.0/24",
+			State:              "Up",
+			LastSeen:           lastSeen,
+			UpThreshold:        1,
+			DownThreshold:      3,
+			PacketsReceived:    3,
+			LastMinutePackets:  2,
+			PacketRate:         2.0 / 60,
+			DowntimeSinceStart: 90 * time.Second,
+			FlapCount:          1,
+		},
+		{
+			Name:               "B",
+			Network:            "192.168.2.0/24",
+			State:              "Down",
+			UpThreshold:        2,
+			DownThreshold:      3,
+			DowntimeSinceStart: 90 * time.Second,
+			StateDuration:      90 * time.Second,
+		},
+	}
+	if statuses := s.Peers(); !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected peers %#v, got %#v", expected, statuses)
+	}
+}
+
+// TestRandomDuration tests that randomDuration returns durations within the
+// requested range.
+func TestRandomDuration(t *testing.T) {
+	max := 10 * time.Millisecond
+	for i := 0; i < 100; i++ {
+		if d := randomDuration(max); d < 0 || d >= max {
+			t.Fatalf("expected random duration in [0, %v), got %v", max, d)
+		}
+	}
+}
+
+// TestCheckPeerStartupGracePeriod tests that checkPeer considers peers seen
+// only during the startup grace period.
+func TestCheckPeerStartupGracePeriod(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		StartedAt     time.Time
+		ExpectedState string
+	}{
+		{
+			Name:          "During grace period",
+			StartedAt:     time.Now(),
+			ExpectedState: "Maybe Up (1 of 2)",
+		},
+		{
+			Name:          "After grace period",
+			StartedAt:     time.Now().Add(-2 * time.Minute),
+			ExpectedState: "Down",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p, err := newPeer("TestPeer", []string{"192.168.1.0/24"}, 2, 2, 0, nil, nil)
+			if err != nil {
+				t.Fatalf("newPeer returned %v expected nil", err)
+			}
+			s := Server{
+				log:                log.New(io.Discard, "", 0),
+				peerTimeout:        time.Minute,
+				startupGracePeriod: time.Minute,
+				startedAt:          tc.StartedAt,
+			}
+			s.checkPeer(context.Background(), p)
+			if state := p.state.String(); state != tc.ExpectedState {
+				t.Errorf("expected state %q, got %q", tc.ExpectedState, state)
+			}
+		})
+	}
+}
+
+// TestCheckPeerStartupGracePeriodEvents tests that checkPeer doesn't dispatch
+// events during the startup grace period and does once it is over.
+func TestCheckPeerStartupGracePeriodEvents(t *testing.T) {
+	c := Config{
+		UpThreshold:        1,
+		DownThreshold:      1,
+		MonitorCycle:       "1s",
+		PeerTimeout:        "2s",
+		StartupGracePeriod: "1m",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		return now
+	}
+	s.startedAt = now
+	events := s.Subscribe()
+
+	// Down -> Maybe Up -> Up during the grace period isn't dispatched.
+	p := s.peers[0]
+	s.checkPeer(context.Background(), p)
+	s.checkPeer(context.Background(), p)
+	if state := p.state.String(); state != "Up" {
+		t.Fatalf("expected peer to be Up, got %q", state)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("expected event in grace period to be suppressed, got %#v", event)
+	default:
+	}
+
+	// Up -> Maybe Down -> Down after the grace period is.
+	now = now.Add(2 * time.Minute)
+	s.checkPeer(context.Background(), p)
+	s.checkPeer(context.Background(), p)
+	select {
+	case event := <-events:
+		if event.NewState != "Down" {
+			t.Errorf("expected Down event, got %#v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Down event after grace period, got none")
+	}
+}
+
+// TestAddRemovePeer tests that peers can be added to and removed from
+// a running Server.
+func TestAddRemovePeer(t *testing.T) {
+	allowShortDurations(t)
+	c := Config{
+		UpThreshold:   1,
+		DownThreshold: 1,
+		MonitorCycle:  "10ms",
+		PeerTimeout:   "1s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.checkPeersTicker(ctx)
+
+	if err := s.AddPeer(PeerConfig{Name: "WAN", Network: "10.0.0.0/8"}); err != nil {
+		t.Fatalf("expected AddPeer to return nil err, got %v", err)
+	}
+	// The added peer is monitored with the global thresholds.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+				s.updatePeer(&net.IPAddr{IP: net.ParseIP("10.0.0.1")}, protocolICMP)
+			}
+		}
+	}()
+	if err := s.WaitForPeer("WAN", "Up", time.Second); err != nil {
+		t.Fatalf("expected added peer to come Up, got %v", err)
+	}
+
+	testCases := []struct {
+		Name          string
+		Peer          PeerConfig
+		ExpectedError error
+	}{
+		{
+			Name:          "Duplicate name",
+			Peer:          PeerConfig{Name: "LAN", Network: "192.168.2.0/24"},
+			ExpectedError: ErrPeerAlreadyExists,
+		},
+		{
+			Name:          "Invalid peer",
+			Peer:          PeerConfig{Name: "Bad"},
+			ExpectedError: ErrNoPeerNetwork,
+		},
+		{
+			Name:          "Overlapping network",
+			Peer:          PeerConfig{Name: "Host", Network: "10.1.2.3/32"},
+			ExpectedError: ErrOverlappingPeerNetworks,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if err := s.AddPeer(tc.Peer); !errors.Is(err, tc.ExpectedError) {
+				t.Errorf("expected AddPeer to return %v, got %v", tc.ExpectedError, err)
+			}
+		})
+	}
+
+	if err := s.RemovePeer("WAN"); err != nil {
+		t.Fatalf("expected RemovePeer to return nil err, got %v", err)
+	}
+	if err := s.RemovePeer("WAN"); err != ErrPeerNotFound {
+		t.Errorf("expected RemovePeer of removed peer to return %v, got %v", ErrPeerNotFound, err)
+	}
+	if peers := s.Peers(); len(peers) != 1 || peers[0].Name != "LAN" {
+		t.Errorf("expected only peer LAN after RemovePeer, got %#v", peers)
+	}
+	// The removed peer's network can be added again.
+	if err := s.AddPeer(PeerConfig{Name: "Host", Network: "10.1.2.3/32"}); err != nil {
+		t.Errorf("expected AddPeer to return nil err, got %v", err)
+	}
+}
+
+// TestConcurrentPeerAccess tests that the peers list can be read by the
+// packet handling and monitoring paths while it is written by AddPeer,
+// RemovePeer and Reload. It is only useful when run with the race detector.
+func TestConcurrentPeerAccess(t *testing.T) {
+	allowShortDurations(t)
+	c := Config{
+		UpThreshold:   1,
+		DownThreshold: 1,
+		MonitorCycle:  "1ms",
+		PeerTimeout:   "1s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.checkPeersTicker(ctx)
+
+	const iterations = 100
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < iterations; i++ {
+			s.updatePeer(&net.IPAddr{IP: net.ParseIP("192.168.1.1")}, protocolICMP)
+			s.updatePeer(&net.IPAddr{IP: net.ParseIP("10.0.0.1")}, protocolICMP)
+			_ = s.Peers()
+		}
+	}()
+
+	for i := 0; i < iterations; i++ {
+		if err := s.AddPeer(PeerConfig{Name: "WAN", Network: "10.0.0.0/8"}); err != nil {
+			t.Fatalf("expected AddPeer to return nil err, got %v", err)
+		}
+		if err := s.RemovePeer("WAN"); err != nil {
+			t.Fatalf("expected RemovePeer to return nil err, got %v", err)
+		}
+		if i%10 == 0 {
+			if err := s.Reload(c); err != nil {
+				t.Fatalf("expected Reload to return nil err, got %v", err)
+			}
+		}
+	}
+	<-done
+}
+
+// BenchmarkPeerLookup compares finding the peer a heartbeat is from with the
+// peerTrie to checking every peer in turn, for growing numbers of peers. The
+// heartbeat is from the last configured peer, the worst case for checking
+// every peer.
+func BenchmarkPeerLookup(b *testing.B) {
+	for _, n := range []int{1, 10, 100, 1000} {
+		peers := make([]*peer, n)
+		for i := range peers {
+			network := fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)
+			p, err := newPeer(fmt.Sprintf("Peer %d", i), []string{network}, 1, 1, 0, nil, nil)
+			if err != nil {
+				b.Fatalf("newPeer returned %v expected nil", err)
+			}
+			peers[i] = p
+		}
+		ip := net.ParseIP(fmt.Sprintf("10.%d.%d.1", (n-1)/256, (n-1)%256))
+		trie := newPeerTrie(peers)
+
+		b.Run(fmt.Sprintf("linear/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var matched *peer
+				for _, p := range peers {
+					if p.monitoredBy(protocolICMP) && p.contains(ip) {
+						matched = p
+
+						break
+					}
+				}
+				if matched == nil {
+					b.Fatalf("expected a peer to match %s", ip)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("trie/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if trie.lookup(ip, protocolICMP) == nil {
+					b.Fatalf("expected a peer to match %s", ip)
+				}
+			}
+		})
+	}
+}
+
+const (
+	// maxCheckPeerAllocs is the most allocations BenchmarkCheckPeer allows
+	// checking a peer whose state doesn't change to make.
+	maxCheckPeerAllocs = 24
+	// maxUpdatePeerAllocs is the most allocations BenchmarkUpdatePeer allows
+	// updating a peer to make.
+	maxUpdatePeerAllocs = 5
+)
+
+// newBenchmarkServer returns a Server monitoring n peers that are Up and have
+// just been seen, each with its own /24 network in 10.0.0.0/8.
+func newBenchmarkServer(b *testing.B, n int) *Server {
+	b.Helper()
+	peers := make([]*peer, n)
+	for i := range peers {
+		network := fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)
+		p, err := newPeer(fmt.Sprintf("Peer %d", i), []string{network}, 1, 1, 0, nil, nil)
+		if err != nil {
+			b.Fatalf("newPeer returned %v expected nil", err)
+		}
+		p.state = states.NewPeerUp(1, 1, 0)
+		p.lastSeen = time.Now()
+		peers[i] = p
+	}
+
+	return &Server{
+		log:         log.New(io.Discard, "", 0),
+		peerTimeout: time.Hour,
+		peers:       peers,
+		peerTrie:    newPeerTrie(peers),
+	}
+}
+
+// BenchmarkCheckPeer measures the overhead of checking each of a growing
+// number of peers every monitor cycle. The peers stay Up so no events are
+// dispatched. It fails if checking a peer makes more than maxCheckPeerAllocs
+// allocations, averaged over 1000 calls.
+func BenchmarkCheckPeer(b *testing.B) {
+	ctx := context.Background()
+	for _, n := range []int{1, 10, 100, 1000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			s := newBenchmarkServer(b, n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.checkPeer(ctx, s.peers[i%n])
+			}
+			b.StopTimer()
+
+			allocs := testing.AllocsPerRun(1000, func() {
+				s.checkPeer(ctx, s.peers[0])
+			})
+			if allocs > maxCheckPeerAllocs {
+				b.Errorf("expected checkPeer to make at most %d allocations, got %.1f",
+					maxCheckPeerAllocs, allocs)
+			}
+		})
+	}
+}
+
+// BenchmarkUpdatePeer measures the overhead of updating the peer a heartbeat
+// is from for a growing number of peers. Heartbeats are from each peer in
+// turn. It fails if updating a peer makes more than maxUpdatePeerAllocs
+// allocations, averaged over 1000 calls.
+func BenchmarkUpdatePeer(b *testing.B) {
+	for _, n := range []int{1, 10, 100, 1000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			s := newBenchmarkServer(b, n)
+			addrs := make([]*net.IPAddr, n)
+			for i := range addrs {
+				addrs[i] = &net.IPAddr{IP: net.IPv4(10, byte(i/256), byte(i%256), 1)}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if s.updatePeer(addrs[i%n], protocolICMP) == nil {
+					b.Fatalf("expected a peer to match %s", addrs[i%n])
+				}
+			}
+			b.StopTimer()
+
+			allocs := testing.AllocsPerRun(1000, func() {
+				s.updatePeer(addrs[0], protocolICMP)
+			})
+			if allocs > maxUpdatePeerAllocs {
+				b.Errorf("expected updatePeer to make at most %d allocations, got %.1f",
+					maxUpdatePeerAllocs, allocs)
+			}
+		})
+	}
+}
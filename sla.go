@@ -0,0 +1,87 @@
+package woodwatch
+
+import "time"
+
+// addStateDuration adds the given duration the peer spent in the given state
+// to its totalUptime if the state was Up or its totalDowntime if it was Down.
+// Time spent in any other state, e.g. "Maybe Down (1 of 2)" or "Flapping", is
+// counted as neither. The caller must hold the lastSeenMu.
+func (p *peer) addStateDuration(state string, d time.Duration) {
+	switch state {
+	case "Up":
+		p.totalUptime += d
+	case "Down":
+		p.totalDowntime += d
+	}
+}
+
+// uptime returns how long the peer has been Up and how long it has been Down
+// as of the given time, including the time since it entered its current
+// state. The caller must hold the lastSeenMu.
+func (p *peer) uptime(now time.Time) (time.Duration, time.Duration) {
+	up, down := p.totalUptime, p.totalDowntime
+	switch elapsed := now.Sub(p.stateEnteredAt); p.state.String() {
+	case "Up":
+		up += elapsed
+	case "Down":
+		down += elapsed
+	}
+
+	return up, down
+}
+
+// PeerSLA returns the percentage of the given trailing window the peer with
+// the given name was Up, e.g. 99.5, computed from the peer's state history.
+// Parts of the window before the Server started aren't counted. If the
+// window reaches back before the oldest state change in the peer's history
+// the peer is assumed to have been in the state it changed from. If no peer
+// with the given name is configured ErrPeerNotFound is returned, if the
+// window isn't positive ErrInvalidSLAWindow is returned and if the peer's lock
+// can't be acquired ErrPeerLockTimeout is returned.
+func (s *Server) PeerSLA(name string, window time.Duration) (float64, error) {
+	if window <= 0 {
+		return 0, ErrInvalidSLAWindow
+	}
+	p := s.findPeer(name)
+	if p == nil {
+		return 0, ErrPeerNotFound
+	}
+
+	now := s.currentTime()
+	start := now.Add(-window)
+	if start.Before(s.startedAt) {
+		start = s.startedAt
+	}
+	if !now.After(start) {
+		return 0, nil
+	}
+
+	if !s.rLockPeer(p) {
+		return 0, peerLockTimeoutError(p)
+	}
+	history := p.history.list()
+	state := p.state.String()
+	p.lastSeenMu.RUnlock()
+	if len(history) > 0 {
+		state = history[0].OldState
+	}
+
+	// Walk the state changes adding the part of each period the peer was Up
+	// that falls within the window.
+	var up time.Duration
+	from := start
+	for _, entry := range history {
+		if state == "Up" && entry.Timestamp.After(from) {
+			up += entry.Timestamp.Sub(from)
+		}
+		if entry.Timestamp.After(from) {
+			from = entry.Timestamp
+		}
+		state = entry.NewState
+	}
+	if state == "Up" {
+		up += now.Sub(from)
+	}
+
+	return 100 * float64(up) / float64(now.Sub(start)), nil
+}
@@ -0,0 +1,118 @@
+package woodwatch
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// TestPeerSLA tests that a peer's uptime and downtime are tracked across state
+// changes and that PeerSLA computes its uptime percentage over a window.
+func TestPeerSLA(t *testing.T) {
+	c := Config{
+		UpThreshold:   1,
+		DownThreshold: 1,
+		MonitorCycle:  "1s",
+		PeerTimeout:   "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	// Use a fake clock that only advances when told to.
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	s.now = func() time.Time {
+		return now
+	}
+	s.startedAt = start
+	p := s.peers[0]
+	p.stateEnteredAt = start
+
+	// Up for an hour, Down for half an hour and then Up for half an hour.
+	for _, step := range []struct {
+		at   time.Duration
+		seen bool
+	}{
+		{at: 0, seen: true},
+		{at: time.Hour, seen: false},
+		{at: 90 * time.Minute, seen: true},
+	} {
+		now = start.Add(step.at)
+		if step.seen {
+			p.lastSeen = now
+		}
+		s.checkPeer(context.Background(), p)
+		s.checkPeer(context.Background(), p)
+	}
+	now = start.Add(2 * time.Hour)
+
+	status := s.Peers()[0]
+	if status.UptimeSinceStart != 90*time.Minute {
+		t.Errorf("expected UptimeSinceStart %v, got %v", 90*time.Minute, status.UptimeSinceStart)
+	}
+	if status.DowntimeSinceStart != 30*time.Minute {
+		t.Errorf("expected DowntimeSinceStart %v, got %v", 30*time.Minute, status.DowntimeSinceStart)
+	}
+
+	testCases := []struct {
+		Name        string
+		Peer        string
+		Window      time.Duration
+		ExpectedPct float64
+		ExpectedErr error
+	}{
+		{
+			Name:        "Since start",
+			Peer:        "LAN",
+			Window:      2 * time.Hour,
+			ExpectedPct: 75,
+		},
+		{
+			Name:        "Within history",
+			Peer:        "LAN",
+			Window:      time.Hour,
+			ExpectedPct: 50,
+		},
+		{
+			Name:        "Only up",
+			Peer:        "LAN",
+			Window:      15 * time.Minute,
+			ExpectedPct: 100,
+		},
+		{
+			Name:        "Before start",
+			Peer:        "LAN",
+			Window:      7 * 24 * time.Hour,
+			ExpectedPct: 75,
+		},
+		{
+			Name:        "Unknown peer",
+			Peer:        "WAN",
+			Window:      time.Hour,
+			ExpectedErr: ErrPeerNotFound,
+		},
+		{
+			Name:        "Invalid window",
+			Peer:        "LAN",
+			ExpectedErr: ErrInvalidSLAWindow,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pct, err := s.PeerSLA(tc.Peer, tc.Window)
+			if err != tc.ExpectedErr {
+				t.Fatalf("expected PeerSLA to return err %v, got %v", tc.ExpectedErr, err)
+			}
+			if pct != tc.ExpectedPct {
+				t.Errorf("expected PeerSLA to return %v, got %v", tc.ExpectedPct, pct)
+			}
+		})
+	}
+}
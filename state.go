@@ -0,0 +1,131 @@
+package woodwatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cpu/woodwatch/internal/states"
+)
+
+// ErrInvalidState is returned (wrapped with the cause) from Server.LoadState
+// when the saved peer states can't be read.
+var ErrInvalidState = errors.New("Saved peer state is not valid")
+
+// savedPeer is the JSON representation of a peer's state written by
+// Server.SaveState and read by Server.LoadState.
+type savedPeer struct {
+	// Name is the peer's name.
+	Name string `json:"name"`
+	// Network is the peer's CIDR network, or its comma separated CIDR networks
+	// if it has more than one.
+	Network string `json:"network"`
+	// State is the JSON encoding of the peer's PeerState.
+	State json.RawMessage `json:"state"`
+	// LastSeen is when the peer was last seen.
+	LastSeen time.Time `json:"lastSeen"`
+	// StateEnteredAt is when the peer entered its state.
+	StateEnteredAt time.Time `json:"stateEnteredAt"`
+	// StableStateEnteredAt is when the peer last entered an Up, Down or
+	// Flapping state. It is zero in states saved before it was added.
+	StableStateEnteredAt time.Time `json:"stableStateEnteredAt"`
+}
+
+// SaveState writes the state, last seen time and state entry time of each of
+// the Server's peers to the given io.Writer as a JSON array so that they can be
+// restored by LoadState after a restart. If a peer's lock can't be acquired
+// ErrPeerLockTimeout is returned and nothing is written.
+func (s *Server) SaveState(w io.Writer) error {
+	s.peersMu.RLock()
+	peers := s.peers
+	s.peersMu.RUnlock()
+
+	saved := make([]savedPeer, 0, len(peers))
+	for _, p := range peers {
+		if !s.rLockPeer(p) {
+			return peerLockTimeoutError(p)
+		}
+		state, err := json.Marshal(p.state)
+		sp := savedPeer{
+			Name:                 p.Name,
+			Network:              p.networks(),
+			State:                state,
+			LastSeen:             p.lastSeen,
+			StateEnteredAt:       p.stateEnteredAt,
+			StableStateEnteredAt: p.stableStateEnteredAt,
+		}
+		p.lastSeenMu.RUnlock()
+		if err != nil {
+			return fmt.Errorf("saving state of peer %q: %w", p.Name, err)
+		}
+		saved = append(saved, sp)
+	}
+
+	return json.NewEncoder(w).Encode(saved)
+}
+
+// LoadState reads peer states written by SaveState from the given io.Reader and
+// restores them to the Server's peers with the same name and network. The
+// restored states use the peers' current thresholds. Saved states of peers that
+// aren't configured are logged and ignored. If any saved state can't be read
+// ErrInvalidState wrapped with the cause is returned and no peer is changed. If
+// a peer's lock can't be acquired ErrPeerLockTimeout is returned and the peers
+// after it aren't restored.
+func (s *Server) LoadState(r io.Reader) error {
+	var saved []savedPeer
+	if err := json.NewDecoder(r).Decode(&saved); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidState, err)
+	}
+
+	s.peersMu.RLock()
+	peers := s.peers
+	s.peersMu.RUnlock()
+
+	// Build all of the restored states before changing any peer.
+	type restoredPeer struct {
+		peer  *peer
+		state states.PeerState
+		saved savedPeer
+	}
+	var restored []restoredPeer
+	for _, sp := range saved {
+		var match *peer
+		for _, p := range peers {
+			if p.Name == sp.Name && p.networks() == sp.Network {
+				match = p
+
+				break
+			}
+		}
+		if match == nil {
+			s.warnf("ignoring saved state of unknown Peer %s - Network %s\n",
+				sp.Name, sp.Network)
+
+			continue
+		}
+		state, err := states.NewPeerFromJSON(
+			match.upThreshold, match.downThreshold, match.flappingThreshold, sp.State)
+		if err != nil {
+			return fmt.Errorf("%w: peer %q: %w", ErrInvalidState, sp.Name, err)
+		}
+		restored = append(restored, restoredPeer{peer: match, state: state, saved: sp})
+	}
+
+	for _, r := range restored {
+		if !s.lockPeer(r.peer) {
+			return peerLockTimeoutError(r.peer)
+		}
+		r.peer.state = r.state
+		r.peer.lastSeen = r.saved.LastSeen
+		r.peer.stateEnteredAt = r.saved.StateEnteredAt
+		r.peer.stableStateEnteredAt = r.saved.StableStateEnteredAt
+		if r.peer.stableStateEnteredAt.IsZero() {
+			r.peer.stableStateEnteredAt = r.saved.StateEnteredAt
+		}
+		r.peer.lastSeenMu.Unlock()
+	}
+
+	return nil
+}
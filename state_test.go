@@ -0,0 +1,132 @@
+package woodwatch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSaveLoadState tests that peer states saved by SaveState are restored by
+// LoadState on a new Server with the same peers.
+func TestSaveLoadState(t *testing.T) {
+	c := Config{
+		UpThreshold:   2,
+		DownThreshold: 2,
+		MonitorCycle:  "1s",
+		PeerTimeout:   "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+			{Name: "WAN", Network: "10.0.0.0/8"},
+		},
+	}
+	newServer := func() *Server {
+		s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+		if err != nil {
+			t.Fatalf("expected NewServer to return nil err, got %v", err)
+		}
+
+		return s
+	}
+
+	// Bring the LAN peer Up.
+	s := newServer()
+	lan := s.peers[0]
+	lan.lastSeen = time.Now()
+	for i := 0; i < 3; i++ {
+		s.checkPeer(context.Background(), lan)
+	}
+	if state := lan.state.String(); state != "Up" {
+		t.Fatalf("expected LAN peer to be Up, got %q", state)
+	}
+
+	var buf bytes.Buffer
+	if err := s.SaveState(&buf); err != nil {
+		t.Fatalf("expected SaveState to return nil err, got %v", err)
+	}
+
+	restored := newServer()
+	if err := restored.LoadState(&buf); err != nil {
+		t.Fatalf("expected LoadState to return nil err, got %v", err)
+	}
+	for i, p := range restored.peers {
+		orig := s.peers[i]
+		if p.state.String() != orig.state.String() {
+			t.Errorf("expected peer %q state %q, got %q",
+				p.Name, orig.state.String(), p.state.String())
+		}
+		if !p.lastSeen.Equal(orig.lastSeen) {
+			t.Errorf("expected peer %q last seen %v, got %v",
+				p.Name, orig.lastSeen, p.lastSeen)
+		}
+		if !p.stateEnteredAt.Equal(orig.stateEnteredAt) {
+			t.Errorf("expected peer %q state entered at %v, got %v",
+				p.Name, orig.stateEnteredAt, p.stateEnteredAt)
+		}
+		if !p.stableStateEnteredAt.Equal(orig.stableStateEnteredAt) {
+			t.Errorf("expected peer %q stable state entered at %v, got %v",
+				p.Name, orig.stableStateEnteredAt, p.stableStateEnteredAt)
+		}
+	}
+}
+
+// TestLoadStateErrors tests that LoadState returns an error for invalid saved
+// state without changing any peer, and ignores saved state for unknown peers.
+func TestLoadStateErrors(t *testing.T) {
+	c := Config{
+		UpThreshold:   1,
+		DownThreshold: 1,
+		MonitorCycle:  "1s",
+		PeerTimeout:   "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+
+	testCases := []struct {
+		Name        string
+		Input       string
+		ExpectError bool
+	}{
+		{
+			Name:        "Invalid JSON",
+			Input:       `{`,
+			ExpectError: true,
+		},
+		{
+			Name:        "Unknown state",
+			Input:       `[{"name":"LAN","network":"192.168.1.0/24","state":{"state":"Sideways"}}]`,
+			ExpectError: true,
+		},
+		{
+			Name:  "Unknown peer",
+			Input: `[{"name":"WAN","network":"10.0.0.0/8","state":{"state":"Up"}}]`,
+		},
+		{
+			Name:  "Peer with different network",
+			Input: `[{"name":"LAN","network":"192.168.2.0/24","state":{"state":"Up"}}]`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := s.LoadState(strings.NewReader(tc.Input))
+			if tc.ExpectError && !errors.Is(err, ErrInvalidState) {
+				t.Errorf("expected LoadState to return %v, got %v", ErrInvalidState, err)
+			} else if !tc.ExpectError && err != nil {
+				t.Errorf("expected LoadState to return nil err, got %v", err)
+			}
+			if state := s.peers[0].state.String(); state != "Down" {
+				t.Errorf("expected LAN peer to remain Down, got %q", state)
+			}
+		})
+	}
+}
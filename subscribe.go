@@ -0,0 +1,65 @@
+package woodwatch
+
+import (
+	"github.com/cpu/woodwatch/internal/webhook"
+)
+
+var (
+	// subscriberBufferSize is the buffer size of the channels returned by
+	// Server.Subscribe.
+	subscriberBufferSize = 64
+)
+
+// Subscribe returns a buffered channel that receives every event the Server
+// dispatches to webhooks: noteworthy events, or all state change events if the
+// Server is verbose. Events are sent without blocking and are dropped for
+// a subscriber whose channel buffer is full, so subscribers should receive
+// promptly. The channel is closed by Unsubscribe or when the Server stops
+// listening.
+func (s *Server) Subscribe() <-chan webhook.Event {
+	ch := make(chan webhook.Event, subscriberBufferSize)
+
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[<-chan webhook.Event]chan webhook.Event)
+	}
+	s.subscribers[ch] = ch
+
+	return ch
+}
+
+// Unsubscribe stops sending events to the given channel returned by Subscribe
+// and closes it. Unsubscribing a channel that isn't subscribed does nothing.
+func (s *Server) Unsubscribe(ch <-chan webhook.Event) {
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+	if sub, ok := s.subscribers[ch]; ok {
+		delete(s.subscribers, ch)
+		close(sub)
+	}
+}
+
+// notifySubscribers sends the given event to each of the Server's subscribers
+// without blocking, skipping subscribers whose channel buffer is full.
+func (s *Server) notifySubscribers(event webhook.Event) {
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+	for _, sub := range s.subscribers {
+		select {
+		case sub <- event:
+		default:
+		}
+	}
+}
+
+// closeSubscribers unsubscribes and closes all of the Server's subscriber
+// channels.
+func (s *Server) closeSubscribers() {
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+	for ch, sub := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(sub)
+	}
+}
@@ -0,0 +1,99 @@
+package woodwatch
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/cpu/woodwatch/internal/webhook"
+)
+
+// TestSubscribe tests that subscribers receive the Server's noteworthy events
+// and stop receiving them once unsubscribed.
+func TestSubscribe(t *testing.T) {
+	c := Config{
+		UpThreshold:   1,
+		DownThreshold: 1,
+		MonitorCycle:  "1s",
+		PeerTimeout:   "2s",
+		Peers: []PeerConfig{
+			{Name: "LAN", Network: "192.168.1.0/24"},
+		},
+	}
+	s, err := NewServerFromConfig(log.New(io.Discard, "", 0), false, "whatever", c)
+	if err != nil {
+		t.Fatalf("expected NewServer to return nil err, got %v", err)
+	}
+	// Use a fake clock that only advances when told to.
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		return now
+	}
+	p := s.peers[0]
+	a, b := s.Subscribe(), s.Subscribe()
+
+	expectEvent := func(ch <-chan webhook.Event, expectedState string) {
+		t.Helper()
+		select {
+		case event := <-ch:
+			if event.NewState != expectedState {
+				t.Errorf("expected event with state %q, got %q", expectedState, event.NewState)
+			}
+			if !event.Timestamp.Equal(now) {
+				t.Errorf("expected event at %v, got %v", now, event.Timestamp)
+			}
+		default:
+			t.Fatalf("expected event with state %q, got none", expectedState)
+		}
+	}
+
+	// Down -> Maybe Up -> Up is one noteworthy event.
+	p.lastSeen = now
+	s.checkPeer(context.Background(), p)
+	s.checkPeer(context.Background(), p)
+	expectEvent(a, "Up")
+	expectEvent(b, "Up")
+
+	// Up -> Maybe Down -> Down once the peer timeout has passed is another, but
+	// only for the subscriber that is still subscribed.
+	s.Unsubscribe(b)
+	if _, ok := <-b; ok {
+		t.Errorf("expected unsubscribed channel to be closed")
+	}
+	now = now.Add(time.Minute)
+	s.checkPeer(context.Background(), p)
+	s.checkPeer(context.Background(), p)
+	expectEvent(a, "Down")
+	select {
+	case event := <-a:
+		t.Errorf("expected no more events, got %#v", event)
+	default:
+	}
+
+	// Unsubscribing twice does nothing.
+	s.Unsubscribe(b)
+}
+
+// TestSubscribeFullBuffer tests that events for a subscriber with a full
+// channel buffer are dropped rather than blocking.
+func TestSubscribeFullBuffer(t *testing.T) {
+	s := &Server{}
+	ch := s.Subscribe()
+	for i := 0; i < subscriberBufferSize+1; i++ {
+		s.notifySubscribers(webhook.Event{Title: "event"})
+	}
+	if len(ch) != subscriberBufferSize {
+		t.Errorf("expected %d buffered events, got %d", subscriberBufferSize, len(ch))
+	}
+
+	// Closing the subscribers closes the channel once it is drained.
+	s.closeSubscribers()
+	for range subscriberBufferSize {
+		<-ch
+	}
+	if _, ok := <-ch; ok {
+		t.Errorf("expected channel to be closed")
+	}
+}
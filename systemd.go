@@ -0,0 +1,28 @@
+package woodwatch
+
+import "github.com/coreos/go-systemd/v22/daemon"
+
+// WithSystemdNotify configures whether the Server notifies systemd of its
+// status with sd_notify, for running as a Type=notify service. When enabled
+// the Server sends READY=1 once it is listening, WATCHDOG=1 each monitor cycle
+// for services with a WatchdogSec and STOPPING=1 when it starts shutting down.
+// Notifications are sent to the socket named by the NOTIFY_SOCKET environment
+// variable and nothing is sent if it isn't set.
+func WithSystemdNotify(enabled bool) ServerOption {
+	return func(s *Server) error {
+		s.systemdNotify = enabled
+
+		return nil
+	}
+}
+
+// sdNotify sends the given state, e.g. daemon.SdNotifyReady, to systemd if the
+// Server is configured to notify systemd. Errors are logged.
+func (s *Server) sdNotify(state string) {
+	if !s.systemdNotify {
+		return
+	}
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		s.errorf("error notifying systemd of %q: %v\n", state, err)
+	}
+}
@@ -0,0 +1,116 @@
+package woodwatch
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"golang.org/x/net/icmp"
+)
+
+// listenNotifySocket listens on a unix datagram socket and sets NOTIFY_SOCKET
+// to its path for the duration of the test.
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	// Unix socket paths are limited to ~100 bytes so don't use t.TempDir.
+	dir, err := os.MkdirTemp("", "woodwatch")
+	if err != nil {
+		t.Fatalf("expected MkdirTemp to return nil err, got %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("expected ListenUnixgram to return nil err, got %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	return conn
+}
+
+// readNotification returns the next notification sent to the given socket, or
+// the empty string if none is sent within the given timeout.
+func readNotification(t *testing.T, conn *net.UnixConn, timeout time.Duration) string {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return ""
+	}
+
+	return string(buf[:n])
+}
+
+// TestSdNotify tests that sdNotify only notifies systemd when the Server is
+// configured with WithSystemdNotify.
+func TestSdNotify(t *testing.T) {
+	conn := listenNotifySocket(t)
+
+	s := &Server{log: log.New(io.Discard, "", 0)}
+	s.sdNotify(daemon.SdNotifyReady)
+	if state := readNotification(t, conn, 50*time.Millisecond); state != "" {
+		t.Errorf("expected no notification without WithSystemdNotify, got %q", state)
+	}
+
+	if err := WithSystemdNotify(true)(s); err != nil {
+		t.Fatalf("expected WithSystemdNotify to return nil err, got %v", err)
+	}
+	s.sdNotify(daemon.SdNotifyReady)
+	if state := readNotification(t, conn, time.Second); state != daemon.SdNotifyReady {
+		t.Errorf("expected notification %q, got %q", daemon.SdNotifyReady, state)
+	}
+}
+
+// TestListenSystemdNotify tests that a listening Server notifies systemd that
+// it is ready, pings the watchdog each monitor cycle and notifies systemd that
+// it is stopping when closed.
+func TestListenSystemdNotify(t *testing.T) {
+	conn := listenNotifySocket(t)
+
+	// Use an unprivileged ICMP socket so the test doesn't need to run as root.
+	s := &Server{
+		log:           log.New(io.Discard, "", 0),
+		listenAddress: "127.0.0.1",
+		listenNetwork: "udp4",
+		monitorCycle:  10 * time.Millisecond,
+		systemdNotify: true,
+	}
+	icmpConn, err := icmp.ListenPacket(s.listenNetwork, s.listenAddress)
+	if err != nil {
+		t.Skipf("unprivileged ICMP sockets aren't available: %v", err)
+	}
+	_ = icmpConn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.Listen(ctx)
+	}()
+
+	for _, expected := range []string{daemon.SdNotifyReady, daemon.SdNotifyWatchdog} {
+		if state := readNotification(t, conn, 5*time.Second); state != expected {
+			t.Fatalf("expected notification %q, got %q", expected, state)
+		}
+	}
+
+	cancel()
+	<-errChan
+	// Skip any watchdog pings sent before the Server stopped.
+	for {
+		state := readNotification(t, conn, time.Second)
+		if state == daemon.SdNotifyStopping {
+			break
+		}
+		if state != daemon.SdNotifyWatchdog {
+			t.Fatalf("expected notification %q, got %q", daemon.SdNotifyStopping, state)
+		}
+	}
+}
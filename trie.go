@@ -0,0 +1,109 @@
+package woodwatch
+
+import "net"
+
+// peerTrie is a binary trie of the networks of a Server's peers keyed by the
+// bits of each network's prefix. Finding the peer whose network contains an IP
+// takes at most 32 steps for IPv4 and 128 for IPv6 no matter how many peers
+// there are. A peerTrie is never modified after it is built so it can be read
+// by multiple goroutines.
+type peerTrie struct {
+	// v4 is the root of the trie of IPv4 networks.
+	v4 *peerTrieNode
+	// v6 is the root of the trie of IPv6 networks.
+	v6 *peerTrieNode
+}
+
+// peerTrieNode is a node of a peerTrie. Its depth is the prefix length of the
+// networks it holds.
+type peerTrieNode struct {
+	// children are the nodes for the next bit of the prefix being 0 or 1.
+	children [2]*peerTrieNode
+	// entries are the peers with a network ending at this node.
+	entries []peerTrieEntry
+}
+
+// peerTrieEntry is a peer held by a peerTrieNode.
+type peerTrieEntry struct {
+	// index is the position of the peer in the peers the peerTrie was built
+	// from. When more than one peer matches the one configured first is used.
+	index int
+	// peer is the peer.
+	peer *peer
+}
+
+// newPeerTrie returns a peerTrie of the Networks of the given peers.
+func newPeerTrie(peers []*peer) *peerTrie {
+	t := &peerTrie{
+		v4: &peerTrieNode{},
+		v6: &peerTrieNode{},
+	}
+	for i, p := range peers {
+		for _, network := range p.Networks {
+			t.insert(network, peerTrieEntry{index: i, peer: p})
+		}
+	}
+
+	return t
+}
+
+// insert adds the given entry to the peerTrie for the given network.
+func (t *peerTrie) insert(network *net.IPNet, entry peerTrieEntry) {
+	ones, bits := network.Mask.Size()
+	node, ip := t.v6, network.IP.To16()
+	if ip4 := network.IP.To4(); ip4 != nil {
+		// IPv4 networks, including IPv4-mapped IPv6 networks, only contain IPv4
+		// addresses as with net.IPNet.Contains.
+		if bits == 8*net.IPv6len {
+			if ones < 96 {
+				return
+			}
+			ones -= 96
+		}
+		node, ip = t.v4, ip4
+	}
+
+	for i := 0; i < ones; i++ {
+		b := bit(ip, i)
+		if node.children[b] == nil {
+			node.children[b] = &peerTrieNode{}
+		}
+		node = node.children[b]
+	}
+	node.entries = append(node.entries, entry)
+}
+
+// lookup returns the first configured peer monitored by the given protocol with
+// a network that contains the given IP, or nil if there isn't one.
+func (t *peerTrie) lookup(ip net.IP, protocol string) *peer {
+	node := t.v6
+	if ip4 := ip.To4(); ip4 != nil {
+		node, ip = t.v4, ip4
+	} else if ip = ip.To16(); ip == nil {
+		return nil
+	}
+
+	var match *peerTrieEntry
+	for depth := 0; node != nil; depth++ {
+		for i, entry := range node.entries {
+			if entry.peer.monitoredBy(protocol) && (match == nil || entry.index < match.index) {
+				match = &node.entries[i]
+			}
+		}
+		if depth == 8*len(ip) {
+			break
+		}
+		node = node.children[bit(ip, depth)]
+	}
+	if match == nil {
+		return nil
+	}
+
+	return match.peer
+}
+
+// bit returns the bit of the given IP at the given position, counting from the
+// most significant bit of the first byte.
+func bit(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-i%8)) & 1
+}
@@ -0,0 +1,121 @@
+package woodwatch
+
+import (
+	"net"
+	"testing"
+)
+
+// TestPeerTrieLookup tests that peerTrie.lookup finds the first configured
+// peer monitored by the protocol with a network containing the IP.
+func TestPeerTrieLookup(t *testing.T) {
+	newTestPeer := func(name string, protocols []string, networks ...string) *peer {
+		p, err := newPeer(name, networks, 1, 1, 0, nil, nil)
+		if err != nil {
+			t.Fatalf("newPeer returned %v expected nil", err)
+		}
+		if protocols != nil {
+			p.protocols = protocols
+		}
+
+		return p
+	}
+	peers := []*peer{
+		newTestPeer("LAN", nil, "192.168.1.0/24"),
+		newTestPeer("Dual-homed", nil, "10.0.0.0/8", "2001:db8::/32"),
+		newTestPeer("TCP", []string{"tcp:9999"}, "10.1.0.0/16"),
+		newTestPeer("Host", nil, "172.16.0.1/32"),
+		newTestPeer("Mapped", nil, "::ffff:198.51.100.0/120"),
+		newTestPeer("Everything TCP", []string{"tcp:9999"}, "0.0.0.0/0"),
+	}
+	trie := newPeerTrie(peers)
+
+	testCases := []struct {
+		Name     string
+		IP       string
+		Protocol string
+		Expected string
+	}{
+		{
+			Name:     "IPv4 network",
+			IP:       "192.168.1.42",
+			Protocol: protocolICMP,
+			Expected: "LAN",
+		},
+		{
+			Name:     "Second network",
+			IP:       "2001:db8::1",
+			Protocol: protocolICMP,
+			Expected: "Dual-homed",
+		},
+		{
+			Name:     "Less specific network by protocol",
+			IP:       "10.1.2.3",
+			Protocol: protocolICMP,
+			Expected: "Dual-homed",
+		},
+		{
+			Name:     "First configured network by protocol",
+			IP:       "10.1.2.3",
+			Protocol: "tcp:9999",
+			Expected: "TCP",
+		},
+		{
+			Name:     "Default route",
+			IP:       "203.0.113.1",
+			Protocol: "tcp:9999",
+			Expected: "Everything TCP",
+		},
+		{
+			Name:     "Single host",
+			IP:       "172.16.0.1",
+			Protocol: protocolICMP,
+			Expected: "Host",
+		},
+		{
+			Name:     "Outside single host",
+			IP:       "172.16.0.2",
+			Protocol: protocolICMP,
+		},
+		{
+			Name:     "IPv4-mapped network",
+			IP:       "198.51.100.7",
+			Protocol: protocolICMP,
+			Expected: "Mapped",
+		},
+		{
+			Name:     "IPv4-mapped address",
+			IP:       "::ffff:192.168.1.42",
+			Protocol: protocolICMP,
+			Expected: "LAN",
+		},
+		{
+			Name:     "Unknown IPv6 address",
+			IP:       "2001:db9::1",
+			Protocol: protocolICMP,
+		},
+		{
+			Name:     "Less specific network by other protocol",
+			IP:       "192.168.1.42",
+			Protocol: "tcp:9999",
+			Expected: "Everything TCP",
+		},
+		{
+			Name:     "Invalid IP",
+			IP:       "not an IP",
+			Protocol: protocolICMP,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var name string
+			if p := trie.lookup(net.ParseIP(tc.IP), tc.Protocol); p != nil {
+				name = p.Name
+			}
+			if name != tc.Expected {
+				t.Errorf("expected lookup(%q, %q) to return peer %q, got %q",
+					tc.IP, tc.Protocol, tc.Expected, name)
+			}
+		})
+	}
+}
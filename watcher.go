@@ -0,0 +1,141 @@
+package woodwatch
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	// ErrInvalidWatchInterval is returned from NewConfigWatcher when the poll
+	// interval is not positive.
+	ErrInvalidWatchInterval = errors.New("ConfigWatcher interval must be positive")
+)
+
+// ConfigWatcher polls a woodwatch config file for changes. Each time the file
+// is modified and its contents are a valid Config the new Config is sent on
+// the channel returned by Changes(). Configs that fail to load or are not
+// valid are logged and skipped.
+type ConfigWatcher struct {
+	// log is the ConfigWatcher's log.Logger instance.
+	log *log.Logger
+	// path is the path of the config file being watched.
+	path string
+	// interval is the duration between checking the config file for changes.
+	interval time.Duration
+	// modTime is the modification time of the config file when it was last
+	// checked.
+	modTime time.Time
+	// size is the size of the config file when it was last checked.
+	size int64
+	// changes is the channel valid Configs are sent on.
+	changes chan Config
+	// closeChan is used to signal a close to the polling goroutine.
+	closeChan chan bool
+	// done is closed when the polling goroutine has exited.
+	done chan struct{}
+}
+
+// NewConfigWatcher constructs a ConfigWatcher that checks the config file at the
+// given path for changes once per interval. The config file must exist when
+// the ConfigWatcher is constructed. Only changes made after construction are
+// sent on the Changes() channel.
+func NewConfigWatcher(
+	log *log.Logger,
+	path string,
+	interval time.Duration) (*ConfigWatcher, error) {
+	if interval <= 0 {
+		return nil, ErrInvalidWatchInterval
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &ConfigWatcher{
+		log:       log,
+		path:      path,
+		interval:  interval,
+		modTime:   info.ModTime(),
+		size:      info.Size(),
+		changes:   make(chan Config),
+		closeChan: make(chan bool, 1),
+		done:      make(chan struct{}),
+	}
+	go w.poll()
+
+	return w, nil
+}
+
+// Changes returns a channel that receives a Config each time the watched config
+// file changes. The channel is closed when the ConfigWatcher is closed.
+func (w *ConfigWatcher) Changes() <-chan Config {
+	return w.changes
+}
+
+// poll checks the config file for changes once per interval until the
+// ConfigWatcher's Close function is called.
+func (w *ConfigWatcher) poll() {
+	defer close(w.done)
+	defer close(w.changes)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closeChan:
+			return
+		case <-ticker.C:
+			c, changed := w.check()
+			if !changed {
+				continue
+			}
+			select {
+			case w.changes <- c:
+			case <-w.closeChan:
+				return
+			}
+		}
+	}
+}
+
+// check stats the config file and if it has been modified since the last check
+// loads it. It returns the loaded Config and true if the file changed and the
+// Config is valid.
+func (w *ConfigWatcher) check() (Config, bool) {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		w.log.Printf("error checking config %q: %v\n", w.path, err)
+
+		return Config{}, false
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return Config{}, false
+	}
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+
+	c, err := LoadConfigFile(w.path)
+	if err != nil {
+		w.log.Printf("error loading changed config %q: %v\n", w.path, err)
+
+		return Config{}, false
+	}
+	if err := c.Valid(); err != nil {
+		w.log.Printf("changed config %q is not valid: %v\n", w.path, err)
+
+		return Config{}, false
+	}
+
+	return c, true
+}
+
+// Close stops the ConfigWatcher polling the config file and closes the
+// Changes() channel.
+func (w *ConfigWatcher) Close() error {
+	w.closeChan <- true
+	<-w.done
+
+	return nil
+}
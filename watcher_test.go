@@ -0,0 +1,73 @@
+package woodwatch
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testWatchInterval is the poll interval used by ConfigWatchers in tests.
+const testWatchInterval = 10 * time.Millisecond
+
+// TestNewConfigWatcherError tests that calling NewConfigWatcher with invalid
+// arguments produces an error.
+func TestNewConfigWatcherError(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	if _, err := NewConfigWatcher(logger, "whatever.json", 0); err != ErrInvalidWatchInterval {
+		t.Errorf("expected err %v for zero interval, got %v", ErrInvalidWatchInterval, err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	if _, err := NewConfigWatcher(logger, missing, testWatchInterval); err == nil {
+		t.Errorf("expected err for missing config file, got nil")
+	}
+}
+
+// TestConfigWatcherChanges tests that a ConfigWatcher sends valid configs on
+// its Changes channel when the watched file changes and skips invalid ones.
+func TestConfigWatcherChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatalf("error writing config: %v", err)
+		}
+	}
+	writeConfig(`{}`)
+
+	w, err := NewConfigWatcher(log.New(io.Discard, "", 0), path, testWatchInterval)
+	if err != nil {
+		t.Fatalf("expected NewConfigWatcher to return nil err, got %v", err)
+	}
+
+	// An invalid config shouldn't be sent on the Changes channel.
+	writeConfig(`{"Peers": []}`)
+	select {
+	case c := <-w.Changes():
+		t.Fatalf("expected no config for invalid change, got %#v", c)
+	case <-time.After(testWatchInterval * 5):
+	}
+
+	// A valid config should be sent on the Changes channel.
+	writeConfig(`{
+		"MonitorCycle": "1s",
+		"PeerTimeout": "2s",
+		"Peers": [{"Name": "LAN", "Network": "192.168.1.0/24"}]
+	}`)
+	select {
+	case c := <-w.Changes():
+		if len(c.Peers) != 1 || c.Peers[0].Name != "LAN" {
+			t.Errorf("expected config with peer LAN, got %#v", c)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected config change, got none")
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected Close to return nil err, got %v", err)
+	}
+	if _, ok := <-w.Changes(); ok {
+		t.Errorf("expected Changes channel to be closed after Close")
+	}
+}